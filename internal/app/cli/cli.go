@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/compute"
 	"github.com/kirban/potato-db/internal/helpers"
 	loggerModule "github.com/kirban/potato-db/internal/logger"
 	"github.com/kirban/potato-db/internal/network"
@@ -23,6 +24,8 @@ type AppCli struct {
 	client *network.TCPClient
 	logger *zap.Logger
 	config *config.Config
+	// framed sends queries tokenized locally over binary framing instead of text lines
+	framed bool
 }
 
 func NewAppCli() (*AppCli, error) {
@@ -59,7 +62,7 @@ func (app *AppCli) Run() error {
 			app.logger.Error("failed to read query", zap.Error(err))
 		}
 
-		result, err := app.client.Send([]byte(query))
+		result, err := app.send(query)
 
 		if errors.Is(err, syscall.EPIPE) {
 			app.logger.Fatal("connection was closed", zap.Error(err))
@@ -73,6 +76,33 @@ func (app *AppCli) Run() error {
 	}
 }
 
+func (app *AppCli) send(query string) ([]byte, error) {
+	if !app.framed {
+		return app.client.Send([]byte(query))
+	}
+
+	tokens, err := compute.Tokenize(query)
+	if err != nil {
+		return []byte(compute.ErrorResult(err).String()), nil
+	}
+
+	if len(tokens) == 0 {
+		return []byte(compute.ErrorResult(compute.ErrInvalidQuery).String()), nil
+	}
+
+	args := make([][]byte, len(tokens))
+	for i, token := range tokens {
+		args[i] = []byte(token)
+	}
+
+	result, err := app.client.Execute(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(result.String()), nil
+}
+
 func (app *AppCli) initDeps() error {
 	deps := []func() error{
 		app.initConfig,
@@ -122,6 +152,7 @@ func (app *AppCli) initClient() error {
 	port := flag.String("port", "8282", "port to connect to")
 	idleTimeout := flag.Duration("idle-timeout", time.Minute, "idle timeout")
 	maxMessageSize := flag.String("max-message-size", "4KB", "max message size")
+	framed := flag.Bool("framed", false, "use binary safe framed protocol")
 	flag.Parse()
 
 	maxSize, err := helpers.ParseSize(*maxMessageSize)
@@ -138,6 +169,14 @@ func (app *AppCli) initClient() error {
 		return err
 	}
 
+	if *framed {
+		if err := client.Negotiate(); err != nil {
+			app.logger.Fatal("failed to negotiate framed protocol", zap.Error(err))
+			return err
+		}
+	}
+
 	app.client = client
+	app.framed = *framed
 	return nil
 }
@@ -2,25 +2,45 @@ package server
 
 import (
 	"context"
+	"errors"
+	"github.com/kirban/potato-db/internal/cluster"
 	"github.com/kirban/potato-db/internal/config"
 	"github.com/kirban/potato-db/internal/db"
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/snapshot"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/db/wal"
+	"github.com/kirban/potato-db/internal/helpers"
 	loggerModule "github.com/kirban/potato-db/internal/logger"
 	"github.com/kirban/potato-db/internal/network"
 	"github.com/kirban/potato-db/internal/network/handlers"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/kirban/potato-db/internal/replication"
 	"go.uber.org/zap"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
 const DefaultConfigPath = "config.potato.yaml"
 
 type AppServer struct {
-	config *config.Config
-	logger *zap.Logger
-	db     *db.Database
-	server *network.TCPServer
+	config      *config.Config
+	logger      *zap.Logger
+	db          *db.Database
+	wal         *wal.WAL
+	snapshotter *snapshot.Snapshotter
+	broker      *pubsub.Broker
+	events      *storage.EventBus
+	changes     *cdc.Log
+	leader      *replication.Leader
+	follower    *replication.Follower
+	persister   *cluster.FilePersister
+	transport   *cluster.TCPTransport
+	server      *network.TCPServer
+	httpServer  *network.HTTPServer
 }
 
 func NewAppServer() (*AppServer, error) {
@@ -50,23 +70,91 @@ func (s *AppServer) Run() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	wg := &sync.WaitGroup{}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.db.Start(ctx)
+	}()
+
+	if s.wal != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.wal.Start(ctx)
+		}()
+	}
+
+	if s.snapshotter != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.snapshotter.Start(ctx, s.db.Snapshot)
+		}()
+	}
+
+	if s.follower != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.follower.Start(ctx, s.db)
+		}()
+	}
+
+	if node := s.db.Cluster(); node != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Run(ctx, s.config.Cluster.TickInterval)
+		}()
+
+		go func() {
+			if err := s.transport.Serve(ctx, node.Step); err != nil {
+				s.logger.Fatal("failed starting cluster transport", zap.Error(err))
+			}
+		}()
+	}
+
 	go func() {
 		if err := s.server.StartAndServe(ctx); err != nil {
 			s.logger.Fatal("failed starting server", zap.Error(err))
 		}
 	}()
 
+	if s.httpServer != nil {
+		go func() {
+			if err := s.httpServer.StartAndServe(ctx); err != nil {
+				s.logger.Fatal("failed starting http server", zap.Error(err))
+			}
+		}()
+	}
+
 	s.logger.Info("Server started. Press CTRL+C to stop")
 	<-ctx.Done()
 	s.logger.Info("Got exit signal. Gracefully shutdown.")
+	wg.Wait()
+
+	if err := s.db.Close(); err != nil {
+		s.logger.Error("failed to close database", zap.Error(err))
+	}
 }
 
 func (s *AppServer) initDeps() error {
 	deps := []func() error{
 		s.initConfig,
 		s.initLogger,
+		s.initWAL,
+		s.initSnapshotter,
+		s.initPubSub,
+		s.initEvents,
+		s.initChangeLog,
+		s.initReplication,
+		s.initCluster,
 		s.initDatabase,
+		s.recoverDatabase,
 		s.initServer,
+		s.initHTTPServer,
 	}
 
 	for _, dep := range deps {
@@ -106,16 +194,170 @@ func (s *AppServer) initLogger() error {
 	return nil
 }
 
+func (s *AppServer) initWAL() error {
+	if s.config.Wal == nil {
+		s.logger.Info("wal is disabled")
+		return nil
+	}
+
+	w, err := wal.NewWAL(s.logger, s.config.Wal)
+
+	if err != nil {
+		return err
+	}
+
+	s.wal = w
+	return nil
+}
+
+func (s *AppServer) initSnapshotter() error {
+	if s.config.Snapshot == nil {
+		s.logger.Info("snapshots are disabled")
+		return nil
+	}
+
+	snapshotter, err := snapshot.NewSnapshotter(s.logger, s.config.Snapshot)
+
+	if err != nil {
+		return err
+	}
+
+	s.snapshotter = snapshotter
+	return nil
+}
+
+func (s *AppServer) initPubSub() error {
+	s.broker = pubsub.NewBroker(s.logger, s.config.PubSub.SubscriberBufferSize)
+	return nil
+}
+
+func (s *AppServer) initEvents() error {
+	if len(s.config.PubSub.KeyspaceEvents) == 0 {
+		return nil
+	}
+
+	classes, err := storage.ParseEventClasses(s.config.PubSub.KeyspaceEvents)
+	if err != nil {
+		return err
+	}
+
+	s.events = storage.NewEventBus(classes)
+	return nil
+}
+
+func (s *AppServer) initChangeLog() error {
+	if s.config.CDC == nil {
+		s.logger.Info("cdc is disabled")
+		return nil
+	}
+
+	s.changes = cdc.NewLog(s.config.CDC.Capacity)
+	return nil
+}
+
+func (s *AppServer) initReplication() error {
+	if s.config.Replication == nil {
+		return nil
+	}
+
+	if s.config.Replication.Role == config.ReplicationRoleLeader {
+		// followers stream changes from the same log CDC does
+		if s.changes == nil {
+			s.changes = cdc.NewLog(cdc.DefaultCapacity)
+		}
+
+		s.leader = replication.NewLeader(s.changes)
+		return nil
+	}
+
+	// data of leader is sent in framed messages, so they are limited like requests to this server
+	maxMessageSize, err := helpers.ParseSize(s.config.TcpServer.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+
+	s.follower = replication.NewFollower(s.logger, s.config.Replication.LeaderAddress, func(address string) (replication.Conn, error) {
+		client, err := network.NewTCPClient(address, 0, maxMessageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := client.Negotiate(); err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+	return nil
+}
+
+func (s *AppServer) initCluster() error {
+	if s.config.Cluster == nil {
+		return nil
+	}
+
+	persister, err := cluster.NewFilePersister(s.config.Cluster.DataDirectory)
+	if err != nil {
+		return err
+	}
+
+	s.persister = persister
+	s.transport = cluster.NewTCPTransport(s.logger, s.config.Cluster.Address)
+	return nil
+}
+
 func (s *AppServer) initDatabase() error {
-	database := db.NewDbBuilder(s.logger).
+	builder := db.NewDbBuilder(s.logger, s.config.Db)
+
+	if s.wal != nil {
+		builder = builder.InitWAL(s.wal)
+	}
+
+	if s.snapshotter != nil {
+		builder = builder.InitSnapshotter(s.snapshotter)
+	}
+
+	if s.changes != nil {
+		builder = builder.InitChangeLog(s.changes)
+	}
+
+	if s.leader != nil {
+		builder = builder.InitLeader(s.leader)
+	}
+
+	if s.follower != nil {
+		builder = builder.InitFollower(s.follower)
+	}
+
+	if s.transport != nil {
+		builder = builder.InitCluster(cluster.Config{
+			ID:                s.config.Cluster.NodeID,
+			Address:           s.config.Cluster.Address,
+			Peers:             s.config.Cluster.Peers,
+			ElectionTicks:     s.config.Cluster.ElectionTicks,
+			HeartbeatTicks:    s.config.Cluster.HeartbeatTicks,
+			SnapshotThreshold: uint64(s.config.Cluster.SnapshotThreshold),
+		}, s.persister, s.transport)
+	}
+
+	database := builder.
+		InitPubSub(s.broker).
+		InitEvents(s.events).
 		InitStorage().
 		InitCompute().
 		Build()
 
+	if database == nil {
+		return errors.New("failed to build database")
+	}
+
 	s.db = database
 	return nil
 }
 
+func (s *AppServer) recoverDatabase() error {
+	return s.db.Recover()
+}
+
 func (s *AppServer) initServer() error {
 	handler := &handlers.DatabaseHandler{
 		Db: s.db,
@@ -130,3 +372,21 @@ func (s *AppServer) initServer() error {
 	s.server = server
 	return nil
 }
+
+func (s *AppServer) initHTTPServer() error {
+	if s.config.HttpServer == nil {
+		return nil
+	}
+
+	handler := &handlers.DatabaseHandler{
+		Db: s.db,
+	}
+
+	server, err := network.NewHTTPServer(s.logger, s.config.HttpServer, handler)
+	if err != nil {
+		return err
+	}
+
+	s.httpServer = server
+	return nil
+}
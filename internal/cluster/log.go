@@ -0,0 +1,163 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"maps"
+)
+
+type EntryType uint8
+
+const (
+	// EntryCommand carries data applied to state machine
+	EntryCommand EntryType = iota
+	// EntryConfig carries new membership of cluster, it takes effect as soon as it is appended
+	EntryConfig
+	// EntryNoop is appended by new leader, so entries of previous terms get committed
+	EntryNoop
+)
+
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// SnapshotMeta describes the latest entry included into snapshot and membership at it
+type SnapshotMeta struct {
+	Index   uint64
+	Term    uint64
+	Members map[string]string
+}
+
+type Snapshot struct {
+	Meta SnapshotMeta
+	Data []byte
+}
+
+// raftLog keeps entries after the latest snapshot, entries[i] has index snapshot.Index+1+i
+type raftLog struct {
+	snapshot SnapshotMeta
+	entries  []Entry
+	// entries up to saved are handed to persister, entries up to stable are saved by it
+	saved  uint64
+	stable uint64
+}
+
+func (l *raftLog) lastIndex() uint64 {
+	return l.snapshot.Index + uint64(len(l.entries))
+}
+
+func (l *raftLog) lastTerm() uint64 {
+	if len(l.entries) == 0 {
+		return l.snapshot.Term
+	}
+	return l.entries[len(l.entries)-1].Term
+}
+
+// term returns term of entry at index, it is unknown for compacted entries except the last one
+func (l *raftLog) term(index uint64) (uint64, bool) {
+	switch {
+	case index == l.snapshot.Index:
+		return l.snapshot.Term, true
+	case index < l.snapshot.Index || index > l.lastIndex():
+		return 0, false
+	}
+
+	return l.entries[index-l.snapshot.Index-1].Term, true
+}
+
+// entry returns entry at index, it must be neither compacted nor beyond the last one
+func (l *raftLog) entry(index uint64) Entry {
+	return l.entries[index-l.snapshot.Index-1]
+}
+
+// slice copies up to limit entries starting from index, so they may be sent while log changes
+func (l *raftLog) slice(from uint64, limit int) []Entry {
+	if from > l.lastIndex() {
+		return nil
+	}
+
+	start := from - l.snapshot.Index - 1
+	end := min(uint64(len(l.entries)), start+uint64(limit))
+	return append([]Entry(nil), l.entries[start:end]...)
+}
+
+func (l *raftLog) append(entries ...Entry) {
+	l.entries = append(l.entries, entries...)
+}
+
+// merge appends entries sent by leader, entries conflicting with them are dropped first.
+// It reports whether log has changed
+func (l *raftLog) merge(entries []Entry) bool {
+	for i, entry := range entries {
+		if entry.Index <= l.snapshot.Index {
+			continue
+		}
+
+		if term, ok := l.term(entry.Index); ok {
+			if term == entry.Term {
+				continue
+			}
+			l.entries = l.entries[:entry.Index-l.snapshot.Index-1]
+			l.saved = min(l.saved, entry.Index-1)
+			l.stable = min(l.stable, l.saved)
+		}
+
+		l.entries = append(l.entries, entries[i:]...)
+		return true
+	}
+
+	return false
+}
+
+// unsaved returns entries which are not handed to persister yet and marks them as saved
+func (l *raftLog) unsaved() []Entry {
+	entries := l.slice(max(l.saved, l.snapshot.Index)+1, len(l.entries))
+	l.saved = l.lastIndex()
+	return entries
+}
+
+// stableTo marks entries up to index as saved, unless entry at index has been replaced since
+func (l *raftLog) stableTo(index uint64, term uint64) {
+	if t, ok := l.term(index); ok && t == term && index <= l.saved {
+		l.stable = index
+	}
+}
+
+// compact drops entries up to snapshot, entries after it are kept if log agrees with snapshot
+func (l *raftLog) compact(snapshot SnapshotMeta) {
+	if term, ok := l.term(snapshot.Index); ok && term == snapshot.Term && snapshot.Index >= l.snapshot.Index {
+		l.entries = append([]Entry(nil), l.entries[snapshot.Index-l.snapshot.Index:]...)
+	} else {
+		l.entries = nil
+	}
+
+	l.snapshot = snapshot
+}
+
+// members returns membership at index, it is set by the latest config entry up to index
+func (l *raftLog) members(index uint64) (map[string]string, uint64) {
+	for i := min(index, l.lastIndex()); i > l.snapshot.Index; i-- {
+		if entry := l.entry(i); entry.Type == EntryConfig {
+			return decodeMembers(entry.Data), i
+		}
+	}
+
+	return maps.Clone(l.snapshot.Members), l.snapshot.Index
+}
+
+func encodeMembers(members map[string]string) []byte {
+	var buf bytes.Buffer
+	// map of strings is always encodable
+	_ = gob.NewEncoder(&buf).Encode(members)
+	return buf.Bytes()
+}
+
+func decodeMembers(data []byte) map[string]string {
+	members := make(map[string]string)
+	// config entries are made by encodeMembers only
+	_ = gob.NewDecoder(bytes.NewReader(data)).Decode(&members)
+	return members
+}
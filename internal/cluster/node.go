@@ -0,0 +1,1125 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotLeader               = errors.New("cluster: node is not the leader")
+	ErrLeadershipLost          = errors.New("cluster: leadership lost before entry was committed, it may be applied or not")
+	ErrProposalDropped         = errors.New("cluster: entry was replaced by another leader")
+	ErrMembershipChangePending = errors.New("cluster: another membership change is in progress")
+	ErrUnknownMember           = errors.New("cluster: node is not a member")
+	ErrInvalidConfig           = errors.New("cluster: id and address of node are required")
+)
+
+const (
+	DefaultElectionTicks        = 10
+	DefaultHeartbeatTicks       = 1
+	DefaultSnapshotThreshold    = 10000
+	DefaultMaxEntriesPerMessage = 64
+)
+
+type Config struct {
+	ID string
+	// Address is where transport delivers messages to node
+	Address string
+	// Peers is membership the cluster starts with, id to address, it is the same on every
+	// initial node. Node joining existing cluster starts without peers and waits for leader
+	Peers map[string]string
+	// ElectionTicks is the least number of ticks without leader before follower starts election,
+	// actual timeout is randomized between it and twice of it
+	ElectionTicks  int
+	HeartbeatTicks int
+	// SnapshotThreshold is number of applied entries after which log is compacted by snapshot
+	SnapshotThreshold    uint64
+	MaxEntriesPerMessage int
+	// Seed makes randomized election timeouts reproducible, zero seeds by current time
+	Seed uint64
+}
+
+// StateMachine is replicated by cluster, it must be deterministic: the same entries applied in
+// the same order bring every node to the same state. It is called by node holding its lock, so it
+// must not call node except for Status
+type StateMachine interface {
+	Apply(data []byte) any
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+type State int
+
+const (
+	Follower State = iota
+	PreCandidate
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case PreCandidate:
+		return "pre-candidate"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// progress is what leader knows about log of follower
+type progress struct {
+	next  uint64
+	match uint64
+	// active is set by any reply, leader steps down if most of members are silent
+	active bool
+	// snapshotWait is number of ticks before snapshot may be sent again
+	snapshotWait int
+}
+
+type outgoing struct {
+	address string
+	msg     Message
+}
+
+// Node is a member of raft cluster. It is driven by Tick and Step: there are no goroutines
+// inside, so tests decide when time passes and messages arrive
+type Node struct {
+	logger    *zap.Logger
+	config    Config
+	sm        StateMachine
+	persister Persister
+	transport Transport
+
+	mu       sync.Mutex
+	state    State
+	term     uint64
+	votedFor string
+	leader   string
+	log      raftLog
+	// members is membership set by the latest config entry in log, it is used at once
+	members     map[string]string
+	configIndex uint64
+	commit      uint64
+	applied     uint64
+
+	rand             *rand.Rand
+	electionElapsed  int
+	electionTimeout  int
+	heartbeatElapsed int
+	votes            map[string]bool
+	progress         map[string]*progress
+	proposals        map[uint64]*Proposal
+
+	// snapshotData is data of snapshot log starts after, it is sent to followers behind it
+	snapshotData []byte
+	// hardState is the latest one handed to persister
+	hardState     HardState
+	snapshotDirty bool
+	msgs          []outgoing
+	// changed state is saved before messages are sent, so nothing promised is forgotten on
+	// restart. Saves are queued and done one by one without holding mu by one of callers
+	saves  []pendingSave
+	saving bool
+
+	// status is published on every flush, so it is read without waiting for state machine
+	status atomic.Pointer[Status]
+}
+
+// pendingSave is update along with messages which are sent once it is saved
+type pendingSave struct {
+	update Update
+	msgs   []outgoing
+}
+
+func NewNode(logger *zap.Logger, config Config, sm StateMachine, persister Persister, transport Transport) (*Node, error) {
+	if config.ID == "" || config.Address == "" {
+		return nil, ErrInvalidConfig
+	}
+
+	if config.ElectionTicks <= 0 {
+		config.ElectionTicks = DefaultElectionTicks
+	}
+	if config.HeartbeatTicks <= 0 {
+		config.HeartbeatTicks = DefaultHeartbeatTicks
+	}
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if config.MaxEntriesPerMessage <= 0 {
+		config.MaxEntriesPerMessage = DefaultMaxEntriesPerMessage
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+
+	n := &Node{
+		logger:    logger,
+		config:    config,
+		sm:        sm,
+		persister: persister,
+		transport: transport,
+		rand:      rand.New(rand.NewPCG(seed, seed)),
+		proposals: make(map[uint64]*Proposal),
+	}
+
+	if err := n.restore(); err != nil {
+		return nil, err
+	}
+	n.resetElectionTimeout()
+	n.publish()
+
+	return n, nil
+}
+
+// restore loads saved state, new node starts with membership from config
+func (n *Node) restore() error {
+	hardState, entries, snapshot, err := n.persister.Load()
+	if err != nil {
+		return err
+	}
+
+	// initial membership is not logged, so nodes restart with the same peers they started with
+	n.log.snapshot.Members = maps.Clone(n.config.Peers)
+	if hardState == (HardState{}) && entries == nil && snapshot == nil {
+		n.members, n.configIndex = n.log.members(0)
+		return nil
+	}
+
+	if snapshot != nil {
+		if err := n.sm.Restore(snapshot.Data); err != nil {
+			return fmt.Errorf("failed to restore cluster snapshot: %w", err)
+		}
+
+		n.log.snapshot = snapshot.Meta
+		n.snapshotData = snapshot.Data
+		n.commit, n.applied = snapshot.Meta.Index, snapshot.Meta.Index
+	}
+
+	n.term, n.votedFor = hardState.Term, hardState.VotedFor
+	n.hardState = hardState
+	n.log.merge(entries)
+	n.log.saved, n.log.stable = n.log.lastIndex(), n.log.lastIndex()
+
+	n.members, n.configIndex = n.log.members(n.log.lastIndex())
+	n.logger.Info("cluster state restored", zap.String("id", n.config.ID), zap.Uint64("term", n.term),
+		zap.Uint64("snapshot", n.log.snapshot.Index), zap.Uint64("last", n.log.lastIndex()))
+	return nil
+}
+
+// Run ticks node every interval until ctx is done
+func (n *Node) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.Tick()
+		}
+	}
+}
+
+// Tick advances logical clock of node, timeouts of election and heartbeats are counted in ticks
+func (n *Node) Tick() {
+	n.mu.Lock()
+	n.tick()
+	save := n.flush()
+	n.mu.Unlock()
+
+	if save {
+		n.save()
+	}
+}
+
+// Step handles message of another node
+func (n *Node) Step(msg Message) {
+	n.mu.Lock()
+	n.step(msg)
+	save := n.flush()
+	n.mu.Unlock()
+
+	if save {
+		n.save()
+	}
+}
+
+// Propose appends data to log of leader, proposal is done once the entry is applied
+func (n *Node) Propose(data []byte) (*Proposal, error) {
+	return n.propose(EntryCommand, data)
+}
+
+// Execute proposes data and waits until it is applied, it returns result of state machine
+func (n *Node) Execute(ctx context.Context, data []byte) (any, error) {
+	proposal, err := n.Propose(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal.Wait(ctx)
+}
+
+// AddMember adds node to cluster. New node counts for majority as soon as the change is appended,
+// before it catches up with log, so until then a failure of one more member stops commits, e.g.
+// a cluster of three which adds a fourth one tolerates no failures. Only one membership change
+// may be in progress at a time
+func (n *Node) AddMember(id string, address string) (*Proposal, error) {
+	return n.changeMembers(func(members map[string]string) error {
+		members[id] = address
+		return nil
+	})
+}
+
+// RemoveMember removes node from cluster, leader which removes itself steps down once removal
+// is committed
+func (n *Node) RemoveMember(id string) (*Proposal, error) {
+	return n.changeMembers(func(members map[string]string) error {
+		if _, ok := members[id]; !ok {
+			return ErrUnknownMember
+		}
+		delete(members, id)
+		return nil
+	})
+}
+
+func (n *Node) changeMembers(change func(members map[string]string) error) (*Proposal, error) {
+	n.mu.Lock()
+	if n.state != Leader {
+		err := n.notLeader()
+		n.mu.Unlock()
+		return nil, err
+	}
+
+	// membership changes one node at a time, so majorities of old and new membership overlap.
+	// Leader commits entry of its term first, otherwise change may be lost with older entries
+	if term, _ := n.log.term(n.commit); n.configIndex > n.commit || term != n.term {
+		n.mu.Unlock()
+		return nil, ErrMembershipChangePending
+	}
+
+	members := maps.Clone(n.members)
+	if err := change(members); err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	n.mu.Unlock()
+
+	return n.propose(EntryConfig, encodeMembers(members))
+}
+
+func (n *Node) propose(entryType EntryType, data []byte) (*Proposal, error) {
+	n.mu.Lock()
+	if n.state != Leader {
+		err := n.notLeader()
+		n.mu.Unlock()
+		return nil, err
+	}
+
+	entry := Entry{Index: n.log.lastIndex() + 1, Term: n.term, Type: entryType, Data: data}
+	proposal := &Proposal{index: entry.Index, term: entry.Term, done: make(chan struct{})}
+	n.proposals[entry.Index] = proposal
+
+	n.appendEntries(entry)
+	save := n.flush()
+	n.mu.Unlock()
+
+	if save {
+		n.save()
+	}
+	return proposal, nil
+}
+
+// Status describes node for monitoring
+type Status struct {
+	ID            string
+	State         State
+	Term          uint64
+	Leader        string
+	LeaderAddress string
+	Commit        uint64
+	Applied       uint64
+	LastIndex     uint64
+	Members       map[string]string
+}
+
+// Status returns state of node as of the latest tick, message or proposal it has handled
+func (n *Node) Status() Status {
+	status := *n.status.Load()
+	status.Members = maps.Clone(status.Members)
+	return status
+}
+
+func (n *Node) publish() {
+	n.status.Store(&Status{
+		ID:            n.config.ID,
+		State:         n.state,
+		Term:          n.term,
+		Leader:        n.leader,
+		LeaderAddress: n.members[n.leader],
+		Commit:        n.commit,
+		Applied:       n.applied,
+		LastIndex:     n.log.lastIndex(),
+		Members:       maps.Clone(n.members),
+	})
+}
+
+// Info describes node and its membership for INFO command
+func (n *Node) Info() []string {
+	status := n.Status()
+
+	ids := slices.Sorted(maps.Keys(status.Members))
+	members := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, id+"="+status.Members[id])
+	}
+
+	return []string{
+		"cluster_enabled:1",
+		"node_id:" + status.ID,
+		"state:" + status.State.String(),
+		fmt.Sprintf("term:%d", status.Term),
+		"leader:" + status.Leader,
+		fmt.Sprintf("commit_index:%d", status.Commit),
+		fmt.Sprintf("applied_index:%d", status.Applied),
+		fmt.Sprintf("last_index:%d", status.LastIndex),
+		"members:" + strings.Join(members, ","),
+	}
+}
+
+func (n *Node) notLeader() error {
+	if n.leader == "" {
+		return ErrNotLeader
+	}
+	return fmt.Errorf("%w, leader is %s at %s", ErrNotLeader, n.leader, n.members[n.leader])
+}
+
+func (n *Node) tick() {
+	if n.state == Leader {
+		n.tickLeader()
+		return
+	}
+
+	n.electionElapsed++
+	if n.electionElapsed >= n.electionTimeout && n.promotable() {
+		n.preCampaign()
+	}
+}
+
+func (n *Node) tickLeader() {
+	n.electionElapsed++
+	if n.electionElapsed >= n.config.ElectionTicks {
+		n.electionElapsed = 0
+
+		// leader cut off from most of members steps down, so clients look for another one
+		if !n.checkQuorum() {
+			n.logger.Warn("leader lost contact with majority, stepping down", zap.String("id", n.config.ID), zap.Uint64("term", n.term))
+			n.becomeFollower(n.term, "")
+			return
+		}
+	}
+
+	for _, p := range n.progress {
+		if p.snapshotWait > 0 {
+			p.snapshotWait--
+		}
+	}
+
+	n.heartbeatElapsed++
+	if n.heartbeatElapsed >= n.config.HeartbeatTicks {
+		n.heartbeatElapsed = 0
+		n.broadcastAppend()
+	}
+}
+
+// promotable reports whether node may become leader, nodes out of membership never campaign
+func (n *Node) promotable() bool {
+	_, ok := n.members[n.config.ID]
+	return ok
+}
+
+// preCampaign checks that node would win election before it raises term
+func (n *Node) preCampaign() {
+	n.state = PreCandidate
+	n.leader = ""
+	n.votes = map[string]bool{n.config.ID: true}
+	n.electionElapsed = 0
+	n.resetElectionTimeout()
+
+	if n.quorum(n.granted()) {
+		n.campaign()
+		return
+	}
+
+	for _, id := range n.peers() {
+		n.reply(n.members[id], Message{Type: MsgPreVote, Term: n.term + 1, Index: n.log.lastIndex(), LogTerm: n.log.lastTerm()})
+	}
+}
+
+func (n *Node) campaign() {
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.config.ID
+	n.leader = ""
+	n.votes = map[string]bool{n.config.ID: true}
+	n.electionElapsed = 0
+	n.resetElectionTimeout()
+
+	n.logger.Info("starting election", zap.String("id", n.config.ID), zap.Uint64("term", n.term))
+
+	if n.quorum(n.granted()) {
+		n.becomeLeader()
+		return
+	}
+
+	for _, id := range n.peers() {
+		n.reply(n.members[id], Message{Type: MsgVote, Index: n.log.lastIndex(), LogTerm: n.log.lastTerm()})
+	}
+}
+
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+	}
+
+	if n.state == Leader {
+		n.failProposals(ErrLeadershipLost)
+	}
+
+	n.state = Follower
+	n.leader = leader
+	n.progress = nil
+	n.votes = nil
+	n.electionElapsed = 0
+	n.resetElectionTimeout()
+}
+
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leader = n.config.ID
+	n.heartbeatElapsed = 0
+	n.electionElapsed = 0
+	n.progress = make(map[string]*progress)
+	n.updateProgress()
+
+	n.logger.Info("became leader", zap.String("id", n.config.ID), zap.Uint64("term", n.term))
+
+	// entries of previous terms are committed only along with an entry of current term
+	n.appendEntries(Entry{Index: n.log.lastIndex() + 1, Term: n.term, Type: EntryNoop})
+}
+
+// updateProgress tracks followers of current membership, new ones start from the end of log
+func (n *Node) updateProgress() {
+	for id := range n.progress {
+		if _, ok := n.members[id]; !ok {
+			delete(n.progress, id)
+		}
+	}
+
+	for id := range n.members {
+		if _, ok := n.progress[id]; !ok && id != n.config.ID {
+			n.progress[id] = &progress{next: n.log.lastIndex() + 1, active: true}
+		}
+	}
+}
+
+// appendEntries appends entries proposed by leader and sends them to followers
+func (n *Node) appendEntries(entries ...Entry) {
+	n.log.append(entries...)
+	n.updateMembers()
+
+	if !n.maybeCommit() {
+		n.broadcastAppend()
+	}
+}
+
+// updateMembers applies the latest config entry, it may be appended or dropped from log
+func (n *Node) updateMembers() {
+	members, index := n.log.members(n.log.lastIndex())
+	if index == n.configIndex {
+		return
+	}
+
+	n.members, n.configIndex = members, index
+	if n.state == Leader {
+		n.updateProgress()
+	}
+}
+
+func (n *Node) step(msg Message) {
+	switch {
+	case msg.Term > n.term:
+		// node which has just heard from leader ignores candidates, so removed or partitioned
+		// nodes can't disrupt cluster by elections with higher terms
+		if msg.Type == MsgVote && n.leaderActive() {
+			return
+		}
+
+		// pre-vote doesn't change terms, granted pre-vote carries term candidate is going to have
+		if msg.Type == MsgPreVote || msg.Type == MsgPreVoteResp && !msg.Reject {
+			break
+		}
+
+		leader := ""
+		if msg.Type == MsgAppend || msg.Type == MsgSnapshot {
+			leader = msg.From
+		}
+		n.becomeFollower(msg.Term, leader)
+	case msg.Term < n.term:
+		// stale leader or candidate learns about newer term from reply
+		switch msg.Type {
+		case MsgPreVote:
+			n.reply(msg.Address, Message{Type: MsgPreVoteResp, Reject: true})
+		case MsgVote:
+			n.reply(msg.Address, Message{Type: MsgVoteResp, Reject: true})
+		case MsgAppend, MsgSnapshot:
+			n.reply(msg.Address, Message{Type: MsgAppendResp, Reject: true, Hint: n.log.lastIndex()})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case MsgPreVote:
+		n.handlePreVote(msg)
+	case MsgPreVoteResp:
+		n.handlePreVoteResp(msg)
+	case MsgVote:
+		n.handleVote(msg)
+	case MsgVoteResp:
+		n.handleVoteResp(msg)
+	case MsgAppend:
+		n.follow(msg.From)
+		n.handleAppend(msg)
+	case MsgAppendResp:
+		n.handleAppendResp(msg)
+	case MsgSnapshot:
+		n.follow(msg.From)
+		n.handleSnapshot(msg)
+	}
+}
+
+// follow resets election timer on message of leader of current term
+func (n *Node) follow(leader string) {
+	if n.state != Follower || n.leader != leader {
+		n.becomeFollower(n.term, leader)
+	}
+	n.electionElapsed = 0
+}
+
+// leaderActive reports whether node has heard from leader within election timeout
+func (n *Node) leaderActive() bool {
+	return n.leader != "" && n.electionElapsed < n.config.ElectionTicks
+}
+
+// upToDate reports whether log of candidate has all entries node has, so committed entries are
+// never lost by election
+func (n *Node) upToDate(msg Message) bool {
+	return msg.LogTerm > n.log.lastTerm() || msg.LogTerm == n.log.lastTerm() && msg.Index >= n.log.lastIndex()
+}
+
+func (n *Node) handlePreVote(msg Message) {
+	if msg.Term > n.term && !n.leaderActive() && n.upToDate(msg) {
+		n.reply(msg.Address, Message{Type: MsgPreVoteResp, Term: msg.Term})
+		return
+	}
+
+	n.reply(msg.Address, Message{Type: MsgPreVoteResp, Reject: true})
+}
+
+func (n *Node) handlePreVoteResp(msg Message) {
+	if n.state != PreCandidate {
+		return
+	}
+
+	n.votes[msg.From] = !msg.Reject
+
+	if n.quorum(n.granted()) {
+		n.campaign()
+	} else if n.quorum(len(n.votes) - n.granted()) {
+		n.becomeFollower(n.term, "")
+	}
+}
+
+func (n *Node) handleVote(msg Message) {
+	grant := (n.votedFor == "" || n.votedFor == msg.From) && n.upToDate(msg)
+
+	if grant {
+		n.votedFor = msg.From
+		n.electionElapsed = 0
+	}
+
+	n.reply(msg.Address, Message{Type: MsgVoteResp, Reject: !grant})
+}
+
+func (n *Node) handleVoteResp(msg Message) {
+	if n.state != Candidate {
+		return
+	}
+
+	n.votes[msg.From] = !msg.Reject
+
+	if n.quorum(n.granted()) {
+		n.becomeLeader()
+	} else if n.quorum(len(n.votes) - n.granted()) {
+		n.becomeFollower(n.term, "")
+	}
+}
+
+func (n *Node) handleAppend(msg Message) {
+	// entries up to commit are already agreed on
+	if msg.Index < n.commit {
+		n.reply(msg.Address, Message{Type: MsgAppendResp, Index: n.commit})
+		return
+	}
+
+	if term, ok := n.log.term(msg.Index); !ok || term != msg.LogTerm {
+		// leader retries from the last entry node has, or the one before mismatching entry
+		n.reply(msg.Address, Message{Type: MsgAppendResp, Reject: true, Hint: min(n.log.lastIndex(), msg.Index-1)})
+		return
+	}
+
+	if n.log.merge(msg.Entries) {
+		n.updateMembers()
+	}
+
+	last := msg.Index + uint64(len(msg.Entries))
+	n.commitTo(min(msg.Commit, last))
+	n.reply(msg.Address, Message{Type: MsgAppendResp, Index: last})
+}
+
+func (n *Node) handleAppendResp(msg Message) {
+	if n.state != Leader {
+		return
+	}
+
+	p, ok := n.progress[msg.From]
+	if !ok {
+		return
+	}
+	p.active = true
+	p.snapshotWait = 0
+
+	if msg.Reject {
+		p.next = max(min(msg.Hint+1, p.next-1), p.match+1)
+		n.sendAppend(msg.From, n.members[msg.From])
+		return
+	}
+
+	p.next = max(p.next, msg.Index+1)
+	if msg.Index > p.match {
+		p.match = msg.Index
+		// commit is sent to every follower along with entries they miss
+		if n.maybeCommit() {
+			return
+		}
+	}
+
+	if p.next <= n.log.lastIndex() {
+		n.sendAppend(msg.From, n.members[msg.From])
+	}
+}
+
+func (n *Node) handleSnapshot(msg Message) {
+	snapshot := msg.Snapshot
+	if snapshot == nil || snapshot.Meta.Index <= n.commit {
+		n.reply(msg.Address, Message{Type: MsgAppendResp, Index: n.commit})
+		return
+	}
+
+	if err := n.sm.Restore(snapshot.Data); err != nil {
+		n.logger.Error("failed to restore snapshot of leader", zap.Error(err))
+		return
+	}
+
+	n.logger.Info("snapshot of leader restored", zap.String("id", n.config.ID), zap.Uint64("index", snapshot.Meta.Index))
+
+	n.log.compact(snapshot.Meta)
+	n.snapshotData = snapshot.Data
+	n.commit, n.applied = snapshot.Meta.Index, snapshot.Meta.Index
+	n.snapshotDirty = true
+	n.updateMembers()
+
+	n.reply(msg.Address, Message{Type: MsgAppendResp, Index: snapshot.Meta.Index})
+}
+
+// broadcastAppend sends entries followers miss, or heartbeat to ones which have them all
+func (n *Node) broadcastAppend() {
+	for _, id := range n.peers() {
+		n.sendAppend(id, n.members[id])
+	}
+}
+
+// peers returns other members in order of ids, so messages are sent in the same order every run
+func (n *Node) peers() []string {
+	ids := make([]string, 0, len(n.members))
+	for id := range n.members {
+		if id != n.config.ID {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (n *Node) sendAppend(id string, address string) {
+	p, ok := n.progress[id]
+	if !ok {
+		return
+	}
+
+	// follower is behind compacted entries, it gets snapshot instead of them
+	if p.next <= n.log.snapshot.Index {
+		if p.snapshotWait > 0 {
+			return
+		}
+		p.snapshotWait = n.config.ElectionTicks
+
+		n.reply(address, Message{
+			Type:     MsgSnapshot,
+			Snapshot: &Snapshot{Meta: n.log.snapshot, Data: n.snapshotData},
+		})
+		return
+	}
+
+	prev := p.next - 1
+	prevTerm, _ := n.log.term(prev)
+	n.reply(address, Message{
+		Type:    MsgAppend,
+		Index:   prev,
+		LogTerm: prevTerm,
+		Entries: n.log.slice(p.next, n.config.MaxEntriesPerMessage),
+		Commit:  n.commit,
+	})
+}
+
+// maybeCommit commits entries stored by majority, it reports whether commit has advanced.
+// Followers learn about new commit index at once
+func (n *Node) maybeCommit() bool {
+	matches := make([]uint64, 0, len(n.members))
+	for id := range n.members {
+		if id == n.config.ID {
+			// leader counts only entries it has saved, otherwise entry could be committed by
+			// minority which has it on disk
+			matches = append(matches, n.log.stable)
+		} else if p, ok := n.progress[id]; ok {
+			matches = append(matches, p.match)
+		}
+	}
+
+	// the highest index stored by majority
+	majority := len(n.members) / 2
+	if len(matches) <= majority {
+		return false
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	index := matches[majority]
+
+	// entry of previous term is committed only by entry of current term after it
+	if term, ok := n.log.term(index); !ok || index <= n.commit || term != n.term {
+		return false
+	}
+
+	n.commitTo(index)
+	if n.state == Leader {
+		n.broadcastAppend()
+	}
+	return true
+}
+
+func (n *Node) commitTo(index uint64) {
+	if index <= n.commit {
+		return
+	}
+
+	n.commit = index
+	n.applyCommitted()
+}
+
+func (n *Node) applyCommitted() {
+	removed := false
+	for n.applied < n.commit {
+		entry := n.log.entry(n.applied + 1)
+
+		var result any
+		switch entry.Type {
+		case EntryCommand:
+			result = n.sm.Apply(entry.Data)
+		case EntryConfig:
+			_, ok := decodeMembers(entry.Data)[n.config.ID]
+			removed = !ok
+		}
+
+		n.applied = entry.Index
+		n.complete(entry, result)
+	}
+
+	// leader removed from cluster hands over by stopping heartbeats
+	if removed && n.state == Leader && !n.promotable() {
+		n.logger.Info("leader is removed from cluster, stepping down", zap.String("id", n.config.ID))
+		n.becomeFollower(n.term, "")
+	}
+
+	n.maybeSnapshot()
+}
+
+// maybeSnapshot compacts log once enough entries are applied after the latest snapshot
+func (n *Node) maybeSnapshot() {
+	if n.applied-n.log.snapshot.Index < n.config.SnapshotThreshold {
+		return
+	}
+
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		n.logger.Error("failed to take cluster snapshot", zap.Error(err))
+		return
+	}
+
+	term, _ := n.log.term(n.applied)
+	members, _ := n.log.members(n.applied)
+	n.log.compact(SnapshotMeta{Index: n.applied, Term: term, Members: members})
+	n.snapshotData = data
+	n.snapshotDirty = true
+
+	n.logger.Info("cluster log compacted", zap.String("id", n.config.ID), zap.Uint64("index", n.applied))
+}
+
+func (n *Node) checkQuorum() bool {
+	active := 0
+	for id := range n.members {
+		if id == n.config.ID {
+			active++
+		} else if p, ok := n.progress[id]; ok && p.active {
+			active++
+		}
+	}
+
+	for _, p := range n.progress {
+		p.active = false
+	}
+
+	return n.quorum(active)
+}
+
+func (n *Node) granted() int {
+	granted := 0
+	for id, vote := range n.votes {
+		if _, ok := n.members[id]; ok && vote {
+			granted++
+		}
+	}
+	return granted
+}
+
+func (n *Node) quorum(count int) bool {
+	return count > len(n.members)/2
+}
+
+func (n *Node) resetElectionTimeout() {
+	n.electionTimeout = n.config.ElectionTicks + n.rand.IntN(n.config.ElectionTicks)
+}
+
+// reply queues message to node at address, it is sent after state is saved. Message is of
+// current term unless it has another one
+func (n *Node) reply(address string, msg Message) {
+	msg.From = n.config.ID
+	msg.Address = n.config.Address
+	if msg.Term == 0 {
+		msg.Term = n.term
+	}
+	n.msgs = append(n.msgs, outgoing{address: address, msg: msg})
+}
+
+// flush queues state changed since the previous flush along with messages to send once it is
+// saved. It reports whether caller must call save after it releases mu
+func (n *Node) flush() bool {
+	n.publish()
+
+	pending := pendingSave{msgs: n.msgs}
+	n.msgs = nil
+
+	if hardState := (HardState{Term: n.term, VotedFor: n.votedFor}); hardState != n.hardState {
+		pending.update.HardState = &hardState
+		n.hardState = hardState
+	}
+
+	if n.snapshotDirty {
+		// log is saved anew along with snapshot
+		pending.update.Snapshot = &Snapshot{Meta: n.log.snapshot, Data: n.snapshotData}
+		pending.update.Entries = n.log.slice(n.log.snapshot.Index+1, len(n.log.entries))
+		n.log.saved = n.log.lastIndex()
+		n.snapshotDirty = false
+	} else {
+		pending.update.Entries = n.log.unsaved()
+	}
+
+	if pending.update.empty() && len(pending.msgs) == 0 {
+		return false
+	}
+	n.saves = append(n.saves, pending)
+
+	if n.saving {
+		return false
+	}
+	n.saving = true
+	return true
+}
+
+// save persists queued updates in order and sends their messages, it is called without mu, so
+// node handles messages and proposals while disk is written. Updates queued in the meantime
+// are saved at once by the next round
+func (n *Node) save() {
+	for {
+		n.mu.Lock()
+		saves := n.saves
+		n.saves = nil
+		if len(saves) == 0 {
+			n.saving = false
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+
+		var update Update
+		for _, pending := range saves {
+			update.merge(pending.update)
+		}
+
+		var err error
+		if !update.empty() {
+			err = n.persister.Save(update)
+		}
+
+		n.mu.Lock()
+		if err != nil {
+			// messages are dropped, so node never acts on state it would forget. Updates queued
+			// meanwhile are dropped too, since saved log must have no gaps, the next flush saves
+			// all of them again
+			n.logger.Error("failed to save cluster state", zap.Error(err))
+			for _, pending := range n.saves {
+				update.merge(pending.update)
+			}
+			n.saves = nil
+			n.unsaved(update)
+			n.mu.Unlock()
+			continue
+		}
+
+		n.saved(update)
+		n.flush()
+		n.mu.Unlock()
+
+		for _, pending := range saves {
+			n.send(pending.msgs)
+		}
+	}
+}
+
+// saved marks entries of update as stable, leader may commit them now
+func (n *Node) saved(update Update) {
+	switch {
+	case len(update.Entries) > 0:
+		last := update.Entries[len(update.Entries)-1]
+		n.log.stableTo(last.Index, last.Term)
+	case update.Snapshot != nil:
+		n.log.stableTo(update.Snapshot.Meta.Index, update.Snapshot.Meta.Term)
+	}
+
+	if n.state == Leader {
+		n.maybeCommit()
+	}
+}
+
+// unsaved makes the next flush save state of update again
+func (n *Node) unsaved(update Update) {
+	n.log.saved = min(n.log.saved, n.log.stable)
+	if update.HardState != nil {
+		n.hardState = HardState{}
+	}
+	if update.Snapshot != nil {
+		n.snapshotDirty = true
+	}
+}
+
+func (n *Node) send(msgs []outgoing) {
+	for _, out := range msgs {
+		n.transport.Send(out.address, out.msg)
+	}
+}
+
+func (n *Node) complete(entry Entry, result any) {
+	proposal, ok := n.proposals[entry.Index]
+	if !ok {
+		return
+	}
+	delete(n.proposals, entry.Index)
+
+	if proposal.term != entry.Term {
+		proposal.finish(nil, ErrProposalDropped)
+		return
+	}
+	proposal.finish(result, nil)
+}
+
+func (n *Node) failProposals(err error) {
+	for index, proposal := range n.proposals {
+		proposal.finish(nil, err)
+		delete(n.proposals, index)
+	}
+}
+
+// Proposal is an entry proposed to leader, it is done once the entry is applied or lost
+type Proposal struct {
+	index  uint64
+	term   uint64
+	done   chan struct{}
+	result any
+	err    error
+}
+
+func (p *Proposal) finish(result any, err error) {
+	p.result, p.err = result, err
+	close(p.done)
+}
+
+// Index returns index of proposed entry in log
+func (p *Proposal) Index() uint64 {
+	return p.index
+}
+
+func (p *Proposal) Done() <-chan struct{} {
+	return p.done
+}
+
+// Result returns result of state machine, it must be called after proposal is done
+func (p *Proposal) Result() (any, error) {
+	return p.result, p.err
+}
+
+// Wait waits until proposal is done or ctx is done, entry may still be applied in the latter case
+func (p *Proposal) Wait(ctx context.Context) (any, error) {
+	select {
+	case <-p.done:
+		return p.result, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,449 @@
+package cluster_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// kv is a state machine applying "key=value" entries
+type kv struct {
+	mu       sync.Mutex
+	data     map[string]string
+	restored int
+}
+
+func newKV() *kv {
+	return &kv{data: make(map[string]string)}
+}
+
+func (s *kv) Apply(data []byte) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, value, _ := strings.Cut(string(data), "=")
+	s.data[key] = value
+	return len(s.data)
+}
+
+func (s *kv) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(s.data)
+	return buf.Bytes(), err
+}
+
+func (s *kv) Restore(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.restored++
+	s.data = make(map[string]string)
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&s.data)
+}
+
+func (s *kv) snapshot() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.data)
+}
+
+// testCluster runs nodes over memory network, time passes only by tick
+type testCluster struct {
+	t          *testing.T
+	network    *cluster.MemoryNetwork
+	threshold  uint64
+	nodes      map[string]*cluster.Node
+	machines   map[string]*kv
+	persisters map[string]*cluster.MemoryPersister
+	peers      map[string]map[string]string
+}
+
+func newTestCluster(t *testing.T, size int, threshold uint64) *testCluster {
+	c := &testCluster{
+		t:          t,
+		network:    cluster.NewMemoryNetwork(),
+		threshold:  threshold,
+		nodes:      make(map[string]*cluster.Node),
+		machines:   make(map[string]*kv),
+		persisters: make(map[string]*cluster.MemoryPersister),
+		peers:      make(map[string]map[string]string),
+	}
+
+	peers := make(map[string]string)
+	for i := 1; i <= size; i++ {
+		peers[fmt.Sprintf("n%d", i)] = fmt.Sprintf("n%d", i)
+	}
+
+	for id := range peers {
+		c.start(id, peers)
+	}
+
+	return c
+}
+
+// start runs node with state saved by its persister, if there is any
+func (c *testCluster) start(id string, peers map[string]string) {
+	persister, ok := c.persisters[id]
+	if !ok {
+		persister = cluster.NewMemoryPersister()
+		c.persisters[id] = persister
+		c.peers[id] = peers
+	}
+
+	machine := newKV()
+	node, err := cluster.NewNode(zap.NewNop(), cluster.Config{
+		ID:                id,
+		Address:           id,
+		Peers:             c.peers[id],
+		SnapshotThreshold: c.threshold,
+		Seed:              uint64(len(id)*31 + int(id[len(id)-1])),
+	}, machine, persister, c.network)
+	require.NoError(c.t, err)
+
+	c.nodes[id] = node
+	c.machines[id] = machine
+	c.network.Attach(id, node)
+}
+
+// crash stops node, its persister keeps what it has saved
+func (c *testCluster) crash(id string) {
+	c.network.Detach(id)
+	delete(c.nodes, id)
+}
+
+func (c *testCluster) tick(times int) {
+	for range times {
+		for _, id := range slices.Sorted(maps.Keys(c.nodes)) {
+			c.nodes[id].Tick()
+		}
+		c.network.Deliver()
+	}
+}
+
+// leader returns running leader of the highest term
+func (c *testCluster) leader() string {
+	leader, term := "", uint64(0)
+	for id, node := range c.nodes {
+		if status := node.Status(); status.State == cluster.Leader && status.Term >= term {
+			leader, term = id, status.Term
+		}
+	}
+	return leader
+}
+
+func (c *testCluster) waitLeader() string {
+	for range 100 {
+		c.tick(1)
+		if leader := c.leader(); leader != "" {
+			return leader
+		}
+	}
+
+	require.Fail(c.t, "leader is not elected")
+	return ""
+}
+
+func (c *testCluster) propose(id string, data string) *cluster.Proposal {
+	proposal, err := c.nodes[id].Propose([]byte(data))
+	require.NoError(c.t, err)
+	return proposal
+}
+
+func (c *testCluster) follower(except ...string) string {
+	for _, id := range slices.Sorted(maps.Keys(c.nodes)) {
+		if id != c.leader() && !slices.Contains(except, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestCluster_Election(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCluster(t, 3, 0)
+	leader := c.waitLeader()
+	c.tick(3)
+
+	term := c.nodes[leader].Status().Term
+	for id, node := range c.nodes {
+		status := node.Status()
+		assert.Equal(t, leader, status.Leader, id)
+		assert.Equal(t, term, status.Term, id)
+		if id != leader {
+			assert.Equal(t, cluster.Follower, status.State, id)
+		}
+	}
+
+	// stable leader keeps its term
+	c.tick(50)
+	assert.Equal(t, leader, c.leader())
+	assert.Equal(t, term, c.nodes[leader].Status().Term)
+}
+
+func TestCluster_Replication(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCluster(t, 3, 0)
+	leader := c.waitLeader()
+
+	first := c.propose(leader, "a=1")
+	second := c.propose(leader, "b=2")
+	c.network.Deliver()
+
+	for _, proposal := range []*cluster.Proposal{first, second} {
+		select {
+		case <-proposal.Done():
+		default:
+			require.Fail(t, "proposal is not done")
+		}
+	}
+
+	result, err := second.Result()
+	require.NoError(t, err)
+	assert.Equal(t, 2, result)
+
+	// followers apply entry once they learn it is committed
+	c.tick(1)
+	for id, machine := range c.machines {
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, machine.snapshot(), id)
+	}
+
+	_, err = c.nodes[c.follower()].Propose([]byte("c=3"))
+	assert.ErrorIs(t, err, cluster.ErrNotLeader)
+	assert.ErrorContains(t, err, "leader is "+leader)
+}
+
+func TestCluster_Failover(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCluster(t, 5, 0)
+	oldLeader := c.waitLeader()
+	c.propose(oldLeader, "a=1")
+	c.tick(1)
+	oldTerm := c.nodes[oldLeader].Status().Term
+
+	others := make([]string, 0, 4)
+	for id := range c.nodes {
+		if id != oldLeader {
+			others = append(others, id)
+		}
+	}
+	c.network.Partition([]string{oldLeader}, others)
+
+	// entry of leader in minority is never committed
+	lost := c.propose(oldLeader, "lost=1")
+	c.tick(50)
+
+	newLeader := c.leader()
+	require.NotEqual(t, oldLeader, newLeader)
+	require.NotEmpty(t, newLeader)
+	assert.Greater(t, c.nodes[newLeader].Status().Term, oldTerm)
+	// leader which can't reach majority steps down, its pre-votes keep term unchanged
+	assert.NotEqual(t, cluster.Leader, c.nodes[oldLeader].Status().State)
+	assert.Equal(t, oldTerm, c.nodes[oldLeader].Status().Term)
+	<-lost.Done()
+	_, err := lost.Result()
+	assert.ErrorIs(t, err, cluster.ErrLeadershipLost)
+
+	c.propose(newLeader, "b=2")
+	c.network.Heal()
+	c.tick(5)
+
+	for id, machine := range c.machines {
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, machine.snapshot(), id)
+	}
+	assert.Equal(t, newLeader, c.nodes[oldLeader].Status().Leader)
+}
+
+func TestCluster_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCluster(t, 3, 5)
+	leader := c.waitLeader()
+	lagging := c.follower()
+	c.network.Partition([]string{leader, c.follower(lagging)})
+
+	for i := range 20 {
+		c.propose(leader, fmt.Sprintf("k%d=%d", i, i))
+	}
+	c.tick(1)
+
+	// entries lagging node misses are compacted, so it gets snapshot of leader
+	// snapshot lost in partition is sent again after election timeout
+	c.network.Heal()
+	c.tick(cluster.DefaultElectionTicks + 1)
+
+	assert.Equal(t, 1, c.machines[lagging].restored)
+	assert.Equal(t, c.machines[leader].snapshot(), c.machines[lagging].snapshot())
+	assert.Len(t, c.machines[lagging].snapshot(), 20)
+	assert.Equal(t, c.nodes[leader].Status().Commit, c.nodes[lagging].Status().Commit)
+}
+
+func TestCluster_Membership(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCluster(t, 3, 0)
+	leader := c.waitLeader()
+	c.propose(leader, "a=1")
+	c.tick(1)
+
+	// new node starts without membership and learns it from leader
+	c.start("n4", nil)
+	proposal, err := c.nodes[leader].AddMember("n4", "n4")
+	require.NoError(t, err)
+	_, err = c.nodes[leader].RemoveMember("n1")
+	assert.ErrorIs(t, err, cluster.ErrMembershipChangePending)
+	c.tick(3)
+
+	_, err = proposal.Result()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, c.machines["n4"].snapshot())
+	assert.Len(t, c.nodes["n4"].Status().Members, 4)
+
+	// leader removing itself steps down once removal is committed, the others elect a new one
+	proposal, err = c.nodes[leader].RemoveMember(leader)
+	require.NoError(t, err)
+	c.tick(1)
+	_, err = proposal.Result()
+	require.NoError(t, err)
+	assert.NotEqual(t, cluster.Leader, c.nodes[leader].Status().State)
+
+	c.tick(50)
+	newLeader := c.leader()
+	require.NotEmpty(t, newLeader)
+	assert.NotEqual(t, leader, newLeader)
+	assert.NotContains(t, c.nodes[newLeader].Status().Members, leader)
+
+	// removed node never starts elections
+	assert.Equal(t, cluster.Follower, c.nodes[leader].Status().State)
+
+	c.propose(newLeader, "b=2")
+	c.tick(1)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, c.machines["n4"].snapshot())
+}
+
+func TestCluster_Restart(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCluster(t, 3, 4)
+	leader := c.waitLeader()
+	for i := range 10 {
+		c.propose(leader, fmt.Sprintf("k%d=%d", i, i))
+	}
+	c.tick(1)
+	term := c.nodes[leader].Status().Term
+
+	ids := slices.Sorted(maps.Keys(c.nodes))
+	for _, id := range ids {
+		c.crash(id)
+	}
+	for _, id := range ids {
+		c.start(id, nil)
+	}
+
+	// data comes back from snapshot and entries after it once they are committed again
+	newLeader := c.waitLeader()
+	assert.Greater(t, c.nodes[newLeader].Status().Term, term)
+	c.propose(newLeader, "after=restart")
+	c.tick(2)
+
+	for id, machine := range c.machines {
+		data := machine.snapshot()
+		assert.Len(t, data, 11, id)
+		assert.Equal(t, "9", data["k9"], id)
+	}
+}
+
+// blockingPersister holds saves until gate is closed, once it is set
+type blockingPersister struct {
+	*cluster.MemoryPersister
+	mu      sync.Mutex
+	gate    chan struct{}
+	entered chan struct{}
+	entries []int
+}
+
+func (p *blockingPersister) Save(update cluster.Update) error {
+	p.mu.Lock()
+	gate := p.gate
+	p.entries = append(p.entries, len(update.Entries))
+	p.mu.Unlock()
+
+	if gate != nil {
+		select {
+		case p.entered <- struct{}{}:
+		default:
+		}
+		<-gate
+	}
+
+	return p.MemoryPersister.Save(update)
+}
+
+func TestCluster_SaveWithoutLock(t *testing.T) {
+	t.Parallel()
+
+	persister := &blockingPersister{MemoryPersister: cluster.NewMemoryPersister()}
+	network := cluster.NewMemoryNetwork()
+	node, err := cluster.NewNode(zap.NewNop(), cluster.Config{ID: "n1", Address: "n1", Peers: map[string]string{"n1": "n1"}, Seed: 1}, newKV(), persister, network)
+	require.NoError(t, err)
+	network.Attach("n1", node)
+
+	for range 2 * cluster.DefaultElectionTicks {
+		node.Tick()
+	}
+	require.Equal(t, cluster.Leader, node.Status().State)
+
+	gate := make(chan struct{})
+	persister.mu.Lock()
+	persister.gate, persister.entered = gate, make(chan struct{}, 1)
+	saved := len(persister.entries)
+	persister.mu.Unlock()
+
+	proposed := make(chan *cluster.Proposal)
+	go func() {
+		proposal, err := node.Propose([]byte("a=1"))
+		assert.NoError(t, err)
+		proposed <- proposal
+	}()
+	<-persister.entered
+
+	// node keeps working while its state is saved, entry is committed only once leader has saved it
+	second, err := node.Propose([]byte("b=2"))
+	require.NoError(t, err)
+	node.Tick()
+	select {
+	case <-second.Done():
+		require.Fail(t, "entry is committed before it is saved")
+	default:
+	}
+
+	close(gate)
+	first := <-proposed
+	<-first.Done()
+	<-second.Done()
+
+	result, err := second.Result()
+	require.NoError(t, err)
+	assert.Equal(t, 2, result)
+
+	// only new entries are saved
+	persister.mu.Lock()
+	defer persister.mu.Unlock()
+	assert.Equal(t, []int{1, 1}, persister.entries[saved:])
+}
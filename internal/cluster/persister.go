@@ -0,0 +1,397 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	hardStateFileName = "hardstate"
+	logFileName       = "log"
+	snapshotFileName  = "snapshot"
+	tempExtension     = ".tmp"
+	// recordHeaderSize is length and checksum of record in log file
+	recordHeaderSize = 8
+)
+
+var ErrCorruptedRecord = errors.New("cluster: corrupted log record")
+
+// HardState is term and vote node must never forget, otherwise it could vote twice in a term
+type HardState struct {
+	Term     uint64
+	VotedFor string
+}
+
+// Update is state node has changed since the previous save
+type Update struct {
+	// HardState is nil if term and vote are unchanged
+	HardState *HardState
+	// Entries are appended to saved ones, saved entries from index of the first of them are replaced
+	Entries []Entry
+	// Snapshot replaces saved one, saved entries are replaced by Entries as a whole then
+	Snapshot *Snapshot
+}
+
+func (u *Update) empty() bool {
+	return u.HardState == nil && u.Snapshot == nil && len(u.Entries) == 0
+}
+
+// merge adds next update to u, so both of them are saved at once
+func (u *Update) merge(next Update) {
+	if next.HardState != nil {
+		u.HardState = next.HardState
+	}
+
+	if next.Snapshot != nil {
+		u.Snapshot, u.Entries = next.Snapshot, next.Entries
+		return
+	}
+
+	u.Entries = replaceEntries(u.Entries, next.Entries)
+}
+
+// Persister keeps state of node across restarts: hard state, log entries and snapshot of state
+// machine. Entries are appended, so cost of save depends only on number of new entries
+type Persister interface {
+	// Load returns saved state, entries may include ones which snapshot already has. New node
+	// gets zero hard state, no entries and nil snapshot
+	Load() (HardState, []Entry, *Snapshot, error)
+	// Save stores update. Snapshot is stored first and entries last, so hard state is never older
+	// than entries and entries never refer to ones missing from both of them
+	Save(update Update) error
+}
+
+// MemoryPersister keeps state in memory, it survives restart of node within process only
+type MemoryPersister struct {
+	mu        sync.Mutex
+	hardState HardState
+	entries   []Entry
+	snapshot  *Snapshot
+}
+
+func NewMemoryPersister() *MemoryPersister {
+	return &MemoryPersister{}
+}
+
+func (p *MemoryPersister) Load() (HardState, []Entry, *Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.hardState, append([]Entry(nil), p.entries...), p.snapshot, nil
+}
+
+func (p *MemoryPersister) Save(update Update) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if update.Snapshot != nil {
+		p.snapshot, p.entries = update.Snapshot, nil
+	}
+
+	if update.HardState != nil {
+		p.hardState = *update.HardState
+	}
+
+	p.entries = replaceEntries(p.entries, update.Entries)
+	return nil
+}
+
+// FilePersister keeps hard state and snapshot in files which are replaced atomically, entries are
+// appended to log file as records, it is rewritten only along with a new snapshot
+type FilePersister struct {
+	directory string
+	// log is opened by the first append, size is length of its valid records
+	log  *os.File
+	size int64
+}
+
+func NewFilePersister(directory string) (*FilePersister, error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cluster directory: %w", err)
+	}
+
+	return &FilePersister{directory: directory, size: -1}, nil
+}
+
+func (p *FilePersister) Load() (HardState, []Entry, *Snapshot, error) {
+	var hardState HardState
+	if err := p.decode(hardStateFileName, &hardState); err != nil {
+		return HardState{}, nil, nil, err
+	}
+
+	var snapshot *Snapshot
+	if data, err := p.read(snapshotFileName); err != nil {
+		return HardState{}, nil, nil, err
+	} else if data != nil {
+		snapshot = &Snapshot{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(snapshot); err != nil {
+			return HardState{}, nil, nil, fmt.Errorf("failed to decode cluster %s: %w", snapshotFileName, err)
+		}
+	}
+
+	entries, err := p.readLog()
+	if err != nil {
+		return HardState{}, nil, nil, err
+	}
+
+	return hardState, entries, snapshot, nil
+}
+
+func (p *FilePersister) Save(update Update) error {
+	if update.Snapshot != nil {
+		if err := p.encode(snapshotFileName, update.Snapshot); err != nil {
+			return err
+		}
+	}
+
+	if update.HardState != nil {
+		if err := p.encode(hardStateFileName, update.HardState); err != nil {
+			return err
+		}
+	}
+
+	if update.Snapshot != nil {
+		return p.rewriteLog(update.Entries)
+	}
+
+	if len(update.Entries) == 0 {
+		return nil
+	}
+	return p.appendLog(update.Entries)
+}
+
+// Close closes log file, persister may be used again after that
+func (p *FilePersister) Close() error {
+	if p.log == nil {
+		return nil
+	}
+
+	err := p.log.Close()
+	p.log = nil
+	return err
+}
+
+// readLog reads entries of log file, torn record at its tail is cut off, so records appended
+// later never follow it
+func (p *FilePersister) readLog() ([]Entry, error) {
+	path := filepath.Join(p.directory, logFileName)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		p.size = 0
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open cluster %s: %w", logFileName, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var entries []Entry
+	var size int64
+
+	for {
+		batch, n, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			if err := os.Truncate(path, size); err != nil {
+				return nil, fmt.Errorf("failed to truncate cluster %s: %w", logFileName, err)
+			}
+			break
+		}
+
+		size += int64(n)
+		entries = replaceEntries(entries, batch)
+	}
+
+	p.size = size
+	return entries, nil
+}
+
+func (p *FilePersister) appendLog(entries []Entry) error {
+	if p.log == nil {
+		file, err := os.OpenFile(filepath.Join(p.directory, logFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open cluster %s: %w", logFileName, err)
+		}
+
+		// log is not read before the first save, or the previous append has failed
+		if p.size < 0 {
+			stat, err := file.Stat()
+			if err != nil {
+				_ = file.Close()
+				return fmt.Errorf("failed to stat cluster %s: %w", logFileName, err)
+			}
+			p.size = stat.Size()
+		} else if err := file.Truncate(p.size); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("failed to truncate cluster %s: %w", logFileName, err)
+		}
+
+		p.log = file
+	}
+
+	record, err := encodeRecord(entries)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.log.Write(record); err != nil {
+		// partial record is cut off once log is opened again
+		_ = p.Close()
+		return fmt.Errorf("failed to write cluster %s: %w", logFileName, err)
+	}
+
+	if err := p.log.Sync(); err != nil {
+		_ = p.Close()
+		return fmt.Errorf("failed to sync cluster %s: %w", logFileName, err)
+	}
+
+	p.size += int64(len(record))
+	return nil
+}
+
+func (p *FilePersister) rewriteLog(entries []Entry) error {
+	if err := p.Close(); err != nil {
+		return fmt.Errorf("failed to close cluster %s: %w", logFileName, err)
+	}
+
+	var record []byte
+	if len(entries) > 0 {
+		var err error
+		if record, err = encodeRecord(entries); err != nil {
+			return err
+		}
+	}
+
+	if err := p.write(logFileName, record); err != nil {
+		return err
+	}
+
+	p.size = int64(len(record))
+	return nil
+}
+
+func (p *FilePersister) encode(name string, value any) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return fmt.Errorf("failed to encode cluster %s: %w", name, err)
+	}
+
+	return p.write(name, buf.Bytes())
+}
+
+func (p *FilePersister) decode(name string, value any) error {
+	data, err := p.read(name)
+	if err != nil || data == nil {
+		return err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(value); err != nil {
+		return fmt.Errorf("failed to decode cluster %s: %w", name, err)
+	}
+	return nil
+}
+
+func (p *FilePersister) read(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(p.directory, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read cluster %s: %w", name, err)
+	}
+
+	return data, nil
+}
+
+func (p *FilePersister) write(name string, data []byte) error {
+	path := filepath.Join(p.directory, name)
+
+	file, err := os.Create(path + tempExtension)
+	if err != nil {
+		return fmt.Errorf("failed to create cluster %s: %w", name, err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write cluster %s: %w", name, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to sync cluster %s: %w", name, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close cluster %s: %w", name, err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename cluster %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// encodeRecord frames entries by their length and checksum, so torn record is detected on load
+func encodeRecord(entries []Entry) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(entries); err != nil {
+		return nil, fmt.Errorf("failed to encode cluster entries: %w", err)
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(record[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+
+	return append(record, payload.Bytes()...), nil
+}
+
+// readRecord reads entries of the next record and its size, io.EOF is returned only on clean end
+func readRecord(reader *bufio.Reader) ([]Entry, int, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	var entries []Entry
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&entries); err != nil {
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	return entries, recordHeaderSize + len(payload), nil
+}
+
+// replaceEntries appends entries to saved ones, saved entries from index of the first of them
+// are dropped
+func replaceEntries(saved []Entry, entries []Entry) []Entry {
+	if len(entries) == 0 {
+		return saved
+	}
+
+	i := sort.Search(len(saved), func(i int) bool {
+		return saved[i].Index >= entries[0].Index
+	})
+	return append(saved[:i:i], entries...)
+}
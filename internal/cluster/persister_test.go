@@ -0,0 +1,68 @@
+package cluster_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entries(term uint64, from uint64, to uint64) []cluster.Entry {
+	var result []cluster.Entry
+	for i := from; i <= to; i++ {
+		result = append(result, cluster.Entry{Index: i, Term: term, Data: []byte{byte(i)}})
+	}
+	return result
+}
+
+func TestFilePersister(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	p, err := cluster.NewFilePersister(directory)
+	require.NoError(t, err)
+
+	hardState, saved, snapshot, err := p.Load()
+	require.NoError(t, err)
+	assert.Equal(t, cluster.HardState{}, hardState)
+	assert.Empty(t, saved)
+	assert.Nil(t, snapshot)
+
+	require.NoError(t, p.Save(cluster.Update{HardState: &cluster.HardState{Term: 1, VotedFor: "n1"}, Entries: entries(1, 1, 3)}))
+	// entries from index of the first saved one are replaced
+	require.NoError(t, p.Save(cluster.Update{HardState: &cluster.HardState{Term: 2}, Entries: entries(2, 3, 4)}))
+	require.NoError(t, p.Close())
+
+	// torn record at the tail is cut off, so entries saved after it are not lost
+	file, err := os.OpenFile(filepath.Join(directory, "log"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 1, 0, 7})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	p, err = cluster.NewFilePersister(directory)
+	require.NoError(t, err)
+	hardState, saved, _, err = p.Load()
+	require.NoError(t, err)
+	assert.Equal(t, cluster.HardState{Term: 2}, hardState)
+	assert.Equal(t, append(entries(1, 1, 2), entries(2, 3, 4)...), saved)
+
+	require.NoError(t, p.Save(cluster.Update{Entries: entries(2, 5, 5)}))
+	_, saved, _, err = p.Load()
+	require.NoError(t, err)
+	assert.Equal(t, append(entries(1, 1, 2), entries(2, 3, 5)...), saved)
+
+	// log is written anew along with snapshot
+	snapshot = &cluster.Snapshot{Meta: cluster.SnapshotMeta{Index: 4, Term: 2}, Data: []byte("data")}
+	require.NoError(t, p.Save(cluster.Update{Snapshot: snapshot, Entries: entries(2, 5, 5)}))
+	require.NoError(t, p.Save(cluster.Update{Entries: entries(2, 6, 6)}))
+
+	hardState, saved, loaded, err := p.Load()
+	require.NoError(t, err)
+	assert.Equal(t, cluster.HardState{Term: 2}, hardState)
+	assert.Equal(t, entries(2, 5, 6), saved)
+	assert.Equal(t, snapshot, loaded)
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// peerQueueSize is number of messages waiting to be sent to a peer, newer ones are dropped
+	peerQueueSize = 1024
+	dialTimeout   = time.Second
+)
+
+// TCPTransport sends messages to peers over tcp connections, one per peer, encoded with gob
+type TCPTransport struct {
+	logger  *zap.Logger
+	address string
+
+	mu     sync.Mutex
+	peers  map[string]chan Message
+	closed bool
+}
+
+func NewTCPTransport(logger *zap.Logger, address string) *TCPTransport {
+	return &TCPTransport{
+		logger:  logger,
+		address: address,
+		peers:   make(map[string]chan Message),
+	}
+}
+
+// Serve accepts connections of peers and passes their messages to handler until ctx is done
+func (t *TCPTransport) Serve(ctx context.Context, handler func(Message)) error {
+	listener, err := net.Listen("tcp", t.address)
+	if err != nil {
+		return fmt.Errorf("failed to start cluster transport: %w", err)
+	}
+
+	t.logger.Info("cluster transport started", zap.String("address", t.address))
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+		t.close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			t.logger.Error("cluster accept error", zap.Error(err))
+			continue
+		}
+
+		go t.receive(ctx, conn, handler)
+	}
+}
+
+func (t *TCPTransport) Send(address string, msg Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	queue, ok := t.peers[address]
+	if !ok {
+		queue = make(chan Message, peerQueueSize)
+		t.peers[address] = queue
+		go t.sendLoop(address, queue)
+	}
+
+	select {
+	case queue <- msg:
+	default:
+		t.logger.Debug("cluster message dropped, peer is too slow", zap.String("peer", address))
+	}
+}
+
+func (t *TCPTransport) receive(ctx context.Context, conn net.Conn, handler func(Message)) {
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	decoder := gob.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg Message
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+		handler(msg)
+	}
+}
+
+// sendLoop keeps connection to peer, message which can't be sent is dropped and connection is
+// dialed again for the next one
+func (t *TCPTransport) sendLoop(address string, queue chan Message) {
+	var conn net.Conn
+	var writer *bufio.Writer
+	var encoder *gob.Encoder
+
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for msg := range queue {
+		if conn == nil {
+			var err error
+			if conn, err = net.DialTimeout("tcp", address, dialTimeout); err != nil {
+				conn = nil
+				continue
+			}
+			writer = bufio.NewWriter(conn)
+			encoder = gob.NewEncoder(writer)
+		}
+
+		err := encoder.Encode(msg)
+		if err == nil && len(queue) == 0 {
+			err = writer.Flush()
+		}
+
+		if err != nil {
+			t.logger.Debug("failed to send cluster message", zap.String("peer", address), zap.Error(err))
+			_ = conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (t *TCPTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for _, queue := range t.peers {
+		close(queue)
+	}
+}
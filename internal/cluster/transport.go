@@ -0,0 +1,147 @@
+package cluster
+
+import (
+	"slices"
+	"sync"
+)
+
+type MessageType uint8
+
+const (
+	// MsgPreVote asks whether node would vote for candidate in Term, without changing terms. Node
+	// cut off from cluster never wins it, so it doesn't raise term and disrupt leader on return
+	MsgPreVote MessageType = iota
+	MsgPreVoteResp
+	// MsgVote asks for vote of candidate, Index and LogTerm describe its last entry
+	MsgVote
+	MsgVoteResp
+	// MsgAppend carries entries following entry at Index with term LogTerm, it is empty for heartbeat
+	MsgAppend
+	// MsgAppendResp reports Index of the last entry matching leader, or Hint to retry from on reject
+	MsgAppendResp
+	// MsgSnapshot replaces log of follower which is behind the entries leader keeps
+	MsgSnapshot
+)
+
+// Message is exchanged by nodes, Address is where reply to sender goes
+type Message struct {
+	Type     MessageType
+	From     string
+	Address  string
+	Term     uint64
+	Index    uint64
+	LogTerm  uint64
+	Entries  []Entry
+	Commit   uint64
+	Reject   bool
+	Hint     uint64
+	Snapshot *Snapshot
+}
+
+// Transport delivers messages to nodes by their addresses. Messages may be lost, delayed or
+// reordered, so Send never blocks and never reports failure
+type Transport interface {
+	Send(address string, msg Message)
+}
+
+// MemoryNetwork is an in-process transport for tests. Messages are queued until Deliver, which
+// passes them to nodes one by one in order they were sent, so runs are deterministic
+type MemoryNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	queue []envelope
+	// groups of nodes which reach each other, nil means there is no partition
+	groups map[string]int
+}
+
+type envelope struct {
+	address string
+	msg     Message
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{nodes: make(map[string]*Node)}
+}
+
+// Attach connects node to network at address, node which was there before is replaced
+func (n *MemoryNetwork) Attach(address string, node *Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.nodes[address] = node
+}
+
+// Detach disconnects node at address, messages to it are dropped like it has crashed
+func (n *MemoryNetwork) Detach(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.nodes, address)
+}
+
+// Partition splits network, nodes reach only ones in the same group. Nodes missing from groups
+// reach nobody
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, address := range group {
+			n.groups[address] = i
+		}
+	}
+}
+
+// Heal removes partition
+func (n *MemoryNetwork) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.groups = nil
+}
+
+func (n *MemoryNetwork) Send(address string, msg Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	msg.Entries = slices.Clone(msg.Entries)
+	n.queue = append(n.queue, envelope{address: address, msg: msg})
+}
+
+// Deliver passes queued messages to nodes until there are none, replies are delivered as well.
+// It returns number of delivered messages
+func (n *MemoryNetwork) Deliver() int {
+	delivered := 0
+	for {
+		n.mu.Lock()
+		if len(n.queue) == 0 {
+			n.mu.Unlock()
+			return delivered
+		}
+
+		next := n.queue[0]
+		n.queue = n.queue[1:]
+		node, ok := n.nodes[next.address]
+		reachable := ok && n.reachable(next.msg.Address, next.address)
+		n.mu.Unlock()
+
+		if reachable {
+			node.Step(next.msg)
+			delivered++
+		}
+	}
+}
+
+func (n *MemoryNetwork) reachable(from string, to string) bool {
+	if n.groups == nil {
+		return true
+	}
+
+	fromGroup, ok := n.groups[from]
+	if !ok {
+		return false
+	}
+	toGroup, ok := n.groups[to]
+	return ok && fromGroup == toGroup
+}
@@ -3,16 +3,44 @@ package config
 import (
 	"bytes"
 	"errors"
+	"github.com/kirban/potato-db/internal/helpers"
 	"gopkg.in/yaml.v3"
 	"io"
 	"os"
 	"slices"
+	"time"
+)
+
+const (
+	EngineTypeInMemory = "in-memory"
+	EngineTypeDisk     = "disk"
+	EngineTypeOrdered  = "ordered"
+)
+
+const (
+	// ProtocolAuto detects protocol from the first byte sent by client
+	ProtocolAuto = "auto"
+	ProtocolText = "text"
+	ProtocolRESP = "resp"
+	// ProtocolFramed is binary safe length-prefixed framing, client starts it with a handshake
+	ProtocolFramed = "framed"
+)
+
+const (
+	// ReplicationRoleLeader lets followers connect to tcp server and stream its data
+	ReplicationRoleLeader = "leader"
+	// ReplicationRoleFollower keeps data in sync with leader and rejects writes
+	ReplicationRoleFollower = "follower"
 )
 
 var (
-	ValidLogLevels   = []string{"debug", "info", "warn", "error", "panic", "fatal"}
-	ValidLogOutputs  = []string{"stdout", "stderr"}
-	ValidEngineTypes = []string{"in-memory", "disk"}
+	ValidLogLevels        = []string{"debug", "info", "warn", "error", "panic", "fatal"}
+	ValidLogOutputs       = []string{"stdout", "stderr"}
+	ValidEngineTypes      = []string{EngineTypeInMemory, EngineTypeDisk, EngineTypeOrdered}
+	ValidEvictionPolicies = []string{"noeviction", "allkeys-lru", "allkeys-lfu", "volatile-ttl", "allkeys-random"}
+	ValidProtocols        = []string{ProtocolAuto, ProtocolText, ProtocolRESP, ProtocolFramed}
+	ValidKeyspaceEvents   = []string{"generic", "string", "list", "hash", "set", "zset", "expired", "evicted", "all"}
+	ValidReplicationRoles = []string{ReplicationRoleLeader, ReplicationRoleFollower}
 )
 
 type Configurable[T any] interface {
@@ -21,9 +49,16 @@ type Configurable[T any] interface {
 }
 
 type Config struct {
-	App       *AppConfigOptions    `yaml:"app"`
-	TcpServer *ServerConfigOptions `yaml:"tcp_server"`
-	Db        *DbConfigOptions     `yaml:"db"`
+	App         *AppConfigOptions         `yaml:"app"`
+	TcpServer   *ServerConfigOptions      `yaml:"tcp_server"`
+	Db          *DbConfigOptions          `yaml:"db"`
+	Wal         *WalConfigOptions         `yaml:"wal"`
+	Snapshot    *SnapshotConfigOptions    `yaml:"snapshot"`
+	HttpServer  *HttpServerConfigOptions  `yaml:"http_server"`
+	PubSub      *PubSubConfigOptions      `yaml:"pubsub"`
+	CDC         *CDCConfigOptions         `yaml:"cdc"`
+	Replication *ReplicationConfigOptions `yaml:"replication"`
+	Cluster     *ClusterConfigOptions     `yaml:"cluster"`
 }
 
 type AppConfigOptions struct {
@@ -32,7 +67,66 @@ type AppConfigOptions struct {
 }
 
 type DbConfigOptions struct {
-	EngineType string `yaml:"engine_type"`
+	EngineType     string `yaml:"engine_type"`
+	DataDirectory  string `yaml:"data_directory"`
+	MaxFileSize    string `yaml:"max_file_size"`
+	MaxMemory      string `yaml:"max_memory"`
+	EvictionPolicy string `yaml:"eviction_policy"`
+	Shards         int    `yaml:"shards"`
+}
+
+type WalConfigOptions struct {
+	FlushingBatchSize    int           `yaml:"flushing_batch_size"`
+	FlushingBatchTimeout time.Duration `yaml:"flushing_batch_timeout"`
+	MaxSegmentSize       string        `yaml:"max_segment_size"`
+	DataDirectory        string        `yaml:"data_directory"`
+}
+
+type SnapshotConfigOptions struct {
+	DataDirectory string        `yaml:"data_directory"`
+	Interval      time.Duration `yaml:"interval"`
+	Retain        int           `yaml:"retain"`
+}
+
+type HttpServerConfigOptions struct {
+	Host        string        `yaml:"host"`
+	Port        int           `yaml:"port"`
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxBodySize string        `yaml:"max_body_size"`
+}
+
+type PubSubConfigOptions struct {
+	// SubscriberBufferSize is number of messages subscriber may lag behind before it is disconnected
+	SubscriberBufferSize int `yaml:"subscriber_buffer_size"`
+	// KeyspaceEvents lists classes of key changes published to __keyspace__ and __keyevent__
+	// channels, empty list turns notifications off
+	KeyspaceEvents []string `yaml:"keyspace_events"`
+}
+
+type CDCConfigOptions struct {
+	// Capacity is number of the latest committed changes kept for consumers to resume from
+	Capacity int `yaml:"capacity"`
+}
+
+type ReplicationConfigOptions struct {
+	Role string `yaml:"role"`
+	// LeaderAddress is host:port of tcp server of leader, it is required for follower
+	LeaderAddress string `yaml:"leader_address"`
+}
+
+type ClusterConfigOptions struct {
+	NodeID string `yaml:"node_id"`
+	// Address is host:port where other members send raft messages to this node
+	Address string `yaml:"address"`
+	// Peers maps id to address of every initial member including this node. Node joining
+	// running cluster starts without peers and waits to be added by CLUSTER ADD
+	Peers         map[string]string `yaml:"peers"`
+	DataDirectory string            `yaml:"data_directory"`
+	// TickInterval is unit of election and heartbeat timeouts
+	TickInterval      time.Duration `yaml:"tick_interval"`
+	ElectionTicks     int           `yaml:"election_ticks"`
+	HeartbeatTicks    int           `yaml:"heartbeat_ticks"`
+	SnapshotThreshold int           `yaml:"snapshot_threshold"`
 }
 
 type ServerConfigOptions struct {
@@ -40,6 +134,9 @@ type ServerConfigOptions struct {
 	Port           int    `yaml:"port"`
 	BufferSize     int    `yaml:"buffer_size"`
 	MaxConnections int    `yaml:"max_connections"`
+	Protocol       string `yaml:"protocol"`
+	// MaxMessageSize limits size of single RESP bulk string or framed request
+	MaxMessageSize string `yaml:"max_message_size"`
 }
 
 var ServerConfigDefaults = &ServerConfigOptions{
@@ -47,10 +144,51 @@ var ServerConfigDefaults = &ServerConfigOptions{
 	Port:           8282,
 	BufferSize:     4 << 10,
 	MaxConnections: 100,
+	Protocol:       ProtocolAuto,
+	MaxMessageSize: "16MB",
 }
 
 var DbConfigDefaults = &DbConfigOptions{
-	EngineType: "in-memory",
+	EngineType:     EngineTypeInMemory,
+	DataDirectory:  "data/db",
+	MaxFileSize:    "64MB",
+	EvictionPolicy: "noeviction",
+	Shards:         16,
+}
+
+var WalConfigDefaults = &WalConfigOptions{
+	FlushingBatchSize:    100,
+	FlushingBatchTimeout: 10 * time.Millisecond,
+	MaxSegmentSize:       "10MB",
+	DataDirectory:        "data/wal",
+}
+
+var HttpServerConfigDefaults = &HttpServerConfigOptions{
+	Host:        "127.0.0.1",
+	Port:        8283,
+	Timeout:     5 * time.Second,
+	MaxBodySize: "1MB",
+}
+
+var SnapshotConfigDefaults = &SnapshotConfigOptions{
+	DataDirectory: "data/snapshots",
+	Retain:        2,
+}
+
+var PubSubConfigDefaults = &PubSubConfigOptions{
+	SubscriberBufferSize: 1024,
+}
+
+var CDCConfigDefaults = &CDCConfigOptions{
+	Capacity: 10000,
+}
+
+var ClusterConfigDefaults = &ClusterConfigOptions{
+	DataDirectory:     "data/cluster",
+	TickInterval:      100 * time.Millisecond,
+	ElectionTicks:     10,
+	HeartbeatTicks:    1,
+	SnapshotThreshold: 10000,
 }
 
 var AppConfigDefaults = &AppConfigOptions{
@@ -103,6 +241,18 @@ func (c *Config) validateConfig() error {
 		if c.TcpServer.MaxConnections == 0 {
 			c.TcpServer.MaxConnections = ServerConfigDefaults.MaxConnections
 		}
+
+		if c.TcpServer.Protocol == "" {
+			c.TcpServer.Protocol = ServerConfigDefaults.Protocol
+		} else if !slices.Contains(ValidProtocols, c.TcpServer.Protocol) {
+			return errors.New("invalid tcp server protocol")
+		}
+
+		if c.TcpServer.MaxMessageSize == "" {
+			c.TcpServer.MaxMessageSize = ServerConfigDefaults.MaxMessageSize
+		} else if size, err := helpers.ParseSize(c.TcpServer.MaxMessageSize); err != nil || size <= 0 {
+			return errors.New("invalid tcp server max message size")
+		}
 	}
 
 	if c.Db == nil {
@@ -113,6 +263,209 @@ func (c *Config) validateConfig() error {
 		} else if !slices.Contains(ValidEngineTypes, c.Db.EngineType) {
 			return errors.New("invalid Db engine type")
 		}
+
+		if c.Db.DataDirectory == "" {
+			c.Db.DataDirectory = DbConfigDefaults.DataDirectory
+		}
+
+		if c.Db.MaxFileSize == "" {
+			c.Db.MaxFileSize = DbConfigDefaults.MaxFileSize
+		} else if size, err := helpers.ParseSize(c.Db.MaxFileSize); err != nil || size <= 0 {
+			return errors.New("invalid Db max file size")
+		}
+
+		// empty max memory means no limit
+		if c.Db.MaxMemory != "" {
+			if size, err := helpers.ParseSize(c.Db.MaxMemory); err != nil || size < 0 {
+				return errors.New("invalid Db max memory")
+			}
+		}
+
+		if c.Db.EvictionPolicy == "" {
+			c.Db.EvictionPolicy = DbConfigDefaults.EvictionPolicy
+		} else if !slices.Contains(ValidEvictionPolicies, c.Db.EvictionPolicy) {
+			return errors.New("invalid Db eviction policy")
+		}
+
+		// in-memory hash table needs power of two shards count
+		if c.Db.Shards == 0 {
+			c.Db.Shards = DbConfigDefaults.Shards
+		} else if c.Db.Shards < 0 || c.Db.Shards&(c.Db.Shards-1) != 0 {
+			return errors.New("invalid Db shards count")
+		}
+	}
+
+	// wal section is optional, without it write-ahead log is disabled
+	if c.Wal != nil {
+		if c.Wal.FlushingBatchSize == 0 {
+			c.Wal.FlushingBatchSize = WalConfigDefaults.FlushingBatchSize
+		} else if c.Wal.FlushingBatchSize < 0 {
+			return errors.New("invalid wal flushing batch size")
+		}
+
+		if c.Wal.FlushingBatchTimeout == 0 {
+			c.Wal.FlushingBatchTimeout = WalConfigDefaults.FlushingBatchTimeout
+		} else if c.Wal.FlushingBatchTimeout < 0 {
+			return errors.New("invalid wal flushing batch timeout")
+		}
+
+		if c.Wal.MaxSegmentSize == "" {
+			c.Wal.MaxSegmentSize = WalConfigDefaults.MaxSegmentSize
+		} else if size, err := helpers.ParseSize(c.Wal.MaxSegmentSize); err != nil || size <= 0 {
+			return errors.New("invalid wal max segment size")
+		}
+
+		if c.Wal.DataDirectory == "" {
+			c.Wal.DataDirectory = WalConfigDefaults.DataDirectory
+		}
+
+		// disk engine keeps data on its own, replaying log on top of it would apply changes twice
+		if c.Db != nil && c.Db.EngineType == EngineTypeDisk {
+			return errors.New("wal is not supported by disk engine")
+		}
+	}
+
+	// snapshot section is optional, zero interval leaves only manual SNAPSHOT command
+	if c.Snapshot != nil {
+		if c.Snapshot.DataDirectory == "" {
+			c.Snapshot.DataDirectory = SnapshotConfigDefaults.DataDirectory
+		}
+
+		if c.Snapshot.Interval < 0 {
+			return errors.New("invalid snapshot interval")
+		}
+
+		if c.Snapshot.Retain == 0 {
+			c.Snapshot.Retain = SnapshotConfigDefaults.Retain
+		} else if c.Snapshot.Retain < 0 {
+			return errors.New("invalid snapshot retain count")
+		}
+	}
+
+	if c.HttpServer != nil {
+		if c.HttpServer.Host == "" {
+			c.HttpServer.Host = HttpServerConfigDefaults.Host
+		}
+
+		if c.HttpServer.Port == 0 {
+			c.HttpServer.Port = HttpServerConfigDefaults.Port
+		} else if c.HttpServer.Port < 1024 || c.HttpServer.Port > 65535 {
+			return errors.New("invalid http server port")
+		}
+
+		if c.HttpServer.Timeout == 0 {
+			c.HttpServer.Timeout = HttpServerConfigDefaults.Timeout
+		} else if c.HttpServer.Timeout < 0 {
+			return errors.New("invalid http server timeout")
+		}
+
+		if c.HttpServer.MaxBodySize == "" {
+			c.HttpServer.MaxBodySize = HttpServerConfigDefaults.MaxBodySize
+		} else if size, err := helpers.ParseSize(c.HttpServer.MaxBodySize); err != nil || size <= 0 {
+			return errors.New("invalid http server max body size")
+		}
+	}
+
+	// pub/sub is always enabled, section only tunes it
+	if c.PubSub == nil {
+		c.PubSub = &PubSubConfigOptions{}
+	}
+
+	if c.PubSub.SubscriberBufferSize == 0 {
+		c.PubSub.SubscriberBufferSize = PubSubConfigDefaults.SubscriberBufferSize
+	} else if c.PubSub.SubscriberBufferSize < 0 {
+		return errors.New("invalid pubsub subscriber buffer size")
+	}
+
+	for _, class := range c.PubSub.KeyspaceEvents {
+		if !slices.Contains(ValidKeyspaceEvents, class) {
+			return errors.New("invalid pubsub keyspace event class")
+		}
+	}
+
+	// cdc section is optional, without it CDC command is disabled
+	if c.CDC != nil {
+		if c.CDC.Capacity == 0 {
+			c.CDC.Capacity = CDCConfigDefaults.Capacity
+		} else if c.CDC.Capacity < 0 {
+			return errors.New("invalid cdc capacity")
+		}
+	}
+
+	// replication section is optional, without it server is standalone
+	if c.Replication != nil {
+		if !slices.Contains(ValidReplicationRoles, c.Replication.Role) {
+			return errors.New("invalid replication role")
+		}
+
+		if c.Replication.Role == ReplicationRoleFollower && c.Replication.LeaderAddress == "" {
+			return errors.New("replication leader address is required for follower")
+		}
+
+		// data is copied to followers the same way snapshots are taken
+		if c.Db != nil && c.Db.EngineType == EngineTypeDisk {
+			return errors.New("replication is not supported by disk engine")
+		}
+
+		// leader streams changes only in framed messages, so tcp server must accept them
+		if c.TcpServer.Protocol != ProtocolAuto && c.TcpServer.Protocol != ProtocolFramed {
+			return errors.New("replication requires tcp server protocol auto or framed")
+		}
+	}
+
+	// cluster section is optional, without it writes are not replicated by raft
+	if c.Cluster != nil {
+		if c.Cluster.NodeID == "" || c.Cluster.Address == "" {
+			return errors.New("cluster node id and address are required")
+		}
+
+		if address, ok := c.Cluster.Peers[c.Cluster.NodeID]; len(c.Cluster.Peers) != 0 && (!ok || address != c.Cluster.Address) {
+			return errors.New("cluster peers must include the node with its address")
+		}
+
+		if c.Cluster.DataDirectory == "" {
+			c.Cluster.DataDirectory = ClusterConfigDefaults.DataDirectory
+		}
+
+		if c.Cluster.TickInterval == 0 {
+			c.Cluster.TickInterval = ClusterConfigDefaults.TickInterval
+		} else if c.Cluster.TickInterval < 0 {
+			return errors.New("invalid cluster tick interval")
+		}
+
+		if c.Cluster.HeartbeatTicks == 0 {
+			c.Cluster.HeartbeatTicks = ClusterConfigDefaults.HeartbeatTicks
+		}
+
+		if c.Cluster.ElectionTicks == 0 {
+			c.Cluster.ElectionTicks = ClusterConfigDefaults.ElectionTicks
+		}
+
+		// followers must hear from leader several times before they start election
+		if c.Cluster.HeartbeatTicks < 0 || c.Cluster.ElectionTicks <= c.Cluster.HeartbeatTicks {
+			return errors.New("invalid cluster election or heartbeat ticks")
+		}
+
+		if c.Cluster.SnapshotThreshold == 0 {
+			c.Cluster.SnapshotThreshold = ClusterConfigDefaults.SnapshotThreshold
+		} else if c.Cluster.SnapshotThreshold < 0 {
+			return errors.New("invalid cluster snapshot threshold")
+		}
+
+		// cluster log and its snapshots persist data instead of wal and snapshots
+		if c.Wal != nil || c.Snapshot != nil || c.Replication != nil {
+			return errors.New("cluster can't be used with wal, snapshot or replication")
+		}
+
+		if c.Db != nil && c.Db.EngineType == EngineTypeDisk {
+			return errors.New("cluster is not supported by disk engine")
+		}
+
+		// eviction depends on memory and access pattern of each member, replicas would diverge.
+		// Expiration is replicated as absolute time, so members drop expired keys on their own
+		if c.Db != nil && (c.Db.MaxMemory != "" || c.Db.EvictionPolicy != DbConfigDefaults.EvictionPolicy) {
+			return errors.New("cluster can't be used with max memory or eviction policy")
+		}
 	}
 
 	return nil
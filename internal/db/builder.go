@@ -1,41 +1,177 @@
 package db
 
 import (
+	"fmt"
+
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/cdc"
 	"github.com/kirban/potato-db/internal/db/compute"
 	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/db/storage/engines/disk"
 	inmemory "github.com/kirban/potato-db/internal/db/storage/engines/in-memory"
+	"github.com/kirban/potato-db/internal/db/storage/engines/ordered"
+	"github.com/kirban/potato-db/internal/helpers"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/kirban/potato-db/internal/replication"
 	"go.uber.org/zap"
 )
 
 type DatabaseBuilder interface {
+	InitWAL(wal storage.WriteAheadLog) DatabaseBuilder
+	InitSnapshotter(snapshots storage.Snapshotter) DatabaseBuilder
+	InitPubSub(broker *pubsub.Broker) DatabaseBuilder
+	InitEvents(events *storage.EventBus) DatabaseBuilder
+	InitChangeLog(changes *cdc.Log) DatabaseBuilder
+	InitLeader(leader *replication.Leader) DatabaseBuilder
+	InitFollower(follower *replication.Follower) DatabaseBuilder
+	InitCluster(config cluster.Config, persister cluster.Persister, transport cluster.Transport) DatabaseBuilder
 	InitStorage() DatabaseBuilder
 	InitCompute() DatabaseBuilder
 	Build() *Database
 }
 
 type dbBuilder struct {
-	logger  *zap.Logger
-	storage *storage.Storage
-	compute *compute.Compute
+	logger    *zap.Logger
+	config    *config.DbConfigOptions
+	wal       storage.WriteAheadLog
+	snapshots storage.Snapshotter
+	storage   *storage.Storage
+	compute   *compute.Compute
+	broker    *pubsub.Broker
+	events    *storage.EventBus
+	changes   *cdc.Log
+	leader    *replication.Leader
+	follower  *replication.Follower
+	cluster   *clusterOptions
+}
+
+type clusterOptions struct {
+	config    cluster.Config
+	persister cluster.Persister
+	transport cluster.Transport
 }
 
-func NewDbBuilder(logger *zap.Logger) DatabaseBuilder {
+func NewDbBuilder(logger *zap.Logger, config *config.DbConfigOptions) DatabaseBuilder {
 	return &dbBuilder{
 		logger: logger,
+		config: config,
 	}
 }
 
+func (d *dbBuilder) InitWAL(wal storage.WriteAheadLog) DatabaseBuilder {
+	d.wal = wal
+	return d
+}
+
+func (d *dbBuilder) InitSnapshotter(snapshots storage.Snapshotter) DatabaseBuilder {
+	d.snapshots = snapshots
+	return d
+}
+
+func (d *dbBuilder) InitPubSub(broker *pubsub.Broker) DatabaseBuilder {
+	d.broker = broker
+	return d
+}
+
+// InitEvents turns on keyspace events, they are published to pub/sub channels if broker is set
+func (d *dbBuilder) InitEvents(events *storage.EventBus) DatabaseBuilder {
+	d.events = events
+	return d
+}
+
+// InitChangeLog keeps committed changes in log, so they can be streamed by CDC
+func (d *dbBuilder) InitChangeLog(changes *cdc.Log) DatabaseBuilder {
+	d.changes = changes
+	return d
+}
+
+// InitLeader lets followers connect and stream data, change log must be set as well
+func (d *dbBuilder) InitLeader(leader *replication.Leader) DatabaseBuilder {
+	d.leader = leader
+	return d
+}
+
+// InitFollower makes database read-only, its data is changed only by leader then
+func (d *dbBuilder) InitFollower(follower *replication.Follower) DatabaseBuilder {
+	d.follower = follower
+	return d
+}
+
+// InitCluster makes database a member of raft cluster, node is created by Build since database
+// is its state machine. Writes are applied once they are committed by majority of members
+func (d *dbBuilder) InitCluster(config cluster.Config, persister cluster.Persister, transport cluster.Transport) DatabaseBuilder {
+	d.cluster = &clusterOptions{config: config, persister: persister, transport: transport}
+	return d
+}
+
 func (d *dbBuilder) InitStorage() DatabaseBuilder {
-	engine, _ := inmemory.NewInMemoryEngine(d.logger)
+	engine, err := d.newEngine()
+
+	if err != nil {
+		d.logger.Error("can't initialize storage engine", zap.Error(err))
+		return d
+	}
 
-	d.storage = storage.
+	builder := storage.
 		NewDatabaseStorageBuilder(d.logger).
 		InitEngine(engine).
-		Build()
+		InitWAL(d.wal).
+		InitSnapshotter(d.snapshots).
+		InitEvents(d.events)
+
+	// typed nil pointer must not become non-nil interface
+	if d.changes != nil {
+		builder = builder.InitChangeLog(d.changes)
+	}
+
+	d.storage = builder.Build()
 
 	return d
 }
 
+func (d *dbBuilder) newEngine() (storage.Engine, error) {
+	engineType := config.EngineTypeInMemory
+	if d.config != nil {
+		engineType = d.config.EngineType
+	}
+
+	switch engineType {
+	case config.EngineTypeInMemory:
+		if d.config == nil {
+			return inmemory.NewInMemoryEngine(d.logger)
+		}
+
+		var opts []inmemory.Option
+		if d.config.Shards != 0 {
+			opts = append(opts, inmemory.WithShards(d.config.Shards))
+		}
+
+		if d.config.MaxMemory != "" {
+			maxMemory, err := helpers.ParseSize(d.config.MaxMemory)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse max memory: %w", err)
+			}
+
+			policy := inmemory.EvictionPolicy(d.config.EvictionPolicy)
+			opts = append(opts, inmemory.WithMaxMemory(maxMemory, policy))
+		}
+
+		return inmemory.NewInMemoryEngine(d.logger, opts...)
+	case config.EngineTypeDisk:
+		maxFileSize, err := helpers.ParseSize(d.config.MaxFileSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse max file size: %w", err)
+		}
+
+		return disk.NewDiskEngine(d.logger, d.config.DataDirectory, maxFileSize)
+	case config.EngineTypeOrdered:
+		return ordered.NewOrderedEngine(d.logger)
+	default:
+		return nil, fmt.Errorf("unknown engine type: %s", engineType)
+	}
+}
+
 func (d *dbBuilder) InitCompute() DatabaseBuilder {
 	var defaultParser = compute.NewQueryParser(d.logger)
 
@@ -48,6 +184,12 @@ func (d *dbBuilder) InitCompute() DatabaseBuilder {
 }
 
 func (d *dbBuilder) Build() *Database {
+	// storage is nil if its engine has failed, typed nil pointer must not become non-nil interface
+	if d.storage == nil {
+		d.logger.Error("can't initialize database", zap.Error(ErrStorageModuleNotInitialized))
+		return nil
+	}
+
 	database, err := NewDatabase(d.compute, d.storage, d.logger)
 
 	if err != nil {
@@ -55,5 +197,24 @@ func (d *dbBuilder) Build() *Database {
 		return nil
 	}
 
+	database.broker = d.broker
+	database.events = d.events
+	database.changes = d.changes
+	database.leader = d.leader
+	database.follower = d.follower
+
+	if d.broker != nil && d.events != nil {
+		d.events.Subscribe(storage.AllEvents, publishKeyspaceEvent(d.broker))
+	}
+
+	if d.cluster != nil {
+		node, err := cluster.NewNode(d.logger, d.cluster.config, clusterMachine{db: database}, d.cluster.persister, d.cluster.transport)
+		if err != nil {
+			d.logger.Error("can't initialize cluster node", zap.Error(err))
+			return nil
+		}
+		database.cluster = node
+	}
+
 	return database
 }
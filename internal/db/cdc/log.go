@@ -0,0 +1,236 @@
+package cdc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+var (
+	ErrTruncated = errors.New("cdc: changes after requested sequence number are no longer retained")
+	ErrAhead     = errors.New("cdc: requested sequence number is ahead of the log")
+)
+
+// DefaultCapacity is used when capacity of log is not configured
+const DefaultCapacity = 10000
+
+// Change is a committed record of write-ahead log, queries of transaction share one change
+type Change struct {
+	Seq     uint64
+	Queries []compute.Query
+}
+
+type recordState int
+
+const (
+	recordPending recordState = iota
+	recordCommitted
+	recordAborted
+)
+
+type record struct {
+	change Change
+	state  recordState
+}
+
+// Log keeps a bounded number of the latest changes in memory. Changes are appended before they
+// are durable and become visible to readers only once they and all changes before them are
+// resolved, so readers see committed changes in order of sequence numbers without gaps
+type Log struct {
+	mu      sync.Mutex
+	records []record
+	// first is sequence number of the oldest retained change, next is given to the next change
+	first uint64
+	next  uint64
+	// changes with sequence number below visible are resolved
+	visible uint64
+	// resolved is closed and replaced whenever visible grows
+	resolved chan struct{}
+}
+
+func NewLog(capacity int) *Log {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	return &Log{
+		records:  make([]record, capacity),
+		first:    1,
+		next:     1,
+		visible:  1,
+		resolved: make(chan struct{}),
+	}
+}
+
+// Append adds pending change of queries and returns its sequence number
+func (l *Log) Append(queries []compute.Query) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seq := l.next
+	l.next++
+	l.records[l.index(seq)] = record{change: Change{Seq: seq, Queries: queries}}
+
+	// the oldest change is overwritten, it is dropped even if still pending
+	if capacity := uint64(len(l.records)); seq-l.first >= capacity {
+		l.first = seq - capacity + 1
+		l.advance()
+	}
+
+	return seq
+}
+
+// Resolve tells whether change was committed, aborted changes are never shown to readers
+func (l *Log) Resolve(seq uint64, committed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq < l.first || seq >= l.next {
+		return
+	}
+
+	if committed {
+		l.records[l.index(seq)].state = recordCommitted
+	} else {
+		l.records[l.index(seq)].state = recordAborted
+	}
+	l.advance()
+}
+
+// Last returns sequence number of the latest appended change, it may be still pending
+func (l *Log) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next - 1
+}
+
+// Resolved returns nil if changes up to seq are resolved, otherwise channel closed once more
+// changes are resolved
+func (l *Log) Resolved(seq uint64) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq < l.visible {
+		return nil
+	}
+
+	return l.resolved
+}
+
+// Committed returns sequence number of the latest change readers may get
+func (l *Log) Committed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.visible - 1
+}
+
+// Rebase drops all changes and continues sequence after last, so sequence numbers keep
+// growing across restarts when they are taken from write-ahead log
+func (l *Log) Rebase(last uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clear(l.records)
+	l.first, l.next = last+1, last+1
+	l.visible = l.next
+	close(l.resolved)
+	l.resolved = make(chan struct{})
+}
+
+// Cursor returns cursor reading changes starting from sequence number from,
+// zero means the oldest retained change
+func (l *Log) Cursor(from uint64) (*Cursor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if from == 0 {
+		from = l.first
+	}
+
+	if err := l.check(from); err != nil {
+		return nil, err
+	}
+
+	return &Cursor{log: l, next: from}, nil
+}
+
+// read returns up to limit committed changes starting from sequence number from and sequence
+// number reading should continue from. Channel is closed when more changes may be available
+func (l *Log) read(from uint64, limit int) ([]Change, uint64, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.check(from); err != nil {
+		return nil, from, nil, err
+	}
+
+	var changes []Change
+	for ; from < l.visible && len(changes) < limit; from++ {
+		if r := l.records[l.index(from)]; r.state == recordCommitted {
+			changes = append(changes, r.change)
+		}
+	}
+
+	return changes, from, l.resolved, nil
+}
+
+func (l *Log) check(from uint64) error {
+	if from < l.first {
+		return ErrTruncated
+	}
+	if from > l.next {
+		return ErrAhead
+	}
+
+	return nil
+}
+
+// advance moves visible over resolved changes, must be called under lock
+func (l *Log) advance() {
+	visible := max(l.visible, l.first)
+	for visible < l.next && l.records[l.index(visible)].state != recordPending {
+		visible++
+	}
+
+	if visible != l.visible {
+		l.visible = visible
+		close(l.resolved)
+		l.resolved = make(chan struct{})
+	}
+}
+
+func (l *Log) index(seq uint64) uint64 {
+	return seq % uint64(len(l.records))
+}
+
+// Cursor reads committed changes in order, it is not safe for concurrent use
+type Cursor struct {
+	log  *Log
+	next uint64
+}
+
+// Next waits until there are changes after the ones already read and returns up to limit of them.
+// ErrTruncated is returned if reader falls behind by more than capacity of log
+func (c *Cursor) Next(ctx context.Context, limit int) ([]Change, error) {
+	for {
+		changes, next, resolved, err := c.log.read(c.next, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		c.next = next
+		if len(changes) > 0 {
+			return changes, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-resolved:
+		}
+	}
+}
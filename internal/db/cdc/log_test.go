@@ -0,0 +1,94 @@
+package cdc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func set(key string) []compute.Query {
+	return []compute.Query{*compute.NewQuery(compute.SetCommand, []string{key, "1"})}
+}
+
+func seqs(changes []cdc.Change) []uint64 {
+	result := make([]uint64, 0, len(changes))
+	for _, change := range changes {
+		result = append(result, change.Seq)
+	}
+	return result
+}
+
+func TestLog_Visibility(t *testing.T) {
+	t.Parallel()
+
+	log := cdc.NewLog(10)
+	cursor, err := log.Cursor(0)
+	require.NoError(t, err)
+
+	first := log.Append(set("a"))
+	second := log.Append(set("b"))
+	third := log.Append(set("c"))
+
+	// change is hidden until all changes before it are resolved
+	log.Resolve(second, true)
+	assert.NotNil(t, log.Resolved(second))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cursor.Next(ctx, 10)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// aborted change is skipped
+	log.Resolve(first, false)
+	changes, err := cursor.Next(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2}, seqs(changes))
+	assert.Nil(t, log.Resolved(second))
+	assert.NotNil(t, log.Resolved(third))
+
+	// waiting reader is woken by commit
+	go log.Resolve(third, true)
+	changes, err = cursor.Next(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3}, seqs(changes))
+	assert.Equal(t, set("c"), changes[0].Queries)
+}
+
+func TestLog_Bounds(t *testing.T) {
+	t.Parallel()
+
+	log := cdc.NewLog(3)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		log.Resolve(log.Append(set(key)), true)
+	}
+
+	_, err := log.Cursor(2)
+	assert.ErrorIs(t, err, cdc.ErrTruncated)
+	_, err = log.Cursor(7)
+	assert.ErrorIs(t, err, cdc.ErrAhead)
+
+	cursor, err := log.Cursor(0)
+	require.NoError(t, err)
+	changes, err := cursor.Next(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, seqs(changes))
+
+	// reader which falls behind by more than capacity can't go on
+	for _, key := range []string{"f", "g", "h"} {
+		log.Resolve(log.Append(set(key)), true)
+	}
+	_, err = cursor.Next(context.Background(), 2)
+	assert.ErrorIs(t, err, cdc.ErrTruncated)
+
+	// sequence goes on after rebase, older changes are gone
+	log.Rebase(100)
+	_, err = log.Cursor(100)
+	assert.ErrorIs(t, err, cdc.ErrTruncated)
+	_, err = log.Cursor(101)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(101), log.Append(set("i")))
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/snapshot"
+	"go.uber.org/zap"
+)
+
+// clusterTimeout limits waiting until write is committed by majority of cluster
+const clusterTimeout = 5 * time.Second
+
+// Cluster returns raft node database is a member of, it is nil if cluster is disabled
+func (db *Database) Cluster() *cluster.Node {
+	return db.cluster
+}
+
+// dispatch executes query, writes of cluster member are applied only once they are committed
+func (db *Database) dispatch(query *compute.Query) compute.Result {
+	if db.cluster != nil {
+		switch {
+		case query.CommandType == compute.ClusterCommand:
+			return db.changeMembers(query)
+		case query.CommandType.IsWrite():
+			return db.replicate([]*compute.Query{query})
+		}
+	}
+
+	db.execMu.RLock()
+	defer db.execMu.RUnlock()
+
+	return db.execute(db.storageModule, query)
+}
+
+// replicate proposes queries to cluster as a single entry and returns result of applying them
+// on this node. Reads go along with writes, so transaction sees its own changes
+func (db *Database) replicate(queries []*compute.Query) compute.Result {
+	entry := make([]compute.Query, len(queries))
+	for i, query := range queries {
+		absolute, err := absoluteExpiry(*query)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		entry[i] = absolute
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(entry); err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+
+	result, err := db.cluster.Execute(ctx, data.Bytes())
+	if errors.Is(err, context.DeadlineExceeded) {
+		return compute.ErrorResult(ErrClusterTimeout)
+	} else if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	return result.(compute.Result)
+}
+
+// changeMembers adds node to cluster or removes it, reply is sent once change is committed
+func (db *Database) changeMembers(query *compute.Query) compute.Result {
+	var proposal *cluster.Proposal
+	var err error
+	if query.Arguments[0] == compute.ClusterAddSubcommand {
+		proposal, err = db.cluster.AddMember(query.Arguments[1], query.Arguments[2])
+	} else {
+		proposal, err = db.cluster.RemoveMember(query.Arguments[1])
+	}
+
+	if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+
+	if _, err := proposal.Wait(ctx); errors.Is(err, context.DeadlineExceeded) {
+		return compute.ErrorResult(ErrClusterTimeout)
+	} else if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	return compute.OkResult()
+}
+
+// absoluteExpiry replaces relative expiration by unix time in milliseconds, so every node and
+// replay after restart sets the same expiration time
+func absoluteExpiry(query compute.Query) (compute.Query, error) {
+	var option, value string
+	var index int
+
+	switch query.CommandType {
+	case compute.SetCommand:
+		for i := 2; i < len(query.Arguments)-1; i++ {
+			if arg := query.Arguments[i]; arg == compute.ExpireSecondsOption || arg == compute.ExpireMillisecondsOption {
+				option, value, index = arg, query.Arguments[i+1], i
+			}
+		}
+	case compute.ExpireCommand, compute.PExpireCommand:
+		option, value = compute.ExpireMillisecondsOption, query.Arguments[1]
+		if query.CommandType == compute.ExpireCommand {
+			option = compute.ExpireSecondsOption
+		}
+	}
+
+	if option == "" {
+		return query, nil
+	}
+
+	expireAt, err := parseExpireAt(option, value)
+	if err != nil {
+		return query, err
+	}
+
+	absolute := strconv.FormatInt(expireAt.UnixMilli(), 10)
+	args := append([]string(nil), query.Arguments...)
+	if query.CommandType == compute.SetCommand {
+		args[index], args[index+1] = compute.ExpireAtMillisecondsOption, absolute
+		return compute.Query{CommandType: query.CommandType, Arguments: args}, nil
+	}
+
+	args[1] = absolute
+	return compute.Query{CommandType: compute.PExpireAtCommand, Arguments: args}, nil
+}
+
+// clusterMachine applies committed entries of cluster log to storage. It is called by node
+// holding its lock, so it must never wait for queries which call node
+type clusterMachine struct {
+	db *Database
+}
+
+func (m clusterMachine) Apply(data []byte) any {
+	var queries []compute.Query
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&queries); err != nil {
+		m.db.logger.Error("failed to decode cluster entry", zap.Error(err))
+		return compute.ErrorResult(err)
+	}
+
+	m.db.execMu.Lock()
+	defer m.db.execMu.Unlock()
+
+	if len(queries) == 1 {
+		return m.db.execute(m.db.storageModule, &queries[0])
+	}
+
+	pointers := make([]*compute.Query, len(queries))
+	for i := range queries {
+		pointers[i] = &queries[i]
+	}
+	return m.db.executeAtomic(pointers)
+}
+
+func (m clusterMachine) Snapshot() ([]byte, error) {
+	_, data, err := m.db.storageModule.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := snapshot.Encode(&buf, 0, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m clusterMachine) Restore(data []byte) error {
+	_, entries, err := snapshot.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode cluster snapshot: %w", err)
+	}
+
+	m.db.execMu.Lock()
+	defer m.db.execMu.Unlock()
+
+	return m.db.storageModule.Load(entries)
+}
@@ -24,3 +24,11 @@ func NewCompute(parser *Parser) *Compute {
 		parser: parser,
 	}
 }
+
+func (c *Compute) ComputeTokens(tokens []string) (*Query, error) {
+	if c.parser == nil {
+		return nil, fmt.Errorf("parser is not initialized")
+	}
+
+	return (*c.parser).ParseTokens(tokens)
+}
@@ -0,0 +1,184 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnterminatedQuote = errors.New("parse error: unterminated quote")
+	ErrInvalidEscape     = errors.New("parse error: invalid escape sequence")
+	ErrQuoteNotSeparated = errors.New("parse error: closing quote must be followed by a space")
+)
+
+// SyntaxError reports position of invalid input in text query
+type SyntaxError struct {
+	Err error
+	// Column is 1-based position in runes
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Err.Error(), e.Column)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Tokenize splits text query into command and arguments. Arguments are separated by whitespace
+// and may be quoted: double quotes support \n, \r, \t, \\, \", \' and \xNN escapes, single quotes
+// support only \' and keep everything else as is. Empty quotes produce empty argument
+func Tokenize(data string) ([]string, error) {
+	l := &lexer{data: data}
+	return l.tokens()
+}
+
+type lexer struct {
+	data string
+	pos  int
+}
+
+func (l *lexer) tokens() ([]string, error) {
+	tokens := make([]string, 0)
+
+	for {
+		l.skipSpaces()
+		if l.pos >= len(l.data) {
+			return tokens, nil
+		}
+
+		var token string
+		var err error
+
+		switch l.data[l.pos] {
+		case '"':
+			token, err = l.quoted('"')
+		case '\'':
+			token, err = l.quoted('\'')
+		default:
+			token = l.word()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+}
+
+func (l *lexer) skipSpaces() {
+	for l.pos < len(l.data) && isSpace(l.data[l.pos]) {
+		l.pos++
+	}
+}
+
+// word reads unquoted argument, quotes and backslashes inside it are regular characters
+func (l *lexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) {
+		l.pos++
+	}
+
+	return l.data[start:l.pos]
+}
+
+func (l *lexer) quoted(quote byte) (string, error) {
+	open := l.pos
+	l.pos++
+
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.data) {
+			return "", l.errorAt(ErrUnterminatedQuote, open)
+		}
+
+		c := l.data[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			if l.pos < len(l.data) && !isSpace(l.data[l.pos]) {
+				return "", l.errorAt(ErrQuoteNotSeparated, l.pos)
+			}
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			decoded, err := l.escape()
+			if err != nil {
+				return "", err
+			}
+			b.WriteByte(decoded)
+		case c == '\\' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '\'':
+			b.WriteByte('\'')
+			l.pos += 2
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// escape decodes backslash sequence inside double quotes
+func (l *lexer) escape() (byte, error) {
+	start := l.pos
+	if l.pos+1 >= len(l.data) {
+		return 0, l.errorAt(ErrUnterminatedQuote, start)
+	}
+
+	c := l.data[l.pos+1]
+	l.pos += 2
+
+	switch c {
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case '\\', '"', '\'':
+		return c, nil
+	case 'x':
+		if l.pos+2 > len(l.data) {
+			return 0, l.errorAt(ErrInvalidEscape, start)
+		}
+
+		hi, okHi := unhex(l.data[l.pos])
+		lo, okLo := unhex(l.data[l.pos+1])
+		if !okHi || !okLo {
+			return 0, l.errorAt(ErrInvalidEscape, start)
+		}
+
+		l.pos += 2
+		return hi<<4 | lo, nil
+	default:
+		return 0, l.errorAt(ErrInvalidEscape, start)
+	}
+}
+
+func (l *lexer) errorAt(err error, pos int) error {
+	return &SyntaxError{Err: err, Column: utf8.RuneCountInString(l.data[:pos]) + 1}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+
+	return false
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,99 @@
+package compute
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input          string
+		expectedTokens []string
+		expectedErr    error
+		expectedColumn int
+	}{
+		"plain words": {
+			input:          "  SET foo\tbar  ",
+			expectedTokens: []string{"SET", "foo", "bar"},
+		},
+		"double quoted with spaces": {
+			input:          `SET foo "bar baz"`,
+			expectedTokens: []string{"SET", "foo", "bar baz"},
+		},
+		"single quoted keeps backslashes": {
+			input:          `SET foo 'a\nb \'c\''`,
+			expectedTokens: []string{"SET", "foo", `a\nb 'c'`},
+		},
+		"escapes": {
+			input:          `SET foo "line\n\"q\"\t\\ \x41\xff"`,
+			expectedTokens: []string{"SET", "foo", "line\n\"q\"\t\\ A\xff"},
+		},
+		"empty arguments": {
+			input:          `SET "" ''`,
+			expectedTokens: []string{"SET", "", ""},
+		},
+		"quotes inside word are regular characters": {
+			input:          `SET fo"o b'ar`,
+			expectedTokens: []string{"SET", `fo"o`, `b'ar`},
+		},
+		"empty input": {
+			input:          "   ",
+			expectedTokens: []string{},
+		},
+		"unterminated quote": {
+			input:          `SET foo "bar`,
+			expectedErr:    ErrUnterminatedQuote,
+			expectedColumn: 9,
+		},
+		"unterminated escape": {
+			input:          `SET foo "bar\`,
+			expectedErr:    ErrUnterminatedQuote,
+			expectedColumn: 13,
+		},
+		"unknown escape": {
+			input:          `SET foo "a\qb"`,
+			expectedErr:    ErrInvalidEscape,
+			expectedColumn: 11,
+		},
+		"invalid hex escape": {
+			input:          `SET foo "\x4g"`,
+			expectedErr:    ErrInvalidEscape,
+			expectedColumn: 10,
+		},
+		"column counts runes": {
+			input:          `SET ключ "знач`,
+			expectedErr:    ErrUnterminatedQuote,
+			expectedColumn: 10,
+		},
+		"quote not followed by space": {
+			input:          `SET "foo"bar baz`,
+			expectedErr:    ErrQuoteNotSeparated,
+			expectedColumn: 10,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tokens, err := Tokenize(tc.input)
+
+			if tc.expectedErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedTokens, tokens)
+				return
+			}
+
+			assert.ErrorIs(t, err, tc.expectedErr)
+
+			var syntaxErr *SyntaxError
+			if assert.True(t, errors.As(err, &syntaxErr)) {
+				assert.Equal(t, tc.expectedColumn, syntaxErr.Column)
+			}
+		})
+	}
+}
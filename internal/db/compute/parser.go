@@ -3,6 +3,8 @@ package compute
 import (
 	"errors"
 	"go.uber.org/zap"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -10,10 +12,13 @@ var (
 	ErrUnknownCommand = errors.New("parse error: unknown command")
 	ErrWrongNOfArgs   = errors.New("parse error: invalid number of arguments")
 	ErrInvalidQuery   = errors.New("parse error: invalid query")
+	ErrInvalidArgs    = errors.New("parse error: invalid arguments")
 )
 
 type Parser interface {
 	Parse(data string) (*Query, error)
+	// ParseTokens parses query already split into command and arguments, like RESP arrays
+	ParseTokens(tokens []string) (*Query, error)
 }
 
 type QueryParser struct {
@@ -21,19 +26,26 @@ type QueryParser struct {
 }
 
 func (q *QueryParser) Parse(data string) (*Query, error) {
-	trimmed := strings.TrimSpace(data)
+	tokens, err := Tokenize(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.ParseTokens(tokens)
+}
 
-	if len(trimmed) == 0 {
+func (q *QueryParser) ParseTokens(tokens []string) (*Query, error) {
+	if len(tokens) == 0 {
 		return nil, ErrInvalidQuery
 	}
 
-	command, err := parseCommandType(trimmed)
+	command, err := parseCommandType(tokens)
 
 	if err != nil {
 		return nil, err
 	}
 
-	args, err := parseArguments(trimmed)
+	args, err := parseArguments(tokens)
 
 	if err != nil {
 		return nil, err
@@ -42,35 +54,334 @@ func (q *QueryParser) Parse(data string) (*Query, error) {
 	return NewQuery(command, args), nil
 }
 
-func parseCommandType(q string) (CommandType, error) {
-	rawCommand := QueryArgsRegExp.FindAllString(q, -1)[0]
+func parseCommandType(tokens []string) (CommandType, error) {
+	rawCommand := tokens[0]
 
 	switch rawCommand {
-	case string(GetCommand), string(SetCommand), string(DelCommand):
+	case string(GetCommand), string(SetCommand), string(DelCommand),
+		string(MGetCommand), string(MSetCommand),
+		string(ExpireCommand), string(PExpireCommand), string(PExpireAtCommand),
+		string(TTLCommand), string(PTTLCommand), string(PersistCommand),
+		string(IncrCommand), string(DecrCommand), string(IncrByCommand), string(DecrByCommand),
+		string(IncrByFloatCommand),
+		string(SetNXCommand), string(GetSetCommand), string(GetDelCommand), string(CASCommand),
+		string(KeysCommand), string(ScanCommand), string(RangeCommand), string(PrefixCommand),
+		string(LPushCommand), string(RPushCommand), string(LPopCommand), string(RPopCommand),
+		string(LLenCommand), string(LRangeCommand), string(LIndexCommand), string(LTrimCommand),
+		string(BLPopCommand), string(BRPopCommand),
+		string(HSetCommand), string(HGetCommand), string(HMGetCommand), string(HDelCommand),
+		string(HGetAllCommand), string(HKeysCommand), string(HLenCommand), string(HIncrByCommand),
+		string(SAddCommand), string(SRemCommand), string(SMembersCommand), string(SIsMemberCommand),
+		string(SInterCommand), string(SUnionCommand), string(SDiffCommand),
+		string(ZAddCommand), string(ZRemCommand), string(ZScoreCommand), string(ZRangeCommand),
+		string(ZRangeByScoreCommand), string(ZRankCommand), string(ZIncrByCommand),
+		string(PublishCommand), string(CDCCommand), string(ClusterCommand),
+		string(SnapshotCommand), string(PingCommand), string(InfoCommand):
 		return CommandType(rawCommand), nil
 	default:
 		return "", ErrUnknownCommand
 	}
 }
 
-func parseArguments(q string) ([]string, error) {
-	splittedQuery := QueryArgsRegExp.FindAllString(q, -1)
-	rawCommand, rawArgs := splittedQuery[0], splittedQuery[1:]
+func parseArguments(tokens []string) ([]string, error) {
+	rawCommand, rawArgs := tokens[0], tokens[1:]
 
 	switch rawCommand {
-	case string(GetCommand), string(DelCommand):
+	case string(GetCommand),
+		string(TTLCommand), string(PTTLCommand), string(PersistCommand),
+		string(IncrCommand), string(DecrCommand), string(GetDelCommand),
+		string(KeysCommand), string(LLenCommand),
+		string(HGetAllCommand), string(HKeysCommand), string(HLenCommand),
+		string(SMembersCommand):
+		if len(rawArgs) != 1 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(DelCommand), string(MGetCommand),
+		string(SInterCommand), string(SUnionCommand), string(SDiffCommand):
+		if len(rawArgs) == 0 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(MSetCommand):
+		if len(rawArgs) == 0 || len(rawArgs)%2 != 0 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(SetNXCommand), string(GetSetCommand), string(HGetCommand),
+		string(SIsMemberCommand), string(ZScoreCommand), string(ZRankCommand), string(PublishCommand):
+		if len(rawArgs) != 2 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(CASCommand):
+		if len(rawArgs) != 3 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(ScanCommand):
+		if len(rawArgs) == 0 || len(rawArgs)%2 != 1 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if _, err := strconv.ParseUint(rawArgs[0], 10, 64); err != nil {
+			return nil, ErrInvalidArgs
+		}
+
+		if err := validateScanOptions(rawArgs[1:]); err != nil {
+			return nil, err
+		}
+	case string(RangeCommand), string(PrefixCommand):
+		bounds := 1
+		if rawCommand == string(RangeCommand) {
+			bounds = 2
+		}
+
+		if len(rawArgs) != bounds && len(rawArgs) != bounds+2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if len(rawArgs) == bounds+2 {
+			rawArgs[bounds] = strings.ToUpper(rawArgs[bounds])
+			if v, err := strconv.Atoi(rawArgs[bounds+1]); rawArgs[bounds] != LimitOption || err != nil || v <= 0 {
+				return nil, ErrInvalidArgs
+			}
+		}
+	case string(HSetCommand):
+		if len(rawArgs) < 3 || len(rawArgs)%2 != 1 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(HMGetCommand), string(HDelCommand),
+		string(SAddCommand), string(SRemCommand), string(ZRemCommand):
+		if len(rawArgs) < 2 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(HIncrByCommand):
+		if len(rawArgs) != 3 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if _, err := strconv.ParseInt(rawArgs[2], 10, 64); err != nil {
+			return nil, ErrInvalidArgs
+		}
+	case string(ZAddCommand):
+		if len(rawArgs) < 3 || len(rawArgs)%2 != 1 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		for i := 1; i < len(rawArgs); i += 2 {
+			if !validScore(rawArgs[i]) {
+				return nil, ErrInvalidArgs
+			}
+		}
+	case string(ZIncrByCommand):
+		if len(rawArgs) != 3 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if !validScore(rawArgs[1]) {
+			return nil, ErrInvalidArgs
+		}
+	case string(ZRangeCommand), string(ZRangeByScoreCommand):
+		if len(rawArgs) != 3 && len(rawArgs) != 4 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if len(rawArgs) == 4 {
+			if rawArgs[3] = strings.ToUpper(rawArgs[3]); rawArgs[3] != WithScoresOption {
+				return nil, ErrInvalidArgs
+			}
+		}
+
+		for _, bound := range rawArgs[1:3] {
+			valid := validScoreBound(bound)
+			if rawCommand == string(ZRangeCommand) {
+				_, err := strconv.ParseInt(bound, 10, 64)
+				valid = err == nil
+			}
+
+			if !valid {
+				return nil, ErrInvalidArgs
+			}
+		}
+	case string(LPushCommand), string(RPushCommand):
+		if len(rawArgs) < 2 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(LPopCommand), string(RPopCommand):
+		if len(rawArgs) != 1 && len(rawArgs) != 2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if len(rawArgs) == 2 {
+			if v, err := strconv.Atoi(rawArgs[1]); err != nil || v < 0 {
+				return nil, ErrInvalidArgs
+			}
+		}
+	case string(BLPopCommand), string(BRPopCommand):
+		if len(rawArgs) < 2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		// timeout is given in seconds, zero means waiting forever
+		timeout, err := strconv.ParseFloat(rawArgs[len(rawArgs)-1], 64)
+		if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) || timeout < 0 {
+			return nil, ErrInvalidArgs
+		}
+	case string(CDCCommand):
 		if len(rawArgs) != 1 {
 			return nil, ErrWrongNOfArgs
 		}
+
+		// stream starts from given sequence number, zero means the oldest retained change
+		if _, err := strconv.ParseUint(rawArgs[0], 10, 64); err != nil {
+			return nil, ErrInvalidArgs
+		}
+	case string(ClusterCommand):
+		if len(rawArgs) == 0 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		// subcommand is case insensitive like options
+		rawArgs[0] = strings.ToUpper(rawArgs[0])
+		switch {
+		case rawArgs[0] == ClusterAddSubcommand && len(rawArgs) != 3,
+			rawArgs[0] == ClusterRemoveSubcommand && len(rawArgs) != 2:
+			return nil, ErrWrongNOfArgs
+		case rawArgs[0] != ClusterAddSubcommand && rawArgs[0] != ClusterRemoveSubcommand:
+			return nil, ErrInvalidArgs
+		}
+	case string(LIndexCommand):
+		if len(rawArgs) != 2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if _, err := strconv.ParseInt(rawArgs[1], 10, 64); err != nil {
+			return nil, ErrInvalidArgs
+		}
+	case string(LRangeCommand), string(LTrimCommand):
+		if len(rawArgs) != 3 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		for _, index := range rawArgs[1:] {
+			if _, err := strconv.ParseInt(index, 10, 64); err != nil {
+				return nil, ErrInvalidArgs
+			}
+		}
 	case string(SetCommand):
+		if len(rawArgs) < 2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if err := validateSetOptions(rawArgs[2:]); err != nil {
+			return nil, err
+		}
+	case string(ExpireCommand), string(PExpireCommand), string(PExpireAtCommand):
+		if len(rawArgs) != 2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if _, err := strconv.ParseInt(rawArgs[1], 10, 64); err != nil {
+			return nil, ErrInvalidArgs
+		}
+	case string(IncrByCommand), string(DecrByCommand):
+		if len(rawArgs) != 2 {
+			return nil, ErrWrongNOfArgs
+		}
+
+		if _, err := strconv.ParseInt(rawArgs[1], 10, 64); err != nil {
+			return nil, ErrInvalidArgs
+		}
+	case string(IncrByFloatCommand):
 		if len(rawArgs) != 2 {
 			return nil, ErrWrongNOfArgs
 		}
+
+		if v, err := strconv.ParseFloat(rawArgs[1], 64); err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, ErrInvalidArgs
+		}
+	case string(SnapshotCommand):
+		if len(rawArgs) != 0 {
+			return nil, ErrWrongNOfArgs
+		}
+	case string(PingCommand), string(InfoCommand):
+		if len(rawArgs) > 1 {
+			return nil, ErrWrongNOfArgs
+		}
 	}
 
 	return rawArgs, nil
 }
 
+// validateSetOptions accepts one expiration option and one of NX and XX in any order
+func validateSetOptions(options []string) error {
+	var hasExpire, hasCondition bool
+
+	for i := 0; i < len(options); i++ {
+		// options are case insensitive like in redis, clients often send them lowercased
+		options[i] = strings.ToUpper(options[i])
+
+		switch options[i] {
+		case IfNotExistsOption, IfExistsOption:
+			if hasCondition {
+				return ErrInvalidArgs
+			}
+			hasCondition = true
+		default:
+			if hasExpire || i+1 == len(options) {
+				return ErrInvalidArgs
+			}
+			if err := validateExpireOption(options[i], options[i+1]); err != nil {
+				return err
+			}
+			hasExpire = true
+			i++
+		}
+	}
+
+	return nil
+}
+
+// validScore accepts floats including infinities like "+inf", but not NaN
+func validScore(value string) bool {
+	v, err := strconv.ParseFloat(value, 64)
+	return err == nil && !math.IsNaN(v)
+}
+
+// validScoreBound accepts score optionally prefixed with "(" which makes bound exclusive
+func validScoreBound(value string) bool {
+	return validScore(strings.TrimPrefix(value, ExclusiveBoundPrefix))
+}
+
+// validateScanOptions accepts MATCH pattern and COUNT n pairs
+func validateScanOptions(options []string) error {
+	for i := 0; i < len(options); i += 2 {
+		options[i] = strings.ToUpper(options[i])
+
+		switch options[i] {
+		case MatchOption:
+		case CountOption:
+			if v, err := strconv.Atoi(options[i+1]); err != nil || v <= 0 {
+				return ErrInvalidArgs
+			}
+		default:
+			return ErrInvalidArgs
+		}
+	}
+
+	return nil
+}
+
+func validateExpireOption(option string, value string) error {
+	switch option {
+	case ExpireSecondsOption, ExpireMillisecondsOption, ExpireAtMillisecondsOption:
+	default:
+		return ErrInvalidArgs
+	}
+
+	if v, err := strconv.ParseInt(value, 10, 64); err != nil || v <= 0 {
+		return ErrInvalidArgs
+	}
+
+	return nil
+}
+
 func NewQueryParser(logger *zap.Logger) *QueryParser {
 	return &QueryParser{
 		logger: logger,
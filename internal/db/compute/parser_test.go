@@ -30,6 +30,371 @@ func TestParse(t *testing.T) {
 			expectedQuery: NewQuery(DelCommand, []string{"foo"}),
 			expectedErr:   nil,
 		},
+		"set with expiration query": {
+			inputQuery:    "SET foo value EX 10",
+			expectedQuery: NewQuery(SetCommand, []string{"foo", "value", "EX", "10"}),
+			expectedErr:   nil,
+		},
+		"set with unknown option": {
+			inputQuery:    "SET foo value XX 10",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"set with condition and expiration": {
+			inputQuery:    "SET foo value nx EX 10",
+			expectedQuery: NewQuery(SetCommand, []string{"foo", "value", "NX", "EX", "10"}),
+			expectedErr:   nil,
+		},
+		"set with both conditions": {
+			inputQuery:    "SET foo value NX XX",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"set with two expirations": {
+			inputQuery:    "SET foo value EX 10 PX 10",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"setnx query": {
+			inputQuery:    "SETNX foo value",
+			expectedQuery: NewQuery(SetNXCommand, []string{"foo", "value"}),
+			expectedErr:   nil,
+		},
+		"getdel query": {
+			inputQuery:    "GETDEL foo",
+			expectedQuery: NewQuery(GetDelCommand, []string{"foo"}),
+			expectedErr:   nil,
+		},
+		"cas query": {
+			inputQuery:    "CAS foo old new",
+			expectedQuery: NewQuery(CASCommand, []string{"foo", "old", "new"}),
+			expectedErr:   nil,
+		},
+		"keys query": {
+			inputQuery:    "KEYS user:*",
+			expectedQuery: NewQuery(KeysCommand, []string{"user:*"}),
+			expectedErr:   nil,
+		},
+		"scan query": {
+			inputQuery:    "SCAN 0 match user:* count 100",
+			expectedQuery: NewQuery(ScanCommand, []string{"0", "MATCH", "user:*", "COUNT", "100"}),
+			expectedErr:   nil,
+		},
+		"scan with invalid cursor": {
+			inputQuery:    "SCAN -1",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"scan with invalid count": {
+			inputQuery:    "SCAN 0 COUNT 0",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"scan with option without value": {
+			inputQuery:    "SCAN 0 MATCH",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"range query": {
+			inputQuery:    "RANGE user:1000 user:2000 limit 10",
+			expectedQuery: NewQuery(RangeCommand, []string{"user:1000", "user:2000", "LIMIT", "10"}),
+			expectedErr:   nil,
+		},
+		"range with invalid limit": {
+			inputQuery:    "RANGE a b LIMIT -1",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"prefix query": {
+			inputQuery:    "PREFIX user:",
+			expectedQuery: NewQuery(PrefixCommand, []string{"user:"}),
+			expectedErr:   nil,
+		},
+		"prefix with unknown option": {
+			inputQuery:    "PREFIX user: COUNT 10",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"lpush query": {
+			inputQuery:    "LPUSH queue a b",
+			expectedQuery: NewQuery(LPushCommand, []string{"queue", "a", "b"}),
+			expectedErr:   nil,
+		},
+		"rpush without values": {
+			inputQuery:    "RPUSH queue",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"lpop with count": {
+			inputQuery:    "LPOP queue 2",
+			expectedQuery: NewQuery(LPopCommand, []string{"queue", "2"}),
+			expectedErr:   nil,
+		},
+		"rpop with negative count": {
+			inputQuery:    "RPOP queue -1",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"lrange query": {
+			inputQuery:    "LRANGE queue 0 -1",
+			expectedQuery: NewQuery(LRangeCommand, []string{"queue", "0", "-1"}),
+			expectedErr:   nil,
+		},
+		"ltrim with invalid index": {
+			inputQuery:    "LTRIM queue 0 last",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"lindex without index": {
+			inputQuery:    "LINDEX queue",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"blpop query": {
+			inputQuery:    "BLPOP jobs:high jobs:low 0.5",
+			expectedQuery: NewQuery(BLPopCommand, []string{"jobs:high", "jobs:low", "0.5"}),
+			expectedErr:   nil,
+		},
+		"brpop without timeout": {
+			inputQuery:    "BRPOP jobs",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"brpop with negative timeout": {
+			inputQuery:    "BRPOP jobs -1",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"publish query": {
+			inputQuery:    `PUBLISH cache:invalidate "user:1 user:2"`,
+			expectedQuery: NewQuery(PublishCommand, []string{"cache:invalidate", "user:1 user:2"}),
+			expectedErr:   nil,
+		},
+		"publish without message": {
+			inputQuery:    "PUBLISH cache:invalidate",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"cdc query": {
+			inputQuery:    "CDC 42",
+			expectedQuery: NewQuery(CDCCommand, []string{"42"}),
+			expectedErr:   nil,
+		},
+		"cdc with negative sequence": {
+			inputQuery:    "CDC -1",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"hset query": {
+			inputQuery:    "HSET user:1 name potato email potato@example.com",
+			expectedQuery: NewQuery(HSetCommand, []string{"user:1", "name", "potato", "email", "potato@example.com"}),
+			expectedErr:   nil,
+		},
+		"hset with field without value": {
+			inputQuery:    "HSET user:1 name potato email",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"hmget query": {
+			inputQuery:    "HMGET user:1 name email",
+			expectedQuery: NewQuery(HMGetCommand, []string{"user:1", "name", "email"}),
+			expectedErr:   nil,
+		},
+		"hgetall with extra argument": {
+			inputQuery:    "HGETALL user:1 name",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"hincrby with invalid delta": {
+			inputQuery:    "HINCRBY user:1 visits 1.5",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"sadd query": {
+			inputQuery:    "SADD tags go db",
+			expectedQuery: NewQuery(SAddCommand, []string{"tags", "go", "db"}),
+			expectedErr:   nil,
+		},
+		"sinter without keys": {
+			inputQuery:    "SINTER",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"zadd query": {
+			inputQuery:    "ZADD board 10 alice -inf bob 1.5 carol",
+			expectedQuery: NewQuery(ZAddCommand, []string{"board", "10", "alice", "-inf", "bob", "1.5", "carol"}),
+			expectedErr:   nil,
+		},
+		"zadd with nan score": {
+			inputQuery:    "ZADD board nan alice",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"zrange with scores": {
+			inputQuery:    "ZRANGE board 0 -1 withscores",
+			expectedQuery: NewQuery(ZRangeCommand, []string{"board", "0", "-1", "WITHSCORES"}),
+			expectedErr:   nil,
+		},
+		"zrange with score bounds": {
+			inputQuery:    "ZRANGE board (1 5",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"zrangebyscore query": {
+			inputQuery:    "ZRANGEBYSCORE board (1 +inf",
+			expectedQuery: NewQuery(ZRangeByScoreCommand, []string{"board", "(1", "+inf"}),
+			expectedErr:   nil,
+		},
+		"zrangebyscore with unknown option": {
+			inputQuery:    "ZRANGEBYSCORE board 1 5 LIMIT",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"zincrby with invalid increment": {
+			inputQuery:    "ZINCRBY board ten alice",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"invalid n of args of CAS": {
+			inputQuery:    "CAS foo old",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"set with non positive expiration": {
+			inputQuery:    "SET foo value PX 0",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"expire query": {
+			inputQuery:    "EXPIRE foo 10",
+			expectedQuery: NewQuery(ExpireCommand, []string{"foo", "10"}),
+			expectedErr:   nil,
+		},
+		"expire with non numeric argument": {
+			inputQuery:    "PEXPIRE foo soon",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"ttl query": {
+			inputQuery:    "TTL foo",
+			expectedQuery: NewQuery(TTLCommand, []string{"foo"}),
+			expectedErr:   nil,
+		},
+		"invalid n of args of PERSIST": {
+			inputQuery:    "PERSIST foo bar",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"snapshot query": {
+			inputQuery:    "SNAPSHOT",
+			expectedQuery: NewQuery(SnapshotCommand, []string{}),
+			expectedErr:   nil,
+		},
+		"invalid n of args of SNAPSHOT": {
+			inputQuery:    "SNAPSHOT now",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"del multiple keys": {
+			inputQuery:    "DEL foo bar",
+			expectedQuery: NewQuery(DelCommand, []string{"foo", "bar"}),
+			expectedErr:   nil,
+		},
+		"mget query": {
+			inputQuery:    "MGET foo bar",
+			expectedQuery: NewQuery(MGetCommand, []string{"foo", "bar"}),
+			expectedErr:   nil,
+		},
+		"invalid n of args of MGET": {
+			inputQuery:    "MGET",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"mset query": {
+			inputQuery:    "MSET foo 1 bar 2",
+			expectedQuery: NewQuery(MSetCommand, []string{"foo", "1", "bar", "2"}),
+			expectedErr:   nil,
+		},
+		"mset without value": {
+			inputQuery:    "MSET foo 1 bar",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"set with lowercased option": {
+			inputQuery:    "SET foo value px 10",
+			expectedQuery: NewQuery(SetCommand, []string{"foo", "value", "PX", "10"}),
+			expectedErr:   nil,
+		},
+		"ping query": {
+			inputQuery:    "PING",
+			expectedQuery: NewQuery(PingCommand, []string{}),
+			expectedErr:   nil,
+		},
+		"invalid n of args of PING": {
+			inputQuery:    "PING foo bar",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"info query": {
+			inputQuery:    "INFO replication",
+			expectedQuery: NewQuery(InfoCommand, []string{"replication"}),
+			expectedErr:   nil,
+		},
+		"invalid n of args of INFO": {
+			inputQuery:    "INFO replication server",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"cluster add query": {
+			inputQuery:    "CLUSTER add n4 127.0.0.1:7004",
+			expectedQuery: NewQuery(ClusterCommand, []string{"ADD", "n4", "127.0.0.1:7004"}),
+			expectedErr:   nil,
+		},
+		"invalid n of args of CLUSTER REMOVE": {
+			inputQuery:    "CLUSTER REMOVE",
+			expectedQuery: nil,
+			expectedErr:   ErrWrongNOfArgs,
+		},
+		"unknown subcommand of CLUSTER": {
+			inputQuery:    "CLUSTER JOIN n4",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"set quoted value": {
+			inputQuery:    `SET "my key" "hello\nworld"`,
+			expectedQuery: NewQuery(SetCommand, []string{"my key", "hello\nworld"}),
+			expectedErr:   nil,
+		},
+		"get empty key": {
+			inputQuery:    `GET ""`,
+			expectedQuery: NewQuery(GetCommand, []string{""}),
+			expectedErr:   nil,
+		},
+		"incr query": {
+			inputQuery:    "INCR counter",
+			expectedQuery: NewQuery(IncrCommand, []string{"counter"}),
+			expectedErr:   nil,
+		},
+		"decrby query": {
+			inputQuery:    "DECRBY counter -5",
+			expectedQuery: NewQuery(DecrByCommand, []string{"counter", "-5"}),
+			expectedErr:   nil,
+		},
+		"incrby with non integer increment": {
+			inputQuery:    "INCRBY counter 1.5",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
+		"incrbyfloat query": {
+			inputQuery:    "INCRBYFLOAT counter 0.1",
+			expectedQuery: NewQuery(IncrByFloatCommand, []string{"counter", "0.1"}),
+			expectedErr:   nil,
+		},
+		"incrbyfloat with infinite increment": {
+			inputQuery:    "INCRBYFLOAT counter inf",
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidArgs,
+		},
 		"empty query": {
 			inputQuery:    "",
 			expectedQuery: nil,
@@ -56,7 +421,7 @@ func TestParse(t *testing.T) {
 			expectedErr:   ErrWrongNOfArgs,
 		},
 		"invalid n of args of DEL": {
-			inputQuery:    "DEL baz bar boo faz",
+			inputQuery:    "DEL",
 			expectedQuery: nil,
 			expectedErr:   ErrWrongNOfArgs,
 		},
@@ -80,3 +445,42 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTokens(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tokens        []string
+		expectedQuery *Query
+		expectedErr   error
+	}{
+		"arguments with spaces": {
+			tokens:        []string{"SET", "foo", "bar baz"},
+			expectedQuery: NewQuery(SetCommand, []string{"foo", "bar baz"}),
+			expectedErr:   nil,
+		},
+		"empty argument": {
+			tokens:        []string{"GET", ""},
+			expectedQuery: NewQuery(GetCommand, []string{""}),
+			expectedErr:   nil,
+		},
+		"no tokens": {
+			tokens:        []string{},
+			expectedQuery: nil,
+			expectedErr:   ErrInvalidQuery,
+		},
+	}
+
+	p := NewQueryParser(zap.NewNop())
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			q, err := p.ParseTokens(tc.tokens)
+
+			assert.True(t, reflect.DeepEqual(tc.expectedQuery, q))
+			assert.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
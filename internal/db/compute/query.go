@@ -1,14 +1,10 @@
 package compute
 
-import "regexp"
-
 type Query struct {
 	Arguments   []string
 	CommandType CommandType
 }
 
-var QueryArgsRegExp *regexp.Regexp = regexp.MustCompile(`[^\s]+`)
-
 type QueryResult string
 
 var (
@@ -22,8 +18,155 @@ var (
 	SetCommand CommandType = "SET"
 	GetCommand CommandType = "GET"
 	DelCommand CommandType = "DEL"
+
+	MGetCommand CommandType = "MGET"
+	MSetCommand CommandType = "MSET"
+
+	ExpireCommand    CommandType = "EXPIRE"
+	PExpireCommand   CommandType = "PEXPIRE"
+	PExpireAtCommand CommandType = "PEXPIREAT"
+	TTLCommand       CommandType = "TTL"
+	PTTLCommand      CommandType = "PTTL"
+	PersistCommand   CommandType = "PERSIST"
+
+	IncrCommand        CommandType = "INCR"
+	DecrCommand        CommandType = "DECR"
+	IncrByCommand      CommandType = "INCRBY"
+	DecrByCommand      CommandType = "DECRBY"
+	IncrByFloatCommand CommandType = "INCRBYFLOAT"
+
+	SetNXCommand  CommandType = "SETNX"
+	GetSetCommand CommandType = "GETSET"
+	GetDelCommand CommandType = "GETDEL"
+	CASCommand    CommandType = "CAS"
+
+	KeysCommand CommandType = "KEYS"
+	ScanCommand CommandType = "SCAN"
+
+	RangeCommand  CommandType = "RANGE"
+	PrefixCommand CommandType = "PREFIX"
+
+	LPushCommand  CommandType = "LPUSH"
+	RPushCommand  CommandType = "RPUSH"
+	LPopCommand   CommandType = "LPOP"
+	RPopCommand   CommandType = "RPOP"
+	LLenCommand   CommandType = "LLEN"
+	LRangeCommand CommandType = "LRANGE"
+	LIndexCommand CommandType = "LINDEX"
+	LTrimCommand  CommandType = "LTRIM"
+	BLPopCommand  CommandType = "BLPOP"
+	BRPopCommand  CommandType = "BRPOP"
+
+	HSetCommand    CommandType = "HSET"
+	HGetCommand    CommandType = "HGET"
+	HMGetCommand   CommandType = "HMGET"
+	HDelCommand    CommandType = "HDEL"
+	HGetAllCommand CommandType = "HGETALL"
+	HKeysCommand   CommandType = "HKEYS"
+	HLenCommand    CommandType = "HLEN"
+	HIncrByCommand CommandType = "HINCRBY"
+
+	SAddCommand      CommandType = "SADD"
+	SRemCommand      CommandType = "SREM"
+	SMembersCommand  CommandType = "SMEMBERS"
+	SIsMemberCommand CommandType = "SISMEMBER"
+	SInterCommand    CommandType = "SINTER"
+	SUnionCommand    CommandType = "SUNION"
+	SDiffCommand     CommandType = "SDIFF"
+
+	ZAddCommand          CommandType = "ZADD"
+	ZRemCommand          CommandType = "ZREM"
+	ZScoreCommand        CommandType = "ZSCORE"
+	ZRangeCommand        CommandType = "ZRANGE"
+	ZRangeByScoreCommand CommandType = "ZRANGEBYSCORE"
+	ZRankCommand         CommandType = "ZRANK"
+	ZIncrByCommand       CommandType = "ZINCRBY"
+
+	// transaction commands are handled per connection by network layer
+	MultiCommand   CommandType = "MULTI"
+	ExecCommand    CommandType = "EXEC"
+	DiscardCommand CommandType = "DISCARD"
+	WatchCommand   CommandType = "WATCH"
+	UnwatchCommand CommandType = "UNWATCH"
+
+	PublishCommand CommandType = "PUBLISH"
+	// subscription commands are handled per connection by network layer
+	SubscribeCommand    CommandType = "SUBSCRIBE"
+	UnsubscribeCommand  CommandType = "UNSUBSCRIBE"
+	PSubscribeCommand   CommandType = "PSUBSCRIBE"
+	PUnsubscribeCommand CommandType = "PUNSUBSCRIBE"
+
+	// CDCCommand switches connection to stream of committed changes
+	CDCCommand CommandType = "CDC"
+	// SyncCommand switches connection of follower to stream of data of leader, it is only
+	// served over framed protocol
+	SyncCommand CommandType = "SYNC"
+
+	// ClusterCommand changes membership of raft cluster
+	ClusterCommand CommandType = "CLUSTER"
+
+	SnapshotCommand CommandType = "SNAPSHOT"
+	PingCommand     CommandType = "PING"
+	InfoCommand     CommandType = "INFO"
 )
 
+// CLUSTER subcommands, ADD takes id and address of node, REMOVE takes its id
+var (
+	ClusterAddSubcommand    = "ADD"
+	ClusterRemoveSubcommand = "REMOVE"
+)
+
+// SET options setting key expiration
+var (
+	ExpireSecondsOption        = "EX"
+	ExpireMillisecondsOption   = "PX"
+	ExpireAtMillisecondsOption = "PXAT"
+)
+
+// SET options making it conditional
+var (
+	IfNotExistsOption = "NX"
+	IfExistsOption    = "XX"
+)
+
+// SCAN options
+var (
+	MatchOption = "MATCH"
+	CountOption = "COUNT"
+)
+
+// LimitOption limits number of pairs returned by RANGE and PREFIX
+var LimitOption = "LIMIT"
+
+// WithScoresOption makes ZRANGE and ZRANGEBYSCORE reply scores after members
+var WithScoresOption = "WITHSCORES"
+
+// ExclusiveBoundPrefix marks score bound of ZRANGEBYSCORE which is not included into range
+var ExclusiveBoundPrefix = "("
+
+// IsBlocking reports whether command may wait for other clients, like BLPOP waits for push
+func (c CommandType) IsBlocking() bool {
+	return c == BLPopCommand || c == BRPopCommand
+}
+
+// IsWrite reports whether command may change data, followers reject such commands
+func (c CommandType) IsWrite() bool {
+	switch c {
+	case SetCommand, DelCommand, MSetCommand,
+		ExpireCommand, PExpireCommand, PExpireAtCommand, PersistCommand,
+		IncrCommand, DecrCommand, IncrByCommand, DecrByCommand, IncrByFloatCommand,
+		SetNXCommand, GetSetCommand, GetDelCommand, CASCommand,
+		LPushCommand, RPushCommand, LPopCommand, RPopCommand, LTrimCommand,
+		BLPopCommand, BRPopCommand,
+		HSetCommand, HDelCommand, HIncrByCommand,
+		SAddCommand, SRemCommand,
+		ZAddCommand, ZRemCommand, ZIncrByCommand:
+		return true
+	default:
+		return false
+	}
+}
+
 func NewQuery(c CommandType, args []string) *Query {
 	return &Query{
 		CommandType: c,
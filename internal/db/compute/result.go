@@ -0,0 +1,138 @@
+package compute
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type ResultType int
+
+const (
+	// OkResultType is a reply of command without value
+	OkResultType ResultType = iota
+	// StatusResultType is a short human readable reply, like PONG
+	StatusResultType
+	StringResultType
+	IntegerResultType
+	// NilResultType means there is no value, Err describes why for protocols without nulls
+	NilResultType
+	ErrorResultType
+	ArrayResultType
+)
+
+// Result is a protocol independent reply of executed query, network layer encodes it
+type Result struct {
+	Type  ResultType
+	Value string
+	Int   int64
+	Err   error
+	Array []Result
+}
+
+func OkResult() Result {
+	return Result{Type: OkResultType}
+}
+
+func StatusResult(status string) Result {
+	return Result{Type: StatusResultType, Value: status}
+}
+
+func StringResult(value string) Result {
+	return Result{Type: StringResultType, Value: value}
+}
+
+// BytesResult copies stored value into string result, go strings are binary safe
+func BytesResult(value []byte) Result {
+	return Result{Type: StringResultType, Value: string(value)}
+}
+
+func IntegerResult(value int64) Result {
+	return Result{Type: IntegerResultType, Int: value}
+}
+
+func NilResult(err error) Result {
+	return Result{Type: NilResultType, Err: err}
+}
+
+func ErrorResult(err error) Result {
+	return Result{Type: ErrorResultType, Err: err}
+}
+
+func ArrayResult(items []Result) Result {
+	return Result{Type: ArrayResultType, Array: items}
+}
+
+// String formats result for text protocol, like "[ok] value" or "[err] message"
+func (r Result) String() string {
+	switch r.Type {
+	case OkResultType:
+		return string(QueryOkResult)
+	case StatusResultType:
+		return fmt.Sprintf("%s %s", QueryOkResult, r.Value)
+	case StringResultType:
+		if needsQuoting(r.Value) {
+			return fmt.Sprintf("%s %s", QueryOkResult, strconv.Quote(r.Value))
+		}
+		return fmt.Sprintf("%s %s", QueryOkResult, r.Value)
+	case IntegerResultType:
+		return fmt.Sprintf("%s %s", QueryOkResult, strconv.FormatInt(r.Int, 10))
+	case ArrayResultType:
+		items := make([]string, 0, len(r.Array)+1)
+		items = append(items, string(QueryOkResult))
+		for _, item := range r.Array {
+			items = append(items, item.item())
+		}
+		return strings.Join(items, " ")
+	case NilResultType, ErrorResultType:
+		if r.Err != nil {
+			return fmt.Sprintf("%s %s", QueryErrorResult, r.Err.Error())
+		}
+		return string(QueryErrorResult)
+	default:
+		return string(QueryErrorResult)
+	}
+}
+
+// item formats result as element of array, strings are quoted so they can contain spaces
+func (r Result) item() string {
+	switch r.Type {
+	case OkResultType:
+		return "OK"
+	case StatusResultType, StringResultType:
+		return strconv.Quote(r.Value)
+	case IntegerResultType:
+		return strconv.FormatInt(r.Int, 10)
+	case ArrayResultType:
+		items := make([]string, 0, len(r.Array))
+		for _, item := range r.Array {
+			items = append(items, item.item())
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case ErrorResultType:
+		if r.Err != nil {
+			return "(error) " + strconv.Quote(r.Err.Error())
+		}
+		return "(error)"
+	default:
+		return "(nil)"
+	}
+}
+
+// needsQuoting reports whether value can't be written as is on a single response line,
+// quoted value uses the same escapes as query grammar
+func needsQuoting(value string) bool {
+	if value == "" || value[0] == '"' {
+		return true
+	}
+
+	for _, r := range value {
+		if r == utf8.RuneError || !unicode.IsPrint(r) && r != ' ' {
+			return true
+		}
+	}
+
+	return false
+}
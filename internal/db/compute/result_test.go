@@ -0,0 +1,62 @@
+package compute
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResult_String(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		result   Result
+		expected string
+	}{
+		"ok": {
+			result:   OkResult(),
+			expected: "[ok]",
+		},
+		"string": {
+			result:   StringResult("foo bar"),
+			expected: "[ok] foo bar",
+		},
+		"string with newline is quoted": {
+			result:   StringResult("foo\nbar"),
+			expected: `[ok] "foo\nbar"`,
+		},
+		"empty string is quoted": {
+			result:   StringResult(""),
+			expected: `[ok] ""`,
+		},
+		"integer": {
+			result:   IntegerResult(-2),
+			expected: "[ok] -2",
+		},
+		"nil with reason": {
+			result:   NilResult(errors.New("key not found")),
+			expected: "[err] key not found",
+		},
+		"error": {
+			result:   ErrorResult(ErrUnknownCommand),
+			expected: "[err] parse error: unknown command",
+		},
+		"array": {
+			result:   ArrayResult([]Result{StringResult("foo bar"), NilResult(nil), IntegerResult(1)}),
+			expected: `[ok] "foo bar" (nil) 1`,
+		},
+		"empty array": {
+			result:   ArrayResult(nil),
+			expected: "[ok]",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, tc.result.String())
+		})
+	}
+}
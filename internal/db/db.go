@@ -1,10 +1,20 @@
 package db
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/kirban/potato-db/internal/db/cdc"
 	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/kirban/potato-db/internal/replication"
 	"go.uber.org/zap"
 )
 
@@ -12,26 +22,128 @@ var (
 	ErrLoggerNotInitialized        = errors.New("logger is not initialized")
 	ErrComputeModuleNotInitialized = errors.New("compute module is not initialized")
 	ErrStorageModuleNotInitialized = errors.New("storage module is not initialized")
+	ErrInvalidExpireTime           = errors.New("invalid expire time")
+	ErrWatchedKeyChanged           = errors.New("transaction aborted: watched key changed")
+	ErrConditionNotMet             = errors.New("condition not met, key was not set")
+	ErrBlockTimeout                = errors.New("timeout, nothing was pushed")
+	ErrBlockCancelled              = errors.New("blocking command cancelled, server is shutting down")
+	ErrPubSubDisabled              = errors.New("pub/sub is disabled")
+	ErrEventsDisabled              = errors.New("keyspace events are disabled")
+	ErrCDCDisabled                 = errors.New("change data capture is disabled")
+	ErrCDCNotAllowed               = errors.New("CDC is allowed only as a standalone command")
+	ErrNotLeader                   = errors.New("replication is disabled, server is not a leader")
+	ErrReadOnlyReplica             = errors.New("writes are not allowed on a follower")
+	ErrClusterDisabled             = errors.New("cluster is disabled")
+	ErrClusterNotAllowed           = errors.New("CLUSTER is allowed only as a standalone command")
+	ErrClusterTimeout              = errors.New("write is not committed by cluster in time, it may be applied later")
+	ErrWatchNotSupported           = errors.New("WATCH is not supported in cluster mode")
+	ErrBlockingNotSupported        = errors.New("blocking commands are not supported in cluster mode")
+)
+
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
 )
 
 type Executable interface {
 	ExecuteQuery(q string) (string, error)
+	ExecuteCommand(tokens []string) compute.Result
+	ExecuteBlockingCommand(ctx context.Context, tokens []string) compute.Result
+	ValidateCommand(tokens []string) error
+	Watch(keys []string) (map[string]uint64, error)
+	ExecuteTransaction(commands [][]string, watched map[string]uint64) compute.Result
+	NewSubscriber() (*pubsub.Subscriber, error)
+	OpenChanges(tokens []string) (*cdc.Cursor, error)
+	StartSync(remote string) (*replication.Sync, error)
+	Role() string
 }
 
 type computeModule interface {
 	Compute(q string) (*compute.Query, error)
+	ComputeTokens(tokens []string) (*compute.Query, error)
 }
 
 type storageModule interface {
-	Set(k string, v string) error
-	Get(k string) (string, error)
-	Del(k string) error
+	Set(k string, v []byte) error
+	SetWithExpiry(k string, v []byte, expireAt time.Time) error
+	Get(k string) ([]byte, error)
+	MGet(keys []string) ([][]byte, []bool)
+	MSet(pairs []storage.KeyValue) error
+	Del(keys ...string) (int, error)
+	Expire(k string, expireAt time.Time) (bool, error)
+	Persist(k string) (bool, error)
+	TTL(k string) (time.Duration, bool, error)
+	IncrBy(k string, delta int64) (int64, error)
+	IncrByFloat(k string, delta float64) ([]byte, error)
+	SetIf(k string, v []byte, expireAt time.Time, cond storage.SetCondition) (bool, error)
+	GetSet(k string, v []byte) ([]byte, error)
+	GetDel(k string) ([]byte, error)
+	CompareAndSwap(k string, expected []byte, v []byte) (bool, error)
+	Keys(pattern string) ([]string, error)
+	Scan(cursor uint64, pattern string, count int) (uint64, []string, error)
+	Range(start string, end string, limit int) ([]storage.KeyValue, error)
+	Prefix(prefix string, limit int) ([]storage.KeyValue, error)
+	LPush(key string, values ...[]byte) (int, error)
+	RPush(key string, values ...[]byte) (int, error)
+	LPop(key string, count int) ([][]byte, error)
+	RPop(key string, count int) ([][]byte, error)
+	LLen(key string) (int, error)
+	LRange(key string, start int64, stop int64) ([][]byte, error)
+	LIndex(key string, index int64) ([]byte, error)
+	LTrim(key string, start int64, stop int64) error
+	BPop(keys []string, front bool) (*storage.BlockedPop, error)
+	CancelPop(p *storage.BlockedPop) bool
+	HSet(key string, pairs []storage.KeyValue) (int, error)
+	HGet(key string, field string) ([]byte, error)
+	HMGet(key string, fields ...string) ([][]byte, []bool, error)
+	HDel(key string, fields ...string) (int, error)
+	HGetAll(key string) ([]storage.KeyValue, error)
+	HKeys(key string) ([]string, error)
+	HLen(key string) (int, error)
+	HIncrBy(key string, field string, delta int64) (int64, error)
+	SAdd(key string, members ...string) (int, error)
+	SRem(key string, members ...string) (int, error)
+	SMembers(key string) ([]string, error)
+	SIsMember(key string, member string) (bool, error)
+	SInter(keys ...string) ([]string, error)
+	SUnion(keys ...string) ([]string, error)
+	SDiff(keys ...string) ([]string, error)
+	ZAdd(key string, members []storage.ScoredMember) (int, error)
+	ZRem(key string, members ...string) (int, error)
+	ZScore(key string, member string) (float64, error)
+	ZRange(key string, start int64, stop int64) ([]storage.ScoredMember, error)
+	ZRangeByScore(key string, from storage.ScoreBound, to storage.ScoreBound) ([]storage.ScoredMember, error)
+	ZRank(key string, member string) (int, error)
+	ZIncrBy(key string, member string, delta float64) (float64, error)
+	Recover() error
+	Close() error
+	Snapshot() error
+	Start(ctx context.Context)
+	Versions(keys []string) ([]uint64, error)
+	Atomic(fn func(tx *storage.Storage) error) error
+	Dump() (uint64, map[string]storage.Entry, error)
+	Load(data map[string]storage.Entry) error
+	Replicate(queries []compute.Query) error
 }
 
 type Database struct {
 	logger        *zap.Logger
 	computeModule computeModule
 	storageModule storageModule
+	// broker delivers messages of PUBLISH, it is optional
+	broker *pubsub.Broker
+	// events reports changes of keys made by storage, it is optional
+	events *storage.EventBus
+	// changes keeps the latest committed changes for CDC and followers, it is optional
+	changes *cdc.Log
+	// leader streams data to followers, follower keeps data in sync with leader and makes
+	// database read-only. At most one of them is set
+	leader   *replication.Leader
+	follower *replication.Follower
+	// cluster commits writes to raft log before they are applied, it is optional
+	cluster *cluster.Node
+	// execMu is held exclusively by transactions, so other queries never interleave with them
+	execMu sync.RWMutex
 }
 
 func NewDatabase(computeModule computeModule, storageModule storageModule, logger *zap.Logger) (*Database, error) {
@@ -54,31 +166,948 @@ func NewDatabase(computeModule computeModule, storageModule storageModule, logge
 	}, nil
 }
 
+// Recover restores data persisted by storage module before queries are served
+func (db *Database) Recover() error {
+	if err := db.storageModule.Recover(); err != nil {
+		db.logger.Error("failed to recover database", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// Close releases resources of storage module, queries must not be served after that
+func (db *Database) Close() error {
+	return db.storageModule.Close()
+}
+
+// Start runs background work of storage module, like active keys expiration, until ctx is done
+func (db *Database) Start(ctx context.Context) {
+	db.storageModule.Start(ctx)
+}
+
+// Snapshot saves point-in-time copy of stored data
+func (db *Database) Snapshot() error {
+	return db.storageModule.Snapshot()
+}
+
+// ExecuteQuery executes text protocol query and formats result as text reply
 func (db *Database) ExecuteQuery(q string) (string, error) {
 	query, err := db.computeModule.Compute(q)
 
 	if err != nil {
-		return fmt.Sprintf("%s %s", compute.QueryErrorResult, err.Error()), nil
+		return compute.ErrorResult(err).String(), nil
+	}
+
+	return db.dispatch(query).String(), nil
+}
+
+// ExecuteCommand executes query already split into command and arguments
+func (db *Database) ExecuteCommand(tokens []string) compute.Result {
+	query, err := db.computeModule.ComputeTokens(tokens)
+
+	if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	return db.dispatch(query)
+}
+
+// ExecuteBlockingCommand executes command which may wait for other clients, like BLPOP, until
+// timeout of command or ctx is done. Nothing is locked while waiting, so the others go on
+func (db *Database) ExecuteBlockingCommand(ctx context.Context, tokens []string) compute.Result {
+	query, err := db.computeModule.ComputeTokens(tokens)
+
+	if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	if !query.CommandType.IsBlocking() {
+		return db.dispatch(query)
+	}
+
+	if db.follower != nil {
+		return compute.ErrorResult(ErrReadOnlyReplica)
+	}
+
+	// waiting pop can't be a part of replicated log
+	if db.cluster != nil {
+		return compute.ErrorResult(ErrBlockingNotSupported)
+	}
+
+	keys, timeout := blockingArguments(query)
+
+	db.execMu.RLock()
+	pop, err := db.storageModule.BPop(keys, query.CommandType == compute.BLPopCommand)
+	db.execMu.RUnlock()
+
+	if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-pop.Done():
+		return popResult(pop)
+	case <-expired:
+		err = ErrBlockTimeout
+	case <-ctx.Done():
+		err = ErrBlockCancelled
+	}
+
+	// item may be popped for client while it stops waiting, it is replied then
+	if !db.storageModule.CancelPop(pop) {
+		return popResult(pop)
+	}
+
+	if errors.Is(err, ErrBlockTimeout) {
+		return compute.NilResult(err)
+	}
+	return compute.ErrorResult(err)
+}
+
+// NewSubscriber creates subscriber of pub/sub channels, caller must close it
+func (db *Database) NewSubscriber() (*pubsub.Subscriber, error) {
+	if db.broker == nil {
+		return nil, ErrPubSubDisabled
+	}
+
+	return db.broker.NewSubscriber(), nil
+}
+
+// OpenChanges parses CDC command and returns cursor reading committed changes from
+// requested sequence number
+func (db *Database) OpenChanges(tokens []string) (*cdc.Cursor, error) {
+	query, err := db.computeModule.ComputeTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.CommandType != compute.CDCCommand {
+		return nil, ErrCDCNotAllowed
+	}
+
+	if db.changes == nil {
+		return nil, ErrCDCDisabled
+	}
+
+	from, _ := strconv.ParseUint(query.Arguments[0], 10, 64)
+	return db.changes.Cursor(from)
+}
+
+// StartSync copies data for follower connected from remote address and opens cursor reading
+// changes after the copy, so follower gets every change exactly once
+func (db *Database) StartSync(remote string) (*replication.Sync, error) {
+	if db.leader == nil {
+		return nil, ErrNotLeader
+	}
+
+	seq, data, err := db.storageModule.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := db.changes.Cursor(seq + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Info("follower is connected", zap.String("remote", remote), zap.Uint64("offset", seq))
+	return db.leader.Attach(remote, seq, data, cursor), nil
+}
+
+// LoadReplica replaces all data with copy received from leader
+func (db *Database) LoadReplica(data map[string]storage.Entry) error {
+	db.execMu.Lock()
+	defer db.execMu.Unlock()
+
+	return db.storageModule.Load(data)
+}
+
+// ApplyReplicated applies change received from leader, queries of a single change are applied
+// atomically like transaction they are made by
+func (db *Database) ApplyReplicated(queries []compute.Query) error {
+	db.execMu.Lock()
+	defer db.execMu.Unlock()
+
+	return db.storageModule.Replicate(queries)
+}
+
+// SubscribeEvents calls fn for every change of keys of classes, see storage.EventBus for
+// restrictions on fn. Returned func cancels subscription
+func (db *Database) SubscribeEvents(classes storage.EventClass, fn func(storage.Event)) (cancel func(), err error) {
+	if db.events == nil {
+		return nil, ErrEventsDisabled
+	}
+
+	return db.events.Subscribe(classes, fn), nil
+}
+
+// ValidateCommand checks command without executing it, transactions validate commands on queueing
+func (db *Database) ValidateCommand(tokens []string) error {
+	_, err := db.computeModule.ComputeTokens(tokens)
+	return err
+}
+
+// Watch returns current versions of keys, transaction is aborted if any of them changes
+func (db *Database) Watch(keys []string) (map[string]uint64, error) {
+	// versions of keys are local to node, they are not replicated
+	if db.cluster != nil {
+		return nil, ErrWatchNotSupported
+	}
+
+	versions, err := db.storageModule.Versions(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	watched := make(map[string]uint64, len(keys))
+	for i, key := range keys {
+		watched[key] = versions[i]
+	}
+
+	return watched, nil
+}
+
+// ExecuteTransaction runs commands one after another so that no other query is executed in between,
+// nothing is executed if any of watched keys has changed. Failed command doesn't stop the others.
+// Transaction with writes is a single entry of cluster log
+func (db *Database) ExecuteTransaction(commands [][]string, watched map[string]uint64) compute.Result {
+	queries := make([]*compute.Query, len(commands))
+	writes := false
+	for i, tokens := range commands {
+		query, err := db.computeModule.ComputeTokens(tokens)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		queries[i] = query
+		writes = writes || query.CommandType.IsWrite()
+	}
+
+	if db.cluster != nil && writes {
+		return db.replicate(queries)
+	}
+
+	db.execMu.Lock()
+	defer db.execMu.Unlock()
+
+	keys := make([]string, 0, len(watched))
+	for key := range watched {
+		keys = append(keys, key)
+	}
+
+	versions, err := db.storageModule.Versions(keys)
+	if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	for i, key := range keys {
+		if versions[i] != watched[key] {
+			return compute.NilResult(ErrWatchedKeyChanged)
+		}
+	}
+
+	return db.executeAtomic(queries)
+}
+
+// executeAtomic runs queries as a single change of storage, caller must hold execMu
+func (db *Database) executeAtomic(queries []*compute.Query) compute.Result {
+	results := make([]compute.Result, len(queries))
+	err := db.storageModule.Atomic(func(tx *storage.Storage) error {
+		for i, query := range queries {
+			results[i] = db.execute(tx, query)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return compute.ErrorResult(err)
+	}
+
+	return compute.ArrayResult(results)
+}
+
+func (db *Database) execute(storageModule storageModule, query *compute.Query) compute.Result {
+	if db.follower != nil && query.CommandType.IsWrite() {
+		return compute.ErrorResult(ErrReadOnlyReplica)
 	}
 
 	switch query.CommandType {
 	case compute.GetCommand:
-		value, err := db.storageModule.Get(query.Arguments[0])
-		if err != nil {
-			return fmt.Sprintf("%s %s", compute.QueryErrorResult, err.Error()), nil
+		value, err := storageModule.Get(query.Arguments[0])
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
 		}
-		return fmt.Sprintf("%s %s", compute.QueryOkResult, value), nil
+		return compute.BytesResult(value)
 	case compute.SetCommand:
-		if err := db.storageModule.Set(query.Arguments[0], query.Arguments[1]); err != nil {
-			return fmt.Sprintf("%s %s", compute.QueryErrorResult, err.Error()), nil
+		expireAt, cond, err := parseSetOptions(query.Arguments[2:])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		key, value := query.Arguments[0], []byte(query.Arguments[1])
+		switch {
+		case cond != storage.SetAlways:
+			stored, err := storageModule.SetIf(key, value, expireAt, cond)
+			if err != nil {
+				return compute.ErrorResult(err)
+			}
+			if !stored {
+				return compute.NilResult(ErrConditionNotMet)
+			}
+		case !expireAt.IsZero():
+			err = storageModule.SetWithExpiry(key, value, expireAt)
+		default:
+			err = storageModule.Set(key, value)
+		}
+
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.OkResult()
+	case compute.SetNXCommand:
+		stored, err := storageModule.SetIf(query.Arguments[0], []byte(query.Arguments[1]), time.Time{}, storage.SetIfNotExists)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(boolToInt(stored))
+	case compute.GetSetCommand, compute.GetDelCommand:
+		var value []byte
+		var err error
+		if query.CommandType == compute.GetSetCommand {
+			value, err = storageModule.GetSet(query.Arguments[0], []byte(query.Arguments[1]))
+		} else {
+			value, err = storageModule.GetDel(query.Arguments[0])
+		}
+
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.BytesResult(value)
+	case compute.CASCommand:
+		swapped, err := storageModule.CompareAndSwap(query.Arguments[0], []byte(query.Arguments[1]), []byte(query.Arguments[2]))
+		if err != nil {
+			return compute.ErrorResult(err)
 		}
-		return fmt.Sprint(compute.QueryOkResult), nil
+		return compute.IntegerResult(boolToInt(swapped))
 	case compute.DelCommand:
-		if err := db.storageModule.Del(query.Arguments[0]); err != nil {
-			return fmt.Sprint(compute.QueryErrorResult, err.Error()), nil
+		deleted, err := storageModule.Del(query.Arguments...)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(deleted))
+	case compute.MGetCommand:
+		values, found := storageModule.MGet(query.Arguments)
+		return compute.ArrayResult(optionalResults(values, found))
+	case compute.MSetCommand:
+		pairs := make([]storage.KeyValue, 0, len(query.Arguments)/2)
+		for i := 0; i < len(query.Arguments); i += 2 {
+			pairs = append(pairs, storage.KeyValue{Key: query.Arguments[i], Value: []byte(query.Arguments[i+1])})
+		}
+
+		if err := storageModule.MSet(pairs); err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.OkResult()
+	case compute.ExpireCommand, compute.PExpireCommand, compute.PExpireAtCommand:
+		option := map[compute.CommandType]string{
+			compute.ExpireCommand:    compute.ExpireSecondsOption,
+			compute.PExpireCommand:   compute.ExpireMillisecondsOption,
+			compute.PExpireAtCommand: compute.ExpireAtMillisecondsOption,
+		}[query.CommandType]
+
+		expireAt, err := parseExpireAt(option, query.Arguments[1])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		updated, err := storageModule.Expire(query.Arguments[0], expireAt)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(boolToInt(updated))
+	case compute.PersistCommand:
+		updated, err := storageModule.Persist(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(boolToInt(updated))
+	case compute.TTLCommand, compute.PTTLCommand:
+		ttl, hasExpiry, err := storageModule.TTL(query.Arguments[0])
+		// same replies as in redis: -2 for missing key and -1 for key without expiration
+		switch {
+		case errors.Is(err, storage.ErrKeyNotFound):
+			return compute.IntegerResult(-2)
+		case err != nil:
+			return compute.ErrorResult(err)
+		case !hasExpiry:
+			return compute.IntegerResult(-1)
+		case query.CommandType == compute.TTLCommand:
+			return compute.IntegerResult(int64((ttl + 500*time.Millisecond) / time.Second))
+		default:
+			return compute.IntegerResult(ttl.Milliseconds())
+		}
+	case compute.IncrCommand, compute.DecrCommand, compute.IncrByCommand, compute.DecrByCommand:
+		delta, err := counterDelta(query)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		value, err := storageModule.IncrBy(query.Arguments[0], delta)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(value)
+	case compute.IncrByFloatCommand:
+		delta, err := strconv.ParseFloat(query.Arguments[1], 64)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		value, err := storageModule.IncrByFloat(query.Arguments[0], delta)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.BytesResult(value)
+	case compute.KeysCommand:
+		keys, err := storageModule.Keys(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.ArrayResult(stringResults(keys))
+	case compute.ScanCommand:
+		cursor, _ := strconv.ParseUint(query.Arguments[0], 10, 64)
+		pattern, count := scanOptions(query.Arguments[1:])
+
+		next, keys, err := storageModule.Scan(cursor, pattern, count)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		// reply is the same as in redis: next cursor and keys
+		return compute.ArrayResult([]compute.Result{
+			compute.StringResult(strconv.FormatUint(next, 10)),
+			compute.ArrayResult(stringResults(keys)),
+		})
+	case compute.RangeCommand, compute.PrefixCommand:
+		var pairs []storage.KeyValue
+		var err error
+		if query.CommandType == compute.RangeCommand {
+			pairs, err = storageModule.Range(query.Arguments[0], query.Arguments[1], rangeLimit(query.Arguments[2:]))
+		} else {
+			pairs, err = storageModule.Prefix(query.Arguments[0], rangeLimit(query.Arguments[1:]))
+		}
+
+		if err != nil {
+			return compute.ErrorResult(err)
 		}
-		return fmt.Sprint(compute.QueryOkResult), nil
+
+		// keys and values go one after another like in HGETALL reply
+		items := make([]compute.Result, 0, 2*len(pairs))
+		for _, pair := range pairs {
+			items = append(items, compute.StringResult(pair.Key), compute.BytesResult(pair.Value))
+		}
+		return compute.ArrayResult(items)
+	case compute.LPushCommand, compute.RPushCommand:
+		values := make([][]byte, 0, len(query.Arguments)-1)
+		for _, arg := range query.Arguments[1:] {
+			values = append(values, []byte(arg))
+		}
+
+		var n int
+		var err error
+		if query.CommandType == compute.LPushCommand {
+			n, err = storageModule.LPush(query.Arguments[0], values...)
+		} else {
+			n, err = storageModule.RPush(query.Arguments[0], values...)
+		}
+
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(n))
+	case compute.LPopCommand, compute.RPopCommand:
+		count := 1
+		if len(query.Arguments) == 2 {
+			count, _ = strconv.Atoi(query.Arguments[1])
+		}
+
+		var popped [][]byte
+		var err error
+		if query.CommandType == compute.LPopCommand {
+			popped, err = storageModule.LPop(query.Arguments[0], count)
+		} else {
+			popped, err = storageModule.RPop(query.Arguments[0], count)
+		}
+
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		// like in redis, single item is replied without array if count is not given
+		if len(query.Arguments) == 1 {
+			return compute.BytesResult(popped[0])
+		}
+		return compute.ArrayResult(bytesResults(popped))
+	case compute.BLPopCommand, compute.BRPopCommand:
+		// commands of transaction can't wait, so blocking pop times out right away if lists are empty
+		keys, _ := blockingArguments(query)
+
+		pop, err := storageModule.BPop(keys, query.CommandType == compute.BLPopCommand)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		if storageModule.CancelPop(pop) {
+			return compute.NilResult(ErrBlockTimeout)
+		}
+		return popResult(pop)
+	case compute.LLenCommand:
+		n, err := storageModule.LLen(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(n))
+	case compute.LRangeCommand:
+		start, _ := strconv.ParseInt(query.Arguments[1], 10, 64)
+		stop, _ := strconv.ParseInt(query.Arguments[2], 10, 64)
+
+		items, err := storageModule.LRange(query.Arguments[0], start, stop)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.ArrayResult(bytesResults(items))
+	case compute.LIndexCommand:
+		index, _ := strconv.ParseInt(query.Arguments[1], 10, 64)
+
+		item, err := storageModule.LIndex(query.Arguments[0], index)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.BytesResult(item)
+	case compute.LTrimCommand:
+		start, _ := strconv.ParseInt(query.Arguments[1], 10, 64)
+		stop, _ := strconv.ParseInt(query.Arguments[2], 10, 64)
+
+		if err := storageModule.LTrim(query.Arguments[0], start, stop); err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.OkResult()
+	case compute.HSetCommand:
+		pairs := make([]storage.KeyValue, 0, len(query.Arguments)/2)
+		for i := 1; i < len(query.Arguments); i += 2 {
+			pairs = append(pairs, storage.KeyValue{Key: query.Arguments[i], Value: []byte(query.Arguments[i+1])})
+		}
+
+		added, err := storageModule.HSet(query.Arguments[0], pairs)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(added))
+	case compute.HGetCommand:
+		value, err := storageModule.HGet(query.Arguments[0], query.Arguments[1])
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.BytesResult(value)
+	case compute.HMGetCommand:
+		values, found, err := storageModule.HMGet(query.Arguments[0], query.Arguments[1:]...)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.ArrayResult(optionalResults(values, found))
+	case compute.HDelCommand:
+		deleted, err := storageModule.HDel(query.Arguments[0], query.Arguments[1:]...)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(deleted))
+	case compute.HGetAllCommand:
+		pairs, err := storageModule.HGetAll(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		items := make([]compute.Result, 0, 2*len(pairs))
+		for _, pair := range pairs {
+			items = append(items, compute.StringResult(pair.Key), compute.BytesResult(pair.Value))
+		}
+		return compute.ArrayResult(items)
+	case compute.HKeysCommand:
+		fields, err := storageModule.HKeys(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.ArrayResult(stringResults(fields))
+	case compute.HLenCommand:
+		n, err := storageModule.HLen(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(n))
+	case compute.HIncrByCommand:
+		delta, _ := strconv.ParseInt(query.Arguments[2], 10, 64)
+
+		n, err := storageModule.HIncrBy(query.Arguments[0], query.Arguments[1], delta)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(n)
+	case compute.SAddCommand, compute.SRemCommand:
+		var n int
+		var err error
+		if query.CommandType == compute.SAddCommand {
+			n, err = storageModule.SAdd(query.Arguments[0], query.Arguments[1:]...)
+		} else {
+			n, err = storageModule.SRem(query.Arguments[0], query.Arguments[1:]...)
+		}
+
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(n))
+	case compute.SMembersCommand:
+		members, err := storageModule.SMembers(query.Arguments[0])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.ArrayResult(stringResults(members))
+	case compute.SIsMemberCommand:
+		contains, err := storageModule.SIsMember(query.Arguments[0], query.Arguments[1])
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(boolToInt(contains))
+	case compute.SInterCommand, compute.SUnionCommand, compute.SDiffCommand:
+		combine := map[compute.CommandType]func(keys ...string) ([]string, error){
+			compute.SInterCommand: storageModule.SInter,
+			compute.SUnionCommand: storageModule.SUnion,
+			compute.SDiffCommand:  storageModule.SDiff,
+		}[query.CommandType]
+
+		members, err := combine(query.Arguments...)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.ArrayResult(stringResults(members))
+	case compute.ZAddCommand:
+		members := make([]storage.ScoredMember, 0, len(query.Arguments)/2)
+		for i := 1; i < len(query.Arguments); i += 2 {
+			score, _ := strconv.ParseFloat(query.Arguments[i], 64)
+			members = append(members, storage.ScoredMember{Member: query.Arguments[i+1], Score: score})
+		}
+
+		added, err := storageModule.ZAdd(query.Arguments[0], members)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(added))
+	case compute.ZRemCommand:
+		removed, err := storageModule.ZRem(query.Arguments[0], query.Arguments[1:]...)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(removed))
+	case compute.ZScoreCommand:
+		score, err := storageModule.ZScore(query.Arguments[0], query.Arguments[1])
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.StringResult(storage.FormatScore(score))
+	case compute.ZRankCommand:
+		rank, err := storageModule.ZRank(query.Arguments[0], query.Arguments[1])
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return compute.NilResult(err)
+		} else if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.IntegerResult(int64(rank))
+	case compute.ZRangeCommand, compute.ZRangeByScoreCommand:
+		var members []storage.ScoredMember
+		var err error
+		if query.CommandType == compute.ZRangeCommand {
+			start, _ := strconv.ParseInt(query.Arguments[1], 10, 64)
+			stop, _ := strconv.ParseInt(query.Arguments[2], 10, 64)
+			members, err = storageModule.ZRange(query.Arguments[0], start, stop)
+		} else {
+			members, err = storageModule.ZRangeByScore(query.Arguments[0], parseScoreBound(query.Arguments[1]), parseScoreBound(query.Arguments[2]))
+		}
+
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+
+		withScores := len(query.Arguments) == 4
+		items := make([]compute.Result, 0, 2*len(members))
+		for _, member := range members {
+			items = append(items, compute.StringResult(member.Member))
+			if withScores {
+				items = append(items, compute.StringResult(storage.FormatScore(member.Score)))
+			}
+		}
+		return compute.ArrayResult(items)
+	case compute.ZIncrByCommand:
+		delta, _ := strconv.ParseFloat(query.Arguments[1], 64)
+
+		score, err := storageModule.ZIncrBy(query.Arguments[0], query.Arguments[2], delta)
+		if err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.StringResult(storage.FormatScore(score))
+	case compute.PublishCommand:
+		if db.broker == nil {
+			return compute.ErrorResult(ErrPubSubDisabled)
+		}
+		return compute.IntegerResult(int64(db.broker.Publish(query.Arguments[0], query.Arguments[1])))
+	case compute.CDCCommand:
+		// stream takes over connection, so it can't be a part of transaction or http request
+		return compute.ErrorResult(ErrCDCNotAllowed)
+	case compute.ClusterCommand:
+		// membership change waits for cluster, so it can't be a part of transaction
+		if db.cluster == nil {
+			return compute.ErrorResult(ErrClusterDisabled)
+		}
+		return compute.ErrorResult(ErrClusterNotAllowed)
+	case compute.SnapshotCommand:
+		if err := storageModule.Snapshot(); err != nil {
+			return compute.ErrorResult(err)
+		}
+		return compute.OkResult()
+	case compute.PingCommand:
+		if len(query.Arguments) == 1 {
+			return compute.StringResult(query.Arguments[0])
+		}
+		return compute.StatusResult("PONG")
+	case compute.InfoCommand:
+		// like in redis all sections are replied without argument and unknown section is empty
+		if len(query.Arguments) == 0 {
+			return compute.StringResult(db.replicationInfo() + "\r\n\r\n" + db.clusterInfo())
+		}
+
+		switch strings.ToLower(query.Arguments[0]) {
+		case "replication":
+			return compute.StringResult(db.replicationInfo())
+		case "cluster":
+			return compute.StringResult(db.clusterInfo())
+		}
+		return compute.StringResult("")
+	}
+
+	return compute.ErrorResult(compute.ErrUnknownCommand)
+}
+
+// parseExpireAt converts expiration option validated by parser into absolute time
+func parseExpireAt(option string, rawValue string) (time.Time, error) {
+	value, _ := strconv.ParseInt(rawValue, 10, 64)
+
+	unit := time.Millisecond
+	if option == compute.ExpireSecondsOption {
+		unit = time.Second
+	}
+
+	if value > math.MaxInt64/int64(unit) || value < math.MinInt64/int64(unit) {
+		return time.Time{}, ErrInvalidExpireTime
+	}
+
+	if option == compute.ExpireAtMillisecondsOption {
+		return time.UnixMilli(value), nil
+	}
+
+	return time.Now().Add(time.Duration(value) * unit), nil
+}
+
+// parseSetOptions returns expiration and condition of SET, zero time means no expiration
+func parseSetOptions(options []string) (time.Time, storage.SetCondition, error) {
+	var expireAt time.Time
+	cond := storage.SetAlways
+
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case compute.IfNotExistsOption:
+			cond = storage.SetIfNotExists
+		case compute.IfExistsOption:
+			cond = storage.SetIfExists
+		default:
+			var err error
+			if expireAt, err = parseExpireAt(options[i], options[i+1]); err != nil {
+				return time.Time{}, storage.SetAlways, err
+			}
+			i++
+		}
+	}
+
+	return expireAt, cond, nil
+}
+
+// defaultScanCount is number of keys SCAN reads when COUNT is not given
+const defaultScanCount = 10
+
+// scanOptions returns pattern and count of SCAN options validated by parser
+func scanOptions(options []string) (string, int) {
+	pattern, count := "*", defaultScanCount
+
+	for i := 0; i+1 < len(options); i += 2 {
+		switch options[i] {
+		case compute.MatchOption:
+			pattern = options[i+1]
+		case compute.CountOption:
+			count, _ = strconv.Atoi(options[i+1])
+		}
+	}
+
+	return pattern, count
+}
+
+// rangeLimit returns LIMIT of RANGE and PREFIX validated by parser, zero means no limit
+func rangeLimit(options []string) int {
+	if len(options) != 2 {
+		return 0
+	}
+
+	limit, _ := strconv.Atoi(options[1])
+	return limit
+}
+
+// blockingArguments returns keys and timeout of BLPOP or BRPOP, zero timeout means waiting forever
+func blockingArguments(query *compute.Query) ([]string, time.Duration) {
+	keys := query.Arguments[:len(query.Arguments)-1]
+	seconds, _ := strconv.ParseFloat(query.Arguments[len(query.Arguments)-1], 64)
+
+	// timeout which doesn't fit into duration is as good as forever
+	if seconds == 0 || seconds*float64(time.Second) >= math.MaxInt64 {
+		return keys, 0
+	}
+
+	return keys, max(time.Duration(seconds*float64(time.Second)), time.Nanosecond)
+}
+
+// popResult replies popped item with its list like redis does
+func popResult(pop *storage.BlockedPop) compute.Result {
+	key, value := pop.Result()
+	return compute.ArrayResult([]compute.Result{compute.StringResult(key), compute.BytesResult(value)})
+}
+
+func stringResults(values []string) []compute.Result {
+	items := make([]compute.Result, len(values))
+	for i, value := range values {
+		items[i] = compute.StringResult(value)
+	}
+
+	return items
+}
+
+// parseScoreBound converts score bound of ZRANGEBYSCORE validated by parser
+func parseScoreBound(value string) storage.ScoreBound {
+	trimmed := strings.TrimPrefix(value, compute.ExclusiveBoundPrefix)
+	score, _ := strconv.ParseFloat(trimmed, 64)
+
+	return storage.ScoreBound{Score: score, Exclusive: trimmed != value}
+}
+
+// optionalResults replies nil for values which are not found
+func optionalResults(values [][]byte, found []bool) []compute.Result {
+	items := make([]compute.Result, len(values))
+	for i, value := range values {
+		if found[i] {
+			items[i] = compute.BytesResult(value)
+		} else {
+			items[i] = compute.NilResult(nil)
+		}
+	}
+
+	return items
+}
+
+func bytesResults(values [][]byte) []compute.Result {
+	items := make([]compute.Result, len(values))
+	for i, value := range values {
+		items[i] = compute.BytesResult(value)
+	}
+
+	return items
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// counterDelta returns increment of INCR, DECR, INCRBY or DECRBY query
+func counterDelta(query *compute.Query) (int64, error) {
+	switch query.CommandType {
+	case compute.IncrCommand:
+		return 1, nil
+	case compute.DecrCommand:
+		return -1, nil
+	}
+
+	delta, err := strconv.ParseInt(query.Arguments[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if query.CommandType == compute.DecrByCommand {
+		// negation of the minimal value doesn't fit into int64
+		if delta == math.MinInt64 {
+			return 0, storage.ErrIncrOverflow
+		}
+		delta = -delta
+	}
+
+	return delta, nil
+}
+
+// Role tells whether server takes writes like role of redis does: master for leader or standalone
+// server, replica for replication follower or cluster member which is not a leader now
+func (db *Database) Role() string {
+	switch {
+	case db.follower != nil:
+		return RoleReplica
+	case db.cluster != nil && db.cluster.Status().State != cluster.Leader:
+		return RoleReplica
+	}
+
+	return RoleMaster
+}
+
+// replicationInfo formats replication section of INFO, lines are separated like in redis
+func (db *Database) replicationInfo() string {
+	lines := []string{"role:standalone"}
+	switch {
+	case db.leader != nil:
+		lines = db.leader.Info()
+	case db.follower != nil:
+		lines = db.follower.Info()
+	}
+
+	return "# Replication\r\n" + strings.Join(lines, "\r\n")
+}
+
+// clusterInfo formats cluster section of INFO
+func (db *Database) clusterInfo() string {
+	lines := []string{"cluster_enabled:0"}
+	if db.cluster != nil {
+		lines = db.cluster.Info()
 	}
 
-	return fmt.Sprintf("%s", compute.QueryErrorResult), nil
+	return "# Cluster\r\n" + strings.Join(lines, "\r\n")
 }
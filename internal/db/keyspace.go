@@ -0,0 +1,22 @@
+package db
+
+import (
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/pubsub"
+)
+
+const (
+	// KeyspaceChannelPrefix is followed by key, message is name of event
+	KeyspaceChannelPrefix = "__keyspace__:"
+	// KeyeventChannelPrefix is followed by name of event, message is key
+	KeyeventChannelPrefix = "__keyevent__:"
+)
+
+// publishKeyspaceEvent sends events to pub/sub channels like redis keyspace notifications,
+// broker never blocks, so it is safe to call it from storage
+func publishKeyspaceEvent(broker *pubsub.Broker) func(storage.Event) {
+	return func(event storage.Event) {
+		broker.Publish(KeyspaceChannelPrefix+event.Key, event.Name)
+		broker.Publish(KeyeventChannelPrefix+event.Name, event.Key)
+	}
+}
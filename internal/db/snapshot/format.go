@@ -0,0 +1,266 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+var (
+	ErrCorruptedSnapshot = errors.New("snapshot: corrupted file")
+)
+
+const (
+	// version 2 stores expiration time of every entry, version 3 stores type of value and
+	// items of collections
+	formatVersion byte = 3
+	// maxChunkSize protects from allocating garbage sizes of corrupted file
+	maxChunkSize = 1 << 30
+)
+
+var magic = []byte("PTSNAP")
+
+// Encode writes data in snapshot format, it is also used to send data to replicas.
+// File layout: magic | version | lsn | count | entries... | crc32 of everything before it, where entry
+// is key size | type | expire at | key | value size | value for strings and key size | type | expire at |
+// key | items count | (item size | item)... for collections
+func Encode(w io.Writer, lsn uint64, data map[string]storage.Entry) error {
+	checksum := crc32.NewIEEE()
+	writer := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	header := make([]byte, 0, len(magic)+1+8+binary.MaxVarintLen64)
+	header = append(header, magic...)
+	header = append(header, formatVersion)
+	header = binary.BigEndian.AppendUint64(header, lsn)
+	header = binary.AppendUvarint(header, uint64(len(data)))
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 3*binary.MaxVarintLen64)
+	for key, entry := range data {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(key)))
+		buf = append(buf, byte(entry.Type()))
+		buf = binary.AppendUvarint(buf, uint64(entry.ExpireAt))
+
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+		if _, err := writer.WriteString(key); err != nil {
+			return err
+		}
+
+		items := [][]byte{entry.Value}
+		if entry.Collection != nil {
+			items = entry.Collection.Items()
+			buf = binary.AppendUvarint(buf[:0], uint64(len(items)))
+			if _, err := writer.Write(buf); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range items {
+			buf = binary.AppendUvarint(buf[:0], uint64(len(item)))
+			if _, err := writer.Write(buf); err != nil {
+				return err
+			}
+			if _, err := writer.Write(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, checksum.Sum32())
+}
+
+type checksumReader struct {
+	reader   *bufio.Reader
+	checksum hash.Hash32
+}
+
+func (r *checksumReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		_, _ = r.checksum.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *checksumReader) readFull(size uint64) ([]byte, error) {
+	if size > maxChunkSize {
+		return nil, ErrCorruptedSnapshot
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return nil, ErrCorruptedSnapshot
+	}
+	_, _ = r.checksum.Write(buf)
+
+	return buf, nil
+}
+
+// readSized reads chunk prefixed with its size
+func (r *checksumReader) readSized() ([]byte, error) {
+	size, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.readFull(size)
+}
+
+func (r *checksumReader) readUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, ErrCorruptedSnapshot
+	}
+	return v, nil
+}
+
+// Decode reads data written by Encode and verifies its checksum
+func Decode(rd io.Reader) (uint64, map[string]storage.Entry, error) {
+	r := &checksumReader{reader: bufio.NewReader(rd), checksum: crc32.NewIEEE()}
+
+	header, err := r.readFull(uint64(len(magic) + 1 + 8))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version := header[len(magic)]
+	if version == 0 || version > formatVersion {
+		return 0, nil, ErrCorruptedSnapshot
+	}
+
+	lsn, err := decodeLSN(header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	count, err := r.readUvarint()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// count is not trusted before checksum is verified, so it only hints capacity
+	data := make(map[string]storage.Entry, min(count, 1<<16))
+	for i := uint64(0); i < count; i++ {
+		var key string
+		var entry storage.Entry
+		if version < 3 {
+			key, entry, err = decodeStringEntry(r, version)
+		} else {
+			key, entry, err = decodeEntry(r)
+		}
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		data[key] = entry
+	}
+
+	var expected uint32
+	if err := binary.Read(r.reader, binary.BigEndian, &expected); err != nil {
+		return 0, nil, ErrCorruptedSnapshot
+	}
+
+	if expected != r.checksum.Sum32() {
+		return 0, nil, ErrCorruptedSnapshot
+	}
+
+	return lsn, data, nil
+}
+
+func decodeEntry(r *checksumReader) (string, storage.Entry, error) {
+	keySize, err := r.readUvarint()
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	valueType, err := r.ReadByte()
+	if err != nil {
+		return "", storage.Entry{}, ErrCorruptedSnapshot
+	}
+
+	expireAt, err := r.readUvarint()
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	key, err := r.readFull(keySize)
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	entry := storage.Entry{ExpireAt: int64(expireAt)}
+	if storage.ValueType(valueType) == storage.StringType {
+		entry.Value, err = r.readSized()
+		return string(key), entry, err
+	}
+
+	n, err := r.readUvarint()
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	items := make([][]byte, 0, min(n, 1<<16))
+	for j := uint64(0); j < n; j++ {
+		item, err := r.readSized()
+		if err != nil {
+			return "", storage.Entry{}, err
+		}
+		items = append(items, item)
+	}
+
+	if entry.Collection, err = storage.NewCollection(storage.ValueType(valueType), items); err != nil {
+		return "", storage.Entry{}, ErrCorruptedSnapshot
+	}
+
+	return string(key), entry, nil
+}
+
+// decodeStringEntry reads entries of versions 1 and 2 which hold only strings
+func decodeStringEntry(r *checksumReader, version byte) (string, storage.Entry, error) {
+	keySize, err := r.readUvarint()
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	valueSize, err := r.readUvarint()
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	var expireAt uint64
+	if version > 1 {
+		if expireAt, err = r.readUvarint(); err != nil {
+			return "", storage.Entry{}, err
+		}
+	}
+
+	kv, err := r.readFull(keySize + valueSize)
+	if err != nil {
+		return "", storage.Entry{}, err
+	}
+
+	return string(kv[:keySize]), storage.Entry{Value: kv[keySize:], ExpireAt: int64(expireAt)}, nil
+}
+
+func decodeLSN(header []byte) (uint64, error) {
+	if len(header) < len(magic)+1+8 || string(header[:len(magic)]) != string(magic) {
+		return 0, ErrCorruptedSnapshot
+	}
+
+	return binary.BigEndian.Uint64(header[len(magic)+1:]), nil
+}
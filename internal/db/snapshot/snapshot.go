@@ -0,0 +1,212 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidLogger = errors.New("invalid logger")
+	ErrInvalidConfig = errors.New("invalid snapshot config")
+)
+
+const (
+	snapshotPrefix    = "snapshot_"
+	snapshotExtension = ".snap"
+	tempExtension     = ".tmp"
+)
+
+type Snapshotter struct {
+	logger    *zap.Logger
+	directory string
+	interval  time.Duration
+	retain    int
+}
+
+func NewSnapshotter(logger *zap.Logger, cfg *config.SnapshotConfigOptions) (*Snapshotter, error) {
+	if logger == nil {
+		return nil, ErrInvalidLogger
+	}
+
+	if cfg == nil || cfg.DataDirectory == "" || cfg.Retain <= 0 || cfg.Interval < 0 {
+		return nil, ErrInvalidConfig
+	}
+
+	if err := os.MkdirAll(cfg.DataDirectory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	return &Snapshotter{
+		logger:    logger,
+		directory: cfg.DataDirectory,
+		interval:  cfg.Interval,
+		retain:    cfg.Retain,
+	}, nil
+}
+
+// Start calls take every interval until ctx is done, zero interval disables periodic snapshots
+func (s *Snapshotter) Start(ctx context.Context, take func() error) {
+	if s.interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := take(); err != nil {
+				s.logger.Error("periodic snapshot failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Save atomically writes data as the newest snapshot and removes ones beyond retain count
+func (s *Snapshotter) Save(lsn uint64, data map[string]storage.Entry) error {
+	name := fmt.Sprintf("%s%020d%s", snapshotPrefix, time.Now().UnixNano(), snapshotExtension)
+	path := filepath.Join(s.directory, name)
+
+	file, err := os.Create(path + tempExtension)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	if err := Encode(file, lsn, data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+
+	s.logger.Info("snapshot saved", zap.String("path", path), zap.Int("keys", len(data)), zap.Uint64("lsn", lsn))
+	s.prune()
+
+	return nil
+}
+
+// LoadLatest returns the newest readable snapshot, ok is false when there is none
+func (s *Snapshotter) LoadLatest() (lsn uint64, data map[string]storage.Entry, ok bool, err error) {
+	paths, err := s.list()
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		lsn, data, err = readSnapshot(paths[i])
+		if err == nil {
+			s.logger.Info("snapshot loaded", zap.String("path", paths[i]), zap.Int("keys", len(data)), zap.Uint64("lsn", lsn))
+			return lsn, data, true, nil
+		}
+
+		s.logger.Warn("skipping unreadable snapshot", zap.String("path", paths[i]), zap.Error(err))
+	}
+
+	return 0, nil, false, nil
+}
+
+// OldestLSN returns lsn of the oldest retained snapshot, log records up to it are not needed anymore
+func (s *Snapshotter) OldestLSN() (uint64, bool) {
+	paths, err := s.list()
+	if err != nil || len(paths) == 0 {
+		return 0, false
+	}
+
+	for _, path := range paths {
+		lsn, err := readLSN(path)
+		if err == nil {
+			return lsn, true
+		}
+	}
+
+	return 0, false
+}
+
+func (s *Snapshotter) prune() {
+	paths, err := s.list()
+	if err != nil {
+		s.logger.Error("failed to list snapshots", zap.Error(err))
+		return
+	}
+
+	for len(paths) > s.retain {
+		if err := os.Remove(paths[0]); err != nil {
+			s.logger.Error("failed to remove old snapshot", zap.Error(err))
+			return
+		}
+		paths = paths[1:]
+	}
+}
+
+// list returns complete snapshot files sorted from oldest to newest
+func (s *Snapshotter) list() ([]string, error) {
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotExtension) {
+			continue
+		}
+		paths = append(paths, filepath.Join(s.directory, name))
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func readSnapshot(path string) (uint64, map[string]storage.Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	return Decode(file)
+}
+
+func readLSN(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	header := make([]byte, len(magic)+1+8)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, err
+	}
+
+	return decodeLSN(header)
+}
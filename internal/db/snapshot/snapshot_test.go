@@ -0,0 +1,154 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestSnapshotter(t *testing.T, retain int) *Snapshotter {
+	s, err := NewSnapshotter(zap.NewNop(), &config.SnapshotConfigOptions{
+		DataDirectory: t.TempDir(),
+		Interval:      10 * time.Millisecond,
+		Retain:        retain,
+	})
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestNewSnapshotter(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		logger      *zap.Logger
+		config      *config.SnapshotConfigOptions
+		expectedErr error
+	}{
+		"valid config": {
+			logger: zap.NewNop(),
+			config: &config.SnapshotConfigOptions{DataDirectory: t.TempDir(), Retain: 1},
+		},
+		"nil logger": {
+			config:      &config.SnapshotConfigOptions{DataDirectory: t.TempDir(), Retain: 1},
+			expectedErr: ErrInvalidLogger,
+		},
+		"nil config": {
+			logger:      zap.NewNop(),
+			expectedErr: ErrInvalidConfig,
+		},
+		"zero retain": {
+			logger:      zap.NewNop(),
+			config:      &config.SnapshotConfigOptions{DataDirectory: t.TempDir()},
+			expectedErr: ErrInvalidConfig,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewSnapshotter(tc.logger, tc.config)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if tc.expectedErr != nil {
+				assert.Nil(t, s)
+			} else {
+				assert.NotNil(t, s)
+			}
+		})
+	}
+}
+
+func TestSnapshotter_SaveAndLoad(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSnapshotter(t, 2)
+
+	_, _, ok, err := s.LoadLatest()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	list, err := storage.NewCollection(storage.ListType, [][]byte{[]byte("a"), []byte(""), []byte("c")})
+	require.NoError(t, err)
+
+	data := map[string]storage.Entry{
+		"list":     {Collection: list, ExpireAt: time.Now().Add(time.Hour).UnixNano()},
+		"foo":      {Value: []byte("bar")},
+		"empty":    {Value: []byte("")},
+		"binary":   {Value: []byte("\x00\xff\n")},
+		"volatile": {Value: []byte("baz"), ExpireAt: time.Now().Add(time.Hour).UnixNano()},
+	}
+	require.NoError(t, s.Save(42, data))
+
+	lsn, loaded, ok, err := s.LoadLatest()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(42), lsn)
+	assert.Equal(t, data, loaded)
+}
+
+func TestSnapshotter_Retain(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSnapshotter(t, 2)
+
+	for lsn := uint64(1); lsn <= 4; lsn++ {
+		require.NoError(t, s.Save(lsn, map[string]storage.Entry{"lsn": {Value: []byte("value")}}))
+	}
+
+	paths, err := s.list()
+	require.NoError(t, err)
+	assert.Len(t, paths, 2)
+
+	oldest, ok := s.OldestLSN()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), oldest)
+}
+
+func TestSnapshotter_CorruptedFallback(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSnapshotter(t, 2)
+	require.NoError(t, s.Save(1, map[string]storage.Entry{"foo": {Value: []byte("old")}}))
+	require.NoError(t, s.Save(2, map[string]storage.Entry{"foo": {Value: []byte("new")}}))
+
+	paths, err := s.list()
+	require.NoError(t, err)
+
+	// flip a byte in the newest snapshot
+	raw, err := os.ReadFile(paths[1])
+	require.NoError(t, err)
+	raw[len(raw)-6] ^= 0xff
+	require.NoError(t, os.WriteFile(paths[1], raw, 0o644))
+
+	lsn, data, ok, err := s.LoadLatest()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), lsn)
+	assert.Equal(t, []byte("old"), data["foo"].Value)
+}
+
+func TestSnapshotter_Start(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSnapshotter(t, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var taken atomic.Int32
+	s.Start(ctx, func() error {
+		taken.Add(1)
+		return nil
+	})
+
+	assert.Greater(t, taken.Load(), int32(1))
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"go.uber.org/zap"
+)
+
+// BlockedPop is a pop of client waiting until any of its lists is pushed
+type BlockedPop struct {
+	keys  []string
+	front bool
+	done  chan struct{}
+	key   string
+	value []byte
+}
+
+// Done is closed when item is popped for client
+func (p *BlockedPop) Done() <-chan struct{} {
+	return p.done
+}
+
+// Result returns popped item and its list, it must be called only after Done is closed
+func (p *BlockedPop) Result() (key string, value []byte) {
+	return p.key, p.value
+}
+
+func (p *BlockedPop) serve(key string, value []byte) {
+	p.key, p.value = key, value
+	close(p.done)
+}
+
+// blockedPops keeps clients waiting for each list in order of arrival, so the one waiting
+// longer is served first
+type blockedPops struct {
+	mu     sync.Mutex
+	queues map[string][]*BlockedPop
+	// waiting lets pushes skip the lock while nobody waits
+	waiting atomic.Int64
+}
+
+func newBlockedPops() *blockedPops {
+	return &blockedPops{queues: make(map[string][]*BlockedPop)}
+}
+
+func (b *blockedPops) add(p *BlockedPop) {
+	for _, key := range p.keys {
+		// key repeated in command is waited once
+		if !slices.Contains(b.queues[key], p) {
+			b.queues[key] = append(b.queues[key], p)
+		}
+	}
+}
+
+func (b *blockedPops) remove(p *BlockedPop) {
+	for _, key := range p.keys {
+		queue := slices.DeleteFunc(b.queues[key], func(other *BlockedPop) bool { return other == p })
+		if len(queue) == 0 {
+			delete(b.queues, key)
+		} else {
+			b.queues[key] = queue
+		}
+	}
+	b.waiting.Add(-1)
+}
+
+// BPop pops item from the first non-empty list of keys, if all of them are empty pop waits
+// until any list is pushed. Item is logged as popped by LPOP or RPOP, so replay doesn't
+// depend on clients which were waiting
+func (s *Storage) BPop(keys []string, front bool) (*BlockedPop, error) {
+	p := &BlockedPop{keys: keys, front: front, done: make(chan struct{})}
+
+	s.blocked.mu.Lock()
+	defer s.blocked.mu.Unlock()
+
+	// pop is counted before lists are checked, so push which comes right after the check
+	// takes the lock and serves it
+	s.blocked.waiting.Add(1)
+
+	for _, key := range keys {
+		popped, err := s.pop(popCommand(front), key, 1)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+
+		s.blocked.waiting.Add(-1)
+		if err != nil {
+			return nil, err
+		}
+
+		p.serve(key, popped[0])
+		return p, nil
+	}
+
+	s.blocked.add(p)
+	return p, nil
+}
+
+// CancelPop stops waiting of pop, false is returned if it has been served already
+// and its item must be replied
+func (s *Storage) CancelPop(p *BlockedPop) bool {
+	s.blocked.mu.Lock()
+	defer s.blocked.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+
+	s.blocked.remove(p)
+	return true
+}
+
+// serveBlocked pops items of pushed list for waiting clients until list is drained
+func (s *Storage) serveBlocked(key string) {
+	if s.blocked.waiting.Load() == 0 {
+		return
+	}
+
+	s.blocked.mu.Lock()
+	defer s.blocked.mu.Unlock()
+
+	for len(s.blocked.queues[key]) > 0 {
+		p := s.blocked.queues[key][0]
+
+		popped, err := s.pop(popCommand(p.front), key, 1)
+		if err != nil {
+			if !errors.Is(err, ErrKeyNotFound) {
+				s.logger.Error("failed to serve blocked pop", zap.String("key", key), zap.Error(err))
+			}
+			return
+		}
+
+		s.blocked.remove(p)
+		p.serve(key, popped[0])
+	}
+}
+
+func popCommand(front bool) compute.CommandType {
+	if front {
+		return compute.LPopCommand
+	}
+	return compute.RPopCommand
+}
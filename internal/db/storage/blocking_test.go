@@ -0,0 +1,94 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_BPop(t *testing.T) {
+	t.Parallel()
+
+	wal := &recordingLog{}
+	s := newTestStorage(t, wal)
+
+	_, err := s.RPush("low", []byte("a"), []byte("b"))
+	require.NoError(t, err)
+
+	// the first non-empty list is popped right away
+	p, err := s.BPop([]string{"high", "low"}, false)
+	require.NoError(t, err)
+	requireServed(t, p, "low", "b")
+
+	// clients are served in order of arrival, even if they wait for different lists
+	first, err := s.BPop([]string{"empty", "high"}, true)
+	require.NoError(t, err)
+	second, err := s.BPop([]string{"high"}, true)
+	require.NoError(t, err)
+	third, err := s.BPop([]string{"high"}, true)
+	require.NoError(t, err)
+
+	cancelled, err := s.BPop([]string{"high"}, true)
+	require.NoError(t, err)
+	assert.True(t, s.CancelPop(cancelled))
+
+	n, err := s.RPush("high", []byte("1"), []byte("2"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	requireServed(t, first, "high", "1")
+	requireServed(t, second, "high", "2")
+	assert.False(t, s.CancelPop(first))
+
+	select {
+	case <-third.Done():
+		t.Fatal("pop is served without item")
+	default:
+	}
+
+	// client which waits for many lists is served once
+	_, err = s.LPush("empty", []byte("x"))
+	require.NoError(t, err)
+	_, err = s.LPush("high", []byte("3"))
+	require.NoError(t, err)
+	requireServed(t, third, "high", "3")
+
+	length, err := s.LLen("empty")
+	require.NoError(t, err)
+	assert.Equal(t, 1, length)
+
+	// pops made for clients are logged, so recovered lists don't have popped items
+	assert.Equal(t, compute.RPopCommand, wal.queries[1].CommandType)
+
+	recovered := newTestStorage(t, wal)
+	require.NoError(t, recovered.Recover())
+
+	items, err := recovered.LRange("low", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, items)
+
+	keys, err := recovered.Keys("*")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"low", "empty"}, keys)
+
+	require.NoError(t, s.Set("string", []byte("1")))
+	_, err = s.BPop([]string{"string"}, true)
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+}
+
+func requireServed(t *testing.T, p *storage.BlockedPop, key string, value string) {
+	t.Helper()
+
+	select {
+	case <-p.Done():
+	default:
+		require.Fail(t, "pop is not served")
+	}
+
+	actualKey, actualValue := p.Result()
+	assert.Equal(t, key, actualKey)
+	assert.Equal(t, []byte(value), actualValue)
+}
@@ -4,12 +4,20 @@ import "go.uber.org/zap"
 
 type DatabaseStorageBuilder interface {
 	InitEngine(engine Engine) DatabaseStorageBuilder
+	InitWAL(wal WriteAheadLog) DatabaseStorageBuilder
+	InitSnapshotter(snapshots Snapshotter) DatabaseStorageBuilder
+	InitEvents(events *EventBus) DatabaseStorageBuilder
+	InitChangeLog(changes ChangeLog) DatabaseStorageBuilder
 	Build() *Storage
 }
 
 type dbStorageBuilder struct {
-	engine *Engine
-	logger *zap.Logger
+	engine    *Engine
+	wal       WriteAheadLog
+	snapshots Snapshotter
+	events    *EventBus
+	changes   ChangeLog
+	logger    *zap.Logger
 }
 
 func NewDatabaseStorageBuilder(logger *zap.Logger) DatabaseStorageBuilder {
@@ -23,7 +31,40 @@ func (sb *dbStorageBuilder) InitEngine(engine Engine) DatabaseStorageBuilder {
 	return sb
 }
 
+func (sb *dbStorageBuilder) InitWAL(wal WriteAheadLog) DatabaseStorageBuilder {
+	sb.wal = wal
+	return sb
+}
+
+func (sb *dbStorageBuilder) InitSnapshotter(snapshots Snapshotter) DatabaseStorageBuilder {
+	sb.snapshots = snapshots
+	return sb
+}
+
+// InitEvents makes storage report changes of keys to events, nil bus turns reporting off
+func (sb *dbStorageBuilder) InitEvents(events *EventBus) DatabaseStorageBuilder {
+	sb.events = events
+	return sb
+}
+
+// InitChangeLog makes storage append every logged record to changes
+func (sb *dbStorageBuilder) InitChangeLog(changes ChangeLog) DatabaseStorageBuilder {
+	sb.changes = changes
+	return sb
+}
+
 func (sb *dbStorageBuilder) Build() *Storage {
-	s, _ := NewStorage(sb.engine, sb.logger)
+	s, err := NewStorage(sb.engine, sb.wal, sb.snapshots, sb.logger)
+	if err != nil {
+		sb.logger.Error("can't initialize storage", zap.Error(err))
+		return s
+	}
+
+	s.events = sb.events
+	s.changes = sb.changes
+	if engine, ok := (*sb.engine).(NotifyingEngine); ok && sb.events.Enabled(ExpiredEvents|EvictedEvents) {
+		engine.OnRemove(sb.events.removed)
+	}
+
 	return s
 }
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	ErrWrongType                = errors.New("WRONGTYPE operation against a key holding the wrong kind of value")
+	ErrCollectionsNotSupported  = errors.New("engine supports only string values")
+	errUnknownCollectionType    = errors.New("unknown collection type")
+	errCorruptedCollectionItems = errors.New("invalid collection items")
+)
+
+// ValueType is a kind of value stored by key
+type ValueType uint8
+
+const (
+	StringType ValueType = iota
+	ListType
+	HashType
+	SetType
+	SortedSetType
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case StringType:
+		return "string"
+	case ListType:
+		return "list"
+	case HashType:
+		return "hash"
+	case SetType:
+		return "set"
+	case SortedSetType:
+		return "zset"
+	default:
+		return "unknown"
+	}
+}
+
+// Collection is a value of key holding many items, like list. Engine changes it in place
+// under its lock, so everything it gives away must be a copy
+type Collection interface {
+	Type() ValueType
+	Len() int
+	// Size approximates memory taken by items
+	Size() int
+	Clone() Collection
+	// Items flattens collection for persistence, NewCollection restores it from them
+	Items() [][]byte
+}
+
+// NewCollection restores collection of type t from its items
+func NewCollection(t ValueType, items [][]byte) (Collection, error) {
+	switch t {
+	case ListType:
+		list := NewList()
+		for _, item := range items {
+			list.PushBack(item)
+		}
+		return list, nil
+	case HashType:
+		if len(items)%2 != 0 {
+			return nil, errCorruptedCollectionItems
+		}
+
+		hash := NewHash()
+		for i := 0; i < len(items); i += 2 {
+			hash.Set(string(items[i]), items[i+1])
+		}
+		return hash, nil
+	case SetType:
+		set := NewSet()
+		for _, item := range items {
+			set.Add(string(item))
+		}
+		return set, nil
+	case SortedSetType:
+		if len(items)%2 != 0 {
+			return nil, errCorruptedCollectionItems
+		}
+
+		z := NewSortedSet()
+		for i := 0; i < len(items); i += 2 {
+			score, err := strconv.ParseFloat(string(items[i+1]), 64)
+			if err != nil || math.IsNaN(score) {
+				return nil, errCorruptedCollectionItems
+			}
+			z.Add(string(items[i]), score)
+		}
+		return z, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", errUnknownCollectionType, t)
+	}
+}
+
+// CollectionEngine is implemented by engines which store collections besides strings.
+// String methods of Engine treat keys holding collections as missing
+type CollectionEngine interface {
+	// Type returns type of value of key
+	Type(key string) (ValueType, bool)
+	// ViewCollection calls fn with collection of key under read lock, fn must not modify it.
+	// fn is not called for missing key, false is returned then
+	ViewCollection(key string, t ValueType, fn func(c Collection)) (bool, error)
+	// UpdateCollection calls fn with collection of key under write lock, fn modifies it in place
+	// and reports whether anything has changed. Missing key gets collection made by create, if
+	// create is nil fn is not called. Collection left empty is deleted. fn must not change
+	// collection if it fails
+	UpdateCollection(key string, t ValueType, create func() Collection, fn func(c Collection) (bool, error)) error
+}
+
+// Type returns type of value stored by key
+func (e Entry) Type() ValueType {
+	if e.Collection == nil {
+		return StringType
+	}
+
+	return e.Collection.Type()
+}
+
+func (s *Storage) collectionEngine() (CollectionEngine, error) {
+	engine, ok := (*s.engine).(CollectionEngine)
+	if !ok {
+		return nil, ErrCollectionsNotSupported
+	}
+
+	return engine, nil
+}
+
+// checkString fails for entries holding collections, it is used by string commands
+// modifying existing value
+func checkString(current Entry, exists bool) error {
+	if exists && current.Type() != StringType {
+		return ErrWrongType
+	}
+
+	return nil
+}
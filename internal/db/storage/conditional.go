@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+// SetCondition restricts SetIf to keys which exist or don't exist
+type SetCondition int
+
+const (
+	SetAlways SetCondition = iota
+	// SetIfNotExists sets key only if it does not exist (NX)
+	SetIfNotExists
+	// SetIfExists sets key only if it already exists (XX)
+	SetIfExists
+)
+
+// Update changes key as fn decides in a single engine operation. Effect of update is logged
+// instead of fn itself, so replay doesn't depend on data fn has seen
+func (s *Storage) Update(key string, fn UpdateFunc) (Entry, error) {
+	var updated Entry
+
+	err := s.mutateWith(func() (*compute.Query, error) {
+		var action UpdateAction
+		var existed bool
+
+		var err error
+		updated, err = (*s.engine).Update(key, func(current Entry, exists bool) (Entry, UpdateAction, error) {
+			entry, decided, err := fn(current, exists)
+			action, existed = decided, exists
+			return entry, decided, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case action == UpdateStore && updated.ExpireAt != 0:
+			expireAt := formatLoggedExpireAt(time.Unix(0, updated.ExpireAt))
+			return compute.NewQuery(compute.SetCommand, []string{key, string(updated.Value), compute.ExpireAtMillisecondsOption, expireAt}), nil
+		case action == UpdateStore:
+			return compute.NewQuery(compute.SetCommand, []string{key, string(updated.Value)}), nil
+		case action == UpdateDelete && existed:
+			return compute.NewQuery(compute.DelCommand, []string{key}), nil
+		default:
+			return nil, nil
+		}
+	})
+
+	return updated, err
+}
+
+// SetIf stores value if cond holds and reports whether it was stored, zero expireAt means
+// that key never expires
+func (s *Storage) SetIf(key string, value []byte, expireAt time.Time, cond SetCondition) (bool, error) {
+	if !expireAt.IsZero() && s.expiringEngine() == nil {
+		return false, ErrExpiryNotSupported
+	}
+
+	var stored bool
+	_, err := s.Update(key, func(_ Entry, exists bool) (Entry, UpdateAction, error) {
+		stored = cond == SetAlways || (cond == SetIfNotExists && !exists) || (cond == SetIfExists && exists)
+		if !stored {
+			return Entry{}, UpdateKeep, nil
+		}
+
+		entry := Entry{Value: value}
+		if !expireAt.IsZero() {
+			entry.ExpireAt = expireAt.UnixNano()
+		}
+		return entry, UpdateStore, nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return stored, nil
+}
+
+// GetSet stores value and returns the previous one, expiration of key is dropped like on SET
+func (s *Storage) GetSet(key string, value []byte) ([]byte, error) {
+	var previous []byte
+	var existed bool
+
+	_, err := s.Update(key, func(current Entry, exists bool) (Entry, UpdateAction, error) {
+		if err := checkString(current, exists); err != nil {
+			return Entry{}, UpdateKeep, err
+		}
+
+		previous, existed = current.Value, exists
+		return Entry{Value: value}, UpdateStore, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !existed {
+		return nil, ErrKeyNotFound
+	}
+
+	return previous, nil
+}
+
+// GetDel removes key and returns its value
+func (s *Storage) GetDel(key string) ([]byte, error) {
+	var previous []byte
+	var existed bool
+
+	_, err := s.Update(key, func(current Entry, exists bool) (Entry, UpdateAction, error) {
+		if err := checkString(current, exists); err != nil {
+			return Entry{}, UpdateKeep, err
+		}
+
+		previous, existed = current.Value, exists
+		return Entry{}, UpdateDelete, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !existed {
+		return nil, ErrKeyNotFound
+	}
+
+	return previous, nil
+}
+
+// CompareAndSwap replaces value of key only if it equals expected, missing key never matches.
+// Expiration of key is kept
+func (s *Storage) CompareAndSwap(key string, expected []byte, value []byte) (bool, error) {
+	var swapped bool
+
+	_, err := s.Update(key, func(current Entry, exists bool) (Entry, UpdateAction, error) {
+		if err := checkString(current, exists); err != nil {
+			return Entry{}, UpdateKeep, err
+		}
+
+		swapped = exists && bytes.Equal(current.Value, expected)
+		if !swapped {
+			return Entry{}, UpdateKeep, nil
+		}
+
+		return Entry{Value: value, ExpireAt: current.ExpireAt}, UpdateStore, nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return swapped, nil
+}
@@ -0,0 +1,170 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	inmemory "github.com/kirban/potato-db/internal/db/storage/engines/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordingLog keeps written queries in memory and replays them
+type recordingLog struct {
+	queries []compute.Query
+}
+
+func (l *recordingLog) Write(query compute.Query) <-chan error {
+	l.queries = append(l.queries, query)
+
+	done := make(chan error, 1)
+	done <- nil
+	return done
+}
+
+func (l *recordingLog) Replay(_ uint64, apply func(query compute.Query) error) error {
+	for _, query := range l.queries {
+		if err := apply(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *recordingLog) LastLSN() uint64 {
+	return uint64(len(l.queries))
+}
+
+func (l *recordingLog) Truncate(uint64) error {
+	return nil
+}
+
+func newTestStorage(t *testing.T, wal storage.WriteAheadLog) *storage.Storage {
+	engine, err := inmemory.NewInMemoryEngine(zap.NewNop())
+	require.NoError(t, err)
+
+	return storage.NewDatabaseStorageBuilder(zap.NewNop()).InitEngine(engine).InitWAL(wal).Build()
+}
+
+func TestStorage_SetIf(t *testing.T) {
+	t.Parallel()
+
+	wal := &recordingLog{}
+	s := newTestStorage(t, wal)
+
+	stored, err := s.SetIf("foo", []byte("1"), time.Time{}, storage.SetIfExists)
+	require.NoError(t, err)
+	assert.False(t, stored)
+
+	stored, err = s.SetIf("foo", []byte("1"), time.Time{}, storage.SetIfNotExists)
+	require.NoError(t, err)
+	assert.True(t, stored)
+
+	stored, err = s.SetIf("foo", []byte("2"), time.Time{}, storage.SetIfNotExists)
+	require.NoError(t, err)
+	assert.False(t, stored)
+
+	expireAt := time.Now().Add(time.Hour)
+	stored, err = s.SetIf("foo", []byte("3"), expireAt, storage.SetIfExists)
+	require.NoError(t, err)
+	assert.True(t, stored)
+
+	value, err := s.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+
+	_, hasExpiry, err := s.TTL("foo")
+	require.NoError(t, err)
+	assert.True(t, hasExpiry)
+
+	// keys which were not set are not logged
+	assert.Len(t, wal.queries, 2)
+}
+
+func TestStorage_GetSetGetDel(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	_, err := s.GetSet("foo", []byte("1"))
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	require.NoError(t, s.SetWithExpiry("foo", []byte("2"), time.Now().Add(time.Hour)))
+
+	previous, err := s.GetSet("foo", []byte("3"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), previous)
+
+	// GETSET drops expiration like SET does
+	_, hasExpiry, err := s.TTL("foo")
+	require.NoError(t, err)
+	assert.False(t, hasExpiry)
+
+	previous, err = s.GetDel("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), previous)
+
+	_, err = s.GetDel("foo")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
+
+func TestStorage_CompareAndSwap(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	swapped, err := s.CompareAndSwap("foo", []byte(""), []byte("1"))
+	require.NoError(t, err)
+	assert.False(t, swapped)
+
+	require.NoError(t, s.SetWithExpiry("foo", []byte("1"), time.Now().Add(time.Hour)))
+
+	swapped, err = s.CompareAndSwap("foo", []byte("2"), []byte("3"))
+	require.NoError(t, err)
+	assert.False(t, swapped)
+
+	swapped, err = s.CompareAndSwap("foo", []byte("1"), []byte("3"))
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	value, err := s.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+
+	_, hasExpiry, err := s.TTL("foo")
+	require.NoError(t, err)
+	assert.True(t, hasExpiry)
+}
+
+func TestStorage_UpdateReplay(t *testing.T) {
+	t.Parallel()
+
+	wal := &recordingLog{}
+	s := newTestStorage(t, wal)
+
+	_, err := s.SetIf("a", []byte("1"), time.Now().Add(time.Hour), storage.SetIfNotExists)
+	require.NoError(t, err)
+	_, err = s.CompareAndSwap("a", []byte("1"), []byte("2"))
+	require.NoError(t, err)
+	_, err = s.GetSet("b", []byte("1"))
+	require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	_, err = s.GetDel("b")
+	require.NoError(t, err)
+
+	recovered := newTestStorage(t, wal)
+	require.NoError(t, recovered.Recover())
+
+	value, err := recovered.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	_, hasExpiry, err := recovered.TTL("a")
+	require.NoError(t, err)
+	assert.True(t, hasExpiry)
+
+	_, err = recovered.Get("b")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+var (
+	ErrNotInteger      = errors.New("value is not an integer or out of range")
+	ErrNotFloat        = errors.New("value is not a valid float")
+	ErrIncrOverflow    = errors.New("increment or decrement would overflow")
+	ErrIncrFloatResult = errors.New("increment would produce NaN or Infinity")
+)
+
+// IncrBy atomically adds delta to integer value of key, missing key is treated as zero.
+// Increment itself is logged, so replay reproduces it on the same previous value
+func (s *Storage) IncrBy(key string, delta int64) (int64, error) {
+	query := compute.NewQuery(compute.IncrByCommand, []string{key, strconv.FormatInt(delta, 10)})
+
+	var updated Entry
+	err := s.mutate(query, func() error {
+		var err error
+		updated, err = (*s.engine).Update(key, incrBy(delta))
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(updated.Value), 10, 64)
+}
+
+// IncrByFloat atomically adds delta to float value of key, missing key is treated as zero
+func (s *Storage) IncrByFloat(key string, delta float64) ([]byte, error) {
+	query := compute.NewQuery(compute.IncrByFloatCommand, []string{key, formatFloat(delta)})
+
+	var updated Entry
+	err := s.mutate(query, func() error {
+		var err error
+		updated, err = (*s.engine).Update(key, incrByFloat(delta))
+		return err
+	})
+
+	return updated.Value, err
+}
+
+// incrBy keeps expiration of key like any other in place modification
+func incrBy(delta int64) UpdateFunc {
+	return func(current Entry, exists bool) (Entry, UpdateAction, error) {
+		if err := checkString(current, exists); err != nil {
+			return Entry{}, UpdateKeep, err
+		}
+
+		var n int64
+		if exists {
+			var err error
+			if n, err = parseInteger(current.Value); err != nil {
+				return Entry{}, UpdateKeep, err
+			}
+		}
+
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return Entry{}, UpdateKeep, ErrIncrOverflow
+		}
+
+		return Entry{Value: strconv.AppendInt(nil, n+delta, 10), ExpireAt: current.ExpireAt}, UpdateStore, nil
+	}
+}
+
+func incrByFloat(delta float64) UpdateFunc {
+	return func(current Entry, exists bool) (Entry, UpdateAction, error) {
+		if err := checkString(current, exists); err != nil {
+			return Entry{}, UpdateKeep, err
+		}
+
+		var n float64
+		if exists {
+			var err error
+			if n, err = strconv.ParseFloat(string(current.Value), 64); err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return Entry{}, UpdateKeep, ErrNotFloat
+			}
+		}
+
+		result := n + delta
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return Entry{}, UpdateKeep, ErrIncrFloatResult
+		}
+
+		return Entry{Value: []byte(formatFloat(result)), ExpireAt: current.ExpireAt}, UpdateStore, nil
+	}
+}
+
+// parseInteger accepts only canonical decimal form, like "-12", so "+1" or "01" stay strings
+func parseInteger(value []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != string(value) {
+		return 0, ErrNotInteger
+	}
+
+	return n, nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrBy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		value       string
+		exists      bool
+		delta       int64
+		expected    string
+		expectedErr error
+	}{
+		"missing key starts from zero": {
+			delta:    5,
+			expected: "5",
+		},
+		"negative delta": {
+			value:    "10",
+			exists:   true,
+			delta:    -15,
+			expected: "-5",
+		},
+		"not a number": {
+			value:       "ten",
+			exists:      true,
+			delta:       1,
+			expectedErr: ErrNotInteger,
+		},
+		"not canonical integer": {
+			value:       "+1",
+			exists:      true,
+			delta:       1,
+			expectedErr: ErrNotInteger,
+		},
+		"value out of range": {
+			value:       "9223372036854775808",
+			exists:      true,
+			delta:       1,
+			expectedErr: ErrNotInteger,
+		},
+		"overflow": {
+			value:       strconv.FormatInt(math.MaxInt64, 10),
+			exists:      true,
+			delta:       1,
+			expectedErr: ErrIncrOverflow,
+		},
+		"underflow": {
+			value:       strconv.FormatInt(math.MinInt64+1, 10),
+			exists:      true,
+			delta:       -2,
+			expectedErr: ErrIncrOverflow,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			updated, _, err := incrBy(tc.delta)(Entry{Value: []byte(tc.value)}, tc.exists)
+
+			assert.Equal(t, tc.expected, string(updated.Value))
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestIncrByFloat(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		value       string
+		exists      bool
+		delta       float64
+		expected    string
+		expectedErr error
+	}{
+		"missing key starts from zero": {
+			delta:    0.5,
+			expected: "0.5",
+		},
+		"integer value": {
+			value:    "10",
+			exists:   true,
+			delta:    0.1,
+			expected: "10.1",
+		},
+		"result without fraction": {
+			value:    "1.5",
+			exists:   true,
+			delta:    1.5,
+			expected: "3",
+		},
+		"not a number": {
+			value:       "ten",
+			exists:      true,
+			delta:       1,
+			expectedErr: ErrNotFloat,
+		},
+		"stored infinity": {
+			value:       "inf",
+			exists:      true,
+			delta:       1,
+			expectedErr: ErrNotFloat,
+		},
+		"overflow to infinity": {
+			value:       "1.7976931348623157e308",
+			exists:      true,
+			delta:       1.7976931348623157e308,
+			expectedErr: ErrIncrFloatResult,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			updated, _, err := incrByFloat(tc.delta)(Entry{Value: []byte(tc.value)}, tc.exists)
+
+			assert.Equal(t, tc.expected, string(updated.Value))
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrCorruptedRecord = errors.New("disk engine: corrupted record")
+)
+
+const (
+	dataFileExtension  = ".data"
+	mergeFileExtension = ".merge"
+
+	// crc32 + seq + key size + value size + flags
+	recordHeaderSize = 4 + 8 + 4 + 4 + 1
+
+	flagTombstone byte = 1
+	// flagBatch marks records followed by more records of the same batch, batch is applied
+	// on load only when its last record without the flag is read
+	flagBatch byte = 2
+)
+
+type record struct {
+	seq       uint64
+	key       string
+	value     []byte
+	tombstone bool
+	batch     bool
+}
+
+func (r *record) size() int64 {
+	return int64(recordHeaderSize + len(r.key) + len(r.value))
+}
+
+func (r *record) encode() []byte {
+	data := make([]byte, recordHeaderSize, r.size())
+	binary.BigEndian.PutUint64(data[4:12], r.seq)
+	binary.BigEndian.PutUint32(data[12:16], uint32(len(r.key)))
+	binary.BigEndian.PutUint32(data[16:20], uint32(len(r.value)))
+	if r.tombstone {
+		data[20] |= flagTombstone
+	}
+	if r.batch {
+		data[20] |= flagBatch
+	}
+
+	data = append(data, r.key...)
+	data = append(data, r.value...)
+	binary.BigEndian.PutUint32(data[0:4], crc32.ChecksumIEEE(data[4:]))
+
+	return data
+}
+
+func decodeRecord(data []byte) (*record, error) {
+	if len(data) < recordHeaderSize || crc32.ChecksumIEEE(data[4:]) != binary.BigEndian.Uint32(data[0:4]) {
+		return nil, ErrCorruptedRecord
+	}
+
+	keySize := int(binary.BigEndian.Uint32(data[12:16]))
+	valueSize := int(binary.BigEndian.Uint32(data[16:20]))
+	if len(data) != recordHeaderSize+keySize+valueSize {
+		return nil, ErrCorruptedRecord
+	}
+
+	body := data[recordHeaderSize:]
+	return &record{
+		seq:       binary.BigEndian.Uint64(data[4:12]),
+		key:       string(body[:keySize]),
+		value:     body[keySize:],
+		tombstone: data[20]&flagTombstone != 0,
+		batch:     data[20]&flagBatch != 0,
+	}, nil
+}
+
+// clearBatchFlag makes encoded record standalone, merge copies records out of their batches
+func clearBatchFlag(data []byte) []byte {
+	if data[20]&flagBatch == 0 {
+		return data
+	}
+
+	data[20] &^= flagBatch
+	binary.BigEndian.PutUint32(data[0:4], crc32.ChecksumIEEE(data[4:]))
+
+	return data
+}
+
+// dataFile is a single append-only file of the log, only the active one is written
+type dataFile struct {
+	id   uint32
+	path string
+	file *os.File
+	size int64
+}
+
+func dataFileName(id uint32, extension string) string {
+	return fmt.Sprintf("%09d%s", id, extension)
+}
+
+func openDataFile(directory string, id uint32, extension string) (*dataFile, error) {
+	path := filepath.Join(directory, dataFileName(id, extension))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data file: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to stat data file: %w", err)
+	}
+
+	return &dataFile{
+		id:   id,
+		path: path,
+		file: file,
+		size: stat.Size(),
+	}, nil
+}
+
+func (f *dataFile) append(data []byte) (int64, error) {
+	offset := f.size
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+
+	return offset, err
+}
+
+func (f *dataFile) readAt(offset int64, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := f.file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// scan walks records of file in order and returns offset right after the last valid record
+func (f *dataFile) scan(fn func(r *record, offset int64, size int64)) (int64, error) {
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+
+	for offset < f.size {
+		if _, err := f.file.ReadAt(header, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset, ErrCorruptedRecord
+			}
+			return offset, err
+		}
+
+		size := int64(recordHeaderSize) +
+			int64(binary.BigEndian.Uint32(header[12:16])) +
+			int64(binary.BigEndian.Uint32(header[16:20]))
+		if offset+size > f.size {
+			return offset, ErrCorruptedRecord
+		}
+
+		data, err := f.readAt(offset, size)
+		if err != nil {
+			return offset, err
+		}
+
+		r, err := decodeRecord(data)
+		if err != nil {
+			return offset, err
+		}
+
+		fn(r, offset, size)
+		offset += size
+	}
+
+	return offset, nil
+}
+
+func (f *dataFile) sync() error {
+	return f.file.Sync()
+}
+
+func (f *dataFile) close() error {
+	return f.file.Close()
+}
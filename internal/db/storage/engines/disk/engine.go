@@ -0,0 +1,716 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidLogger      = errors.New("invalid logger")
+	ErrInvalidDirectory   = errors.New("invalid data directory")
+	ErrInvalidMaxFileSize = errors.New("invalid max file size")
+)
+
+// mergeMarkerName lists files replaced by merge, it makes merge atomic across crashes
+const mergeMarkerName = "MERGE"
+
+type keyEntry struct {
+	fileID uint32
+	offset int64
+	size   int64
+	seq    uint64
+}
+
+// DiskEngine is a Bitcask-like store: values live in append-only data files
+// and only key directory with positions of the latest records is kept in memory
+type DiskEngine struct {
+	logger      *zap.Logger
+	directory   string
+	maxFileSize int64
+
+	// mergeMu lets one merge run at a time, it is taken before mu
+	mergeMu sync.Mutex
+
+	mu         sync.RWMutex
+	keydir     map[string]keyEntry
+	files      map[uint32]*dataFile
+	active     *dataFile
+	nextFileID uint32
+	seq        uint64
+	// deletedSeq is seq of the last tombstone, it is a version of all missing keys
+	deletedSeq uint64
+	totalBytes int64
+	liveBytes  int64
+}
+
+func (e *DiskEngine) Get(key string) ([]byte, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	entry, exists := e.keydir[key]
+	if !exists {
+		return nil, false
+	}
+
+	r, err := e.readRecord(entry)
+	if err != nil {
+		e.logger.Error("failed to read record", zap.String("key", key), zap.Error(err))
+		return nil, false
+	}
+
+	return r.value, true
+}
+
+func (e *DiskEngine) Set(key string, value []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.write(&record{key: key, value: value})
+}
+
+func (e *DiskEngine) Delete(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, exists := e.keydir[key]; !exists {
+		return nil
+	}
+
+	return e.write(&record{key: key, tombstone: true})
+}
+
+func (e *DiskEngine) MGet(keys []string) ([][]byte, []bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	values, found := make([][]byte, len(keys)), make([]bool, len(keys))
+	for i, key := range keys {
+		entry, exists := e.keydir[key]
+		if !exists {
+			continue
+		}
+
+		r, err := e.readRecord(entry)
+		if err != nil {
+			e.logger.Error("failed to read record", zap.String("key", key), zap.Error(err))
+			continue
+		}
+
+		values[i], found[i] = r.value, true
+	}
+
+	return values, found
+}
+
+// MSet appends all pairs as a single batch, after crash either all of them are loaded or none
+func (e *DiskEngine) MSet(pairs []storage.KeyValue) error {
+	records := make([]*record, len(pairs))
+	for i, pair := range pairs {
+		records[i] = &record{key: pair.Key, value: pair.Value}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.write(records...)
+}
+
+func (e *DiskEngine) MDelete(keys []string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	records := make([]*record, 0, len(keys))
+	deleted := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, exists := e.keydir[key]; !exists {
+			continue
+		}
+
+		if _, seen := deleted[key]; !seen {
+			deleted[key] = struct{}{}
+			records = append(records, &record{key: key, tombstone: true})
+		}
+	}
+
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	return len(records), e.write(records...)
+}
+
+// Update runs fn and appends record with its decision holding the engine lock
+func (e *DiskEngine) Update(key string, fn storage.UpdateFunc) (storage.Entry, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var current storage.Entry
+	entry, exists := e.keydir[key]
+	if exists {
+		r, err := e.readRecord(entry)
+		if err != nil {
+			return storage.Entry{}, err
+		}
+		current.Value = r.value
+	}
+
+	updated, action, err := fn(current, exists)
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	switch action {
+	case storage.UpdateKeep:
+		return current, nil
+	case storage.UpdateDelete:
+		if !exists {
+			return storage.Entry{}, nil
+		}
+		return storage.Entry{}, e.write(&record{key: key, tombstone: true})
+	}
+
+	// records have no expiration time
+	if updated.ExpireAt != 0 {
+		return storage.Entry{}, storage.ErrExpiryNotSupported
+	}
+
+	if err := e.write(&record{key: key, value: updated.Value}); err != nil {
+		return storage.Entry{}, err
+	}
+
+	return updated, nil
+}
+
+// Version returns seq of the latest record of key, missing keys share seq of the last deletion
+func (e *DiskEngine) Version(key string) uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if entry, exists := e.keydir[key]; exists {
+		return entry.seq
+	}
+
+	return e.deletedSeq
+}
+
+// Scan continues iteration from hash of key stored in cursor, key directory is read locked
+// only while keys are collected
+func (e *DiskEngine) Scan(cursor uint64, count int) (uint64, []string) {
+	if cursor > math.MaxUint32 {
+		return 0, nil
+	}
+
+	batch := storage.NewScanBatch(uint32(cursor), count)
+
+	e.mu.RLock()
+	for key := range e.keydir {
+		batch.Add(key)
+	}
+	e.mu.RUnlock()
+
+	keys, next, done := batch.Take()
+	if done {
+		return 0, keys
+	}
+
+	return uint64(next), keys
+}
+
+// Merge rewrites live records of all inactive files into new files and removes the old ones,
+// it waits for merge started by writes to finish first
+func (e *DiskEngine) Merge() error {
+	e.mergeMu.Lock()
+	defer e.mergeMu.Unlock()
+
+	return e.merge()
+}
+
+// Close waits for running merge and closes data files, engine can't be used after that
+func (e *DiskEngine) Close() error {
+	e.mergeMu.Lock()
+	defer e.mergeMu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.active.sync(); err != nil {
+		return err
+	}
+
+	for _, f := range e.files {
+		if err := f.close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write appends records with a single write call, several records are written as a batch
+// which is never split between data files
+func (e *DiskEngine) write(records ...*record) error {
+	var data []byte
+	for i, r := range records {
+		e.seq++
+		r.seq = e.seq
+		r.batch = i < len(records)-1
+		data = append(data, r.encode()...)
+	}
+
+	offset, err := e.active.append(data)
+	if err != nil {
+		return fmt.Errorf("failed to append record: %w", err)
+	}
+
+	// write is acknowledged only once it survives crash
+	if err := e.active.sync(); err != nil {
+		return fmt.Errorf("failed to sync data file: %w", err)
+	}
+
+	for _, r := range records {
+		e.totalBytes += r.size()
+		if old, exists := e.keydir[r.key]; exists {
+			e.liveBytes -= old.size
+		}
+
+		if r.tombstone {
+			delete(e.keydir, r.key)
+			e.deletedSeq = r.seq
+		} else {
+			e.keydir[r.key] = keyEntry{fileID: e.active.id, offset: offset, size: r.size(), seq: r.seq}
+			e.liveBytes += r.size()
+		}
+
+		offset += r.size()
+	}
+
+	if e.active.size < e.maxFileSize {
+		return nil
+	}
+
+	if err := e.rotate(); err != nil {
+		return err
+	}
+
+	// merge once stale records take more space than live ones
+	if stale := e.totalBytes - e.liveBytes; stale > e.liveBytes && stale > e.maxFileSize {
+		e.mergeInBackground()
+	}
+
+	return nil
+}
+
+// mergeInBackground starts merge unless one is running already, so writer never waits for it
+func (e *DiskEngine) mergeInBackground() {
+	if !e.mergeMu.TryLock() {
+		return
+	}
+
+	go func() {
+		defer e.mergeMu.Unlock()
+
+		if err := e.merge(); err != nil {
+			// records themselves are persisted, merge will be retried on next rotation
+			e.logger.Error("failed to merge data files", zap.Error(err))
+		}
+	}()
+}
+
+// reserveFileID returns id for a new file, ids of files are never reused
+func (e *DiskEngine) reserveFileID() uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id := e.nextFileID
+	e.nextFileID++
+	return id
+}
+
+func (e *DiskEngine) rotate() error {
+	if err := e.active.sync(); err != nil {
+		return fmt.Errorf("failed to sync data file: %w", err)
+	}
+
+	active, err := openDataFile(e.directory, e.nextFileID, dataFileExtension)
+	if err != nil {
+		return err
+	}
+
+	e.nextFileID++
+	e.files[active.id] = active
+	e.active = active
+
+	return nil
+}
+
+// merge copies live records of inactive files holding mu only to pick them and to replace the
+// files, so writes go on while records are copied. Caller holds mergeMu
+func (e *DiskEngine) merge() error {
+	e.mu.Lock()
+	if e.active.size > 0 {
+		if err := e.rotate(); err != nil {
+			e.mu.Unlock()
+			return err
+		}
+	}
+
+	// inactive files are never written, so they are read without mu
+	inputs := make(map[uint32]*dataFile, len(e.files))
+	for id, f := range e.files {
+		if id != e.active.id {
+			inputs[id] = f
+		}
+	}
+
+	live := make(map[string]keyEntry)
+	for key, entry := range e.keydir {
+		if _, ok := inputs[entry.fileID]; ok {
+			live[key] = entry
+		}
+	}
+	e.mu.Unlock()
+
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]keyEntry, len(live))
+	outputs := make([]*dataFile, 0)
+	var output *dataFile
+
+	cleanup := func() {
+		for _, f := range outputs {
+			_ = f.close()
+			_ = os.Remove(f.path)
+		}
+	}
+
+	for key, entry := range live {
+		data, err := inputs[entry.fileID].readAt(entry.offset, entry.size)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to read record for merge: %w", err)
+		}
+
+		if output == nil || output.size >= e.maxFileSize {
+			output, err = openDataFile(e.directory, e.reserveFileID(), mergeFileExtension)
+			if err != nil {
+				cleanup()
+				return err
+			}
+			outputs = append(outputs, output)
+		}
+
+		offset, err := output.append(clearBatchFlag(data))
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to write merged record: %w", err)
+		}
+
+		entry.fileID, entry.offset = output.id, offset
+		merged[key] = entry
+	}
+
+	for _, f := range outputs {
+		if err := f.sync(); err != nil {
+			cleanup()
+			return fmt.Errorf("failed to sync merged file: %w", err)
+		}
+	}
+
+	ids := slices.Sorted(maps.Keys(inputs))
+	if err := writeMergeMarker(e.directory, ids); err != nil {
+		cleanup()
+		return err
+	}
+
+	for _, f := range outputs {
+		path := filepath.Join(e.directory, dataFileName(f.id, dataFileExtension))
+		if err := os.Rename(f.path, path); err != nil {
+			return fmt.Errorf("failed to rename merged file: %w", err)
+		}
+		f.path = path
+	}
+
+	e.mu.Lock()
+	for _, f := range outputs {
+		e.files[f.id] = f
+		e.totalBytes += f.size
+	}
+
+	for id, f := range inputs {
+		_ = f.close()
+		delete(e.files, id)
+		e.totalBytes -= f.size
+	}
+
+	// key changed while records were copied keeps its newer record, the merged one is stale then
+	for key, entry := range merged {
+		if current, exists := e.keydir[key]; exists && current == live[key] {
+			e.keydir[key] = entry
+		}
+	}
+	e.mu.Unlock()
+
+	if err := completeMerge(e.directory, ids); err != nil {
+		return err
+	}
+
+	e.logger.Info("disk engine merged", zap.Int("inputs", len(inputs)), zap.Int("outputs", len(outputs)))
+
+	return nil
+}
+
+func (e *DiskEngine) readRecord(entry keyEntry) (*record, error) {
+	f, exists := e.files[entry.fileID]
+	if !exists {
+		return nil, fmt.Errorf("data file %d is missing", entry.fileID)
+	}
+
+	data, err := f.readAt(entry.offset, entry.size)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRecord(data)
+}
+
+// load restores key directory from data files, record with greatest seq wins
+func (e *DiskEngine) load() error {
+	if err := recoverMerge(e.directory); err != nil {
+		return err
+	}
+
+	ids, err := listDataFiles(e.directory)
+	if err != nil {
+		return err
+	}
+
+	tombstones := make(map[string]uint64)
+
+	for i, id := range ids {
+		f, err := openDataFile(e.directory, id, dataFileExtension)
+		if err != nil {
+			return err
+		}
+		e.files[id] = f
+
+		index := func(r *record, offset int64, size int64) {
+			e.seq = max(e.seq, r.seq)
+			e.totalBytes += size
+
+			old, exists := e.keydir[r.key]
+			if (exists && old.seq > r.seq) || tombstones[r.key] > r.seq {
+				return
+			}
+
+			if exists {
+				e.liveBytes -= old.size
+			}
+
+			if r.tombstone {
+				delete(e.keydir, r.key)
+				tombstones[r.key] = r.seq
+				return
+			}
+
+			e.keydir[r.key] = keyEntry{fileID: id, offset: offset, size: size, seq: r.seq}
+			e.liveBytes += size
+		}
+
+		type position struct {
+			r      *record
+			offset int64
+			size   int64
+		}
+		var batch []position
+
+		end, err := f.scan(func(r *record, offset int64, size int64) {
+			batch = append(batch, position{r: r, offset: offset, size: size})
+			if r.batch {
+				return
+			}
+
+			for _, p := range batch {
+				index(p.r, p.offset, p.size)
+			}
+			batch = batch[:0]
+		})
+
+		// batch without its last record was not written completely
+		if len(batch) > 0 {
+			end = batch[0].offset
+			if err == nil {
+				err = ErrCorruptedRecord
+			}
+		}
+
+		if errors.Is(err, ErrCorruptedRecord) {
+			e.logger.Warn("data file has corrupted tail", zap.String("file", f.path), zap.Int64("offset", end))
+			if i == len(ids)-1 {
+				// unfinished write of the last session
+				if err := f.file.Truncate(end); err != nil {
+					return fmt.Errorf("failed to truncate data file: %w", err)
+				}
+				f.size = end
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to scan data file: %w", err)
+		}
+
+		e.nextFileID = max(e.nextFileID, id+1)
+	}
+
+	active, err := openDataFile(e.directory, e.nextFileID, dataFileExtension)
+	if err != nil {
+		return err
+	}
+
+	e.nextFileID++
+	e.files[active.id] = active
+	e.active = active
+
+	e.logger.Info("disk engine loaded", zap.Int("files", len(ids)), zap.Int("keys", len(e.keydir)))
+	return nil
+}
+
+func listDataFiles(directory string) ([]uint32, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	ids := make([]uint32, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, dataFileExtension) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, dataFileExtension), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func writeMergeMarker(directory string, inputs []uint32) error {
+	lines := make([]string, 0, len(inputs))
+	for _, id := range inputs {
+		lines = append(lines, strconv.FormatUint(uint64(id), 10))
+	}
+
+	path := filepath.Join(directory, mergeMarkerName)
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create merge marker: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(strings.Join(lines, "\n")); err != nil {
+		return fmt.Errorf("failed to write merge marker: %w", err)
+	}
+
+	return file.Sync()
+}
+
+// completeMerge removes files replaced by merge and then the marker itself
+func completeMerge(directory string, inputs []uint32) error {
+	for _, id := range inputs {
+		path := filepath.Join(directory, dataFileName(id, dataFileExtension))
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove merged file: %w", err)
+		}
+	}
+
+	return os.Remove(filepath.Join(directory, mergeMarkerName))
+}
+
+// recoverMerge finishes merge interrupted after marker was written, or drops its leftovers otherwise
+func recoverMerge(directory string) error {
+	marker, err := os.ReadFile(filepath.Join(directory, mergeMarkerName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read merge marker: %w", err)
+	}
+	committed := err == nil
+
+	leftovers, err := filepath.Glob(filepath.Join(directory, "*"+mergeFileExtension))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range leftovers {
+		if committed {
+			err = os.Rename(path, strings.TrimSuffix(path, mergeFileExtension)+dataFileExtension)
+		} else {
+			err = os.Remove(path)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to recover merge: %w", err)
+		}
+	}
+
+	if !committed {
+		return nil
+	}
+
+	inputs := make([]uint32, 0)
+	for _, line := range strings.Fields(string(marker)) {
+		id, err := strconv.ParseUint(line, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid merge marker: %w", err)
+		}
+		inputs = append(inputs, uint32(id))
+	}
+
+	return completeMerge(directory, inputs)
+}
+
+func NewDiskEngine(logger *zap.Logger, directory string, maxFileSize int) (*DiskEngine, error) {
+	if logger == nil {
+		return nil, ErrInvalidLogger
+	}
+
+	if directory == "" {
+		return nil, ErrInvalidDirectory
+	}
+
+	if maxFileSize <= 0 {
+		return nil, ErrInvalidMaxFileSize
+	}
+
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	engine := &DiskEngine{
+		logger:      logger,
+		directory:   directory,
+		maxFileSize: int64(maxFileSize),
+		keydir:      make(map[string]keyEntry),
+		files:       make(map[uint32]*dataFile),
+	}
+
+	if err := engine.load(); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
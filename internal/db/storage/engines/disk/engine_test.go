@@ -0,0 +1,450 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/db/storage/enginetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func openTestEngine(t *testing.T, directory string, maxFileSize int) *DiskEngine {
+	engine, err := NewDiskEngine(zap.NewNop(), directory, maxFileSize)
+	require.NoError(t, err)
+
+	return engine
+}
+
+func TestNewDiskEngine(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		logger      *zap.Logger
+		directory   string
+		maxFileSize int
+		expectedErr error
+	}{
+		"create engine": {
+			logger:      zap.NewNop(),
+			directory:   t.TempDir(),
+			maxFileSize: 1 << 10,
+		},
+		"nil logger": {
+			directory:   t.TempDir(),
+			maxFileSize: 1 << 10,
+			expectedErr: ErrInvalidLogger,
+		},
+		"empty directory": {
+			logger:      zap.NewNop(),
+			maxFileSize: 1 << 10,
+			expectedErr: ErrInvalidDirectory,
+		},
+		"zero max file size": {
+			logger:      zap.NewNop(),
+			directory:   t.TempDir(),
+			expectedErr: ErrInvalidMaxFileSize,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			engine, err := NewDiskEngine(tc.logger, tc.directory, tc.maxFileSize)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if tc.expectedErr != nil {
+				assert.Nil(t, engine)
+			} else {
+				assert.NotNil(t, engine)
+			}
+		})
+	}
+}
+
+func TestDiskEngine_SetGetDelete(t *testing.T) {
+	t.Parallel()
+
+	engine := openTestEngine(t, t.TempDir(), 1<<10)
+
+	_, exists := engine.Get("foo")
+	assert.False(t, exists)
+
+	require.NoError(t, engine.Set("foo", []byte("bar")))
+	require.NoError(t, engine.Set("foo", []byte("baz")))
+
+	value, exists := engine.Get("foo")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("baz"), value)
+
+	require.NoError(t, engine.Delete("foo"))
+	require.NoError(t, engine.Delete("non existing"))
+
+	_, exists = engine.Get("foo")
+	assert.False(t, exists)
+}
+
+func TestDiskEngine_Reopen(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 256)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, engine.Set(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, engine.Set("key1", []byte("updated")))
+	require.NoError(t, engine.Delete("key2"))
+	require.NoError(t, engine.Close())
+
+	engine = openTestEngine(t, directory, 256)
+
+	value, exists := engine.Get("key1")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("updated"), value)
+
+	_, exists = engine.Get("key2")
+	assert.False(t, exists)
+
+	value, exists = engine.Get("key49")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value49"), value)
+}
+
+// nopLog is never used, storage must reject it before that
+type nopLog struct {
+	storage.WriteAheadLog
+}
+
+func TestDiskEngine_RestartCounter(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	restart := func() (*storage.Storage, *DiskEngine) {
+		engine := openTestEngine(t, directory, 1<<10)
+
+		var e storage.Engine = engine
+		s, err := storage.NewStorage(&e, nil, nil, zap.NewNop())
+		require.NoError(t, err)
+		require.NoError(t, s.Recover())
+
+		return s, engine
+	}
+
+	for i := 1; i <= 3; i++ {
+		s, engine := restart()
+
+		value, err := s.IncrBy("counter", 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), value)
+
+		require.NoError(t, engine.Close())
+	}
+
+	s, engine := restart()
+	defer engine.Close()
+
+	value, err := s.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(value))
+
+	var e storage.Engine = engine
+	_, err = storage.NewStorage(&e, nopLog{}, nil, zap.NewNop())
+	assert.ErrorIs(t, err, storage.ErrWALNotSupported)
+}
+
+func TestDiskEngine_Merge(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 256)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, engine.Set("counter", []byte(fmt.Sprintf("%d", i))))
+		require.NoError(t, engine.Set(fmt.Sprintf("tmp%d", i), []byte("value")))
+		require.NoError(t, engine.Delete(fmt.Sprintf("tmp%d", i)))
+	}
+	require.NoError(t, engine.Merge())
+
+	ids, err := listDataFiles(directory)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(ids), 2)
+	assert.Equal(t, engine.liveBytes, engine.totalBytes)
+
+	require.NoError(t, engine.Close())
+	engine = openTestEngine(t, directory, 256)
+
+	value, exists := engine.Get("counter")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("99"), value)
+
+	_, exists = engine.Get("tmp50")
+	assert.False(t, exists)
+}
+
+func TestDiskEngine_MergeConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 256)
+
+	stop := make(chan struct{})
+	merged := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				close(merged)
+				return
+			default:
+			}
+
+			if err := engine.Merge(); err != nil {
+				merged <- err
+			}
+		}
+	}()
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, engine.Set(fmt.Sprintf("key%d", i%10), []byte(fmt.Sprintf("%d", i))))
+		if i%7 == 0 {
+			require.NoError(t, engine.Delete(fmt.Sprintf("key%d", i%10)))
+		}
+	}
+
+	close(stop)
+	for err := range merged {
+		require.NoError(t, err)
+	}
+
+	check := func(engine *DiskEngine) {
+		for k := 0; k < 10; k++ {
+			last := 490 + k
+			value, exists := engine.Get(fmt.Sprintf("key%d", k))
+			if last%7 == 0 {
+				assert.False(t, exists)
+				continue
+			}
+
+			assert.True(t, exists)
+			assert.Equal(t, []byte(fmt.Sprintf("%d", last)), value)
+		}
+	}
+
+	check(engine)
+	require.NoError(t, engine.Close())
+
+	engine = openTestEngine(t, directory, 256)
+	defer engine.Close()
+	check(engine)
+}
+
+func TestDiskEngine_CorruptedTail(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 1<<10)
+	require.NoError(t, engine.Set("foo", []byte("bar")))
+	activePath := engine.active.path
+	require.NoError(t, engine.Close())
+
+	file, err := os.OpenFile(activePath, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	torn := (&record{seq: 2, key: "foo", value: []byte("baz")}).encode()
+	_, err = file.Write(torn[:len(torn)-1])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	engine = openTestEngine(t, directory, 1<<10)
+	value, exists := engine.Get("foo")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("bar"), value)
+
+	stat, err := os.Stat(activePath)
+	require.NoError(t, err)
+	assert.Equal(t, (&record{key: "foo", value: []byte("bar")}).size(), stat.Size())
+}
+
+func TestDiskEngine_InterruptedMerge(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 1<<10)
+	require.NoError(t, engine.Set("foo", []byte("bar")))
+	require.NoError(t, engine.Close())
+
+	// merge output written, but marker is missing: output must be dropped
+	output, err := openDataFile(directory, 100, mergeFileExtension)
+	require.NoError(t, err)
+	_, err = output.append((&record{seq: 1, key: "foo", value: []byte("stale")}).encode())
+	require.NoError(t, err)
+	require.NoError(t, output.close())
+
+	engine = openTestEngine(t, directory, 1<<10)
+	value, exists := engine.Get("foo")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("bar"), value)
+
+	_, err = os.Stat(output.path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDiskEngine_Batch(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 1<<10)
+
+	require.NoError(t, engine.MSet([]storage.KeyValue{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "a", Value: []byte("3")},
+	}))
+
+	values, found := engine.MGet([]string{"a", "b", "c"})
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("2"), nil}, values)
+	assert.Equal(t, []bool{true, true, false}, found)
+
+	deleted, err := engine.MDelete([]string{"b", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	require.NoError(t, engine.Close())
+
+	engine = openTestEngine(t, directory, 1<<10)
+	values, found = engine.MGet([]string{"a", "b"})
+	assert.Equal(t, [][]byte{[]byte("3"), nil}, values)
+	assert.Equal(t, []bool{true, false}, found)
+}
+
+func TestDiskEngine_TornBatch(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 1<<10)
+	require.NoError(t, engine.Set("foo", []byte("bar")))
+	activePath := engine.active.path
+	require.NoError(t, engine.Close())
+
+	// the first record of batch is written completely, but the last one is missing
+	file, err := os.OpenFile(activePath, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write((&record{seq: 2, key: "foo", value: []byte("baz"), batch: true}).encode())
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	engine = openTestEngine(t, directory, 1<<10)
+	value, exists := engine.Get("foo")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("bar"), value)
+
+	stat, err := os.Stat(activePath)
+	require.NoError(t, err)
+	assert.Equal(t, (&record{key: "foo", value: []byte("bar")}).size(), stat.Size())
+}
+
+func TestDiskEngine_MergeBatch(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 1<<10)
+
+	// the last record of batch is overwritten, so merged file keeps only records with batch flag
+	require.NoError(t, engine.MSet([]storage.KeyValue{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}}))
+	require.NoError(t, engine.Set("b", []byte("3")))
+	require.NoError(t, engine.Merge())
+	require.NoError(t, engine.Close())
+
+	engine = openTestEngine(t, directory, 1<<10)
+	values, found := engine.MGet([]string{"a", "b"})
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("3")}, values)
+	assert.Equal(t, []bool{true, true}, found)
+}
+
+func TestDiskEngine_Update(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	engine := openTestEngine(t, directory, 1<<10)
+
+	appendX := func(current storage.Entry, exists bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: append(append([]byte{}, current.Value...), 'x')}, storage.UpdateStore, nil
+	}
+
+	updated, err := engine.Update("foo", appendX)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), updated.Value)
+
+	updated, err = engine.Update("foo", appendX)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("xx"), updated.Value)
+
+	_, err = engine.Update("foo", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateKeep, storage.ErrNotInteger
+	})
+	assert.ErrorIs(t, err, storage.ErrNotInteger)
+
+	_, err = engine.Update("foo", func(current storage.Entry, _ bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: current.Value, ExpireAt: 1}, storage.UpdateStore, nil
+	})
+	assert.ErrorIs(t, err, storage.ErrExpiryNotSupported)
+
+	_, err = engine.Update("bar", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateStore, nil
+	})
+	require.NoError(t, err)
+	_, err = engine.Update("bar", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateDelete, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, engine.Close())
+
+	engine = openTestEngine(t, directory, 1<<10)
+	value, exists := engine.Get("foo")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("xx"), value)
+
+	_, exists = engine.Get("bar")
+	assert.False(t, exists)
+}
+
+func TestDiskEngine_Scan(t *testing.T) {
+	t.Parallel()
+
+	engine := openTestEngine(t, t.TempDir(), 1<<10)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, engine.Set(fmt.Sprintf("key%d", i), []byte("value")))
+	}
+	require.NoError(t, engine.Delete("key0"))
+
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for {
+		var keys []string
+		cursor, keys = engine.Scan(cursor, 4)
+		for _, key := range keys {
+			seen[key]++
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.Len(t, seen, 29)
+	assert.NotContains(t, seen, "key0")
+	for key, n := range seen {
+		assert.Equal(t, 1, n, key)
+	}
+}
+
+func TestDiskEngine_Conformance(t *testing.T) {
+	t.Parallel()
+
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		return openTestEngine(t, t.TempDir(), 1<<10)
+	})
+}
@@ -2,6 +2,9 @@ package inmemory
 
 import (
 	"errors"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
 	"go.uber.org/zap"
 )
 
@@ -14,15 +17,14 @@ type InMemEngine struct {
 	logger      *zap.Logger
 }
 
-func (e *InMemEngine) Get(key string) (string, bool) {
+func (e *InMemEngine) Get(key string) ([]byte, bool) {
 	val, exists := e.dataStorage.Get(key)
 
 	return val, exists
 }
 
-func (e *InMemEngine) Set(key string, value string) error {
-	e.dataStorage.Set(key, value)
-	return nil
+func (e *InMemEngine) Set(key string, value []byte) error {
+	return e.dataStorage.Set(key, value)
 }
 
 func (e *InMemEngine) Delete(key string) error {
@@ -30,13 +32,85 @@ func (e *InMemEngine) Delete(key string) error {
 	return nil
 }
 
-func NewInMemoryEngine(logger *zap.Logger) (*InMemEngine, error) {
+func (e *InMemEngine) MGet(keys []string) ([][]byte, []bool) {
+	return e.dataStorage.MGet(keys)
+}
+
+func (e *InMemEngine) MSet(pairs []storage.KeyValue) error {
+	return e.dataStorage.MSet(pairs)
+}
+
+func (e *InMemEngine) MDelete(keys []string) (int, error) {
+	return e.dataStorage.MDelete(keys), nil
+}
+
+func (e *InMemEngine) Update(key string, fn storage.UpdateFunc) (storage.Entry, error) {
+	return e.dataStorage.Update(key, fn)
+}
+
+func (e *InMemEngine) Version(key string) uint64 {
+	return e.dataStorage.Version(key)
+}
+
+func (e *InMemEngine) Scan(cursor uint64, count int) (uint64, []string) {
+	return e.dataStorage.Scan(cursor, count)
+}
+
+func (e *InMemEngine) Type(key string) (storage.ValueType, bool) {
+	return e.dataStorage.Type(key)
+}
+
+func (e *InMemEngine) ViewCollection(key string, t storage.ValueType, fn func(c storage.Collection)) (bool, error) {
+	return e.dataStorage.ViewCollection(key, t, fn)
+}
+
+func (e *InMemEngine) UpdateCollection(key string, t storage.ValueType, create func() storage.Collection, fn func(c storage.Collection) (bool, error)) error {
+	return e.dataStorage.UpdateCollection(key, t, create, fn)
+}
+
+func (e *InMemEngine) SetWithExpiry(key string, value []byte, expireAt time.Time) error {
+	return e.dataStorage.SetWithExpiry(key, value, expireAt.UnixNano())
+}
+
+func (e *InMemEngine) Expire(key string, expireAt time.Time) bool {
+	return e.dataStorage.Expire(key, expireAt.UnixNano())
+}
+
+func (e *InMemEngine) Persist(key string) bool {
+	return e.dataStorage.Persist(key)
+}
+
+func (e *InMemEngine) TTL(key string) (time.Duration, bool, bool) {
+	return e.dataStorage.TTL(key)
+}
+
+func (e *InMemEngine) DeleteExpired(limit int) (int, int) {
+	return e.dataStorage.DeleteExpired(limit)
+}
+
+func (e *InMemEngine) OnRemove(fn func(key string, reason storage.RemovalReason)) {
+	e.dataStorage.OnRemove(fn)
+}
+
+func (e *InMemEngine) Dump() map[string]storage.Entry {
+	return e.dataStorage.Dump()
+}
+
+func (e *InMemEngine) Cut() func() map[string]storage.Entry {
+	return e.dataStorage.Cut()
+}
+
+func (e *InMemEngine) Load(data map[string]storage.Entry) {
+	e.dataStorage.Load(data)
+}
+
+func NewInMemoryEngine(logger *zap.Logger, opts ...Option) (*InMemEngine, error) {
 	if logger == nil {
 		return nil, ErrInvalidLogger
 	}
 
 	return &InMemEngine{
-		dataStorage: NewHashTable(),
+		dataStorage: NewHashTable(opts...),
 		logger:      logger,
 	}, nil
 }
@@ -1,9 +1,13 @@
 package inmemory
 
 import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/db/storage/enginetest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
-	"testing"
 )
 
 func TestNewInMemoryEngine(t *testing.T) {
@@ -33,3 +37,14 @@ func TestNewInMemoryEngine(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemEngine_Conformance(t *testing.T) {
+	t.Parallel()
+
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		engine, err := NewInMemoryEngine(zap.NewNop(), WithShards(4))
+		require.NoError(t, err)
+
+		return engine
+	})
+}
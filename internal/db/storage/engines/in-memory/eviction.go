@@ -0,0 +1,193 @@
+package inmemory
+
+import (
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+type EvictionPolicy string
+
+const (
+	NoEviction    EvictionPolicy = "noeviction"
+	AllKeysLRU    EvictionPolicy = "allkeys-lru"
+	AllKeysLFU    EvictionPolicy = "allkeys-lfu"
+	VolatileTTL   EvictionPolicy = "volatile-ttl"
+	AllKeysRandom EvictionPolicy = "allkeys-random"
+)
+
+const (
+	// evictionSamples is a number of keys compared to pick eviction candidate, like in redis
+	// eviction is approximated, it is much cheaper than keeping all keys ordered
+	evictionSamples = 5
+
+	// entryOverhead approximates memory taken by map bucket, entry metadata and scan index node
+	entryOverhead = 128
+
+	lfuInitValue   = 5
+	lfuLogFactor   = 10
+	lfuDecayPeriod = time.Minute
+)
+
+type Option func(h *HashTable)
+
+// WithMaxMemory limits approximate memory used by keys and values, zero means no limit
+func WithMaxMemory(maxMemory int, policy EvictionPolicy) Option {
+	return func(h *HashTable) {
+		h.maxMemory = int64(maxMemory)
+		h.policy = policy
+	}
+}
+
+func entrySize(k string, e *entry) int64 {
+	size := len(k) + len(e.value) + entryOverhead
+	if e.collection != nil {
+		size += e.collection.Size()
+	}
+
+	return int64(size)
+}
+
+// evictionRank orders candidates, entry with the lowest rank is evicted first
+type evictionRank struct {
+	primary   int64
+	secondary int64
+}
+
+func (r evictionRank) less(other evictionRank) bool {
+	return r.primary < other.primary || (r.primary == other.primary && r.secondary < other.secondary)
+}
+
+// evictOne removes the best candidate among keys sampled from shards starting with a random one,
+// keys being written are never evicted. False is returned if policy forbids eviction or there is
+// nothing to evict. Must be called without holding any shard lock
+func (h *HashTable) evictOne(now int64, except ...string) bool {
+	if h.policy == NoEviction || h.policy == "" {
+		return false
+	}
+
+	var (
+		candidate     string
+		candidateFrom *shard
+		best          evictionRank
+		found         bool
+	)
+
+	sampled := 0
+	start := rand.IntN(len(h.shards))
+
+	for i := 0; i < len(h.shards) && sampled < evictionSamples; i++ {
+		s := h.shards[(start+i)&int(h.mask)]
+
+		s.mu.RLock()
+		for _, k := range sampleKeys(s, h.policy, evictionSamples-sampled) {
+			if slices.Contains(except, k) {
+				continue
+			}
+			sampled++
+
+			rank := h.evictionRank(s.data[k], now)
+			if !found || rank.less(best) {
+				candidate, candidateFrom, best, found = k, s, rank, true
+			}
+		}
+		s.mu.RUnlock()
+
+		if found && h.policy == AllKeysRandom {
+			break
+		}
+	}
+
+	if !found {
+		return false
+	}
+
+	// candidate could be removed concurrently, memory is freed anyway then
+	candidateFrom.mu.Lock()
+	h.remove(candidateFrom, candidate, storage.RemovedEvicted)
+	candidateFrom.mu.Unlock()
+
+	return true
+}
+
+// sampleKeys returns up to limit random keys of shard eligible for eviction by policy
+func sampleKeys(s *shard, policy EvictionPolicy, limit int) []string {
+	keys := make([]string, 0, limit)
+
+	if policy == VolatileTTL {
+		for k := range s.volatile {
+			if len(keys) == limit {
+				break
+			}
+			keys = append(keys, k)
+		}
+
+		return keys
+	}
+
+	for k := range s.data {
+		if len(keys) == limit {
+			break
+		}
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func (h *HashTable) evictionRank(e *entry, now int64) evictionRank {
+	accessedAt := e.accessedAt.Load()
+
+	switch h.policy {
+	case AllKeysLRU:
+		return evictionRank{primary: accessedAt}
+	case AllKeysLFU:
+		return evictionRank{primary: int64(lfuDecay(uint8(e.frequency.Load()), accessedAt, now)), secondary: accessedAt}
+	case VolatileTTL:
+		return evictionRank{primary: e.expireAt}
+	default:
+		return evictionRank{}
+	}
+}
+
+// touch updates access statistics of entry used by lru and lfu policies. Concurrent readers
+// may lose an increment, counters are approximate anyway
+func (h *HashTable) touch(e *entry, now int64) {
+	if h.policy != AllKeysLRU && h.policy != AllKeysLFU {
+		return
+	}
+
+	counter := lfuDecay(uint8(e.frequency.Load()), e.accessedAt.Load(), now)
+	e.frequency.Store(uint32(lfuIncrement(counter)))
+	e.accessedAt.Store(now)
+}
+
+// lfuIncrement grows logarithmic access counter, the bigger it is the less likely it grows
+func lfuIncrement(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+
+	base := max(float64(counter)-lfuInitValue, 0)
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+
+	return counter
+}
+
+// lfuDecay decrements counter once per decay period passed since the last access
+func lfuDecay(counter uint8, accessedAt int64, now int64) uint8 {
+	periods := (now - accessedAt) / int64(lfuDecayPeriod)
+	if periods <= 0 {
+		return counter
+	}
+
+	if periods >= int64(counter) {
+		return 0
+	}
+
+	return counter - uint8(periods)
+}
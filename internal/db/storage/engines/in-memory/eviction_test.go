@@ -0,0 +1,120 @@
+package inmemory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillHashTable stores n keys of the same size accessed one millisecond after another
+func fillHashTable(t *testing.T, ht *HashTable, now *time.Time, n int) {
+	for i := 0; i < n; i++ {
+		*now = now.Add(time.Millisecond)
+		require.NoError(t, ht.Set(fmt.Sprintf("key%d", i), []byte("value")))
+	}
+}
+
+func TestHashTable_NoEviction(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ht := NewHashTable(WithMaxMemory(3*int(entrySize("key0", newEntry([]byte("value"), 0, 0))), NoEviction))
+	ht.clock = func() time.Time { return now }
+
+	fillHashTable(t, ht, &now, 3)
+
+	assert.ErrorIs(t, ht.Set("key3", []byte("value")), storage.ErrOutOfMemory)
+	// overwriting with value of the same size does not need more memory
+	assert.NoError(t, ht.Set("key0", []byte("other")))
+
+	ht.Del("key1")
+	assert.NoError(t, ht.Set("key3", []byte("value")))
+	assert.Equal(t, ht.maxMemory, ht.UsedMemory())
+}
+
+func TestHashTable_Eviction(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		policy  EvictionPolicy
+		prepare func(ht *HashTable, now *time.Time)
+		evicted string
+	}{
+		"allkeys-lru evicts least recently used key": {
+			policy: AllKeysLRU,
+			prepare: func(ht *HashTable, now *time.Time) {
+				for _, k := range []string{"key0", "key2", "key3", "key4"} {
+					*now = now.Add(time.Millisecond)
+					ht.Get(k)
+				}
+			},
+			evicted: "key1",
+		},
+		"allkeys-lfu evicts least frequently used key": {
+			policy: AllKeysLFU,
+			prepare: func(ht *HashTable, now *time.Time) {
+				for i := 0; i < 100; i++ {
+					for _, k := range []string{"key0", "key1", "key3", "key4"} {
+						ht.Get(k)
+					}
+				}
+			},
+			evicted: "key2",
+		},
+		"volatile-ttl evicts key with the nearest expiration": {
+			policy: VolatileTTL,
+			prepare: func(ht *HashTable, now *time.Time) {
+				ht.Expire("key1", now.Add(time.Hour).UnixNano())
+				ht.Expire("key3", now.Add(time.Minute).UnixNano())
+			},
+			evicted: "key3",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Now()
+			ht := NewHashTable(WithMaxMemory(evictionSamples*int(entrySize("key0", newEntry([]byte("value"), 0, 0))), tc.policy))
+			ht.clock = func() time.Time { return now }
+
+			fillHashTable(t, ht, &now, evictionSamples)
+			tc.prepare(ht, &now)
+
+			require.NoError(t, ht.Set("new", []byte("value")))
+			assert.False(t, existsInHashTable(ht, tc.evicted))
+			assert.True(t, existsInHashTable(ht, "new"))
+			assert.LessOrEqual(t, ht.UsedMemory(), ht.maxMemory)
+		})
+	}
+}
+
+func TestHashTable_RandomEviction(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ht := NewHashTable(WithMaxMemory(10*int(entrySize("key10", newEntry([]byte("value"), 0, 0))), AllKeysRandom))
+	ht.clock = func() time.Time { return now }
+
+	fillHashTable(t, ht, &now, 100)
+
+	keys, _ := countInHashTable(ht)
+	assert.Equal(t, 10, keys)
+	assert.True(t, existsInHashTable(ht, "key99"))
+}
+
+func TestHashTable_VolatileTTLWithoutVolatileKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ht := NewHashTable(WithMaxMemory(int(entrySize("key0", newEntry([]byte("value"), 0, 0))), VolatileTTL))
+	ht.clock = func() time.Time { return now }
+
+	fillHashTable(t, ht, &now, 1)
+	assert.ErrorIs(t, ht.Set("key1", []byte("value")), storage.ErrOutOfMemory)
+}
@@ -1,41 +1,858 @@
 package inmemory
 
-import "sync"
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+const (
+	// DefaultShardsCount is used when shards count is not configured
+	DefaultShardsCount = 16
+	// dumpChunkSize is number of keys copied by dump before it lets writers of shard in
+	dumpChunkSize = 1024
+)
 
 type Hasheable interface {
-	Get(k string) (string, bool)
-	Set(k string, v string)
+	Get(k string) ([]byte, bool)
+	Set(k string, v []byte) error
 	Del(k string)
 }
 
+type entry struct {
+	// value is never modified in place, it is replaced as a whole
+	value []byte
+	// collection is set for keys holding lists and alike, it is modified in place under write lock
+	collection storage.Collection
+	// expireAt is unix time in nanoseconds, zero means key never expires
+	expireAt int64
+	// version changes on every modification of entry, it is used by WATCH
+	version uint64
+	// accessedAt and frequency are used by eviction policies, they are updated
+	// by readers holding only read lock, so access is atomic
+	accessedAt atomic.Int64
+	frequency  atomic.Uint32
+}
+
+func newEntry(value []byte, expireAt int64, now int64) *entry {
+	e := &entry{value: value, expireAt: expireAt}
+	e.accessedAt.Store(now)
+	e.frequency.Store(lfuInitValue)
+
+	return e
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expireAt != 0 && e.expireAt <= now
+}
+
+type shard struct {
+	mu   sync.RWMutex
+	data map[string]*entry
+	// volatile holds keys with expiration, so sweeper samples only them
+	volatile map[string]struct{}
+	// deleted is a version of the last deletion in shard, it is reported for missing keys,
+	// so creating and then deleting key is noticed as a change
+	deleted uint64
+	// frozen keeps state keys had at the moment of cut before they were changed, it is nil
+	// unless dump is running
+	frozen map[string]frozenEntry
+	// index orders keys by scan hash, so scan step reads only keys it returns
+	index *scanIndex
+}
+
+// put stores entry of key, must be called under write lock
+func (s *shard) put(k string, e *entry) {
+	if _, exists := s.data[k]; !exists {
+		s.index.insert(k)
+	}
+	s.data[k] = e
+}
+
+type frozenEntry struct {
+	entry  storage.Entry
+	exists bool
+}
+
+// HashTable splits keys between power of two shards, each guarded by its own lock,
+// so operations on different keys rarely wait for each other
 type HashTable struct {
-	data map[string]string
-	mu   sync.Mutex // todo bench for mutex or rwmutex
+	shards []*shard
+	mask   uint64
+	seed   maphash.Seed
+	clock  func() time.Time
+
+	maxMemory  int64
+	usedMemory atomic.Int64
+	policy     EvictionPolicy
+
+	versions atomic.Uint64
+	// onRemove is told about keys which expire or are evicted
+	onRemove func(k string, reason storage.RemovalReason)
+	// dumpMu lets only one cut exist at a time
+	dumpMu sync.Mutex
 }
 
-func (h *HashTable) Get(k string) (string, bool) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	value, exists := h.data[k]
+// Get returns stored value, it is shared with the table and must not be modified
+func (h *HashTable) Get(k string) ([]byte, bool) {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+
+	s.mu.RLock()
+	e, exists := s.data[k]
+	if !exists {
+		s.mu.RUnlock()
+		return nil, false
+	}
+
+	if e.expired(now) {
+		s.mu.RUnlock()
+		h.deleteIfExpired(s, k, now)
+		return nil, false
+	}
+
+	if e.collection != nil {
+		s.mu.RUnlock()
+		return nil, false
+	}
 
-	return value, exists
+	h.touch(e, now)
+	value := e.value
+	s.mu.RUnlock()
+
+	return value, true
+}
+
+func (h *HashTable) Set(k string, v []byte) error {
+	return h.store(k, v, 0)
 }
 
-func (h *HashTable) Set(k, v string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.data[k] = v
+func (h *HashTable) SetWithExpiry(k string, v []byte, expireAt int64) error {
+	return h.store(k, v, expireAt)
 }
 
 func (h *HashTable) Del(k string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.data, k)
+	s := h.shardFor(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.delete(s, k)
+}
+
+// MGet reads keys holding locks of all their shards, so batch written by MSet is seen as a whole
+func (h *HashTable) MGet(keys []string) ([][]byte, []bool) {
+	shards := h.shardsFor(keys)
+	now := h.clock().UnixNano()
+
+	for _, s := range shards {
+		s.mu.RLock()
+	}
+
+	values, found := make([][]byte, len(keys)), make([]bool, len(keys))
+	for i, k := range keys {
+		// expired keys are left for sweeper, deleting them needs write lock
+		if e, exists := h.shardFor(k).data[k]; exists && !e.expired(now) && e.collection == nil {
+			h.touch(e, now)
+			values[i], found[i] = e.value, true
+		}
+	}
+
+	for _, s := range shards {
+		s.mu.RUnlock()
+	}
+
+	return values, found
+}
+
+// MSet stores all pairs atomically, if memory limit is reached nothing is stored
+func (h *HashTable) MSet(pairs []storage.KeyValue) error {
+	keys, entries := make([]string, len(pairs)), make([]*entry, len(pairs))
+	now := h.clock().UnixNano()
+	for i, pair := range pairs {
+		keys[i], entries[i] = pair.Key, newEntry(pair.Value, 0, now)
+	}
+
+	shards := h.shardsFor(keys)
+
+	for {
+		for _, s := range shards {
+			s.mu.Lock()
+		}
+
+		// the last value wins if key is repeated
+		sizes := make(map[string]int64, len(pairs))
+		var delta int64
+		for i, k := range keys {
+			if _, seen := sizes[k]; !seen {
+				if old, exists := h.shardFor(k).data[k]; exists {
+					delta -= entrySize(k, old)
+				}
+			}
+			delta -= sizes[k]
+			sizes[k] = entrySize(k, entries[i])
+			delta += sizes[k]
+		}
+
+		if h.maxMemory == 0 || delta <= 0 || h.usedMemory.Load()+delta <= h.maxMemory {
+			for i, k := range keys {
+				s := h.shardFor(k)
+				h.preserve(s, k)
+				entries[i].version = h.versions.Add(1)
+				s.put(k, entries[i])
+				delete(s.volatile, k)
+			}
+			h.usedMemory.Add(delta)
+
+			for _, s := range shards {
+				s.mu.Unlock()
+			}
+			return nil
+		}
+
+		for _, s := range shards {
+			s.mu.Unlock()
+		}
+
+		if !h.evictOne(now, keys...) {
+			return storage.ErrOutOfMemory
+		}
+	}
+}
+
+// MDelete removes keys atomically and returns number of keys which existed
+func (h *HashTable) MDelete(keys []string) int {
+	shards := h.shardsFor(keys)
+	now := h.clock().UnixNano()
+
+	for _, s := range shards {
+		s.mu.Lock()
+	}
+
+	deleted := 0
+	for _, k := range keys {
+		s := h.shardFor(k)
+		if e, exists := s.data[k]; exists {
+			if e.expired(now) {
+				h.remove(s, k, storage.RemovedExpired)
+				continue
+			}
+			deleted++
+			h.delete(s, k)
+		}
+	}
+
+	for _, s := range shards {
+		s.mu.Unlock()
+	}
+
+	return deleted
+}
+
+// Update runs fn and applies its decision under the shard lock, so concurrent updates
+// of the same key are never lost
+func (h *HashTable) Update(k string, fn storage.UpdateFunc) (storage.Entry, error) {
+	s := h.shardFor(k)
+
+	for {
+		s.mu.Lock()
+
+		var current storage.Entry
+		old, exists := h.lookup(s, k)
+		if exists {
+			current = storage.Entry{Value: old.value, Collection: old.collection, ExpireAt: old.expireAt}
+		}
+		// fn may change collection of current entry in place
+		h.preserve(s, k)
+
+		updated, action, err := fn(current, exists)
+		if err != nil {
+			s.mu.Unlock()
+			return storage.Entry{}, err
+		}
+
+		switch action {
+		case storage.UpdateKeep:
+			s.mu.Unlock()
+			return current, nil
+		case storage.UpdateDelete:
+			h.delete(s, k)
+			s.mu.Unlock()
+			return storage.Entry{}, nil
+		}
+
+		now := h.clock().UnixNano()
+		e := newEntry(updated.Value, updated.ExpireAt, now)
+		e.collection = updated.Collection
+		delta := entrySize(k, e)
+		if exists {
+			delta -= entrySize(k, old)
+		}
+
+		if h.maxMemory == 0 || delta <= 0 || h.usedMemory.Load()+delta <= h.maxMemory {
+			e.version = h.versions.Add(1)
+			s.put(k, e)
+			h.usedMemory.Add(delta)
+
+			if updated.ExpireAt != 0 {
+				s.volatile[k] = struct{}{}
+			} else {
+				delete(s.volatile, k)
+			}
+
+			s.mu.Unlock()
+			return updated, nil
+		}
+
+		s.mu.Unlock()
+
+		if !h.evictOne(now, k) {
+			return storage.Entry{}, storage.ErrOutOfMemory
+		}
+	}
+}
+
+// Expire sets expiration time of existing key
+func (h *HashTable) Expire(k string, expireAt int64) bool {
+	s := h.shardFor(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := h.lookup(s, k)
+	if !exists {
+		return false
+	}
+
+	h.preserve(s, k)
+	e.expireAt = expireAt
+	e.version = h.versions.Add(1)
+	s.volatile[k] = struct{}{}
+
+	return true
+}
+
+// Persist removes expiration of key, false is returned if key has no expiration
+func (h *HashTable) Persist(k string) bool {
+	s := h.shardFor(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := h.lookup(s, k)
+	if !exists || e.expireAt == 0 {
+		return false
+	}
+
+	h.preserve(s, k)
+	e.expireAt = 0
+	e.version = h.versions.Add(1)
+	delete(s.volatile, k)
+
+	return true
+}
+
+// TTL returns remaining time to live of key and whether key has expiration at all
+func (h *HashTable) TTL(k string) (ttl time.Duration, hasExpiry bool, exists bool) {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, exists := s.data[k]
+	if !exists || e.expired(now) {
+		return 0, false, false
+	}
+
+	if e.expireAt == 0 {
+		return 0, false, true
+	}
+
+	return time.Duration(e.expireAt - now), true, true
+}
+
+// DeleteExpired checks up to limit keys with expiration and removes expired ones
+func (h *HashTable) DeleteExpired(limit int) (sampled int, expired int) {
+	now := h.clock().UnixNano()
+	start := rand.IntN(len(h.shards))
+
+	for i := 0; i < len(h.shards) && sampled < limit; i++ {
+		s := h.shards[(start+i)&int(h.mask)]
+
+		s.mu.Lock()
+		// map iteration starts from random position, so it works as random sampling
+		for k := range s.volatile {
+			if sampled == limit {
+				break
+			}
+			sampled++
+
+			if s.data[k].expired(now) {
+				h.remove(s, k, storage.RemovedExpired)
+				expired++
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return sampled, expired
+}
+
+// Version returns current version of key, it changes whenever key is modified, deleted or expires.
+// Missing keys share version of the last deletion in their shard, so unrelated deletions may look
+// like a change, but a real change is never missed
+func (h *HashTable) Version(k string) uint64 {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if e, exists := s.data[k]; exists && !e.expired(now) {
+		return e.version
+	}
+
+	// expired key is deleted later, so its version changes once more, it's fine for optimistic lock
+	return s.deleted
+}
+
+// Type returns type of value of key
+func (h *HashTable) Type(k string) (storage.ValueType, bool) {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, exists := s.data[k]
+	if !exists || e.expired(now) {
+		return 0, false
+	}
+
+	return entryType(e), true
+}
+
+// ViewCollection calls fn with collection of key under read lock of its shard
+func (h *HashTable) ViewCollection(k string, t storage.ValueType, fn func(c storage.Collection)) (bool, error) {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, exists := s.data[k]
+	if !exists || e.expired(now) {
+		return false, nil
+	}
+
+	if entryType(e) != t {
+		return false, storage.ErrWrongType
+	}
+
+	h.touch(e, now)
+	fn(e.collection)
+
+	return true, nil
+}
+
+// UpdateCollection changes collection of key in place under write lock of its shard. Size of
+// collection is known only after it is changed, so memory is freed before the change and limit
+// may be exceeded by a single write like in redis
+func (h *HashTable) UpdateCollection(k string, t storage.ValueType, create func() storage.Collection, fn func(c storage.Collection) (bool, error)) error {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+
+	for h.maxMemory != 0 && h.usedMemory.Load() > h.maxMemory {
+		if !h.evictOne(now, k) {
+			return storage.ErrOutOfMemory
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := h.lookup(s, k)
+	var before int64
+	switch {
+	case exists && entryType(e) != t:
+		return storage.ErrWrongType
+	case exists:
+		before = entrySize(k, e)
+	case create == nil:
+		return nil
+	default:
+		e = newEntry(nil, 0, now)
+		e.collection = create()
+	}
+
+	// collection is changed in place, so its copy is kept for dump before fn is called
+	h.preserve(s, k)
+	changed, err := fn(e.collection)
+	if err != nil || !changed {
+		return err
+	}
+
+	h.usedMemory.Add(entrySize(k, e) - before)
+	if !exists {
+		s.put(k, e)
+	}
+
+	if e.collection.Len() == 0 {
+		h.delete(s, k)
+		return nil
+	}
+
+	e.version = h.versions.Add(1)
+	h.touch(e, now)
+
+	return nil
 }
 
-func NewHashTable() *HashTable {
-	return &HashTable{
-		data: make(map[string]string),
-		mu:   sync.Mutex{},
+// Scan walks shards one by one until count keys are read, cursor holds index of shard in the
+// upper half and hash of key to continue from in the lower half. Only one shard is read locked at a
+// time, and step reads about count keys from shard index no matter how many keys shard holds
+func (h *HashTable) Scan(cursor uint64, count int) (uint64, []string) {
+	index, from := cursor>>32, uint32(cursor)
+	keys := make([]string, 0)
+	count = max(count, 1)
+	read := 0
+
+	for ; index < uint64(len(h.shards)); index, from = index+1, 0 {
+		if read >= count {
+			return index << 32, keys
+		}
+
+		s := h.shards[index]
+		now := h.clock().UnixNano()
+
+		s.mu.RLock()
+		n := s.index.seek(from)
+		// keys with equal hashes go to the same step, since cursor can't point between them
+		for last := uint32(0); n != nil && (read < count || n.hash == last); n = n.next[0] {
+			if !s.data[n.key].expired(now) {
+				keys = append(keys, n.key)
+			}
+			last = n.hash
+			read++
+		}
+		s.mu.RUnlock()
+
+		// next key has larger hash than keys read before it, so cursor is never zero here
+		if n != nil {
+			return index<<32 | uint64(n.hash), keys
+		}
+	}
+
+	return 0, keys
+}
+
+// Dump returns a point-in-time copy of the table
+func (h *HashTable) Dump() map[string]storage.Entry {
+	return h.Cut()()
+}
+
+// Cut marks point in time and returns func copying the table as it was then. All shards are locked
+// only while cut is taken, keys changed after it keep their state for the copy until it is made.
+// Returned func must be called exactly once
+func (h *HashTable) Cut() func() map[string]storage.Entry {
+	h.dumpMu.Lock()
+
+	for _, s := range h.shards {
+		s.mu.Lock()
+	}
+
+	now := h.clock().UnixNano()
+	size := h.len()
+	for _, s := range h.shards {
+		s.frozen = make(map[string]frozenEntry)
+		s.mu.Unlock()
+	}
+
+	return func() map[string]storage.Entry {
+		defer h.dumpMu.Unlock()
+
+		data := make(map[string]storage.Entry, size)
+		for _, s := range h.shards {
+			h.copyShard(s, now, data)
+		}
+
+		return data
 	}
 }
+
+// copyShard copies state of shard at the moment of cut to data. Read lock is released now and
+// then, so writers of shard wait only for a chunk of keys to be copied
+func (h *HashTable) copyShard(s *shard, now int64, data map[string]storage.Entry) {
+	s.mu.RLock()
+	copied := 0
+	for k, e := range s.data {
+		if _, changed := s.frozen[k]; !changed && !e.expired(now) {
+			data[k] = dumpEntry(e)
+		}
+
+		if copied++; copied%dumpChunkSize == 0 {
+			s.mu.RUnlock()
+			s.mu.RLock()
+		}
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, f := range s.frozen {
+		if f.exists && (f.entry.ExpireAt == 0 || f.entry.ExpireAt > now) {
+			data[k] = f.entry
+		}
+	}
+	s.frozen = nil
+}
+
+// Load replaces table contents with data, it waits for running dump
+func (h *HashTable) Load(data map[string]storage.Entry) {
+	h.dumpMu.Lock()
+	defer h.dumpMu.Unlock()
+
+	for _, s := range h.shards {
+		s.mu.Lock()
+		s.data = make(map[string]*entry)
+		s.volatile = make(map[string]struct{})
+		s.index = newScanIndex()
+	}
+
+	defer func() {
+		for _, s := range h.shards {
+			s.mu.Unlock()
+		}
+	}()
+
+	now := h.clock().UnixNano()
+	var used int64
+
+	for k, e := range data {
+		s := h.shardFor(k)
+		loaded := newEntry(e.Value, e.ExpireAt, now)
+		loaded.collection = e.Collection
+		loaded.version = h.versions.Add(1)
+		s.put(k, loaded)
+		used += entrySize(k, loaded)
+
+		if e.ExpireAt != 0 {
+			s.volatile[k] = struct{}{}
+		}
+	}
+
+	h.usedMemory.Store(used)
+}
+
+// UsedMemory returns approximate memory taken by stored keys and values
+func (h *HashTable) UsedMemory() int64 {
+	return h.usedMemory.Load()
+}
+
+// store puts entry evicting other keys if memory limit is reached. Eviction happens
+// without holding the lock of key shard, so two shard locks are never held together
+func (h *HashTable) store(k string, value []byte, expireAt int64) error {
+	s := h.shardFor(k)
+	now := h.clock().UnixNano()
+	e := newEntry(value, expireAt, now)
+	size := entrySize(k, e)
+
+	for {
+		s.mu.Lock()
+
+		delta := size
+		if old, exists := s.data[k]; exists {
+			delta -= entrySize(k, old)
+		}
+
+		if h.maxMemory == 0 || delta <= 0 || h.usedMemory.Load()+delta <= h.maxMemory {
+			h.preserve(s, k)
+			e.version = h.versions.Add(1)
+			s.put(k, e)
+			h.usedMemory.Add(delta)
+
+			if expireAt != 0 {
+				s.volatile[k] = struct{}{}
+			} else {
+				delete(s.volatile, k)
+			}
+
+			s.mu.Unlock()
+			return nil
+		}
+
+		s.mu.Unlock()
+
+		if !h.evictOne(now, k) {
+			return storage.ErrOutOfMemory
+		}
+	}
+}
+
+func entryType(e *entry) storage.ValueType {
+	if e.collection == nil {
+		return storage.StringType
+	}
+
+	return e.collection.Type()
+}
+
+// dumpEntry copies entry, collections are copied too as they are modified in place
+func dumpEntry(e *entry) storage.Entry {
+	entry := storage.Entry{Value: e.value, ExpireAt: e.expireAt}
+	if e.collection != nil {
+		entry.Collection = e.collection.Clone()
+	}
+
+	return entry
+}
+
+// preserve keeps state of key for running dump before key is changed for the first time since
+// cut, must be called under write lock
+func (h *HashTable) preserve(s *shard, k string) {
+	if s.frozen == nil {
+		return
+	}
+
+	if _, saved := s.frozen[k]; saved {
+		return
+	}
+
+	if e, exists := s.data[k]; exists {
+		s.frozen[k] = frozenEntry{entry: dumpEntry(e), exists: true}
+	} else {
+		s.frozen[k] = frozenEntry{}
+	}
+}
+
+// lookup returns live entry and lazily removes expired one, must be called under write lock
+func (h *HashTable) lookup(s *shard, k string) (*entry, bool) {
+	e, exists := s.data[k]
+	if !exists {
+		return nil, false
+	}
+
+	now := h.clock().UnixNano()
+	if e.expired(now) {
+		h.remove(s, k, storage.RemovedExpired)
+		return nil, false
+	}
+
+	h.touch(e, now)
+	return e, true
+}
+
+func (h *HashTable) deleteIfExpired(s *shard, k string, now int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// key could be overwritten since it was checked under read lock
+	if e, exists := s.data[k]; exists && e.expired(now) {
+		h.remove(s, k, storage.RemovedExpired)
+	}
+}
+
+// remove deletes key which engine drops on its own and reports it, must be called under write lock
+func (h *HashTable) remove(s *shard, k string, reason storage.RemovalReason) {
+	if _, exists := s.data[k]; !exists {
+		return
+	}
+
+	h.delete(s, k)
+	if h.onRemove != nil {
+		h.onRemove(k, reason)
+	}
+}
+
+// OnRemove sets fn called for every expired or evicted key, it must be set before table is used
+func (h *HashTable) OnRemove(fn func(k string, reason storage.RemovalReason)) {
+	h.onRemove = fn
+}
+
+// delete removes key from shard, must be called under write lock
+func (h *HashTable) delete(s *shard, k string) {
+	h.preserve(s, k)
+	if e, exists := s.data[k]; exists {
+		h.usedMemory.Add(-entrySize(k, e))
+		s.deleted = h.versions.Add(1)
+		s.index.remove(k)
+	}
+
+	delete(s.data, k)
+	delete(s.volatile, k)
+}
+
+func (h *HashTable) shardFor(k string) *shard {
+	return h.shards[maphash.String(h.seed, k)&h.mask]
+}
+
+// shardsFor returns distinct shards of keys ordered by index, locking them in this order
+// keeps batch operations from deadlocking with each other
+func (h *HashTable) shardsFor(keys []string) []*shard {
+	indexes := make([]uint64, 0, len(keys))
+	for _, k := range keys {
+		indexes = append(indexes, maphash.String(h.seed, k)&h.mask)
+	}
+
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	shards := make([]*shard, len(indexes))
+	for i, index := range indexes {
+		shards[i] = h.shards[index]
+	}
+
+	return shards
+}
+
+// len returns number of stored keys, must be called under locks of all shards
+func (h *HashTable) len() int {
+	n := 0
+	for _, s := range h.shards {
+		n += len(s.data)
+	}
+
+	return n
+}
+
+// WithShards sets number of shards, it is rounded up to the power of two
+func WithShards(count int) Option {
+	return func(h *HashTable) {
+		if count < 1 {
+			count = 1
+		}
+
+		h.shards = make([]*shard, 1<<bits.Len(uint(count-1)))
+	}
+}
+
+func NewHashTable(opts ...Option) *HashTable {
+	h := &HashTable{
+		shards: make([]*shard, DefaultShardsCount),
+		seed:   maphash.MakeSeed(),
+		clock:  time.Now,
+		policy: NoEviction,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	for i := range h.shards {
+		h.shards[i] = &shard{
+			data:     make(map[string]*entry),
+			volatile: make(map[string]struct{}),
+			index:    newScanIndex(),
+		}
+	}
+	h.mask = uint64(len(h.shards) - 1)
+
+	return h
+}
@@ -1,42 +1,87 @@
 package inmemory
 
 import (
-	"github.com/stretchr/testify/assert"
+	"fmt"
+	"math/rand/v2"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
 )
 
 // helper to check key existence
 func existsInHashTable(ht *HashTable, key string) bool {
-	_, ok := ht.data[key]
+	s := ht.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.data[key]
 
 	return ok
 }
 
+// helper to read stored value bypassing expiration and access statistics
+func valueInHashTable(ht *HashTable, key string) string {
+	s := ht.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if e, ok := s.data[key]; ok {
+		return string(e.value)
+	}
+
+	return ""
+}
+
+// helper to count stored keys and keys with expiration
+func countInHashTable(ht *HashTable) (keys int, volatile int) {
+	for _, s := range ht.shards {
+		s.mu.RLock()
+		keys += len(s.data)
+		volatile += len(s.volatile)
+		s.mu.RUnlock()
+	}
+
+	return keys, volatile
+}
+
+// helper to create table with predefined values
+func newTestHashTable(data map[string]string, opts ...Option) *HashTable {
+	ht := NewHashTable(opts...)
+	for k, v := range data {
+		e := newEntry([]byte(v), 0, 0)
+		ht.shardFor(k).put(k, e)
+		ht.usedMemory.Add(entrySize(k, e))
+	}
+
+	return ht
+}
+
 func TestHashTable_Get(t *testing.T) {
 	t.Parallel()
 
 	tests := map[string]struct {
 		keyArg         string
-		expectedVal    string
+		expectedVal    []byte
 		expectedExists bool
 	}{
 		"get existing key": {
 			keyArg:         "key",
-			expectedVal:    "value",
+			expectedVal:    []byte("value"),
 			expectedExists: true,
 		},
 		"get non existing key": {
 			keyArg:         "nkey",
-			expectedVal:    "",
+			expectedVal:    nil,
 			expectedExists: false,
 		},
 	}
 
-	ht := &HashTable{
-		data: map[string]string{
-			"key": "value",
-		},
-	}
+	ht := newTestHashTable(map[string]string{
+		"key": "value",
+	})
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -67,19 +112,17 @@ func TestHashTable_Set(t *testing.T) {
 		},
 	}
 
-	ht := &HashTable{
-		data: map[string]string{
-			"key": "value",
-		},
-	}
+	ht := newTestHashTable(map[string]string{
+		"key": "value",
+	})
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			ht.Set(tc.keyArg, tc.value)
+			ht.Set(tc.keyArg, []byte(tc.value))
 			assert.True(t, existsInHashTable(ht, tc.keyArg))
-			assert.Equal(t, tc.value, ht.data[tc.keyArg])
+			assert.Equal(t, tc.value, valueInHashTable(ht, tc.keyArg))
 		})
 	}
 }
@@ -98,11 +141,9 @@ func TestHashTable_Del(t *testing.T) {
 		},
 	}
 
-	ht := &HashTable{
-		data: map[string]string{
-			"key": "value",
-		},
-	}
+	ht := newTestHashTable(map[string]string{
+		"key": "value",
+	})
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -110,7 +151,651 @@ func TestHashTable_Del(t *testing.T) {
 
 			ht.Del(tc.keyArg)
 			assert.False(t, existsInHashTable(ht, tc.keyArg))
-			assert.Empty(t, ht.data[tc.keyArg])
+			assert.Empty(t, valueInHashTable(ht, tc.keyArg))
+		})
+	}
+}
+
+func TestHashTable_Expiration(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ht := newTestHashTable(map[string]string{
+		"persistent": "value",
+	})
+	ht.clock = func() time.Time { return now }
+
+	ht.SetWithExpiry("volatile", []byte("value"), now.Add(time.Second).UnixNano())
+	assert.True(t, ht.Expire("persistent", now.Add(time.Minute).UnixNano()))
+	assert.False(t, ht.Expire("nkey", now.Add(time.Minute).UnixNano()))
+
+	ttl, hasExpiry, exists := ht.TTL("volatile")
+	assert.Equal(t, time.Second, ttl)
+	assert.True(t, hasExpiry)
+	assert.True(t, exists)
+
+	assert.True(t, ht.Persist("persistent"))
+	assert.False(t, ht.Persist("persistent"))
+
+	_, hasExpiry, exists = ht.TTL("persistent")
+	assert.False(t, hasExpiry)
+	assert.True(t, exists)
+
+	// lazy expiration on access
+	now = now.Add(2 * time.Second)
+	_, exists = ht.Get("volatile")
+	assert.False(t, exists)
+	assert.False(t, existsInHashTable(ht, "volatile"))
+
+	// set without expiration clears previous one
+	ht.SetWithExpiry("volatile", []byte("value"), now.Add(time.Second).UnixNano())
+	ht.Set("volatile", []byte("value"))
+	_, hasExpiry, _ = ht.TTL("volatile")
+	assert.False(t, hasExpiry)
+}
+
+func TestHashTable_DeleteExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ht := NewHashTable()
+	ht.clock = func() time.Time { return now }
+
+	for _, k := range []string{"a", "b", "c"} {
+		ht.SetWithExpiry(k, []byte("value"), now.Add(time.Second).UnixNano())
+	}
+	ht.SetWithExpiry("d", []byte("value"), now.Add(time.Hour).UnixNano())
+	ht.Set("e", []byte("value"))
+
+	now = now.Add(time.Minute)
+	sampled, expired := ht.DeleteExpired(10)
+
+	assert.Equal(t, 4, sampled)
+	assert.Equal(t, 3, expired)
+	keys, volatile := countInHashTable(ht)
+	assert.Equal(t, 2, keys)
+	assert.Equal(t, 1, volatile)
+}
+
+func TestHashTable_OnRemove(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ht := NewHashTable(WithMaxMemory(3*int(entrySize("key0", newEntry([]byte("value"), 0, 0))), AllKeysLRU))
+	ht.clock = func() time.Time { return now }
+
+	removed := make(map[string]storage.RemovalReason)
+	ht.OnRemove(func(k string, reason storage.RemovalReason) {
+		removed[k] = reason
+	})
+
+	for _, k := range []string{"lazy", "active", "deleted"} {
+		assert.NoError(t, ht.SetWithExpiry(k, []byte("v"), now.Add(time.Second).UnixNano()))
+	}
+
+	now = now.Add(time.Minute)
+	_, exists := ht.Get("lazy")
+	assert.False(t, exists)
+	// expired key is not counted as deleted, but it is reported as expired
+	assert.Equal(t, 0, ht.MDelete([]string{"deleted"}))
+	ht.DeleteExpired(10)
+
+	fillHashTable(t, ht, &now, 4)
+
+	assert.Equal(t, map[string]storage.RemovalReason{
+		"lazy":    storage.RemovedExpired,
+		"active":  storage.RemovedExpired,
+		"deleted": storage.RemovedExpired,
+		"key0":    storage.RemovedEvicted,
+	}, removed)
+}
+
+func TestHashTable_Shards(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		count    int
+		expected int
+	}{
+		"default":                    {count: DefaultShardsCount, expected: DefaultShardsCount},
+		"single shard":               {count: 1, expected: 1},
+		"rounds up to power of two":  {count: 10, expected: 16},
+		"non positive is one shard":  {count: 0, expected: 1},
+		"power of two is kept as is": {count: 64, expected: 64},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ht := NewHashTable(WithShards(tc.count))
+			assert.Len(t, ht.shards, tc.expected)
+
+			for i := 0; i < 100; i++ {
+				assert.NoError(t, ht.Set(fmt.Sprintf("key%d", i), []byte("value")))
+			}
+
+			keys, _ := countInHashTable(ht)
+			assert.Equal(t, 100, keys)
+			assert.Len(t, ht.Dump(), 100)
+		})
+	}
+}
+
+func TestHashTable_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHashTable(WithShards(4), WithMaxMemory(50*int(entrySize("key000", newEntry([]byte("value"), 0, 0))), AllKeysLRU))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 1000; i++ {
+				k := fmt.Sprintf("key%03d", rand.IntN(100))
+				switch i % 4 {
+				case 0:
+					assert.NoError(t, ht.Set(k, []byte("value")))
+				case 1:
+					ht.Del(k)
+				default:
+					ht.Get(k)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	keys, _ := countInHashTable(ht)
+	assert.LessOrEqual(t, keys, 50)
+	assert.Equal(t, int64(keys)*entrySize("key000", newEntry([]byte("value"), 0, 0)), ht.UsedMemory())
+}
+
+// mutexHashTable is the single mutex implementation sharded table is compared with,
+// it keeps entries the same way including expiration check and access statistics
+type mutexHashTable struct {
+	data  map[string]*entry
+	mu    sync.Mutex
+	table *HashTable
+}
+
+func newMutexHashTable() *mutexHashTable {
+	return &mutexHashTable{
+		data:  make(map[string]*entry),
+		table: NewHashTable(WithMaxMemory(0, AllKeysLRU)),
+	}
+}
+
+func (h *mutexHashTable) Get(k string) ([]byte, bool) {
+	now := time.Now().UnixNano()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.data[k]
+	if !ok || e.expired(now) {
+		return nil, false
+	}
+
+	h.table.touch(e, now)
+	return e.value, true
+}
+
+func (h *mutexHashTable) Set(k string, v []byte) error {
+	e := newEntry(v, 0, time.Now().UnixNano())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.data[k] = e
+	return nil
+}
+
+func (h *mutexHashTable) Del(k string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.data, k)
+}
+
+func benchmarkMixed(b *testing.B, ht Hasheable, readPercent int) {
+	const keysCount = 1 << 14
+
+	keys := make([]string, keysCount)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		_ = ht.Set(keys[i], []byte("value"))
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+
+		for pb.Next() {
+			k := keys[r.IntN(keysCount)]
+			if r.IntN(100) < readPercent {
+				ht.Get(k)
+			} else {
+				_ = ht.Set(k, []byte("value"))
+			}
+		}
+	})
+}
+
+func BenchmarkHashTable_Mixed(b *testing.B) {
+	for _, readPercent := range []int{50, 90, 99} {
+		b.Run(fmt.Sprintf("reads=%d%%/mutex", readPercent), func(b *testing.B) {
+			benchmarkMixed(b, newMutexHashTable(), readPercent)
 		})
+
+		for _, shards := range []int{1, 16, 64} {
+			b.Run(fmt.Sprintf("reads=%d%%/shards=%d", readPercent, shards), func(b *testing.B) {
+				benchmarkMixed(b, NewHashTable(WithShards(shards), WithMaxMemory(0, AllKeysLRU)), readPercent)
+			})
+		}
+	}
+}
+
+func TestHashTable_Batch(t *testing.T) {
+	t.Parallel()
+
+	ht := newTestHashTable(map[string]string{
+		"a": "old",
+	})
+	ht.SetWithExpiry("b", []byte("old"), time.Now().Add(time.Hour).UnixNano())
+
+	assert.NoError(t, ht.MSet([]storage.KeyValue{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "a", Value: []byte("3")},
+	}))
+
+	values, found := ht.MGet([]string{"a", "b", "c"})
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("2"), nil}, values)
+	assert.Equal(t, []bool{true, true, false}, found)
+
+	// MSET clears expiration like SET
+	_, hasExpiry, _ := ht.TTL("b")
+	assert.False(t, hasExpiry)
+
+	assert.Equal(t, 2, ht.MDelete([]string{"a", "b", "b", "c"}))
+	keys, volatile := countInHashTable(ht)
+	assert.Zero(t, keys)
+	assert.Zero(t, volatile)
+	assert.Zero(t, ht.UsedMemory())
+}
+
+func TestHashTable_BatchOutOfMemory(t *testing.T) {
+	t.Parallel()
+
+	size := int(entrySize("key0", newEntry([]byte("value"), 0, 0)))
+	ht := NewHashTable(WithMaxMemory(3*size, NoEviction))
+
+	assert.NoError(t, ht.Set("key0", []byte("value")))
+	assert.ErrorIs(t, ht.MSet([]storage.KeyValue{
+		{Key: "key1", Value: []byte("value")},
+		{Key: "key2", Value: []byte("value")},
+		{Key: "key3", Value: []byte("value")},
+	}), storage.ErrOutOfMemory)
+
+	// nothing of failed batch is stored
+	keys, _ := countInHashTable(ht)
+	assert.Equal(t, 1, keys)
+	assert.Equal(t, int64(size), ht.UsedMemory())
+}
+
+func TestHashTable_BatchAtomicity(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHashTable(WithShards(4))
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 1000; i++ {
+			pairs := make([]storage.KeyValue, len(keys))
+			for j, k := range keys {
+				pairs[j] = storage.KeyValue{Key: k, Value: []byte(fmt.Sprint(i))}
+			}
+			assert.NoError(t, ht.MSet(pairs))
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		values, _ := ht.MGet(keys)
+		for _, v := range values {
+			assert.Equal(t, values[0], v)
+		}
+	}
+}
+
+func TestHashTable_Version(t *testing.T) {
+	t.Parallel()
+
+	ht := newTestHashTable(map[string]string{
+		"a": "1",
+	})
+
+	version := ht.Version("a")
+	assert.Equal(t, version, ht.Version("a"))
+
+	ht.Set("a", []byte("2"))
+	assert.NotEqual(t, version, ht.Version("a"))
+
+	// missing key gets new version when it is created
+	missing := ht.Version("b")
+	ht.Set("b", []byte("1"))
+	assert.NotEqual(t, missing, ht.Version("b"))
+
+	version = ht.Version("b")
+	ht.Del("b")
+	assert.NotEqual(t, version, ht.Version("b"))
+
+	version = ht.Version("a")
+	ht.Expire("a", time.Now().Add(time.Hour).UnixNano())
+	assert.NotEqual(t, version, ht.Version("a"))
+}
+
+func TestHashTable_Update(t *testing.T) {
+	t.Parallel()
+
+	ht := newTestHashTable(nil)
+	expireAt := time.Now().Add(time.Hour).UnixNano()
+	assert.NoError(t, ht.SetWithExpiry("a", []byte("1"), expireAt))
+
+	updated, err := ht.Update("a", func(current storage.Entry, exists bool) (storage.Entry, storage.UpdateAction, error) {
+		assert.True(t, exists)
+		return storage.Entry{Value: append([]byte("x"), current.Value...), ExpireAt: current.ExpireAt}, storage.UpdateStore, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("x1"), updated.Value)
+	assert.Equal(t, expireAt, updated.ExpireAt)
+	assert.Equal(t, "x1", valueInHashTable(ht, "a"))
+
+	_, hasExpiry, _ := ht.TTL("a")
+	assert.True(t, hasExpiry)
+
+	// failed update leaves value as is
+	_, err = ht.Update("a", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateKeep, storage.ErrNotInteger
+	})
+	assert.ErrorIs(t, err, storage.ErrNotInteger)
+	assert.Equal(t, "x1", valueInHashTable(ht, "a"))
+
+	// keep returns current entry untouched
+	updated, err = ht.Update("a", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: []byte("ignored")}, storage.UpdateKeep, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("x1"), updated.Value)
+	assert.Equal(t, "x1", valueInHashTable(ht, "a"))
+
+	// storing entry without expiration makes key persistent
+	_, err = ht.Update("a", func(current storage.Entry, _ bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: current.Value}, storage.UpdateStore, nil
+	})
+	assert.NoError(t, err)
+	_, hasExpiry, _ = ht.TTL("a")
+	assert.False(t, hasExpiry)
+
+	updated, err = ht.Update("b", func(_ storage.Entry, exists bool) (storage.Entry, storage.UpdateAction, error) {
+		assert.False(t, exists)
+		return storage.Entry{Value: []byte("new")}, storage.UpdateStore, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), updated.Value)
+	assert.Equal(t, int64(entrySize("a", newEntry([]byte("x1"), 0, 0))+entrySize("b", newEntry([]byte("new"), 0, 0))), ht.UsedMemory())
+
+	_, err = ht.Update("b", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateDelete, nil
+	})
+	assert.NoError(t, err)
+	_, exists := ht.Get("b")
+	assert.False(t, exists)
+	assert.Equal(t, int64(entrySize("a", newEntry([]byte("x1"), 0, 0))), ht.UsedMemory())
+}
+
+func TestHashTable_UpdateConcurrent(t *testing.T) {
+	t.Parallel()
+
+	ht := newTestHashTable(nil)
+	increment := func(current storage.Entry, _ bool) (storage.Entry, storage.UpdateAction, error) {
+		var n int
+		fmt.Sscan(string(current.Value), &n)
+		return storage.Entry{Value: []byte(fmt.Sprint(n + 1))}, storage.UpdateStore, nil
 	}
+
+	const workers, increments = 8, 100
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				_, err := ht.Update("counter", increment)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, fmt.Sprint(workers*increments), valueInHashTable(ht, "counter"))
+}
+
+func TestHashTable_Scan(t *testing.T) {
+	t.Parallel()
+
+	data := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		data[fmt.Sprintf("key%d", i)] = "value"
+	}
+	ht := newTestHashTable(data, WithShards(4))
+	assert.NoError(t, ht.SetWithExpiry("expired", []byte("value"), time.Now().Add(-time.Second).UnixNano()))
+
+	seen := make(map[string]int)
+	cursor, steps := uint64(0), 0
+	for {
+		var keys []string
+		cursor, keys = ht.Scan(cursor, 7)
+		for _, key := range keys {
+			seen[key]++
+		}
+
+		steps++
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.Len(t, seen, len(data))
+	for key := range data {
+		assert.Equal(t, 1, seen[key], key)
+	}
+	assert.Greater(t, steps, 4)
+
+	next, keys := ht.Scan(100<<32, 10)
+	assert.Zero(t, next)
+	assert.Empty(t, keys)
+}
+
+func TestHashTable_ScanConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	stable := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		stable[fmt.Sprintf("stable%d", i)] = "value"
+	}
+	ht := newTestHashTable(stable)
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			key := fmt.Sprintf("volatile%d", i%50)
+			if i%2 == 0 {
+				assert.NoError(t, ht.Set(key, []byte("value")))
+			} else {
+				ht.Del(key)
+			}
+		}
+	}()
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		var keys []string
+		cursor, keys = ht.Scan(cursor, 5)
+		for _, key := range keys {
+			seen[key] = true
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// keys present for the whole scan are never missed
+	for key := range stable {
+		assert.True(t, seen[key], key)
+	}
+}
+
+func TestHashTable_DumpConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	data := make(map[string]string)
+	for i := 0; i < 50000; i++ {
+		data[fmt.Sprintf("key%d", i)] = "value"
+	}
+	ht := newTestHashTable(data)
+	push := func(c storage.Collection) (bool, error) {
+		c.(*storage.List).PushBack([]byte("item"))
+		return true, nil
+	}
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, func() storage.Collection { return storage.NewList() }, push))
+
+	copyCut := ht.Cut()
+
+	// writers are not blocked by cut, their changes don't get into the copy
+	assert.NoError(t, ht.Set("key0", []byte("changed")))
+	ht.Del("key1")
+	assert.NoError(t, ht.Set("new", []byte("value")))
+	assert.True(t, ht.Expire("key2", time.Now().Add(-time.Second).UnixNano()))
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, nil, push))
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			// keys changed before are left as they are
+			key := fmt.Sprintf("key%d", 3+rand.IntN(len(data)-3))
+			if i%2 == 0 {
+				assert.NoError(t, ht.Set(key, []byte("changed")))
+			} else {
+				ht.Del(key)
+			}
+			assert.NoError(t, ht.Set(fmt.Sprintf("new%d", i), []byte("value")))
+		}
+	}()
+
+	dump := copyCut()
+	close(done)
+	wg.Wait()
+
+	assert.Len(t, dump, len(data)+1)
+	for k, v := range data {
+		assert.Equal(t, []byte(v), dump[k].Value, k)
+	}
+	assert.Equal(t, 1, dump["list"].Collection.Len())
+
+	// the next dump sees all changes
+	assert.NotContains(t, ht.Dump(), "key2")
+	assert.Equal(t, "changed", valueInHashTable(ht, "key0"))
+}
+
+func TestHashTable_Collection(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHashTable()
+	push := func(c storage.Collection) (bool, error) {
+		c.(*storage.List).PushBack([]byte("item"))
+		return true, nil
+	}
+
+	// missing key is not created without create func
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, nil, push))
+	assert.False(t, existsInHashTable(ht, "list"))
+
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, func() storage.Collection { return storage.NewList() }, push))
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, nil, push))
+
+	valueType, exists := ht.Type("list")
+	assert.True(t, exists)
+	assert.Equal(t, storage.ListType, valueType)
+
+	list := storage.NewList()
+	list.PushBack([]byte("item"))
+	list.PushBack([]byte("item"))
+	assert.Equal(t, entrySize("list", &entry{collection: list}), ht.UsedMemory())
+
+	// string commands don't see collections and collection commands don't see strings
+	_, exists = ht.Get("list")
+	assert.False(t, exists)
+
+	ht.Set("string", []byte("value"))
+	_, err := ht.ViewCollection("string", storage.ListType, func(storage.Collection) {})
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+	assert.ErrorIs(t, ht.UpdateCollection("string", storage.ListType, nil, push), storage.ErrWrongType)
+
+	// dumped collection doesn't change with the stored one
+	dump := ht.Dump()
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, nil, push))
+	assert.Equal(t, 2, dump["list"].Collection.Len())
+
+	// collection left empty is deleted
+	version := ht.Version("list")
+	assert.NoError(t, ht.UpdateCollection("list", storage.ListType, nil, func(c storage.Collection) (bool, error) {
+		for c.Len() > 0 {
+			c.(*storage.List).PopFront()
+		}
+		return true, nil
+	}))
+	assert.False(t, existsInHashTable(ht, "list"))
+	assert.NotEqual(t, version, ht.Version("list"))
+	assert.Equal(t, entrySize("string", newEntry([]byte("value"), 0, 0)), ht.UsedMemory())
+
+	ht.Load(dump)
+	found, err := ht.ViewCollection("list", storage.ListType, func(c storage.Collection) {
+		assert.Equal(t, 2, c.Len())
+	})
+	assert.NoError(t, err)
+	assert.True(t, found)
 }
@@ -0,0 +1,107 @@
+package inmemory
+
+import (
+	"math/rand/v2"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+const (
+	scanIndexMaxLevel = 32
+	// every next level holds about a quarter of nodes of the previous one
+	scanIndexLevelProbability = 4
+)
+
+type scanNode struct {
+	hash uint32
+	key  string
+	next []*scanNode
+}
+
+// before orders nodes by hash and then by key, so node of any key can be found among keys with equal hashes
+func (n *scanNode) before(hash uint32, key string) bool {
+	return n.hash < hash || n.hash == hash && n.key < key
+}
+
+// scanIndex is a skip list of shard keys ordered by scan hash, so scan continues from any hash
+// without reading keys before it. It is guarded by the lock of shard
+type scanIndex struct {
+	head  *scanNode
+	level int
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{
+		head:  &scanNode{next: make([]*scanNode, scanIndexMaxLevel)},
+		level: 1,
+	}
+}
+
+// insert adds key which is not in index yet
+func (x *scanIndex) insert(key string) {
+	hash := storage.ScanHash(key)
+	update := x.path(hash, key)
+
+	level := 1
+	for level < scanIndexMaxLevel && rand.IntN(scanIndexLevelProbability) == 0 {
+		level++
+	}
+
+	if level > x.level {
+		for i := x.level; i < level; i++ {
+			update[i] = x.head
+		}
+		x.level = level
+	}
+
+	n := &scanNode{hash: hash, key: key, next: make([]*scanNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+}
+
+func (x *scanIndex) remove(key string) {
+	hash := storage.ScanHash(key)
+	update := x.path(hash, key)
+
+	n := update[0].next[0]
+	if n == nil || n.key != key {
+		return
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		update[i].next[i] = n.next[i]
+	}
+
+	for x.level > 1 && x.head.next[x.level-1] == nil {
+		x.level--
+	}
+}
+
+// seek returns the first node with hash not less than from, nodes after it follow on the lowest level
+func (x *scanIndex) seek(from uint32) *scanNode {
+	n := x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].hash < from {
+			n = n.next[i]
+		}
+	}
+
+	return n.next[0]
+}
+
+// path returns the last node before hash and key on every level
+func (x *scanIndex) path(hash uint32, key string) []*scanNode {
+	update := make([]*scanNode, scanIndexMaxLevel)
+
+	n := x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].before(hash, key) {
+			n = n.next[i]
+		}
+		update[i] = n
+	}
+
+	return update
+}
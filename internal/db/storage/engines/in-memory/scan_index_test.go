@@ -0,0 +1,73 @@
+package inmemory
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func indexKeys(x *scanIndex, from uint32) []string {
+	keys := make([]string, 0)
+	for n := x.seek(from); n != nil; n = n.next[0] {
+		keys = append(keys, n.key)
+	}
+
+	return keys
+}
+
+func TestScanIndex(t *testing.T) {
+	t.Parallel()
+
+	x := newScanIndex()
+	for i := 0; i < 1000; i++ {
+		x.insert(fmt.Sprintf("key%d", i))
+	}
+	for i := 0; i < 1000; i += 2 {
+		x.remove(fmt.Sprintf("key%d", i))
+	}
+	x.remove("missing")
+
+	keys := indexKeys(x, 0)
+	assert.Len(t, keys, 500)
+	for i := 1; i < len(keys); i++ {
+		assert.LessOrEqual(t, storage.ScanHash(keys[i-1]), storage.ScanHash(keys[i]))
+	}
+
+	middle := storage.ScanHash(keys[250])
+	for _, key := range indexKeys(x, middle) {
+		assert.GreaterOrEqual(t, storage.ScanHash(key), middle)
+	}
+	assert.Len(t, indexKeys(x, middle), 250)
+}
+
+func TestHashTable_ScanStepSize(t *testing.T) {
+	t.Parallel()
+
+	ht := NewHashTable(WithShards(1))
+	for i := 0; i < 10000; i++ {
+		assert.NoError(t, ht.Set(fmt.Sprintf("key%d", i), []byte("value")))
+	}
+	for i := 0; i < 10000; i += 2 {
+		ht.Del(fmt.Sprintf("key%d", i))
+	}
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		var keys []string
+		cursor, keys = ht.Scan(cursor, 10)
+		// keys with equal hashes may only exceed count
+		assert.LessOrEqual(t, len(keys), 11)
+		for _, key := range keys {
+			seen[key] = true
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.Len(t, seen, 5000)
+}
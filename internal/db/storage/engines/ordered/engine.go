@@ -0,0 +1,103 @@
+package ordered
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidLogger = errors.New("invalid logger")
+)
+
+// OrderedEngine keeps keys sorted in a skip list, so besides everything in-memory engine does
+// it answers range and prefix queries. Memory limit and eviction are not supported
+type OrderedEngine struct {
+	dataStorage *SkipList
+	logger      *zap.Logger
+}
+
+func (e *OrderedEngine) Get(key string) ([]byte, bool) {
+	return e.dataStorage.Get(key)
+}
+
+func (e *OrderedEngine) Set(key string, value []byte) error {
+	e.dataStorage.Set(key, value)
+	return nil
+}
+
+func (e *OrderedEngine) Delete(key string) error {
+	e.dataStorage.Del(key)
+	return nil
+}
+
+func (e *OrderedEngine) MGet(keys []string) ([][]byte, []bool) {
+	return e.dataStorage.MGet(keys)
+}
+
+func (e *OrderedEngine) MSet(pairs []storage.KeyValue) error {
+	e.dataStorage.MSet(pairs)
+	return nil
+}
+
+func (e *OrderedEngine) MDelete(keys []string) (int, error) {
+	return e.dataStorage.MDelete(keys), nil
+}
+
+func (e *OrderedEngine) Update(key string, fn storage.UpdateFunc) (storage.Entry, error) {
+	return e.dataStorage.Update(key, fn)
+}
+
+func (e *OrderedEngine) Version(key string) uint64 {
+	return e.dataStorage.Version(key)
+}
+
+func (e *OrderedEngine) Scan(cursor uint64, count int) (uint64, []string) {
+	return e.dataStorage.Scan(cursor, count)
+}
+
+func (e *OrderedEngine) Ascend(from string, fn func(key string, value []byte) bool) {
+	e.dataStorage.Ascend(from, fn)
+}
+
+func (e *OrderedEngine) SetWithExpiry(key string, value []byte, expireAt time.Time) error {
+	e.dataStorage.SetWithExpiry(key, value, expireAt.UnixNano())
+	return nil
+}
+
+func (e *OrderedEngine) Expire(key string, expireAt time.Time) bool {
+	return e.dataStorage.Expire(key, expireAt.UnixNano())
+}
+
+func (e *OrderedEngine) Persist(key string) bool {
+	return e.dataStorage.Persist(key)
+}
+
+func (e *OrderedEngine) TTL(key string) (time.Duration, bool, bool) {
+	return e.dataStorage.TTL(key)
+}
+
+func (e *OrderedEngine) DeleteExpired(limit int) (int, int) {
+	return e.dataStorage.DeleteExpired(limit)
+}
+
+func (e *OrderedEngine) Dump() map[string]storage.Entry {
+	return e.dataStorage.Dump()
+}
+
+func (e *OrderedEngine) Load(data map[string]storage.Entry) {
+	e.dataStorage.Load(data)
+}
+
+func NewOrderedEngine(logger *zap.Logger) (*OrderedEngine, error) {
+	if logger == nil {
+		return nil, ErrInvalidLogger
+	}
+
+	return &OrderedEngine{
+		dataStorage: NewSkipList(),
+		logger:      logger,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package ordered
+
+import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/db/storage/enginetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewOrderedEngine(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		logger      *zap.Logger
+		expectedErr error
+	}{
+		"create engine": {
+			logger: zap.NewNop(),
+		},
+		"nil logger": {
+			expectedErr: ErrInvalidLogger,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			engine, err := NewOrderedEngine(tc.logger)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if tc.expectedErr != nil {
+				assert.Nil(t, engine)
+			} else {
+				assert.NotNil(t, engine)
+			}
+		})
+	}
+}
+
+func TestOrderedEngine_Conformance(t *testing.T) {
+	t.Parallel()
+
+	enginetest.Run(t, func(t *testing.T) storage.Engine {
+		engine, err := NewOrderedEngine(zap.NewNop())
+		require.NoError(t, err)
+
+		return engine
+	})
+}
@@ -0,0 +1,465 @@
+package ordered
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+const (
+	maxLevel = 32
+	// every next level holds about a quarter of nodes of the previous one
+	levelProbability = 4
+)
+
+type node struct {
+	key   string
+	value []byte
+	// expireAt is unix time in nanoseconds, zero means key never expires
+	expireAt int64
+	// version changes on every modification of node, it is used by WATCH
+	version uint64
+	next    []*node
+}
+
+func (n *node) expired(now int64) bool {
+	return n.expireAt != 0 && n.expireAt <= now
+}
+
+// SkipList keeps keys sorted, so keys can be read in order starting from any key.
+// The whole list is guarded by a single lock
+type SkipList struct {
+	mu     sync.RWMutex
+	head   *node
+	level  int
+	length int
+	// volatile holds keys with expiration, so sweeper samples only them
+	volatile map[string]struct{}
+	// deleted is a version of the last deletion, it is reported for missing keys
+	deleted  uint64
+	versions uint64
+	clock    func() time.Time
+}
+
+func NewSkipList() *SkipList {
+	return &SkipList{
+		head:     &node{next: make([]*node, maxLevel)},
+		level:    1,
+		volatile: make(map[string]struct{}),
+		clock:    time.Now,
+	}
+}
+
+// Get returns stored value, it is shared with the list and must not be modified
+func (l *SkipList) Get(k string) ([]byte, bool) {
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	n := l.find(k)
+	if n == nil {
+		l.mu.RUnlock()
+		return nil, false
+	}
+
+	if n.expired(now) {
+		l.mu.RUnlock()
+		l.deleteIfExpired(k, now)
+		return nil, false
+	}
+
+	value := n.value
+	l.mu.RUnlock()
+
+	return value, true
+}
+
+func (l *SkipList) Set(k string, v []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.store(k, v, 0)
+}
+
+func (l *SkipList) SetWithExpiry(k string, v []byte, expireAt int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.store(k, v, expireAt)
+}
+
+func (l *SkipList) Del(k string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.delete(k)
+}
+
+func (l *SkipList) MGet(keys []string) ([][]byte, []bool) {
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	values, found := make([][]byte, len(keys)), make([]bool, len(keys))
+	for i, k := range keys {
+		if n := l.find(k); n != nil && !n.expired(now) {
+			values[i], found[i] = n.value, true
+		}
+	}
+
+	return values, found
+}
+
+func (l *SkipList) MSet(pairs []storage.KeyValue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, pair := range pairs {
+		l.store(pair.Key, pair.Value, 0)
+	}
+}
+
+// MDelete removes keys and returns number of distinct keys which existed
+func (l *SkipList) MDelete(keys []string) int {
+	now := l.clock().UnixNano()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deleted := 0
+	for _, k := range keys {
+		if n := l.find(k); n != nil {
+			if !n.expired(now) {
+				deleted++
+			}
+			l.delete(k)
+		}
+	}
+
+	return deleted
+}
+
+// Update runs fn and applies its decision under the list lock
+func (l *SkipList) Update(k string, fn storage.UpdateFunc) (storage.Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var current storage.Entry
+	n, exists := l.lookup(k)
+	if exists {
+		current = storage.Entry{Value: n.value, ExpireAt: n.expireAt}
+	}
+
+	updated, action, err := fn(current, exists)
+	if err != nil {
+		return storage.Entry{}, err
+	}
+
+	switch action {
+	case storage.UpdateKeep:
+		return current, nil
+	case storage.UpdateDelete:
+		l.delete(k)
+		return storage.Entry{}, nil
+	}
+
+	l.store(k, updated.Value, updated.ExpireAt)
+	return updated, nil
+}
+
+// Expire sets expiration time of existing key
+func (l *SkipList) Expire(k string, expireAt int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, exists := l.lookup(k)
+	if !exists {
+		return false
+	}
+
+	n.expireAt = expireAt
+	n.version = l.nextVersion()
+	l.volatile[k] = struct{}{}
+
+	return true
+}
+
+// Persist removes expiration of key, false is returned if key has no expiration
+func (l *SkipList) Persist(k string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, exists := l.lookup(k)
+	if !exists || n.expireAt == 0 {
+		return false
+	}
+
+	n.expireAt = 0
+	n.version = l.nextVersion()
+	delete(l.volatile, k)
+
+	return true
+}
+
+// TTL returns remaining time to live of key and whether key has expiration at all
+func (l *SkipList) TTL(k string) (ttl time.Duration, hasExpiry bool, exists bool) {
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	n := l.find(k)
+	if n == nil || n.expired(now) {
+		return 0, false, false
+	}
+
+	if n.expireAt == 0 {
+		return 0, false, true
+	}
+
+	return time.Duration(n.expireAt - now), true, true
+}
+
+// DeleteExpired checks up to limit keys with expiration and removes expired ones
+func (l *SkipList) DeleteExpired(limit int) (sampled int, expired int) {
+	now := l.clock().UnixNano()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// map iteration starts from random position, so it works as random sampling
+	for k := range l.volatile {
+		if sampled == limit {
+			break
+		}
+		sampled++
+
+		if n := l.find(k); n != nil && n.expired(now) {
+			l.delete(k)
+			expired++
+		}
+	}
+
+	return sampled, expired
+}
+
+// Version returns current version of key, missing keys share version of the last deletion
+func (l *SkipList) Version(k string) uint64 {
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if n := l.find(k); n != nil && !n.expired(now) {
+		return n.version
+	}
+
+	return l.deleted
+}
+
+// Ascend calls fn for keys not less than from in ascending order until fn returns false.
+// fn runs under read lock of the list
+func (l *SkipList) Ascend(from string, fn func(key string, value []byte) bool) {
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for n := l.seek(from, nil); n != nil; n = n.next[0] {
+		if n.expired(now) {
+			continue
+		}
+
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
+
+// Scan returns keys ordered by their hashes like hash based engines do, position in the list
+// can't be a cursor because it shifts when keys before it are deleted
+func (l *SkipList) Scan(cursor uint64, count int) (uint64, []string) {
+	if cursor > math.MaxUint32 {
+		return 0, nil
+	}
+
+	batch := storage.NewScanBatch(uint32(cursor), count)
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	for n := l.head.next[0]; n != nil; n = n.next[0] {
+		if !n.expired(now) {
+			batch.Add(n.key)
+		}
+	}
+	l.mu.RUnlock()
+
+	keys, next, done := batch.Take()
+	if done {
+		return 0, keys
+	}
+
+	return uint64(next), keys
+}
+
+// Dump returns a point-in-time copy of the list
+func (l *SkipList) Dump() map[string]storage.Entry {
+	now := l.clock().UnixNano()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	data := make(map[string]storage.Entry, l.length)
+	for n := l.head.next[0]; n != nil; n = n.next[0] {
+		if !n.expired(now) {
+			data[n.key] = storage.Entry{Value: n.value, ExpireAt: n.expireAt}
+		}
+	}
+
+	return data
+}
+
+// Load replaces list contents with data
+func (l *SkipList) Load(data map[string]storage.Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.head = &node{next: make([]*node, maxLevel)}
+	l.level, l.length = 1, 0
+	l.volatile = make(map[string]struct{})
+
+	for k, e := range data {
+		l.store(k, e.Value, e.ExpireAt)
+	}
+}
+
+// Len returns number of stored keys including expired ones which are not deleted yet
+func (l *SkipList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.length
+}
+
+// seek returns the first node with key not less than k, update is filled with the last nodes
+// before it on every level if it is not nil
+func (l *SkipList) seek(k string, update []*node) *node {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < k {
+			x = x.next[i]
+		}
+
+		if update != nil {
+			update[i] = x
+		}
+	}
+
+	return x.next[0]
+}
+
+// find returns node of key, it may be expired
+func (l *SkipList) find(k string) *node {
+	if n := l.seek(k, nil); n != nil && n.key == k {
+		return n
+	}
+
+	return nil
+}
+
+// lookup returns node of live key, expired key is deleted, must be called under write lock
+func (l *SkipList) lookup(k string) (*node, bool) {
+	n := l.find(k)
+	if n == nil {
+		return nil, false
+	}
+
+	if n.expired(l.clock().UnixNano()) {
+		l.delete(k)
+		return nil, false
+	}
+
+	return n, true
+}
+
+// store inserts or replaces key, must be called under write lock
+func (l *SkipList) store(k string, v []byte, expireAt int64) {
+	update := make([]*node, maxLevel)
+	n := l.seek(k, update)
+
+	if n == nil || n.key != k {
+		level := randomLevel()
+		if level > l.level {
+			for i := l.level; i < level; i++ {
+				update[i] = l.head
+			}
+			l.level = level
+		}
+
+		n = &node{key: k, next: make([]*node, level)}
+		for i := 0; i < level; i++ {
+			n.next[i] = update[i].next[i]
+			update[i].next[i] = n
+		}
+		l.length++
+	}
+
+	n.value, n.expireAt = v, expireAt
+	n.version = l.nextVersion()
+
+	if expireAt != 0 {
+		l.volatile[k] = struct{}{}
+	} else {
+		delete(l.volatile, k)
+	}
+}
+
+// delete unlinks key and reports whether it existed, must be called under write lock
+func (l *SkipList) delete(k string) bool {
+	update := make([]*node, maxLevel)
+	n := l.seek(k, update)
+	if n == nil || n.key != k {
+		return false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		update[i].next[i] = n.next[i]
+	}
+
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+
+	l.length--
+	l.deleted = l.nextVersion()
+	delete(l.volatile, k)
+
+	return true
+}
+
+func (l *SkipList) deleteIfExpired(k string, now int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// key could be overwritten since it was checked under read lock
+	if n := l.find(k); n != nil && n.expired(now) {
+		l.delete(k)
+	}
+}
+
+func (l *SkipList) nextVersion() uint64 {
+	l.versions++
+	return l.versions
+}
+
+func randomLevel() int {
+	level := 1
+	for level < maxLevel && rand.IntN(levelProbability) == 0 {
+		level++
+	}
+
+	return level
+}
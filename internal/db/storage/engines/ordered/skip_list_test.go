@@ -0,0 +1,111 @@
+package ordered
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func keysInOrder(l *SkipList) []string {
+	keys := make([]string, 0)
+	l.Ascend("", func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return keys
+}
+
+func TestSkipList_RandomOperations(t *testing.T) {
+	t.Parallel()
+
+	l := NewSkipList()
+	model := make(map[string]string)
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key%03d", rand.IntN(300))
+
+		if rand.IntN(3) == 0 {
+			l.Del(key)
+			delete(model, key)
+		} else {
+			value := fmt.Sprint(i)
+			l.Set(key, []byte(value))
+			model[key] = value
+		}
+	}
+
+	expected := make([]string, 0, len(model))
+	for key := range model {
+		expected = append(expected, key)
+	}
+	slices.Sort(expected)
+
+	assert.Equal(t, expected, keysInOrder(l))
+	assert.Equal(t, len(model), l.Len())
+
+	for key, value := range model {
+		stored, exists := l.Get(key)
+		assert.True(t, exists)
+		assert.Equal(t, value, string(stored))
+	}
+}
+
+func TestSkipList_AscendSkipsExpired(t *testing.T) {
+	t.Parallel()
+
+	l := NewSkipList()
+	l.Set("a", []byte("1"))
+	l.SetWithExpiry("b", []byte("2"), time.Now().Add(-time.Second).UnixNano())
+	l.SetWithExpiry("c", []byte("3"), time.Now().Add(time.Hour).UnixNano())
+
+	assert.Equal(t, []string{"a", "c"}, keysInOrder(l))
+}
+
+func TestSkipList_Load(t *testing.T) {
+	t.Parallel()
+
+	l := NewSkipList()
+	l.Set("stale", []byte("1"))
+	l.SetWithExpiry("c", []byte("3"), time.Now().Add(time.Hour).UnixNano())
+
+	data := l.Dump()
+	delete(data, "stale")
+	data["a"] = data["c"]
+	l.Load(data)
+
+	assert.Equal(t, []string{"a", "c"}, keysInOrder(l))
+	_, hasExpiry, exists := l.TTL("a")
+	assert.True(t, exists)
+	assert.True(t, hasExpiry)
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	l := NewSkipList()
+
+	const workers, keys = 8, 200
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < keys; j++ {
+				key := fmt.Sprintf("key%d:%d", i, j)
+				l.Set(key, []byte("value"))
+				l.Get(key)
+				keysInOrder(l)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, workers*keys, l.Len())
+	assert.True(t, slices.IsSorted(keysInOrder(l)))
+}
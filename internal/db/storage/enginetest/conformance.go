@@ -0,0 +1,268 @@
+// Package enginetest checks that storage engines behave the same way, every engine runs
+// the suite from its own tests. Optional interfaces are checked only if engine implements them
+package enginetest
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs conformance tests, newEngine must return a new empty engine on every call
+func Run(t *testing.T, newEngine func(t *testing.T) storage.Engine) {
+	tests := map[string]func(t *testing.T, engine storage.Engine){
+		"set get delete": testSetGetDelete,
+		"batch":          testBatch,
+		"update":         testUpdate,
+		"expiration":     testExpiration,
+		"versions":       testVersions,
+		"scan":           testScan,
+		"snapshot":       testSnapshot,
+		"ascend":         testAscend,
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			test(t, newEngine(t))
+		})
+	}
+}
+
+func testSetGetDelete(t *testing.T, engine storage.Engine) {
+	_, exists := engine.Get("foo")
+	assert.False(t, exists)
+
+	require.NoError(t, engine.Set("foo", []byte("bar")))
+	require.NoError(t, engine.Set("foo", []byte("baz")))
+	require.NoError(t, engine.Set("", []byte("empty key")))
+
+	value, exists := engine.Get("foo")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("baz"), value)
+
+	value, exists = engine.Get("")
+	assert.True(t, exists)
+	assert.Equal(t, []byte("empty key"), value)
+
+	require.NoError(t, engine.Delete("foo"))
+	require.NoError(t, engine.Delete("non existing"))
+
+	_, exists = engine.Get("foo")
+	assert.False(t, exists)
+}
+
+func testBatch(t *testing.T, engine storage.Engine) {
+	require.NoError(t, engine.MSet([]storage.KeyValue{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "a", Value: []byte("3")},
+	}))
+
+	values, found := engine.MGet([]string{"a", "b", "c"})
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("2"), nil}, values)
+	assert.Equal(t, []bool{true, true, false}, found)
+
+	deleted, err := engine.MDelete([]string{"b", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	values, found = engine.MGet([]string{"a", "b"})
+	assert.Equal(t, [][]byte{[]byte("3"), nil}, values)
+	assert.Equal(t, []bool{true, false}, found)
+}
+
+func testUpdate(t *testing.T, engine storage.Engine) {
+	appendX := func(current storage.Entry, _ bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: append(append([]byte{}, current.Value...), 'x')}, storage.UpdateStore, nil
+	}
+
+	updated, err := engine.Update("foo", appendX)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), updated.Value)
+
+	updated, err = engine.Update("foo", func(current storage.Entry, exists bool) (storage.Entry, storage.UpdateAction, error) {
+		assert.True(t, exists)
+		return appendX(current, exists)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("xx"), updated.Value)
+
+	// failed update changes nothing
+	_, err = engine.Update("foo", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateStore, storage.ErrNotInteger
+	})
+	assert.ErrorIs(t, err, storage.ErrNotInteger)
+
+	updated, err = engine.Update("foo", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: []byte("ignored")}, storage.UpdateKeep, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("xx"), updated.Value)
+
+	value, _ := engine.Get("foo")
+	assert.Equal(t, []byte("xx"), value)
+
+	_, err = engine.Update("foo", func(storage.Entry, bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{}, storage.UpdateDelete, nil
+	})
+	require.NoError(t, err)
+
+	_, exists := engine.Get("foo")
+	assert.False(t, exists)
+}
+
+func testExpiration(t *testing.T, engine storage.Engine) {
+	expiring, ok := engine.(storage.ExpiringEngine)
+	if !ok {
+		t.Skip("engine does not support expiration")
+	}
+
+	require.NoError(t, expiring.SetWithExpiry("past", []byte("1"), time.Now().Add(-time.Second)))
+	require.NoError(t, expiring.SetWithExpiry("future", []byte("2"), time.Now().Add(time.Hour)))
+	require.NoError(t, engine.Set("forever", []byte("3")))
+
+	_, exists := engine.Get("past")
+	assert.False(t, exists)
+
+	_, found := engine.MGet([]string{"past", "future"})
+	assert.Equal(t, []bool{false, true}, found)
+
+	ttl, hasExpiry, exists := expiring.TTL("future")
+	assert.True(t, exists)
+	assert.True(t, hasExpiry)
+	assert.InDelta(t, time.Hour, ttl, float64(time.Minute))
+
+	_, hasExpiry, exists = expiring.TTL("forever")
+	assert.True(t, exists)
+	assert.False(t, hasExpiry)
+
+	_, _, exists = expiring.TTL("past")
+	assert.False(t, exists)
+
+	assert.True(t, expiring.Persist("future"))
+	assert.False(t, expiring.Persist("future"))
+	assert.False(t, expiring.Expire("missing", time.Now().Add(time.Hour)))
+
+	// update keeps expiration only if it is returned back
+	updated, err := engine.Update("future", func(current storage.Entry, _ bool) (storage.Entry, storage.UpdateAction, error) {
+		return storage.Entry{Value: current.Value, ExpireAt: time.Now().Add(time.Hour).UnixNano()}, storage.UpdateStore, nil
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, updated.ExpireAt)
+
+	_, hasExpiry, _ = expiring.TTL("future")
+	assert.True(t, hasExpiry)
+
+	assert.True(t, expiring.Expire("forever", time.Now().Add(-time.Second)))
+	_, expired := expiring.DeleteExpired(10)
+	assert.Equal(t, 1, expired)
+
+	_, exists = engine.Get("forever")
+	assert.False(t, exists)
+}
+
+func testVersions(t *testing.T, engine storage.Engine) {
+	versioned, ok := engine.(storage.VersionedEngine)
+	if !ok {
+		t.Skip("engine does not track versions")
+	}
+
+	missing := versioned.Version("foo")
+
+	require.NoError(t, engine.Set("foo", []byte("1")))
+	created := versioned.Version("foo")
+	assert.NotEqual(t, missing, created)
+
+	require.NoError(t, engine.Set("bar", []byte("1")))
+	assert.Equal(t, created, versioned.Version("foo"))
+
+	require.NoError(t, engine.Set("foo", []byte("2")))
+	modified := versioned.Version("foo")
+	assert.NotEqual(t, created, modified)
+
+	require.NoError(t, engine.Delete("foo"))
+	assert.NotEqual(t, modified, versioned.Version("foo"))
+	assert.NotEqual(t, missing, versioned.Version("foo"))
+}
+
+func testScan(t *testing.T, engine storage.Engine) {
+	scanning, ok := engine.(storage.ScanningEngine)
+	if !ok {
+		t.Skip("engine does not support scan")
+	}
+
+	expected := make([]string, 0)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		require.NoError(t, engine.Set(key, []byte("value")))
+		expected = append(expected, key)
+	}
+
+	keys := make([]string, 0)
+	cursor := uint64(0)
+	for {
+		var batch []string
+		cursor, batch = scanning.Scan(cursor, 7)
+		keys = append(keys, batch...)
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.ElementsMatch(t, expected, keys)
+}
+
+func testSnapshot(t *testing.T, engine storage.Engine) {
+	snapshotting, ok := engine.(storage.SnapshotEngine)
+	if !ok {
+		t.Skip("engine does not support snapshots")
+	}
+
+	require.NoError(t, engine.Set("a", []byte("1")))
+	require.NoError(t, engine.Set("b", []byte("2")))
+	data := snapshotting.Dump()
+	assert.Len(t, data, 2)
+
+	require.NoError(t, engine.Set("c", []byte("3")))
+	snapshotting.Load(data)
+
+	values, found := engine.MGet([]string{"a", "b", "c"})
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), nil}, values)
+	assert.Equal(t, []bool{true, true, false}, found)
+}
+
+func testAscend(t *testing.T, engine storage.Engine) {
+	sorted, ok := engine.(storage.SortedEngine)
+	if !ok {
+		t.Skip("engine does not keep keys ordered")
+	}
+
+	keys := []string{"user:2", "user:10", "order:1", "user:1", "", "zebra"}
+	for _, key := range keys {
+		require.NoError(t, engine.Set(key, []byte(key)))
+	}
+
+	visited := make([]string, 0)
+	sorted.Ascend("", func(key string, value []byte) bool {
+		assert.Equal(t, key, string(value))
+		visited = append(visited, key)
+		return true
+	})
+	assert.True(t, slices.IsSorted(visited))
+	assert.ElementsMatch(t, keys, visited)
+
+	visited = visited[:0]
+	sorted.Ascend("user:", func(key string, _ []byte) bool {
+		visited = append(visited, key)
+		return len(visited) < 2
+	})
+	assert.Equal(t, []string{"user:1", "user:10"}, visited)
+}
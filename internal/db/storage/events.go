@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+var (
+	ErrUnknownEventClass = errors.New("unknown event class")
+)
+
+// EventClass is a set of kinds of keyspace events, like flags of redis notify-keyspace-events
+type EventClass uint16
+
+const (
+	// GenericEvents are del, expire and persist of keys of any type
+	GenericEvents EventClass = 1 << iota
+	StringEvents
+	ListEvents
+	HashEvents
+	SetEvents
+	SortedSetEvents
+	// ExpiredEvents are reported when engine removes key whose time to live is over
+	ExpiredEvents
+	// EvictedEvents are reported when engine removes key to free memory
+	EvictedEvents
+
+	AllEvents = GenericEvents | StringEvents | ListEvents | HashEvents | SetEvents | SortedSetEvents |
+		ExpiredEvents | EvictedEvents
+)
+
+var eventClassNames = map[string]EventClass{
+	"generic": GenericEvents,
+	"string":  StringEvents,
+	"list":    ListEvents,
+	"hash":    HashEvents,
+	"set":     SetEvents,
+	"zset":    SortedSetEvents,
+	"expired": ExpiredEvents,
+	"evicted": EvictedEvents,
+	"all":     AllEvents,
+}
+
+// ParseEventClasses combines classes given by names, e.g. "list" or "all"
+func ParseEventClasses(names []string) (EventClass, error) {
+	var classes EventClass
+	for _, name := range names {
+		class, ok := eventClassNames[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownEventClass, name)
+		}
+		classes |= class
+	}
+
+	return classes, nil
+}
+
+// Event tells that key was changed, Name is lowercase name of change like redis uses,
+// e.g. "set", "lpush" or "expired"
+type Event struct {
+	Class EventClass
+	Name  string
+	Key   string
+}
+
+// RemovalReason tells why engine has removed key on its own
+type RemovalReason int
+
+const (
+	RemovedExpired RemovalReason = iota
+	RemovedEvicted
+)
+
+// NotifyingEngine is implemented by engines which report keys removed without a command
+type NotifyingEngine interface {
+	// OnRemove sets fn called for every expired or evicted key, it must be set before engine
+	// is used. fn is called under engine locks, so it must not block or access engine
+	OnRemove(fn func(key string, reason RemovalReason))
+}
+
+// EventBus delivers keyspace events of enabled classes to subscribers. Subscribers are called
+// synchronously by writers, sometimes under engine locks, so they must be fast and must not
+// access storage
+type EventBus struct {
+	enabled     EventClass
+	mu          sync.RWMutex
+	subscribers map[uint64]eventSubscriber
+	nextID      uint64
+}
+
+type eventSubscriber struct {
+	classes EventClass
+	fn      func(Event)
+}
+
+func NewEventBus(enabled EventClass) *EventBus {
+	return &EventBus{
+		enabled:     enabled,
+		subscribers: make(map[uint64]eventSubscriber),
+	}
+}
+
+// Enabled reports whether any of classes is enabled, nil bus has nothing enabled
+func (b *EventBus) Enabled(classes EventClass) bool {
+	return b != nil && b.enabled&classes != 0
+}
+
+// Subscribe calls fn for every event of classes, returned func cancels subscription
+func (b *EventBus) Subscribe(classes EventClass, fn func(Event)) (cancel func()) {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = eventSubscriber{classes: classes, fn: fn}
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+	}
+}
+
+func (b *EventBus) publish(event Event) {
+	if !b.Enabled(event.Class) {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subscribers {
+		if sub.classes&event.Class != 0 {
+			sub.fn(event)
+		}
+	}
+}
+
+// removed reports key removed by engine on its own
+func (b *EventBus) removed(key string, reason RemovalReason) {
+	switch reason {
+	case RemovedExpired:
+		b.publish(Event{Class: ExpiredEvents, Name: "expired", Key: key})
+	case RemovedEvicted:
+		b.publish(Event{Class: EvictedEvents, Name: "evicted", Key: key})
+	}
+}
+
+// notify publishes events of logged query, so every change made through storage is reported
+// exactly as it is replayed
+func (b *EventBus) notify(query *compute.Query) {
+	if b == nil || b.enabled == 0 {
+		return
+	}
+
+	args := query.Arguments
+	switch query.CommandType {
+	case compute.SetCommand:
+		b.publish(Event{Class: StringEvents, Name: "set", Key: args[0]})
+		if len(args) > 2 {
+			b.publish(Event{Class: GenericEvents, Name: "expire", Key: args[0]})
+		}
+	case compute.MSetCommand:
+		for i := 0; i+1 < len(args); i += 2 {
+			b.publish(Event{Class: StringEvents, Name: "set", Key: args[i]})
+		}
+	case compute.DelCommand:
+		for _, key := range args {
+			b.publish(Event{Class: GenericEvents, Name: "del", Key: key})
+		}
+	case compute.PExpireAtCommand:
+		b.publish(Event{Class: GenericEvents, Name: "expire", Key: args[0]})
+	case compute.PersistCommand:
+		b.publish(Event{Class: GenericEvents, Name: "persist", Key: args[0]})
+	case compute.IncrByCommand, compute.IncrByFloatCommand:
+		b.publish(Event{Class: StringEvents, Name: eventName(query.CommandType), Key: args[0]})
+	case compute.LPushCommand, compute.RPushCommand, compute.LPopCommand, compute.RPopCommand,
+		compute.LTrimCommand:
+		b.publish(Event{Class: ListEvents, Name: eventName(query.CommandType), Key: args[0]})
+	case compute.HSetCommand, compute.HDelCommand, compute.HIncrByCommand:
+		b.publish(Event{Class: HashEvents, Name: eventName(query.CommandType), Key: args[0]})
+	case compute.SAddCommand, compute.SRemCommand:
+		b.publish(Event{Class: SetEvents, Name: eventName(query.CommandType), Key: args[0]})
+	case compute.ZAddCommand, compute.ZRemCommand:
+		b.publish(Event{Class: SortedSetEvents, Name: eventName(query.CommandType), Key: args[0]})
+	case compute.ZIncrByCommand:
+		// redis names it without "by"
+		b.publish(Event{Class: SortedSetEvents, Name: "zincr", Key: args[0]})
+	}
+}
+
+func eventName(command compute.CommandType) string {
+	return strings.ToLower(string(command))
+}
@@ -0,0 +1,82 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	inmemory "github.com/kirban/potato-db/internal/db/storage/engines/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStorage_Events(t *testing.T) {
+	t.Parallel()
+
+	engine, err := inmemory.NewInMemoryEngine(zap.NewNop())
+	require.NoError(t, err)
+
+	wal := &recordingLog{}
+	bus := storage.NewEventBus(storage.AllEvents &^ storage.HashEvents)
+	s := storage.NewDatabaseStorageBuilder(zap.NewNop()).InitEngine(engine).InitWAL(wal).InitEvents(bus).Build()
+
+	var events []storage.Event
+	cancel := bus.Subscribe(storage.AllEvents, func(event storage.Event) {
+		events = append(events, event)
+	})
+
+	var lists []string
+	bus.Subscribe(storage.ListEvents, func(event storage.Event) {
+		lists = append(lists, event.Key)
+	})
+
+	require.NoError(t, s.Set("a", []byte("1")))
+	_, err = s.RPush("list", []byte("x"))
+	require.NoError(t, err)
+	// class which is not enabled is not reported
+	_, err = s.HSet("hash", []storage.KeyValue{{Key: "f", Value: []byte("v")}})
+	require.NoError(t, err)
+
+	// missing keys are neither reported nor logged
+	n, err := s.Del("a", "missing", "a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"a"}, wal.queries[len(wal.queries)-1].Arguments)
+
+	require.NoError(t, s.Atomic(func(tx *storage.Storage) error {
+		_, err := tx.IncrBy("counter", 1)
+		return err
+	}))
+
+	require.NoError(t, s.SetWithExpiry("volatile", []byte("1"), time.Now().Add(time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+	_, err = s.Get("volatile")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	assert.Equal(t, []storage.Event{
+		{Class: storage.StringEvents, Name: "set", Key: "a"},
+		{Class: storage.ListEvents, Name: "rpush", Key: "list"},
+		{Class: storage.GenericEvents, Name: "del", Key: "a"},
+		{Class: storage.StringEvents, Name: "incrby", Key: "counter"},
+		{Class: storage.StringEvents, Name: "set", Key: "volatile"},
+		{Class: storage.GenericEvents, Name: "expire", Key: "volatile"},
+		{Class: storage.ExpiredEvents, Name: "expired", Key: "volatile"},
+	}, events)
+	assert.Equal(t, []string{"list"}, lists)
+
+	cancel()
+	require.NoError(t, s.Set("b", []byte("1")))
+	assert.Len(t, events, 7)
+}
+
+func TestParseEventClasses(t *testing.T) {
+	t.Parallel()
+
+	classes, err := storage.ParseEventClasses([]string{"list", "Expired"})
+	require.NoError(t, err)
+	assert.Equal(t, storage.ListEvents|storage.ExpiredEvents, classes)
+
+	_, err = storage.ParseEventClasses([]string{"keys"})
+	assert.ErrorIs(t, err, storage.ErrUnknownEventClass)
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+var (
+	ErrExpiryNotSupported = errors.New("engine does not support key expiration")
+)
+
+const (
+	expireCycleInterval = 100 * time.Millisecond
+	expireCycleBudget   = 25 * time.Millisecond
+	expireSampleSize    = 20
+)
+
+// ExpiringEngine is implemented by engines which support keys with time to live
+type ExpiringEngine interface {
+	SetWithExpiry(key string, value []byte, expireAt time.Time) error
+	Expire(key string, expireAt time.Time) bool
+	Persist(key string) bool
+	TTL(key string) (ttl time.Duration, hasExpiry bool, exists bool)
+	// DeleteExpired samples up to limit keys with expiration and removes expired ones
+	DeleteExpired(limit int) (sampled int, expired int)
+}
+
+func (s *Storage) SetWithExpiry(key string, value []byte, expireAt time.Time) error {
+	engine := s.expiringEngine()
+	if engine == nil {
+		return ErrExpiryNotSupported
+	}
+
+	// absolute expiration time is logged, so replay does not prolong key life
+	query := compute.NewQuery(compute.SetCommand, []string{key, string(value), compute.ExpireAtMillisecondsOption, formatLoggedExpireAt(expireAt)})
+
+	return s.mutate(query, func() error {
+		return engine.SetWithExpiry(key, value, expireAt)
+	})
+}
+
+// Expire sets expiration of existing key, false is returned if there is no such key
+func (s *Storage) Expire(key string, expireAt time.Time) (bool, error) {
+	engine := s.expiringEngine()
+	if engine == nil {
+		return false, ErrExpiryNotSupported
+	}
+
+	var updated bool
+	query := compute.NewQuery(compute.PExpireAtCommand, []string{key, formatLoggedExpireAt(expireAt)})
+
+	err := s.mutateIf(query, func() (bool, error) {
+		updated = engine.Expire(key, expireAt)
+		return updated, nil
+	})
+
+	return updated, err
+}
+
+// Persist removes expiration of key, false is returned if key does not exist or has no expiration
+func (s *Storage) Persist(key string) (bool, error) {
+	engine := s.expiringEngine()
+	if engine == nil {
+		return false, ErrExpiryNotSupported
+	}
+
+	var updated bool
+	query := compute.NewQuery(compute.PersistCommand, []string{key})
+
+	err := s.mutateIf(query, func() (bool, error) {
+		updated = engine.Persist(key)
+		return updated, nil
+	})
+
+	return updated, err
+}
+
+// TTL returns remaining time to live of key, hasExpiry is false for keys without expiration
+func (s *Storage) TTL(key string) (ttl time.Duration, hasExpiry bool, err error) {
+	engine := s.expiringEngine()
+	if engine == nil {
+		if _, exists := (*s.engine).Get(key); !exists {
+			return 0, false, ErrKeyNotFound
+		}
+		return 0, false, nil
+	}
+
+	ttl, hasExpiry, exists := engine.TTL(key)
+	if !exists {
+		return 0, false, ErrKeyNotFound
+	}
+
+	return ttl, hasExpiry, nil
+}
+
+// Start runs active expiration of keys until ctx is done
+func (s *Storage) Start(ctx context.Context) {
+	engine := s.expiringEngine()
+	if engine == nil {
+		return
+	}
+
+	ticker := time.NewTicker(expireCycleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireCycle(engine)
+		}
+	}
+}
+
+// expireCycle repeats sampling while more than a quarter of sampled keys were expired
+func (s *Storage) expireCycle(engine ExpiringEngine) {
+	deadline := time.Now().Add(expireCycleBudget)
+
+	for {
+		sampled, expired := engine.DeleteExpired(expireSampleSize)
+		if sampled == 0 || expired*4 < sampled || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+func (s *Storage) expiringEngine() ExpiringEngine {
+	engine, ok := (*s.engine).(ExpiringEngine)
+	if !ok {
+		return nil
+	}
+
+	return engine
+}
+
+// applyExpiring replays logged record which needs engine with expiration support
+func (s *Storage) applyExpiring(rawExpireAt string, apply func(engine ExpiringEngine, expireAt time.Time)) error {
+	engine := s.expiringEngine()
+	if engine == nil {
+		return ErrExpiryNotSupported
+	}
+
+	expireAt, err := parseLoggedExpireAt(rawExpireAt)
+	if err != nil {
+		return err
+	}
+
+	apply(engine, expireAt)
+	return nil
+}
+
+func formatLoggedExpireAt(expireAt time.Time) string {
+	return strconv.FormatInt(expireAt.UnixMilli(), 10)
+}
+
+func parseLoggedExpireAt(raw string) (time.Time, error) {
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration time in wal: %w", err)
+	}
+
+	return time.UnixMilli(ms), nil
+}
@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+// hashFieldOverhead approximates memory taken by map slot of hash field
+const hashFieldOverhead = 48
+
+// Hash maps fields to values, values are never modified in place, they are replaced as a whole
+type Hash struct {
+	fields map[string][]byte
+	size   int
+}
+
+func NewHash() *Hash {
+	return &Hash{fields: make(map[string][]byte)}
+}
+
+func (h *Hash) Type() ValueType {
+	return HashType
+}
+
+func (h *Hash) Len() int {
+	return len(h.fields)
+}
+
+func (h *Hash) Size() int {
+	return h.size
+}
+
+func (h *Hash) Clone() Collection {
+	fields := make(map[string][]byte, len(h.fields))
+	for field, value := range h.fields {
+		fields[field] = value
+	}
+
+	return &Hash{fields: fields, size: h.size}
+}
+
+// Items returns fields and their values one after another, fields are sorted
+func (h *Hash) Items() [][]byte {
+	items := make([][]byte, 0, 2*len(h.fields))
+	for _, field := range h.Fields() {
+		items = append(items, []byte(field), h.fields[field])
+	}
+
+	return items
+}
+
+// Fields returns sorted fields, so replies don't depend on map order
+func (h *Hash) Fields() []string {
+	fields := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	return fields
+}
+
+func (h *Hash) Get(field string) ([]byte, bool) {
+	value, ok := h.fields[field]
+	return value, ok
+}
+
+// Set reports whether field is new
+func (h *Hash) Set(field string, value []byte) bool {
+	old, exists := h.fields[field]
+	if exists {
+		h.size -= len(old)
+	} else {
+		h.size += len(field) + hashFieldOverhead
+	}
+
+	h.fields[field] = value
+	h.size += len(value)
+
+	return !exists
+}
+
+// Delete reports whether field existed
+func (h *Hash) Delete(field string) bool {
+	value, exists := h.fields[field]
+	if !exists {
+		return false
+	}
+
+	delete(h.fields, field)
+	h.size -= len(field) + len(value) + hashFieldOverhead
+
+	return true
+}
+
+// HSet sets fields of hash and returns number of fields which are new
+func (s *Storage) HSet(key string, pairs []KeyValue) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]string, 0, 2*len(pairs)+1)
+	args = append(args, key)
+	for _, pair := range pairs {
+		args = append(args, pair.Key, string(pair.Value))
+	}
+
+	var added int
+	err = s.mutate(compute.NewQuery(compute.HSetCommand, args), func() error {
+		var err error
+		added, err = setHash(engine, key, pairs)
+		return err
+	})
+
+	return added, err
+}
+
+// HGet returns value of field, ErrKeyNotFound is returned if there is no such field
+func (s *Storage) HGet(key string, field string) ([]byte, error) {
+	values, found, err := s.HMGet(key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found[0] {
+		return nil, ErrKeyNotFound
+	}
+
+	return values[0], nil
+}
+
+// HMGet returns values of fields, missing fields are reported in found like in MGet
+func (s *Storage) HMGet(key string, fields ...string) ([][]byte, []bool, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values, found := make([][]byte, len(fields)), make([]bool, len(fields))
+	_, err = engine.ViewCollection(key, HashType, func(c Collection) {
+		hash := c.(*Hash)
+		for i, field := range fields {
+			values[i], found[i] = hash.Get(field)
+		}
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return values, found, nil
+}
+
+// HDel removes fields and returns number of removed ones, hash left empty is deleted
+func (s *Storage) HDel(key string, fields ...string) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	query := compute.NewQuery(compute.HDelCommand, append([]string{key}, fields...))
+
+	var deleted int
+	err = s.mutateIf(query, func() (bool, error) {
+		var err error
+		deleted, err = deleteHash(engine, key, fields)
+		return deleted > 0, err
+	})
+
+	return deleted, err
+}
+
+// HGetAll returns fields with their values ordered by field
+func (s *Storage) HGetAll(key string) ([]KeyValue, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]KeyValue, 0)
+	_, err = engine.ViewCollection(key, HashType, func(c Collection) {
+		hash := c.(*Hash)
+		for _, field := range hash.Fields() {
+			value, _ := hash.Get(field)
+			pairs = append(pairs, KeyValue{Key: field, Value: value})
+		}
+	})
+
+	return pairs, err
+}
+
+// HKeys returns sorted fields of hash
+func (s *Storage) HKeys(key string) ([]string, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0)
+	_, err = engine.ViewCollection(key, HashType, func(c Collection) {
+		fields = c.(*Hash).Fields()
+	})
+
+	return fields, err
+}
+
+// HLen returns number of fields, missing key is an empty hash
+func (s *Storage) HLen(key string) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	_, err = engine.ViewCollection(key, HashType, func(c Collection) {
+		n = c.Len()
+	})
+
+	return n, err
+}
+
+// HIncrBy atomically adds delta to integer value of field, missing field is treated as zero
+func (s *Storage) HIncrBy(key string, field string, delta int64) (int64, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	query := compute.NewQuery(compute.HIncrByCommand, []string{key, field, strconv.FormatInt(delta, 10)})
+
+	var n int64
+	err = s.mutate(query, func() error {
+		var err error
+		n, err = incrHash(engine, key, field, delta)
+		return err
+	})
+
+	return n, err
+}
+
+func newHash() Collection {
+	return NewHash()
+}
+
+func setHash(engine CollectionEngine, key string, pairs []KeyValue) (int, error) {
+	var added int
+
+	err := engine.UpdateCollection(key, HashType, newHash, func(c Collection) (bool, error) {
+		hash := c.(*Hash)
+		for _, pair := range pairs {
+			if hash.Set(pair.Key, pair.Value) {
+				added++
+			}
+		}
+
+		return true, nil
+	})
+
+	return added, err
+}
+
+func deleteHash(engine CollectionEngine, key string, fields []string) (int, error) {
+	var deleted int
+
+	err := engine.UpdateCollection(key, HashType, nil, func(c Collection) (bool, error) {
+		hash := c.(*Hash)
+		for _, field := range fields {
+			if hash.Delete(field) {
+				deleted++
+			}
+		}
+
+		return deleted > 0, nil
+	})
+
+	return deleted, err
+}
+
+func incrHash(engine CollectionEngine, key string, field string, delta int64) (int64, error) {
+	var n int64
+
+	err := engine.UpdateCollection(key, HashType, newHash, func(c Collection) (bool, error) {
+		hash := c.(*Hash)
+
+		n = 0
+		if value, exists := hash.Get(field); exists {
+			var err error
+			if n, err = parseInteger(value); err != nil {
+				return false, err
+			}
+		}
+
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return false, ErrIncrOverflow
+		}
+
+		n += delta
+		hash.Set(field, strconv.AppendInt(nil, n, 10))
+		return true, nil
+	})
+
+	return n, err
+}
+
+func (s *Storage) applyHash(query compute.Query) error {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return err
+	}
+
+	args := query.Arguments
+
+	switch query.CommandType {
+	case compute.HSetCommand:
+		pairs := make([]KeyValue, 0, len(args)/2)
+		for i := 1; i+1 < len(args); i += 2 {
+			pairs = append(pairs, KeyValue{Key: args[i], Value: []byte(args[i+1])})
+		}
+		_, err = setHash(engine, args[0], pairs)
+		return err
+	case compute.HDelCommand:
+		_, err = deleteHash(engine, args[0], args[1:])
+		return err
+	case compute.HIncrByCommand:
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		_, err = incrHash(engine, args[0], args[1], delta)
+		return err
+	default:
+		return fmt.Errorf("unexpected hash command in wal: %s", query.CommandType)
+	}
+}
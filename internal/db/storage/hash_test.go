@@ -0,0 +1,162 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash_Size(t *testing.T) {
+	t.Parallel()
+
+	hash := storage.NewHash()
+	assert.True(t, hash.Set("name", []byte("potato")))
+	assert.False(t, hash.Set("name", []byte("tomato!")))
+	assert.True(t, hash.Set("age", []byte("3")))
+	assert.Equal(t, len("name")+len("tomato!")+len("age")+len("3")+2*48, hash.Size())
+
+	assert.True(t, hash.Delete("name"))
+	assert.False(t, hash.Delete("name"))
+	assert.Equal(t, len("age")+len("3")+48, hash.Size())
+
+	restored, err := storage.NewCollection(storage.HashType, hash.Items())
+	require.NoError(t, err)
+	assert.Equal(t, hash, restored)
+
+	_, err = storage.NewCollection(storage.HashType, [][]byte{[]byte("field")})
+	assert.Error(t, err)
+}
+
+func TestStorage_HashCommands(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	added, err := s.HSet("user:1", []storage.KeyValue{
+		{Key: "name", Value: []byte("potato")},
+		{Key: "email", Value: []byte("potato@example.com")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	added, err = s.HSet("user:1", []storage.KeyValue{
+		{Key: "name", Value: []byte("tomato")},
+		{Key: "city", Value: []byte("Moscow")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	value, err := s.HGet("user:1", "name")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("tomato"), value)
+
+	_, err = s.HGet("user:1", "phone")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	_, err = s.HGet("user:2", "name")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	values, found, err := s.HMGet("user:1", "city", "phone")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("Moscow"), nil}, values)
+	assert.Equal(t, []bool{true, false}, found)
+
+	pairs, err := s.HGetAll("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, []storage.KeyValue{
+		{Key: "city", Value: []byte("Moscow")},
+		{Key: "email", Value: []byte("potato@example.com")},
+		{Key: "name", Value: []byte("tomato")},
+	}, pairs)
+
+	fields, err := s.HKeys("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"city", "email", "name"}, fields)
+
+	deleted, err := s.HDel("user:1", "city", "phone")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	n, err := s.HLen("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// hash left empty is deleted
+	deleted, err = s.HDel("user:1", "name", "email")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	keys, err := s.Keys("*")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestStorage_HIncrBy(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	n, err := s.HIncrBy("stats", "visits", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+
+	n, err = s.HIncrBy("stats", "visits", -7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	_, err = s.HSet("stats", []storage.KeyValue{{Key: "name", Value: []byte("potato")}, {Key: "max", Value: []byte("9223372036854775807")}})
+	require.NoError(t, err)
+
+	_, err = s.HIncrBy("stats", "name", 1)
+	assert.ErrorIs(t, err, storage.ErrNotInteger)
+
+	_, err = s.HIncrBy("stats", "max", 1)
+	assert.ErrorIs(t, err, storage.ErrIncrOverflow)
+
+	value, err := s.HGet("stats", "max")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("9223372036854775807"), value)
+
+	require.NoError(t, s.Set("string", []byte("1")))
+	_, err = s.HIncrBy("string", "field", 1)
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	_, err = s.Get("stats")
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	_, err = s.LPush("stats", []byte("a"))
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+}
+
+func TestStorage_HashReplay(t *testing.T) {
+	t.Parallel()
+
+	wal := &recordingLog{}
+	s := newTestStorage(t, wal)
+
+	_, err := s.HSet("user:1", []storage.KeyValue{{Key: "name", Value: []byte("potato")}, {Key: "city", Value: []byte("Moscow")}})
+	require.NoError(t, err)
+	_, err = s.HIncrBy("user:1", "visits", 3)
+	require.NoError(t, err)
+	_, err = s.HDel("user:1", "city")
+	require.NoError(t, err)
+
+	// nothing is logged for commands which change nothing
+	_, err = s.HDel("user:1", "city")
+	require.NoError(t, err)
+	_, err = s.HIncrBy("user:1", "name", 1)
+	require.ErrorIs(t, err, storage.ErrNotInteger)
+	assert.Len(t, wal.queries, 3)
+
+	recovered := newTestStorage(t, wal)
+	require.NoError(t, recovered.Recover())
+
+	pairs, err := recovered.HGetAll("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, []storage.KeyValue{
+		{Key: "name", Value: []byte("potato")},
+		{Key: "visits", Value: []byte("3")},
+	}, pairs)
+}
@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+const (
+	// listItemOverhead approximates memory taken by slice header of list item
+	listItemOverhead = 24
+	listMinCapacity  = 4
+)
+
+// List is a double ended queue, pushing and popping from both ends takes constant time
+type List struct {
+	items [][]byte
+	head  int
+	len   int
+	size  int
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+func (l *List) Type() ValueType {
+	return ListType
+}
+
+func (l *List) Len() int {
+	return l.len
+}
+
+func (l *List) Size() int {
+	return l.size
+}
+
+func (l *List) Clone() Collection {
+	return &List{items: l.Items(), len: l.len, size: l.size}
+}
+
+func (l *List) Items() [][]byte {
+	items := make([][]byte, l.len)
+	for i := range items {
+		items[i] = l.At(i)
+	}
+
+	return items
+}
+
+// At returns item by index counted from the head, index must be within list
+func (l *List) At(i int) []byte {
+	return l.items[(l.head+i)%len(l.items)]
+}
+
+func (l *List) PushFront(value []byte) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.items)) % len(l.items)
+	l.items[l.head] = value
+	l.len++
+	l.size += len(value) + listItemOverhead
+}
+
+func (l *List) PushBack(value []byte) {
+	l.grow()
+	l.items[(l.head+l.len)%len(l.items)] = value
+	l.len++
+	l.size += len(value) + listItemOverhead
+}
+
+func (l *List) PopFront() ([]byte, bool) {
+	if l.len == 0 {
+		return nil, false
+	}
+
+	value := l.items[l.head]
+	l.items[l.head] = nil
+	l.head = (l.head + 1) % len(l.items)
+	l.removed(value)
+
+	return value, true
+}
+
+func (l *List) PopBack() ([]byte, bool) {
+	if l.len == 0 {
+		return nil, false
+	}
+
+	i := (l.head + l.len - 1) % len(l.items)
+	value := l.items[i]
+	l.items[i] = nil
+	l.removed(value)
+
+	return value, true
+}
+
+// Trim keeps only items from start to stop exclusive
+func (l *List) Trim(start int, stop int) {
+	for l.len > stop {
+		l.PopBack()
+	}
+
+	for i := 0; i < start; i++ {
+		l.PopFront()
+	}
+}
+
+func (l *List) removed(value []byte) {
+	l.len--
+	l.size -= len(value) + listItemOverhead
+	l.shrink()
+}
+
+func (l *List) grow() {
+	if l.len < len(l.items) {
+		return
+	}
+
+	l.resize(max(listMinCapacity, 2*len(l.items)))
+}
+
+// shrink releases memory of drained queue
+func (l *List) shrink() {
+	if len(l.items) > listMinCapacity && l.len < len(l.items)/4 {
+		l.resize(len(l.items) / 2)
+	}
+}
+
+func (l *List) resize(capacity int) {
+	items := make([][]byte, capacity)
+	for i := 0; i < l.len; i++ {
+		items[i] = l.At(i)
+	}
+
+	l.items, l.head = items, 0
+}
+
+// listBounds converts inclusive indices, negative ones are counted from the end, into bounds
+// of items within list of length n, start is not less than stop if range is empty
+func listBounds(start int64, stop int64, n int) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+
+	start, stop = max(start, 0), min(stop, int64(n)-1)
+	if start > stop {
+		return 0, 0
+	}
+
+	return int(start), int(stop) + 1
+}
+
+// LPush prepends values one by one and returns length of list
+func (s *Storage) LPush(key string, values ...[]byte) (int, error) {
+	return s.push(compute.LPushCommand, key, values)
+}
+
+// RPush appends values and returns length of list
+func (s *Storage) RPush(key string, values ...[]byte) (int, error) {
+	return s.push(compute.RPushCommand, key, values)
+}
+
+// LPop removes up to count items from the head, ErrKeyNotFound is returned for missing key
+func (s *Storage) LPop(key string, count int) ([][]byte, error) {
+	return s.pop(compute.LPopCommand, key, count)
+}
+
+// RPop removes up to count items from the tail, ErrKeyNotFound is returned for missing key
+func (s *Storage) RPop(key string, count int) ([][]byte, error) {
+	return s.pop(compute.RPopCommand, key, count)
+}
+
+// LLen returns length of list, missing key is an empty list
+func (s *Storage) LLen(key string) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	_, err = engine.ViewCollection(key, ListType, func(c Collection) {
+		n = c.Len()
+	})
+
+	return n, err
+}
+
+// LRange returns items from start to stop inclusive, negative indices are counted from the end
+func (s *Storage) LRange(key string, start int64, stop int64) ([][]byte, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, 0)
+	_, err = engine.ViewCollection(key, ListType, func(c Collection) {
+		list := c.(*List)
+		from, to := listBounds(start, stop, list.Len())
+		for i := from; i < to; i++ {
+			items = append(items, list.At(i))
+		}
+	})
+
+	return items, err
+}
+
+// LIndex returns item by index, ErrKeyNotFound is returned if there is no such item
+func (s *Storage) LIndex(key string, index int64) ([]byte, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	var item []byte
+	var found bool
+	_, err = engine.ViewCollection(key, ListType, func(c Collection) {
+		list := c.(*List)
+		if from, to := listBounds(index, index, list.Len()); from < to {
+			item, found = list.At(from), true
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	return item, nil
+}
+
+// LTrim keeps only items from start to stop inclusive, list left empty is deleted
+func (s *Storage) LTrim(key string, start int64, stop int64) error {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return err
+	}
+
+	query := compute.NewQuery(compute.LTrimCommand, []string{key, strconv.FormatInt(start, 10), strconv.FormatInt(stop, 10)})
+
+	return s.mutateIf(query, func() (bool, error) {
+		return trimList(engine, key, start, stop)
+	})
+}
+
+func (s *Storage) push(command compute.CommandType, key string, values [][]byte) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]string, 0, len(values)+1)
+	args = append(args, key)
+	for _, value := range values {
+		args = append(args, string(value))
+	}
+
+	var n int
+	err = s.mutate(compute.NewQuery(command, args), func() error {
+		var err error
+		n, err = pushList(engine, key, command == compute.LPushCommand, values)
+		return err
+	})
+
+	if err == nil {
+		s.serveBlocked(key)
+	}
+
+	return n, err
+}
+
+// pop logs number of actually removed items, so replay never depends on list length
+func (s *Storage) pop(command compute.CommandType, key string, count int) ([][]byte, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	var popped [][]byte
+	var exists bool
+
+	err = s.mutateWith(func() (*compute.Query, error) {
+		var err error
+		popped, exists, err = popList(engine, key, command == compute.LPopCommand, count)
+		if err != nil || len(popped) == 0 {
+			return nil, err
+		}
+
+		return compute.NewQuery(command, []string{key, strconv.Itoa(len(popped))}), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+
+	return popped, nil
+}
+
+func pushList(engine CollectionEngine, key string, front bool, values [][]byte) (int, error) {
+	var n int
+
+	err := engine.UpdateCollection(key, ListType, func() Collection { return NewList() }, func(c Collection) (bool, error) {
+		list := c.(*List)
+		for _, value := range values {
+			if front {
+				list.PushFront(value)
+			} else {
+				list.PushBack(value)
+			}
+		}
+
+		n = list.Len()
+		return true, nil
+	})
+
+	return n, err
+}
+
+func popList(engine CollectionEngine, key string, front bool, count int) ([][]byte, bool, error) {
+	var popped [][]byte
+	var exists bool
+
+	err := engine.UpdateCollection(key, ListType, nil, func(c Collection) (bool, error) {
+		list := c.(*List)
+		exists = true
+
+		popped = make([][]byte, 0, min(count, list.Len()))
+		for len(popped) < count {
+			var value []byte
+			var ok bool
+			if front {
+				value, ok = list.PopFront()
+			} else {
+				value, ok = list.PopBack()
+			}
+
+			if !ok {
+				break
+			}
+			popped = append(popped, value)
+		}
+
+		return len(popped) > 0, nil
+	})
+
+	return popped, exists, err
+}
+
+func trimList(engine CollectionEngine, key string, start int64, stop int64) (bool, error) {
+	var changed bool
+
+	err := engine.UpdateCollection(key, ListType, nil, func(c Collection) (bool, error) {
+		list := c.(*List)
+		n := list.Len()
+		from, to := listBounds(start, stop, n)
+
+		list.Trim(from, to)
+		changed = list.Len() != n
+		return changed, nil
+	})
+
+	return changed, err
+}
+
+func (s *Storage) applyList(query compute.Query) error {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return err
+	}
+
+	args := query.Arguments
+
+	switch query.CommandType {
+	case compute.LPushCommand, compute.RPushCommand:
+		values := make([][]byte, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			values = append(values, []byte(arg))
+		}
+		_, err = pushList(engine, args[0], query.CommandType == compute.LPushCommand, values)
+		return err
+	case compute.LPopCommand, compute.RPopCommand:
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		_, _, err = popList(engine, args[0], query.CommandType == compute.LPopCommand, count)
+		return err
+	case compute.LTrimCommand:
+		start, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		stop, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		_, err = trimList(engine, args[0], start, stop)
+		return err
+	default:
+		return fmt.Errorf("unexpected list command in wal: %s", query.CommandType)
+	}
+}
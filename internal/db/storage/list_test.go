@@ -0,0 +1,182 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList_Deque(t *testing.T) {
+	t.Parallel()
+
+	list := storage.NewList()
+	expected := make([][]byte, 0)
+
+	// items wrap around ring buffer and it grows and shrinks several times
+	for i := 0; i < 20; i++ {
+		list.PushBack([]byte(fmt.Sprint(i)))
+		expected = append(expected, []byte(fmt.Sprint(i)))
+	}
+	for i := 0; i < 20; i++ {
+		list.PushFront([]byte(fmt.Sprint(-i - 1)))
+		expected = append([][]byte{[]byte(fmt.Sprint(-i - 1))}, expected...)
+	}
+	assert.Equal(t, expected, list.Items())
+
+	for i := 0; i < 35; i++ {
+		value, ok := list.PopFront()
+		require.True(t, ok)
+		assert.Equal(t, expected[0], value)
+		expected = expected[1:]
+	}
+
+	value, ok := list.PopBack()
+	require.True(t, ok)
+	assert.Equal(t, []byte("19"), value)
+	assert.Equal(t, [][]byte{[]byte("15"), []byte("16"), []byte("17"), []byte("18")}, list.Items())
+
+	list.Trim(1, 3)
+	assert.Equal(t, [][]byte{[]byte("16"), []byte("17")}, list.Items())
+	assert.Equal(t, 2*(2+24), list.Size())
+
+	clone := list.Clone()
+	list.PopBack()
+	list.PopBack()
+	assert.Equal(t, 2, clone.Len())
+
+	_, ok = list.PopBack()
+	assert.False(t, ok)
+	assert.Zero(t, list.Size())
+}
+
+func TestStorage_ListCommands(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	n, err := s.RPush("queue", []byte("b"), []byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = s.LPush("queue", []byte("a"), []byte("z"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	tests := map[string]struct {
+		start    int64
+		stop     int64
+		expected []string
+	}{
+		"whole list":          {start: 0, stop: -1, expected: []string{"z", "a", "b", "c"}},
+		"negative indices":    {start: -3, stop: -2, expected: []string{"a", "b"}},
+		"stop out of range":   {start: 2, stop: 100, expected: []string{"b", "c"}},
+		"start after stop":    {start: 3, stop: 1, expected: []string{}},
+		"start out of range":  {start: 10, stop: 20, expected: []string{}},
+		"start before head":   {start: -100, stop: 0, expected: []string{"z"}},
+		"single item at tail": {start: -1, stop: -1, expected: []string{"c"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			items, err := s.LRange("queue", tc.start, tc.stop)
+			require.NoError(t, err)
+
+			expected := make([][]byte, 0, len(tc.expected))
+			for _, item := range tc.expected {
+				expected = append(expected, []byte(item))
+			}
+			assert.Equal(t, expected, items)
+		})
+	}
+
+	item, err := s.LIndex("queue", -1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("c"), item)
+
+	_, err = s.LIndex("queue", 4)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	popped, err := s.LPop("queue", 1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("z")}, popped)
+
+	popped, err = s.RPop("queue", 5)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("b"), []byte("a")}, popped)
+
+	// list left empty is deleted
+	_, err = s.LPop("queue", 1)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	n, err = s.LLen("queue")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	keys, err := s.Keys("*")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestStorage_ListWrongType(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	require.NoError(t, s.Set("string", []byte("1")))
+	_, err := s.RPush("list", []byte("a"))
+	require.NoError(t, err)
+
+	_, err = s.LPush("string", []byte("a"))
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	_, err = s.LRange("string", 0, -1)
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	_, err = s.Get("list")
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	_, err = s.IncrBy("list", 1)
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	_, err = s.GetSet("list", []byte("1"))
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+
+	// SET overwrites value of any type
+	require.NoError(t, s.Set("list", []byte("2")))
+	value, err := s.Get("list")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+}
+
+func TestStorage_ListReplay(t *testing.T) {
+	t.Parallel()
+
+	wal := &recordingLog{}
+	s := newTestStorage(t, wal)
+
+	_, err := s.RPush("queue", []byte("a"), []byte("b"), []byte("c"), []byte("d"))
+	require.NoError(t, err)
+	_, err = s.LPush("queue", []byte("z"))
+	require.NoError(t, err)
+	_, err = s.LPop("queue", 2)
+	require.NoError(t, err)
+	require.NoError(t, s.LTrim("queue", 0, 1))
+	_, err = s.RPop("queue", 1)
+	require.NoError(t, err)
+
+	// nothing is logged for commands which change nothing
+	_, err = s.LPop("missing", 1)
+	require.ErrorIs(t, err, storage.ErrKeyNotFound)
+	require.NoError(t, s.LTrim("queue", 0, -1))
+	assert.Len(t, wal.queries, 5)
+
+	recovered := newTestStorage(t, wal)
+	require.NoError(t, recovered.Recover())
+
+	items, err := recovered.LRange("queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, items)
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrRangeNotSupported = errors.New("engine does not keep keys ordered")
+)
+
+// SortedEngine is implemented by engines which keep keys in ascending order
+type SortedEngine interface {
+	// Ascend calls fn for keys not less than from in ascending order until fn returns false,
+	// fn runs under engine lock and must not call engine
+	Ascend(from string, fn func(key string, value []byte) bool)
+}
+
+// Range returns pairs with keys from start to end inclusive in ascending order, zero limit
+// means no limit
+func (s *Storage) Range(start string, end string, limit int) ([]KeyValue, error) {
+	return s.ascend(start, limit, func(key string) bool {
+		return key <= end
+	})
+}
+
+// Prefix returns pairs with keys starting with prefix in ascending order, zero limit means no limit
+func (s *Storage) Prefix(prefix string, limit int) ([]KeyValue, error) {
+	return s.ascend(prefix, limit, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// ascend collects pairs starting from key from while inRange holds for their keys
+func (s *Storage) ascend(from string, limit int, inRange func(key string) bool) ([]KeyValue, error) {
+	engine, ok := (*s.engine).(SortedEngine)
+	if !ok {
+		return nil, ErrRangeNotSupported
+	}
+
+	pairs := make([]KeyValue, 0)
+	engine.Ascend(from, func(key string, value []byte) bool {
+		if !inRange(key) {
+			return false
+		}
+
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+		return limit == 0 || len(pairs) < limit
+	})
+
+	return pairs, nil
+}
@@ -0,0 +1,64 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/db/storage/engines/ordered"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStorage_RangePrefix(t *testing.T) {
+	t.Parallel()
+
+	engine, err := ordered.NewOrderedEngine(zap.NewNop())
+	require.NoError(t, err)
+	s := storage.NewDatabaseStorageBuilder(zap.NewNop()).InitEngine(engine).Build()
+
+	for _, key := range []string{"user:0999", "user:1000", "user:1500", "user:2000", "user:2001", "users"} {
+		require.NoError(t, s.Set(key, []byte("v"+key)))
+	}
+
+	keysOf := func(pairs []storage.KeyValue) []string {
+		keys := make([]string, len(pairs))
+		for i, pair := range pairs {
+			keys[i] = pair.Key
+		}
+		return keys
+	}
+
+	pairs, err := s.Range("user:1000", "user:2000", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:1000", "user:1500", "user:2000"}, keysOf(pairs))
+	assert.Equal(t, []byte("vuser:1000"), pairs[0].Value)
+
+	pairs, err = s.Range("user:1000", "user:2000", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:1000", "user:1500"}, keysOf(pairs))
+
+	pairs, err = s.Range("b", "a", 0)
+	require.NoError(t, err)
+	assert.Empty(t, pairs)
+
+	pairs, err = s.Prefix("user:", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:0999", "user:1000", "user:1500", "user:2000", "user:2001"}, keysOf(pairs))
+
+	pairs, err = s.Prefix("user:2", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:2000"}, keysOf(pairs))
+}
+
+func TestStorage_RangeNotSupported(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, nil)
+
+	_, err := s.Range("a", "b", 0)
+	assert.ErrorIs(t, err, storage.ErrRangeNotSupported)
+
+	_, err = s.Prefix("a", 0)
+	assert.ErrorIs(t, err, storage.ErrRangeNotSupported)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"cmp"
+	"container/heap"
+	"errors"
+	"hash/maphash"
+	"math"
+	"slices"
+
+	"github.com/kirban/potato-db/internal/helpers"
+)
+
+var (
+	ErrScanNotSupported = errors.New("engine does not support key enumeration")
+)
+
+// ScanningEngine is implemented by engines which can enumerate keys incrementally
+type ScanningEngine interface {
+	// Scan returns about count keys starting from cursor and cursor to continue from. Zero cursor
+	// starts and ends iteration, keys existing during the whole iteration are returned at least once
+	Scan(cursor uint64, count int) (next uint64, keys []string)
+}
+
+// Scan makes one step of iteration over keys, keys not matching pattern are filtered out
+// after they are read, so step may return no keys even though iteration is not finished
+func (s *Storage) Scan(cursor uint64, pattern string, count int) (uint64, []string, error) {
+	engine, ok := (*s.engine).(ScanningEngine)
+	if !ok {
+		return 0, nil, ErrScanNotSupported
+	}
+
+	next, keys := engine.Scan(cursor, count)
+
+	return next, filterKeys(keys, pattern), nil
+}
+
+// Keys returns all keys matching pattern, engine is iterated step by step, so it is never
+// locked as a whole
+func (s *Storage) Keys(pattern string) ([]string, error) {
+	engine, ok := (*s.engine).(ScanningEngine)
+	if !ok {
+		return nil, ErrScanNotSupported
+	}
+
+	keys := make([]string, 0)
+	cursor := uint64(0)
+	for {
+		var batch []string
+		cursor, batch = engine.Scan(cursor, math.MaxInt)
+		keys = append(keys, filterKeys(batch, pattern)...)
+
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func filterKeys(keys []string, pattern string) []string {
+	if pattern == "*" {
+		return keys
+	}
+
+	return slices.DeleteFunc(keys, func(key string) bool {
+		return !helpers.MatchGlob(pattern, key)
+	})
+}
+
+var scanSeed = maphash.MakeSeed()
+
+// ScanHash orders keys of hash based engines during iteration
+func ScanHash(key string) uint32 {
+	return uint32(maphash.String(scanSeed, key))
+}
+
+// ScanBatch orders keys of hash based engine by hash of key, so iteration can continue from
+// the hash where it has stopped no matter how keys were moved around in between. Only keys with
+// the smallest hashes are kept, so batch takes memory for count keys, not for all keys of engine
+type ScanBatch struct {
+	from  uint32
+	count int
+	// candidates is a max-heap by hash, keys with hashes not less than limit are dropped
+	candidates scanHeap
+	dropped    bool
+	limit      uint32
+}
+
+type scanCandidate struct {
+	key  string
+	hash uint32
+}
+
+// NewScanBatch starts batch of count keys with hashes not less than from
+func NewScanBatch(from uint32, count int) *ScanBatch {
+	return &ScanBatch{from: from, count: max(count, 1)}
+}
+
+// Add offers key to batch, engine calls it for all keys it holds while they are locked
+func (b *ScanBatch) Add(key string) {
+	hash := ScanHash(key)
+	if hash < b.from || b.dropped && hash >= b.limit {
+		return
+	}
+
+	heap.Push(&b.candidates, scanCandidate{key: key, hash: hash})
+	b.shrink()
+}
+
+// shrink drops keys with the largest hash while the rest of keys fill the batch, keys with
+// equal hashes are dropped together since they must go to the same batch
+func (b *ScanBatch) shrink() {
+	for len(b.candidates) > b.count {
+		largest := b.candidates[0].hash
+
+		var group []scanCandidate
+		for len(b.candidates) > 0 && b.candidates[0].hash == largest {
+			group = append(group, heap.Pop(&b.candidates).(scanCandidate))
+		}
+
+		if len(b.candidates) < b.count {
+			for _, candidate := range group {
+				heap.Push(&b.candidates, candidate)
+			}
+			return
+		}
+
+		b.dropped, b.limit = true, largest
+	}
+}
+
+// Take returns at least count keys with the smallest hashes if there are so many, keys with
+// equal hashes always go to the same batch. done is true if no keys are left after the batch
+func (b *ScanBatch) Take() (keys []string, next uint32, done bool) {
+	candidates := b.candidates
+	slices.SortFunc(candidates, func(a, b scanCandidate) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	keys = make([]string, len(candidates))
+	for i := range keys {
+		keys[i] = candidates[i].key
+	}
+
+	// dropped keys have larger hashes than kept ones, so batch is not empty and next never overflows
+	if !b.dropped {
+		return keys, 0, true
+	}
+
+	return keys, candidates[len(candidates)-1].hash + 1, false
+}
+
+// scanHeap keeps candidate with the largest hash on top
+type scanHeap []scanCandidate
+
+func (h scanHeap) Len() int           { return len(h) }
+func (h scanHeap) Less(i, j int) bool { return h[i].hash > h[j].hash }
+func (h scanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *scanHeap) Push(x any) {
+	*h = append(*h, x.(scanCandidate))
+}
+
+func (h *scanHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,132 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_Keys(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, nil)
+	for _, key := range []string{"user:1", "user:2", "user:10", "session:1"} {
+		require.NoError(t, s.Set(key, []byte("value")))
+	}
+
+	tests := map[string]struct {
+		pattern  string
+		expected []string
+	}{
+		"all keys":       {pattern: "*", expected: []string{"user:1", "user:2", "user:10", "session:1"}},
+		"prefix":         {pattern: "user:*", expected: []string{"user:1", "user:2", "user:10"}},
+		"single byte":    {pattern: "user:?", expected: []string{"user:1", "user:2"}},
+		"class":          {pattern: "[su]*:1", expected: []string{"user:1", "session:1"}},
+		"nothing to see": {pattern: "order:*", expected: []string{}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			keys, err := s.Keys(tc.pattern)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, keys)
+		})
+	}
+}
+
+func TestStorage_Scan(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, nil)
+	for _, key := range []string{"a1", "a2", "a3", "b1", "b2"} {
+		require.NoError(t, s.Set(key, []byte("value")))
+	}
+
+	keys := make([]string, 0)
+	cursor := uint64(0)
+	for {
+		var batch []string
+		var err error
+		cursor, batch, err = s.Scan(cursor, "a*", 1)
+		require.NoError(t, err)
+		keys = append(keys, batch...)
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"a1", "a2", "a3"}, keys)
+}
+
+func TestScanBatch_Take(t *testing.T) {
+	t.Parallel()
+
+	batch := storage.NewScanBatch(0, 2)
+	for _, key := range []string{"a", "b", "c"} {
+		batch.Add(key)
+	}
+
+	keys, next, done := batch.Take()
+	assert.Len(t, keys, 2)
+	assert.False(t, done)
+
+	rest := storage.NewScanBatch(next, 2)
+	for _, key := range []string{"a", "b", "c"} {
+		rest.Add(key)
+	}
+
+	last, _, done := rest.Take()
+	assert.True(t, done)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, append(keys, last...))
+}
+
+func TestScanBatch_TakeAll(t *testing.T) {
+	t.Parallel()
+
+	all := make([]string, 1000)
+	for i := range all {
+		all[i] = fmt.Sprintf("key%d", i)
+	}
+
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{name: "single key", count: 0},
+		{name: "small batches", count: 7},
+		{name: "large batches", count: 300},
+		{name: "whole keyspace", count: len(all)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var keys []string
+			var from uint32
+			for {
+				batch := storage.NewScanBatch(from, tt.count)
+				for _, key := range all {
+					batch.Add(key)
+				}
+
+				taken, next, done := batch.Take()
+				assert.LessOrEqual(t, len(taken), max(tt.count, 1))
+				keys = append(keys, taken...)
+
+				if done {
+					break
+				}
+				from = next
+			}
+
+			assert.ElementsMatch(t, all, keys)
+		})
+	}
+}
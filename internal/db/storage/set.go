@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+// setMemberOverhead approximates memory taken by map slot of set member
+const setMemberOverhead = 32
+
+// Set is an unordered collection of distinct members
+type Set struct {
+	members map[string]struct{}
+	size    int
+}
+
+func NewSet() *Set {
+	return &Set{members: make(map[string]struct{})}
+}
+
+func (s *Set) Type() ValueType {
+	return SetType
+}
+
+func (s *Set) Len() int {
+	return len(s.members)
+}
+
+func (s *Set) Size() int {
+	return s.size
+}
+
+func (s *Set) Clone() Collection {
+	members := make(map[string]struct{}, len(s.members))
+	for member := range s.members {
+		members[member] = struct{}{}
+	}
+
+	return &Set{members: members, size: s.size}
+}
+
+func (s *Set) Items() [][]byte {
+	items := make([][]byte, 0, len(s.members))
+	for _, member := range s.Members() {
+		items = append(items, []byte(member))
+	}
+
+	return items
+}
+
+// Members returns sorted members, so replies don't depend on map order
+func (s *Set) Members() []string {
+	members := make([]string, 0, len(s.members))
+	for member := range s.members {
+		members = append(members, member)
+	}
+	slices.Sort(members)
+
+	return members
+}
+
+func (s *Set) Contains(member string) bool {
+	_, ok := s.members[member]
+	return ok
+}
+
+// Add reports whether member is new
+func (s *Set) Add(member string) bool {
+	if s.Contains(member) {
+		return false
+	}
+
+	s.members[member] = struct{}{}
+	s.size += len(member) + setMemberOverhead
+
+	return true
+}
+
+// Remove reports whether member existed
+func (s *Set) Remove(member string) bool {
+	if !s.Contains(member) {
+		return false
+	}
+
+	delete(s.members, member)
+	s.size -= len(member) + setMemberOverhead
+
+	return true
+}
+
+// SAdd adds members to set and returns number of members which are new
+func (s *Storage) SAdd(key string, members ...string) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	query := compute.NewQuery(compute.SAddCommand, append([]string{key}, members...))
+
+	var added int
+	err = s.mutateIf(query, func() (bool, error) {
+		var err error
+		added, err = addSet(engine, key, members)
+		return added > 0, err
+	})
+
+	return added, err
+}
+
+// SRem removes members from set and returns number of removed ones, set left empty is deleted
+func (s *Storage) SRem(key string, members ...string) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	query := compute.NewQuery(compute.SRemCommand, append([]string{key}, members...))
+
+	var removed int
+	err = s.mutateIf(query, func() (bool, error) {
+		var err error
+		removed, err = removeSet(engine, key, members)
+		return removed > 0, err
+	})
+
+	return removed, err
+}
+
+// SMembers returns sorted members of set
+func (s *Storage) SMembers(key string) ([]string, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0)
+	_, err = engine.ViewCollection(key, SetType, func(c Collection) {
+		members = c.(*Set).Members()
+	})
+
+	return members, err
+}
+
+func (s *Storage) SIsMember(key string, member string) (bool, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return false, err
+	}
+
+	var contains bool
+	_, err = engine.ViewCollection(key, SetType, func(c Collection) {
+		contains = c.(*Set).Contains(member)
+	})
+
+	return contains, err
+}
+
+// SInter returns sorted members which are in all sets, missing key is an empty set. Sets are
+// read one by one, so result is not a point-in-time view of all of them
+func (s *Storage) SInter(keys ...string) ([]string, error) {
+	return s.combineSets(keys, func(result *Set, other *Set) {
+		for member := range result.members {
+			if !other.Contains(member) {
+				result.Remove(member)
+			}
+		}
+	})
+}
+
+// SUnion returns sorted members which are in any of sets
+func (s *Storage) SUnion(keys ...string) ([]string, error) {
+	return s.combineSets(keys, func(result *Set, other *Set) {
+		for member := range other.members {
+			result.Add(member)
+		}
+	})
+}
+
+// SDiff returns sorted members of the first set which are in none of the others
+func (s *Storage) SDiff(keys ...string) ([]string, error) {
+	return s.combineSets(keys, func(result *Set, other *Set) {
+		for member := range other.members {
+			result.Remove(member)
+		}
+	})
+}
+
+// combineSets folds sets of keys into copy of the first one
+func (s *Storage) combineSets(keys []string, combine func(result *Set, other *Set)) ([]string, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	result := NewSet()
+	for i, key := range keys {
+		found, err := engine.ViewCollection(key, SetType, func(c Collection) {
+			if i == 0 {
+				result = c.Clone().(*Set)
+			} else {
+				combine(result, c.(*Set))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !found && i > 0 {
+			combine(result, NewSet())
+		}
+	}
+
+	return result.Members(), nil
+}
+
+func addSet(engine CollectionEngine, key string, members []string) (int, error) {
+	var added int
+
+	err := engine.UpdateCollection(key, SetType, func() Collection { return NewSet() }, func(c Collection) (bool, error) {
+		set := c.(*Set)
+		for _, member := range members {
+			if set.Add(member) {
+				added++
+			}
+		}
+
+		return added > 0, nil
+	})
+
+	return added, err
+}
+
+func removeSet(engine CollectionEngine, key string, members []string) (int, error) {
+	var removed int
+
+	err := engine.UpdateCollection(key, SetType, nil, func(c Collection) (bool, error) {
+		set := c.(*Set)
+		for _, member := range members {
+			if set.Remove(member) {
+				removed++
+			}
+		}
+
+		return removed > 0, nil
+	})
+
+	return removed, err
+}
+
+func (s *Storage) applySet(query compute.Query) error {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return err
+	}
+
+	args := query.Arguments
+
+	switch query.CommandType {
+	case compute.SAddCommand:
+		_, err = addSet(engine, args[0], args[1:])
+		return err
+	case compute.SRemCommand:
+		_, err = removeSet(engine, args[0], args[1:])
+		return err
+	default:
+		return fmt.Errorf("unexpected set command in wal: %s", query.CommandType)
+	}
+}
@@ -0,0 +1,89 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_SetCommands(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	added, err := s.SAdd("tags", "go", "db", "go")
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	contains, err := s.SIsMember("tags", "go")
+	require.NoError(t, err)
+	assert.True(t, contains)
+
+	contains, err = s.SIsMember("missing", "go")
+	require.NoError(t, err)
+	assert.False(t, contains)
+
+	removed, err := s.SRem("tags", "go", "rust")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	members, err := s.SMembers("tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db"}, members)
+
+	// set left empty is deleted
+	_, err = s.SRem("tags", "db")
+	require.NoError(t, err)
+	keys, err := s.Keys("*")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	require.NoError(t, s.Set("string", []byte("1")))
+	_, err = s.SAdd("string", "a")
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+}
+
+func TestStorage_SetAlgebra(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	_, err := s.SAdd("a", "1", "2", "3", "4")
+	require.NoError(t, err)
+	_, err = s.SAdd("b", "3", "4", "5")
+	require.NoError(t, err)
+	_, err = s.SAdd("c", "4", "6")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		combine  func(keys ...string) ([]string, error)
+		keys     []string
+		expected []string
+	}{
+		"inter":              {combine: s.SInter, keys: []string{"a", "b", "c"}, expected: []string{"4"}},
+		"inter with missing": {combine: s.SInter, keys: []string{"a", "missing"}, expected: []string{}},
+		"union":              {combine: s.SUnion, keys: []string{"a", "b", "c"}, expected: []string{"1", "2", "3", "4", "5", "6"}},
+		"union of missing":   {combine: s.SUnion, keys: []string{"missing"}, expected: []string{}},
+		"diff":               {combine: s.SDiff, keys: []string{"a", "b"}, expected: []string{"1", "2"}},
+		"diff with missing":  {combine: s.SDiff, keys: []string{"c", "missing"}, expected: []string{"4", "6"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			members, err := tc.combine(tc.keys...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, members)
+		})
+	}
+
+	// sets used by algebra stay the same
+	members, err := s.SMembers("a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, members)
+
+	require.NoError(t, s.Set("string", []byte("1")))
+	_, err = s.SUnion("a", "string")
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+}
@@ -2,61 +2,605 @@ package storage
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
 	"go.uber.org/zap"
 )
 
 var (
-	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyNotFound           = errors.New("key not found")
+	ErrOutOfMemory           = errors.New("out of memory: used memory exceeds max memory")
+	ErrSnapshotsDisabled     = errors.New("snapshots are disabled")
+	ErrSnapshotsNotSupported = errors.New("engine does not support snapshots")
+	ErrSnapshotInProgress    = errors.New("snapshot is already in progress")
+	ErrWALNotSupported       = errors.New("engine does not support write-ahead log")
 )
 
 type Engine interface {
-	Get(key string) (string, bool)
-	Set(key string, value string) error
+	// Get returns stored value, callers must not modify it
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte) error
 	Delete(key string) error
+	// MGet reads all keys at once, so none of them is changed in between
+	MGet(keys []string) (values [][]byte, found []bool)
+	// MSet stores all pairs atomically with respect to other clients
+	MSet(pairs []KeyValue) error
+	// MDelete removes keys atomically and returns number of keys which existed
+	MDelete(keys []string) (int, error)
+	// Update reads key and changes it as fn decides, the key can't be changed by anyone else
+	// in between. Entry of key after update is returned, nothing is changed if fn fails
+	Update(key string, fn UpdateFunc) (Entry, error)
+}
+
+// UpdateAction tells engine what to do with key after UpdateFunc
+type UpdateAction int
+
+const (
+	// UpdateKeep leaves key as is
+	UpdateKeep UpdateAction = iota
+	// UpdateStore replaces key with returned entry
+	UpdateStore
+	// UpdateDelete removes key
+	UpdateDelete
+)
+
+// UpdateFunc gets current entry of key and decides what to do with it. It may be called more
+// than once if engine has to retry, only the last call takes effect
+type UpdateFunc func(current Entry, exists bool) (Entry, UpdateAction, error)
+
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// Entry is a stored value with its metadata
+type Entry struct {
+	Value []byte
+	// Collection holds value of list and other collection types, it is nil for strings
+	Collection Collection
+	// ExpireAt is unix time in nanoseconds, zero means key never expires
+	ExpireAt int64
+}
+
+// SnapshotEngine is implemented by engines which keep the whole dataset in memory
+type SnapshotEngine interface {
+	Dump() map[string]Entry
+	Load(data map[string]Entry)
+}
+
+// CuttingEngine takes point-in-time cut of data without copying it, so writers are blocked only
+// while cut is taken and data is copied after that
+type CuttingEngine interface {
+	SnapshotEngine
+	// Cut returns func copying data as it was at the moment of cut, it must be called exactly once
+	Cut() func() map[string]Entry
+}
+
+type WriteAheadLog interface {
+	Write(query compute.Query) <-chan error
+	Replay(fromLSN uint64, apply func(query compute.Query) error) error
+	LastLSN() uint64
+	Truncate(lsn uint64) error
+}
+
+// ChangeLog receives records of write-ahead log, so committed changes can be streamed to consumers
+type ChangeLog interface {
+	// Append adds record of queries which is not durable yet and returns its sequence number
+	Append(queries []compute.Query) uint64
+	// Resolve tells whether record was flushed, consumers get only flushed records
+	Resolve(seq uint64, committed bool)
+	// Rebase continues sequence after last, it is called after recovery with lsn of the latest
+	// record, so sequence numbers of records match their lsn
+	Rebase(last uint64)
+	// Last returns sequence number of the latest appended record
+	Last() uint64
+	// Resolved returns nil if records up to seq are resolved, otherwise channel closed once
+	// more records are resolved
+	Resolved(seq uint64) <-chan struct{}
+}
+
+type Snapshotter interface {
+	Save(lsn uint64, data map[string]Entry) error
+	LoadLatest() (lsn uint64, data map[string]Entry, ok bool, err error)
+	OldestLSN() (uint64, bool)
 }
 
 type Storage struct {
-	engine *Engine
-	logger *zap.Logger
+	engine    *Engine
+	wal       WriteAheadLog
+	snapshots Snapshotter
+	logger    *zap.Logger
+	// walMu keeps order of records in log equal to order of applying them to engine
+	walMu      sync.Mutex
+	snapshotMu sync.Mutex
+	blocked    *blockedPops
+	events     *EventBus
+	changes    ChangeLog
+	// dumpMu lets one dump run at a time, so cut taken under walMu never waits for another one
+	dumpMu sync.Mutex
+	// failed is set under walMu once write-ahead log has failed, engine is rolled back to data
+	// on disk then and writes are rejected until restart
+	failed error
 }
 
-func (s *Storage) Get(key string) (string, error) {
+func (s *Storage) Get(key string) ([]byte, error) {
 	val, exists := (*s.engine).Get(key)
 
 	if !exists {
-		return "", ErrKeyNotFound
+		// engine treats collections as missing keys for string commands
+		if engine, ok := (*s.engine).(CollectionEngine); ok {
+			if t, found := engine.Type(key); found && t != StringType {
+				return nil, ErrWrongType
+			}
+		}
+		return nil, ErrKeyNotFound
 	}
 
 	return val, nil
 }
 
-func (s *Storage) Set(key string, value string) error {
-	err := (*s.engine).Set(key, value)
+func (s *Storage) Set(key string, value []byte) error {
+	query := compute.NewQuery(compute.SetCommand, []string{key, string(value)})
+
+	return s.mutate(query, func() error {
+		return (*s.engine).Set(key, value)
+	})
+}
+
+func (s *Storage) MGet(keys []string) ([][]byte, []bool) {
+	return (*s.engine).MGet(keys)
+}
+
+func (s *Storage) MSet(pairs []KeyValue) error {
+	args := make([]string, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		args = append(args, pair.Key, string(pair.Value))
+	}
+	query := compute.NewQuery(compute.MSetCommand, args)
+
+	return s.mutate(query, func() error {
+		return (*s.engine).MSet(pairs)
+	})
+}
+
+// Del removes keys and returns number of keys which existed
+func (s *Storage) Del(keys ...string) (int, error) {
+	var deleted int
+	err := s.mutateWith(func() (*compute.Query, error) {
+		// only existing keys are logged when deletions are reported, so no event is published
+		// for missing ones. Replay of narrowed query removes the same keys
+		logged := keys
+		if s.events.Enabled(GenericEvents) {
+			logged = s.existing(keys)
+		}
+
+		var err error
+		deleted, err = (*s.engine).MDelete(keys)
+		if err != nil || deleted == 0 {
+			return nil, err
+		}
+
+		// key could be created in between by concurrent writer, deletion must be logged anyway
+		if len(logged) == 0 {
+			logged = keys
+		}
+		return compute.NewQuery(compute.DelCommand, logged), nil
+	})
+
+	return deleted, err
+}
+
+// existing returns keys which exist, value of any type counts
+func (s *Storage) existing(keys []string) []string {
+	engine, _ := (*s.engine).(CollectionEngine)
+
+	found := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if slices.Contains(found, key) {
+			continue
+		}
+
+		if _, exists := (*s.engine).Get(key); exists {
+			found = append(found, key)
+		} else if engine != nil {
+			if _, exists := engine.Type(key); exists {
+				found = append(found, key)
+			}
+		}
+	}
+
+	return found
+}
+
+// Snapshot saves point-in-time copy of engine data. Writers are blocked only while engine takes
+// cut of data, copying, serialization and disk writes happen without holding walMu
+func (s *Storage) Snapshot() error {
+	if s.snapshots == nil {
+		return ErrSnapshotsDisabled
+	}
+
+	engine, ok := (*s.engine).(SnapshotEngine)
+	if !ok {
+		return ErrSnapshotsNotSupported
+	}
+
+	// transaction holds walMu until its record is written, snapshot taken before that would
+	// miss the record
+	if log, ok := s.wal.(*transactionLog); ok {
+		log.snapshot = true
+		return nil
+	}
+
+	if !s.snapshotMu.TryLock() {
+		return ErrSnapshotInProgress
+	}
+	defer s.snapshotMu.Unlock()
+
+	s.dumpMu.Lock()
+	var lsn uint64
+
+	s.walMu.Lock()
+	if s.wal != nil {
+		lsn = s.wal.LastLSN()
+	}
+	copyCut := cut(engine)
+	s.walMu.Unlock()
+
+	data := copyCut()
+	s.dumpMu.Unlock()
+
+	if err := s.snapshots.Save(lsn, data); err != nil {
+		s.logger.Error("failed to save snapshot", zap.Error(err))
+		return err
+	}
+
+	if s.wal == nil {
+		return nil
+	}
+
+	// log is truncated up to the oldest retained snapshot, so falling back to it is still possible
+	if oldest, ok := s.snapshots.OldestLSN(); ok {
+		if err := s.wal.Truncate(oldest); err != nil {
+			s.logger.Error("failed to truncate wal", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// Dump copies all data along with sequence number of the latest change in change log it contains,
+// so a copy can be brought up to date by changes after it. Sequence number is zero without change log
+func (s *Storage) Dump() (uint64, map[string]Entry, error) {
+	engine, ok := (*s.engine).(SnapshotEngine)
+	if !ok {
+		return 0, nil, ErrSnapshotsNotSupported
+	}
+
+	s.dumpMu.Lock()
+	defer s.dumpMu.Unlock()
+
+	// every change is applied and appended to change log under walMu, cut is taken once all of them
+	// are committed, since a change which fails to be written is rolled back
+	for {
+		s.walMu.Lock()
+		if s.failed != nil {
+			s.walMu.Unlock()
+			return 0, nil, s.failed
+		}
+
+		var seq uint64
+		if s.changes != nil {
+			seq = s.changes.Last()
+			if resolved := s.changes.Resolved(seq); resolved != nil {
+				s.walMu.Unlock()
+				<-resolved
+				continue
+			}
+		}
+
+		copyCut := cut(engine)
+		s.walMu.Unlock()
+
+		return seq, copyCut(), nil
+	}
+}
+
+// cut returns func copying engine data as it is now, engines which can't take cut copy data
+// right away
+func cut(engine SnapshotEngine) func() map[string]Entry {
+	if cutting, ok := engine.(CuttingEngine); ok {
+		return cutting.Cut()
+	}
+
+	data := engine.Dump()
+	return func() map[string]Entry {
+		return data
+	}
+}
+
+// Load replaces all data with copy made by Dump. Nothing is logged, so the copy is lost
+// after restart unless it is saved by snapshot
+func (s *Storage) Load(data map[string]Entry) error {
+	engine, ok := (*s.engine).(SnapshotEngine)
+	if !ok {
+		return ErrSnapshotsNotSupported
+	}
+
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+
+	engine.Load(data)
+	return nil
+}
+
+// Replicate applies queries made by another storage, like wal replay does. Queries are not
+// logged, but they are reported to subscribers of events
+func (s *Storage) Replicate(queries []compute.Query) error {
+	s.walMu.Lock()
+	for i, query := range queries {
+		if err := s.apply(query); err != nil {
+			s.walMu.Unlock()
+			s.notify(queries[:i])
+			return fmt.Errorf("failed to apply %s: %w", query.CommandType, err)
+		}
+	}
+	s.walMu.Unlock()
+
+	s.notify(queries)
+	return nil
+}
+
+func (s *Storage) notify(queries []compute.Query) {
+	for i := range queries {
+		s.events.notify(&queries[i])
+	}
+}
 
-	if err != nil {
+// Recover loads the latest snapshot and replays write-ahead log after it,
+// it must be called before serving queries
+func (s *Storage) Recover() error {
+	if err := s.restore(); err != nil {
 		return err
 	}
 
+	if s.wal != nil && s.changes != nil {
+		s.changes.Rebase(s.wal.LastLSN())
+	}
+	return nil
+}
+
+// Close releases files held by engine, it must be called after the last query is served
+func (s *Storage) Close() error {
+	if engine, ok := (*s.engine).(io.Closer); ok {
+		return engine.Close()
+	}
 	return nil
 }
 
-func (s *Storage) Del(key string) error {
-	err := (*s.engine).Delete(key)
+// rollback drops changes which are not on disk after write-ahead log has failed. Log rejects
+// every write after failure, so it also drops changes other writers have applied in between
+func (s *Storage) rollback(cause error) {
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+
+	if s.failed != nil {
+		return
+	}
+	s.failed = cause
+
+	engine, ok := (*s.engine).(SnapshotEngine)
+	if !ok {
+		s.logger.Error("failed to roll back changes", zap.Error(ErrSnapshotsNotSupported))
+		return
+	}
+
+	engine.Load(make(map[string]Entry))
+	if err := s.restore(); err != nil {
+		s.logger.Error("failed to roll back changes", zap.Error(err))
+	}
+}
+
+// restore loads the latest snapshot and replays write-ahead log after it
+func (s *Storage) restore() error {
+	var fromLSN uint64
+
+	if engine, ok := (*s.engine).(SnapshotEngine); ok && s.snapshots != nil {
+		lsn, data, found, err := s.snapshots.LoadLatest()
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot: %w", err)
+		}
+
+		if found {
+			engine.Load(data)
+			fromLSN = lsn
+		}
+	}
+
+	if s.wal == nil {
+		return nil
+	}
+
+	return s.wal.Replay(fromLSN, s.apply)
+}
+
+// mutate applies change to engine and waits until its log record is flushed
+func (s *Storage) mutate(query *compute.Query, apply func() error) error {
+	return s.mutateIf(query, func() (bool, error) {
+		return true, apply()
+	})
+}
+
+// mutateIf is like mutate, but query is logged only if apply reports that data has changed
+func (s *Storage) mutateIf(query *compute.Query, apply func() (bool, error)) error {
+	return s.mutateWith(func() (*compute.Query, error) {
+		changed, err := apply()
+		if err != nil || !changed {
+			return nil, err
+		}
+		return query, nil
+	})
+}
+
+// mutateWith logs query returned by apply, so it may depend on the data, nil query means
+// that nothing has changed
+func (s *Storage) mutateWith(apply func() (*compute.Query, error)) error {
+	if s.wal == nil && s.changes == nil {
+		query, err := apply()
+		if err == nil && query != nil {
+			s.events.notify(query)
+		}
+		return err
+	}
+
+	s.walMu.Lock()
+	if s.failed != nil {
+		s.walMu.Unlock()
+		return s.failed
+	}
 
-	if err != nil {
+	query, err := apply()
+	if err != nil || query == nil {
+		s.walMu.Unlock()
 		return err
 	}
+	wait := s.write(*query, []compute.Query{*query})
+	s.walMu.Unlock()
 
+	if err := wait(); err != nil {
+		return err
+	}
+
+	s.events.notify(query)
 	return nil
 }
 
-func NewStorage(engine *Engine, logger *zap.Logger) (*Storage, error) {
+// write logs record made of queries and returns func waiting until it is flushed. Record is
+// appended to change log in the same order, so it must be called under walMu. Changes are
+// rolled back if record is not flushed, so wait must be called without walMu
+func (s *Storage) write(record compute.Query, queries []compute.Query) (wait func() error) {
+	var done <-chan error
+	if s.wal != nil {
+		done = s.wal.Write(record)
+	}
+
+	var seq uint64
+	if s.changes != nil {
+		seq = s.changes.Append(queries)
+	}
+
+	return func() error {
+		var err error
+		if done != nil {
+			err = <-done
+		}
+
+		// rollback goes first, so failed change is never resolved while engine still has it
+		if err != nil {
+			s.logger.Error("failed to write wal", zap.Error(err))
+			err = fmt.Errorf("failed to write wal: %w", err)
+			s.rollback(err)
+		}
+
+		if s.changes != nil {
+			s.changes.Resolve(seq, err == nil)
+		}
+		return err
+	}
+}
+
+func (s *Storage) apply(query compute.Query) error {
+	args := query.Arguments
+
+	switch query.CommandType {
+	case compute.SetCommand:
+		if len(args) == 2 {
+			return (*s.engine).Set(args[0], []byte(args[1]))
+		}
+		return s.applyExpiring(args[3], func(engine ExpiringEngine, expireAt time.Time) {
+			_ = engine.SetWithExpiry(args[0], []byte(args[1]), expireAt)
+		})
+	case compute.DelCommand:
+		_, err := (*s.engine).MDelete(args)
+		return err
+	case compute.MSetCommand:
+		pairs := make([]KeyValue, 0, len(args)/2)
+		for i := 0; i+1 < len(args); i += 2 {
+			pairs = append(pairs, KeyValue{Key: args[i], Value: []byte(args[i+1])})
+		}
+		return (*s.engine).MSet(pairs)
+	case compute.PExpireAtCommand:
+		return s.applyExpiring(args[1], func(engine ExpiringEngine, expireAt time.Time) {
+			engine.Expire(args[0], expireAt)
+		})
+	case compute.PersistCommand:
+		engine := s.expiringEngine()
+		if engine == nil {
+			return ErrExpiryNotSupported
+		}
+		engine.Persist(args[0])
+		return nil
+	case compute.IncrByCommand:
+		delta, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		_, err = (*s.engine).Update(args[0], incrBy(delta))
+		return err
+	case compute.IncrByFloatCommand:
+		delta, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return err
+		}
+		_, err = (*s.engine).Update(args[0], incrByFloat(delta))
+		return err
+	case compute.LPushCommand, compute.RPushCommand, compute.LPopCommand, compute.RPopCommand,
+		compute.LTrimCommand:
+		return s.applyList(query)
+	case compute.HSetCommand, compute.HDelCommand, compute.HIncrByCommand:
+		return s.applyHash(query)
+	case compute.SAddCommand, compute.SRemCommand:
+		return s.applySet(query)
+	case compute.ZAddCommand, compute.ZRemCommand, compute.ZIncrByCommand:
+		return s.applySortedSet(query)
+	case compute.ExecCommand:
+		queries, err := decodeTransaction(args)
+		if err != nil {
+			return err
+		}
+
+		for _, query := range queries {
+			if err := s.apply(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected command in wal: %s", query.CommandType)
+	}
+}
+
+func NewStorage(engine *Engine, wal WriteAheadLog, snapshots Snapshotter, logger *zap.Logger) (*Storage, error) {
 	if engine == nil {
 		return nil, errors.New("engine is required")
 	}
 
+	// log is replayed on top of data engine has on start, engine keeping data on its own would
+	// apply changes twice and can't be rolled back after failed write
+	if _, ok := (*engine).(SnapshotEngine); !ok && wal != nil {
+		return nil, ErrWALNotSupported
+	}
+
 	return &Storage{
-		engine: engine,
-		logger: logger,
+		engine:    engine,
+		wal:       wal,
+		snapshots: snapshots,
+		logger:    logger,
+		blocked:   newBlockedPops(),
 	}, nil
 }
@@ -0,0 +1,248 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	inmemory "github.com/kirban/potato-db/internal/db/storage/engines/in-memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStorage_ChangeLog(t *testing.T) {
+	t.Parallel()
+
+	newStorage := func(wal storage.WriteAheadLog, changes *cdc.Log) *storage.Storage {
+		engine, err := inmemory.NewInMemoryEngine(zap.NewNop())
+		require.NoError(t, err)
+
+		return storage.NewDatabaseStorageBuilder(zap.NewNop()).InitEngine(engine).InitWAL(wal).InitChangeLog(changes).Build()
+	}
+
+	wal := &recordingLog{}
+	changes := cdc.NewLog(10)
+	s := newStorage(wal, changes)
+
+	require.NoError(t, s.Set("a", []byte("1")))
+	// nothing is changed, so nothing is logged
+	_, err := s.Del("missing")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Atomic(func(tx *storage.Storage) error {
+		if _, err := tx.IncrBy("n", 1); err != nil {
+			return err
+		}
+		_, err := tx.RPush("l", []byte("x"))
+		return err
+	}))
+
+	cursor, err := changes.Cursor(0)
+	require.NoError(t, err)
+	read, err := cursor.Next(context.Background(), 10)
+	require.NoError(t, err)
+
+	// queries of transaction share a single change like they share a single wal record
+	assert.Equal(t, []cdc.Change{
+		{Seq: 1, Queries: []compute.Query{*compute.NewQuery(compute.SetCommand, []string{"a", "1"})}},
+		{Seq: 2, Queries: []compute.Query{
+			*compute.NewQuery(compute.IncrByCommand, []string{"n", "1"}),
+			*compute.NewQuery(compute.RPushCommand, []string{"l", "x"}),
+		}},
+	}, read)
+	assert.Equal(t, uint64(len(wal.queries)), read[1].Seq)
+
+	// after restart sequence numbers continue from lsn of the latest record
+	recoveredChanges := cdc.NewLog(10)
+	recovered := newStorage(wal, recoveredChanges)
+	require.NoError(t, recovered.Recover())
+	require.NoError(t, recovered.Set("b", []byte("1")))
+
+	_, err = recoveredChanges.Cursor(2)
+	assert.ErrorIs(t, err, cdc.ErrTruncated)
+	cursor, err = recoveredChanges.Cursor(3)
+	require.NoError(t, err)
+	read, err = cursor.Next(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, read, 1)
+	assert.Equal(t, uint64(3), read[0].Seq)
+}
+
+// failingLog fails every write once err is set, like disk which has run out of space
+type failingLog struct {
+	recordingLog
+	err error
+}
+
+func (l *failingLog) Write(query compute.Query) <-chan error {
+	if l.err == nil {
+		return l.recordingLog.Write(query)
+	}
+
+	done := make(chan error, 1)
+	done <- l.err
+	return done
+}
+
+// memorySnapshotter keeps saved snapshots in memory
+type memorySnapshotter struct {
+	lsn  uint64
+	data map[string]storage.Entry
+}
+
+func (m *memorySnapshotter) Save(lsn uint64, data map[string]storage.Entry) error {
+	m.lsn, m.data = lsn, data
+	return nil
+}
+
+func (m *memorySnapshotter) LoadLatest() (uint64, map[string]storage.Entry, bool, error) {
+	return m.lsn, m.data, m.data != nil, nil
+}
+
+func (m *memorySnapshotter) OldestLSN() (uint64, bool) {
+	return m.lsn, m.data != nil
+}
+
+func TestStorage_SnapshotInTransaction(t *testing.T) {
+	t.Parallel()
+
+	engine, err := inmemory.NewInMemoryEngine(zap.NewNop())
+	require.NoError(t, err)
+
+	wal := &recordingLog{}
+	snapshots := &memorySnapshotter{}
+	s := storage.NewDatabaseStorageBuilder(zap.NewNop()).InitEngine(engine).InitWAL(wal).InitSnapshotter(snapshots).Build()
+
+	require.NoError(t, s.Atomic(func(tx *storage.Storage) error {
+		if err := tx.Set("a", []byte("1")); err != nil {
+			return err
+		}
+		if err := tx.Snapshot(); err != nil {
+			return err
+		}
+		return tx.Set("b", []byte("2"))
+	}))
+
+	// snapshot is taken after commit, so it has the whole transaction and lsn of its record
+	assert.Equal(t, uint64(1), snapshots.lsn)
+	assert.Equal(t, []byte("1"), snapshots.data["a"].Value)
+	assert.Equal(t, []byte("2"), snapshots.data["b"].Value)
+
+	disabled := newTestStorage(t, wal)
+	assert.ErrorIs(t, disabled.Atomic(func(tx *storage.Storage) error {
+		return tx.Snapshot()
+	}), storage.ErrSnapshotsDisabled)
+}
+
+func TestStorage_FailedWAL(t *testing.T) {
+	t.Parallel()
+
+	wal := &failingLog{}
+	s := newTestStorage(t, wal)
+	require.NoError(t, s.Set("a", []byte("1")))
+
+	wal.err = errors.New("no space left on device")
+
+	// change which is not on disk is rolled back
+	assert.ErrorIs(t, s.Set("a", []byte("2")), wal.err)
+	value, err := s.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	// the next writes are rejected, even if log would accept them
+	wal.err = nil
+	assert.Error(t, s.Set("b", []byte("1")))
+	assert.Error(t, s.Atomic(func(tx *storage.Storage) error {
+		return tx.Set("b", []byte("1"))
+	}))
+	_, err = s.Get("b")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	assert.Len(t, wal.queries, 1)
+}
+
+// gatedLog keeps writes pending until test resolves them, nothing is replayed
+type gatedLog struct {
+	recordingLog
+	done chan error
+}
+
+func (l *gatedLog) Write(query compute.Query) <-chan error {
+	l.recordingLog.Write(query)
+	return l.done
+}
+
+func (l *gatedLog) Replay(uint64, func(query compute.Query) error) error {
+	return nil
+}
+
+func TestStorage_DumpPendingWrite(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		walErr      error
+		expectedSeq uint64
+		expectedErr bool
+	}{
+		"committed write is in dump": {
+			expectedSeq: 1,
+		},
+		"rolled back write fails dump": {
+			walErr:      errors.New("no space left on device"),
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			engine, err := inmemory.NewInMemoryEngine(zap.NewNop())
+			require.NoError(t, err)
+
+			wal := &gatedLog{done: make(chan error, 1)}
+			changes := cdc.NewLog(10)
+			s := storage.NewDatabaseStorageBuilder(zap.NewNop()).InitEngine(engine).InitWAL(wal).InitChangeLog(changes).Build()
+
+			written := make(chan error, 1)
+			go func() {
+				written <- s.Set("a", []byte("1"))
+			}()
+			require.Eventually(t, func() bool {
+				return changes.Last() == 1
+			}, time.Second, time.Millisecond)
+
+			var seq uint64
+			var data map[string]storage.Entry
+			dumped := make(chan error, 1)
+			go func() {
+				var err error
+				seq, data, err = s.Dump()
+				dumped <- err
+			}()
+
+			// change is applied already, but dump waits until it is written
+			select {
+			case <-dumped:
+				t.Fatal("dump has not waited for pending write")
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			wal.done <- test.walErr
+			assert.ErrorIs(t, <-written, test.walErr)
+
+			err = <-dumped
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSeq, seq)
+			assert.Equal(t, []byte("1"), data["a"].Value)
+		})
+	}
+}
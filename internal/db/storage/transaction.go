@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrWatchNotSupported = errors.New("engine does not support watching keys")
+)
+
+// VersionedEngine is implemented by engines which track versions of keys for optimistic locking
+type VersionedEngine interface {
+	// Version changes whenever key is modified or deleted
+	Version(key string) uint64
+}
+
+// Versions returns current versions of keys
+func (s *Storage) Versions(keys []string) ([]uint64, error) {
+	engine, ok := (*s.engine).(VersionedEngine)
+	if !ok {
+		return nil, ErrWatchNotSupported
+	}
+
+	versions := make([]uint64, len(keys))
+	for i, key := range keys {
+		versions[i] = engine.Version(key)
+	}
+
+	return versions, nil
+}
+
+// Atomic runs fn against storage which logs all changes made by fn as a single wal record,
+// so after crash either all of them are replayed or none. Caller must keep other clients
+// from accessing storage while fn runs
+func (s *Storage) Atomic(fn func(tx *Storage) error) error {
+	if s.wal == nil && s.changes == nil {
+		return fn(s)
+	}
+
+	log := &transactionLog{}
+	tx := &Storage{
+		engine:    s.engine,
+		wal:       log,
+		snapshots: s.snapshots,
+		logger:    s.logger,
+		blocked:   s.blocked,
+		events:    s.events,
+	}
+
+	s.walMu.Lock()
+	if s.failed != nil {
+		s.walMu.Unlock()
+		return s.failed
+	}
+
+	err := fn(tx)
+
+	var wait func() error
+	switch len(log.queries) {
+	case 0:
+	case 1:
+		wait = s.write(log.queries[0], log.queries)
+	default:
+		wait = s.write(encodeTransaction(log.queries), log.queries)
+	}
+	s.walMu.Unlock()
+
+	if wait != nil {
+		if walErr := wait(); walErr != nil {
+			return walErr
+		}
+	}
+
+	// snapshot includes the whole transaction, reply of transaction is already made by then
+	if log.snapshot {
+		if err := s.Snapshot(); err != nil {
+			s.logger.Warn("failed to take snapshot requested by transaction", zap.Error(err))
+		}
+	}
+
+	return err
+}
+
+// transactionLog collects queries of transaction instead of writing them
+type transactionLog struct {
+	queries []compute.Query
+	// snapshot is requested by transaction, it is taken after commit
+	snapshot bool
+}
+
+func (l *transactionLog) Write(query compute.Query) <-chan error {
+	l.queries = append(l.queries, query)
+
+	done := make(chan error)
+	close(done)
+	return done
+}
+
+func (l *transactionLog) Replay(uint64, func(query compute.Query) error) error {
+	return nil
+}
+
+func (l *transactionLog) LastLSN() uint64 {
+	return 0
+}
+
+func (l *transactionLog) Truncate(uint64) error {
+	return nil
+}
+
+// encodeTransaction packs queries into arguments of EXEC record: for every query
+// number of its tokens goes first, then command and arguments
+func encodeTransaction(queries []compute.Query) compute.Query {
+	args := make([]string, 0)
+	for _, query := range queries {
+		args = append(args, strconv.Itoa(len(query.Arguments)+1), string(query.CommandType))
+		args = append(args, query.Arguments...)
+	}
+
+	return *compute.NewQuery(compute.ExecCommand, args)
+}
+
+func decodeTransaction(args []string) ([]compute.Query, error) {
+	queries := make([]compute.Query, 0)
+
+	for len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n >= len(args) {
+			return nil, fmt.Errorf("invalid transaction in wal")
+		}
+
+		queries = append(queries, *compute.NewQuery(compute.CommandType(args[1]), args[2:n+1]))
+		args = args[n+1:]
+	}
+
+	return queries, nil
+}
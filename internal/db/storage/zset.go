@@ -0,0 +1,529 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+const (
+	zsetMaxLevel = 32
+	// every next level holds about a quarter of nodes of the previous one
+	zsetLevelProbability = 4
+	// zsetMemberOverhead approximates memory taken by map slot and skip list node of member
+	zsetMemberOverhead = 96
+)
+
+// ScoredMember is a member of sorted set with its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreBound is a bound of score range, infinite bounds are math.Inf values
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+func (b ScoreBound) lessOrEqual(score float64) bool {
+	if b.Exclusive {
+		return b.Score < score
+	}
+	return b.Score <= score
+}
+
+func (b ScoreBound) greaterOrEqual(score float64) bool {
+	if b.Exclusive {
+		return b.Score > score
+	}
+	return b.Score >= score
+}
+
+type zsetLevel struct {
+	next *zsetNode
+	// span is a number of nodes between node and the next one on the level, it gives rank of node
+	span int
+}
+
+type zsetNode struct {
+	member string
+	score  float64
+	levels []zsetLevel
+}
+
+// before orders nodes by score and then by member like in redis
+func (n *zsetNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// SortedSet keeps members ordered by score, map answers score of member and skip list with
+// spans answers rank and range queries in logarithmic time
+type SortedSet struct {
+	scores map[string]float64
+	head   *zsetNode
+	level  int
+	size   int
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		scores: make(map[string]float64),
+		head:   &zsetNode{levels: make([]zsetLevel, zsetMaxLevel)},
+		level:  1,
+	}
+}
+
+func (z *SortedSet) Type() ValueType {
+	return SortedSetType
+}
+
+func (z *SortedSet) Len() int {
+	return len(z.scores)
+}
+
+func (z *SortedSet) Size() int {
+	return z.size
+}
+
+func (z *SortedSet) Clone() Collection {
+	clone := NewSortedSet()
+	for n := z.head.levels[0].next; n != nil; n = n.levels[0].next {
+		clone.Add(n.member, n.score)
+	}
+
+	return clone
+}
+
+// Items returns members and their scores one after another ordered by score
+func (z *SortedSet) Items() [][]byte {
+	items := make([][]byte, 0, 2*z.Len())
+	for n := z.head.levels[0].next; n != nil; n = n.levels[0].next {
+		items = append(items, []byte(n.member), []byte(FormatScore(n.score)))
+	}
+
+	return items
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// Add sets score of member and reports whether member is new
+func (z *SortedSet) Add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.unlink(member, old)
+	} else {
+		z.size += len(member) + zsetMemberOverhead
+	}
+
+	z.scores[member] = score
+	z.insert(member, score)
+
+	return !exists
+}
+
+// Remove reports whether member existed
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+
+	z.unlink(member, score)
+	delete(z.scores, member)
+	z.size -= len(member) + zsetMemberOverhead
+
+	return true
+}
+
+// Rank returns position of member counted from the lowest score
+func (z *SortedSet) Rank(member string) (int, bool) {
+	score, exists := z.scores[member]
+	if !exists {
+		return 0, false
+	}
+
+	rank := 0
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for next := x.levels[i].next; next != nil && (next.before(score, member) || next.member == member); next = x.levels[i].next {
+			rank += x.levels[i].span
+			x = next
+		}
+	}
+
+	return rank - 1, true
+}
+
+// Range returns members from start to stop exclusive rank
+func (z *SortedSet) Range(start int, stop int) []ScoredMember {
+	members := make([]ScoredMember, 0, max(stop-start, 0))
+	for n := z.byRank(start); n != nil && len(members) < stop-start; n = n.levels[0].next {
+		members = append(members, ScoredMember{Member: n.member, Score: n.score})
+	}
+
+	return members
+}
+
+// RangeByScore returns members with scores between from and to ordered by score
+func (z *SortedSet) RangeByScore(from ScoreBound, to ScoreBound) []ScoredMember {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for next := x.levels[i].next; next != nil && !from.lessOrEqual(next.score); next = x.levels[i].next {
+			x = next
+		}
+	}
+
+	members := make([]ScoredMember, 0)
+	for n := x.levels[0].next; n != nil && to.greaterOrEqual(n.score); n = n.levels[0].next {
+		members = append(members, ScoredMember{Member: n.member, Score: n.score})
+	}
+
+	return members
+}
+
+// byRank returns node of zero based rank, spans let it skip nodes without visiting them
+func (z *SortedSet) byRank(rank int) *zsetNode {
+	traversed := 0
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+
+		if traversed == rank+1 {
+			return x
+		}
+	}
+
+	return nil
+}
+
+func (z *SortedSet) insert(member string, score float64) {
+	var update [zsetMaxLevel]*zsetNode
+	var rank [zsetMaxLevel]int
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	length := len(z.scores) - 1
+	level := zsetRandomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.head
+			update[i].levels[i].span = length
+		}
+		z.level = level
+	}
+
+	n := &zsetNode{member: member, score: score, levels: make([]zsetLevel, level)}
+	for i := 0; i < level; i++ {
+		n.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = n
+
+		n.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// levels above the new node now jump over one more node
+	for i := level; i < z.level; i++ {
+		update[i].levels[i].span++
+	}
+}
+
+// unlink removes node of member from skip list, it doesn't touch scores map
+func (z *SortedSet) unlink(member string, score float64) {
+	var update [zsetMaxLevel]*zsetNode
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].next
+	if x == nil || x.member != member {
+		return
+	}
+
+	for i := 0; i < z.level; i++ {
+		if update[i].levels[i].next == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].next = x.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	for z.level > 1 && z.head.levels[z.level-1].next == nil {
+		z.level--
+	}
+}
+
+func zsetRandomLevel() int {
+	level := 1
+	for level < zsetMaxLevel && rand.IntN(zsetLevelProbability) == 0 {
+		level++
+	}
+
+	return level
+}
+
+// FormatScore formats score like redis does, shortest form which is parsed back to the same value
+func FormatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+}
+
+// ZAdd sets scores of members and returns number of members which are new
+func (s *Storage) ZAdd(key string, members []ScoredMember) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]string, 0, 2*len(members)+1)
+	args = append(args, key)
+	for _, member := range members {
+		args = append(args, FormatScore(member.Score), member.Member)
+	}
+
+	var added int
+	err = s.mutateIf(compute.NewQuery(compute.ZAddCommand, args), func() (bool, error) {
+		var changed bool
+		var err error
+		added, changed, err = addSortedSet(engine, key, members)
+		return changed, err
+	})
+
+	return added, err
+}
+
+// ZRem removes members and returns number of removed ones, sorted set left empty is deleted
+func (s *Storage) ZRem(key string, members ...string) (int, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	query := compute.NewQuery(compute.ZRemCommand, append([]string{key}, members...))
+
+	var removed int
+	err = s.mutateIf(query, func() (bool, error) {
+		var err error
+		removed, err = removeSortedSet(engine, key, members)
+		return removed > 0, err
+	})
+
+	return removed, err
+}
+
+// ZScore returns score of member, ErrKeyNotFound is returned if there is no such member
+func (s *Storage) ZScore(key string, member string) (float64, error) {
+	var score float64
+	var found bool
+
+	err := s.viewSortedSet(key, func(z *SortedSet) {
+		score, found = z.Score(member)
+	})
+
+	if err == nil && !found {
+		err = ErrKeyNotFound
+	}
+
+	return score, err
+}
+
+// ZRank returns rank of member counted from the lowest score, ErrKeyNotFound is returned if
+// there is no such member
+func (s *Storage) ZRank(key string, member string) (int, error) {
+	var rank int
+	var found bool
+
+	err := s.viewSortedSet(key, func(z *SortedSet) {
+		rank, found = z.Rank(member)
+	})
+
+	if err == nil && !found {
+		err = ErrKeyNotFound
+	}
+
+	return rank, err
+}
+
+// ZRange returns members from start to stop rank inclusive, negative ranks are counted from the end
+func (s *Storage) ZRange(key string, start int64, stop int64) ([]ScoredMember, error) {
+	members := make([]ScoredMember, 0)
+
+	err := s.viewSortedSet(key, func(z *SortedSet) {
+		members = z.Range(listBounds(start, stop, z.Len()))
+	})
+
+	return members, err
+}
+
+// ZRangeByScore returns members with scores between from and to ordered by score
+func (s *Storage) ZRangeByScore(key string, from ScoreBound, to ScoreBound) ([]ScoredMember, error) {
+	members := make([]ScoredMember, 0)
+
+	err := s.viewSortedSet(key, func(z *SortedSet) {
+		members = z.RangeByScore(from, to)
+	})
+
+	return members, err
+}
+
+// ZIncrBy atomically adds delta to score of member, missing member gets delta as its score
+func (s *Storage) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return 0, err
+	}
+
+	query := compute.NewQuery(compute.ZIncrByCommand, []string{key, FormatScore(delta), member})
+
+	var score float64
+	err = s.mutate(query, func() error {
+		var err error
+		score, err = incrSortedSet(engine, key, member, delta)
+		return err
+	})
+
+	return score, err
+}
+
+func (s *Storage) viewSortedSet(key string, fn func(z *SortedSet)) error {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return err
+	}
+
+	_, err = engine.ViewCollection(key, SortedSetType, func(c Collection) {
+		fn(c.(*SortedSet))
+	})
+
+	return err
+}
+
+func addSortedSet(engine CollectionEngine, key string, members []ScoredMember) (int, bool, error) {
+	var added int
+	var changed bool
+
+	err := engine.UpdateCollection(key, SortedSetType, func() Collection { return NewSortedSet() }, func(c Collection) (bool, error) {
+		z := c.(*SortedSet)
+		for _, member := range members {
+			if score, exists := z.Score(member.Member); exists && score == member.Score {
+				continue
+			}
+
+			changed = true
+			if z.Add(member.Member, member.Score) {
+				added++
+			}
+		}
+
+		return changed, nil
+	})
+
+	return added, changed, err
+}
+
+func removeSortedSet(engine CollectionEngine, key string, members []string) (int, error) {
+	var removed int
+
+	err := engine.UpdateCollection(key, SortedSetType, nil, func(c Collection) (bool, error) {
+		z := c.(*SortedSet)
+		for _, member := range members {
+			if z.Remove(member) {
+				removed++
+			}
+		}
+
+		return removed > 0, nil
+	})
+
+	return removed, err
+}
+
+func incrSortedSet(engine CollectionEngine, key string, member string, delta float64) (float64, error) {
+	var score float64
+
+	err := engine.UpdateCollection(key, SortedSetType, func() Collection { return NewSortedSet() }, func(c Collection) (bool, error) {
+		z := c.(*SortedSet)
+
+		current, _ := z.Score(member)
+		score = current + delta
+		if math.IsNaN(score) {
+			return false, ErrIncrFloatResult
+		}
+
+		z.Add(member, score)
+		return true, nil
+	})
+
+	return score, err
+}
+
+func (s *Storage) applySortedSet(query compute.Query) error {
+	engine, err := s.collectionEngine()
+	if err != nil {
+		return err
+	}
+
+	args := query.Arguments
+
+	switch query.CommandType {
+	case compute.ZAddCommand:
+		members := make([]ScoredMember, 0, len(args)/2)
+		for i := 1; i+1 < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return err
+			}
+			members = append(members, ScoredMember{Member: args[i+1], Score: score})
+		}
+		_, _, err = addSortedSet(engine, args[0], members)
+		return err
+	case compute.ZRemCommand:
+		_, err = removeSortedSet(engine, args[0], args[1:])
+		return err
+	case compute.ZIncrByCommand:
+		delta, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return err
+		}
+		_, err = incrSortedSet(engine, args[0], args[2], delta)
+		return err
+	default:
+		return fmt.Errorf("unexpected sorted set command in wal: %s", query.CommandType)
+	}
+}
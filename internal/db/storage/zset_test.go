@@ -0,0 +1,190 @@
+package storage_test
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortedSet_RankAndRange(t *testing.T) {
+	t.Parallel()
+
+	z := storage.NewSortedSet()
+	expected := make([]storage.ScoredMember, 0)
+
+	// scores collide, so members with the same score are ordered by name
+	for _, i := range rand.Perm(200) {
+		member := storage.ScoredMember{Member: fmt.Sprintf("m%03d", i), Score: float64(i / 3)}
+		assert.True(t, z.Add(member.Member, member.Score))
+		expected = append(expected, member)
+	}
+
+	// every third member is moved or removed, so spans are updated by both unlink and insert
+	for i := 0; i < len(expected); i += 3 {
+		if i%2 == 0 {
+			assert.True(t, z.Remove(expected[i].Member))
+			expected[i].Member = ""
+		} else {
+			assert.False(t, z.Add(expected[i].Member, -float64(i)))
+			expected[i].Score = -float64(i)
+		}
+	}
+
+	expected = slices.DeleteFunc(expected, func(m storage.ScoredMember) bool { return m.Member == "" })
+	slices.SortFunc(expected, func(a, b storage.ScoredMember) int {
+		return cmp.Or(cmp.Compare(a.Score, b.Score), strings.Compare(a.Member, b.Member))
+	})
+
+	require.Equal(t, len(expected), z.Len())
+	assert.Equal(t, expected, z.Range(0, z.Len()))
+
+	for rank, member := range expected {
+		actual, ok := z.Rank(member.Member)
+		require.True(t, ok)
+		assert.Equal(t, rank, actual, member.Member)
+	}
+
+	assert.Equal(t, expected[10:15], z.Range(10, 15))
+	assert.Empty(t, z.Range(z.Len(), z.Len()+5))
+
+	_, ok := z.Rank("missing")
+	assert.False(t, ok)
+
+	restored, err := storage.NewCollection(storage.SortedSetType, z.Items())
+	require.NoError(t, err)
+	assert.Equal(t, z.Items(), restored.Items())
+	assert.Equal(t, z.Size(), restored.Size())
+}
+
+func TestSortedSet_RangeByScore(t *testing.T) {
+	t.Parallel()
+
+	z := storage.NewSortedSet()
+	z.Add("a", 1)
+	z.Add("b", 2)
+	z.Add("c", 2)
+	z.Add("d", 3)
+	z.Add("low", math.Inf(-1))
+
+	tests := map[string]struct {
+		from     storage.ScoreBound
+		to       storage.ScoreBound
+		expected []string
+	}{
+		"inclusive":      {from: storage.ScoreBound{Score: 2}, to: storage.ScoreBound{Score: 3}, expected: []string{"b", "c", "d"}},
+		"exclusive from": {from: storage.ScoreBound{Score: 2, Exclusive: true}, to: storage.ScoreBound{Score: 3}, expected: []string{"d"}},
+		"exclusive to":   {from: storage.ScoreBound{Score: 1}, to: storage.ScoreBound{Score: 2, Exclusive: true}, expected: []string{"a"}},
+		"infinite":       {from: storage.ScoreBound{Score: math.Inf(-1)}, to: storage.ScoreBound{Score: math.Inf(1)}, expected: []string{"low", "a", "b", "c", "d"}},
+		"empty":          {from: storage.ScoreBound{Score: 3}, to: storage.ScoreBound{Score: 1}, expected: []string{}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			members := make([]string, 0)
+			for _, member := range z.RangeByScore(tc.from, tc.to) {
+				members = append(members, member.Member)
+			}
+			assert.Equal(t, tc.expected, members)
+		})
+	}
+}
+
+func TestStorage_SortedSetCommands(t *testing.T) {
+	t.Parallel()
+
+	s := newTestStorage(t, &recordingLog{})
+
+	added, err := s.ZAdd("board", []storage.ScoredMember{{Member: "alice", Score: 10}, {Member: "bob", Score: 5}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	// updated score is not counted as added
+	added, err = s.ZAdd("board", []storage.ScoredMember{{Member: "alice", Score: 1}, {Member: "carol", Score: 7.5}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	score, err := s.ZIncrBy("board", "bob", 10)
+	require.NoError(t, err)
+	assert.Equal(t, float64(15), score)
+
+	members, err := s.ZRange("board", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ScoredMember{{Member: "alice", Score: 1}, {Member: "carol", Score: 7.5}, {Member: "bob", Score: 15}}, members)
+
+	members, err = s.ZRange("board", -2, -2)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ScoredMember{{Member: "carol", Score: 7.5}}, members)
+
+	rank, err := s.ZRank("board", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, 2, rank)
+
+	_, err = s.ZRank("board", "dave")
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	score, err = s.ZScore("board", "carol")
+	require.NoError(t, err)
+	assert.Equal(t, 7.5, score)
+
+	_, err = s.ZIncrBy("board", "inf", math.Inf(1))
+	require.NoError(t, err)
+	_, err = s.ZIncrBy("board", "inf", math.Inf(-1))
+	assert.ErrorIs(t, err, storage.ErrIncrFloatResult)
+
+	removed, err := s.ZRem("board", "alice", "inf", "dave")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	members, err = s.ZRangeByScore("board", storage.ScoreBound{Score: 7.5, Exclusive: true}, storage.ScoreBound{Score: math.Inf(1)})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ScoredMember{{Member: "bob", Score: 15}}, members)
+
+	_, err = s.SAdd("board", "x")
+	assert.ErrorIs(t, err, storage.ErrWrongType)
+}
+
+func TestStorage_SetReplay(t *testing.T) {
+	t.Parallel()
+
+	wal := &recordingLog{}
+	s := newTestStorage(t, wal)
+
+	_, err := s.SAdd("tags", "go", "db")
+	require.NoError(t, err)
+	_, err = s.SRem("tags", "go")
+	require.NoError(t, err)
+	_, err = s.ZAdd("board", []storage.ScoredMember{{Member: "alice", Score: 0.1}, {Member: "bob", Score: math.Inf(-1)}})
+	require.NoError(t, err)
+	score, err := s.ZIncrBy("board", "alice", 0.2)
+	require.NoError(t, err)
+	_, err = s.ZRem("board", "bob")
+	require.NoError(t, err)
+
+	// nothing is logged for commands which change nothing
+	_, err = s.SAdd("tags", "db")
+	require.NoError(t, err)
+	_, err = s.ZAdd("board", []storage.ScoredMember{{Member: "alice", Score: score}})
+	require.NoError(t, err)
+	assert.Len(t, wal.queries, 5)
+
+	recovered := newTestStorage(t, wal)
+	require.NoError(t, recovered.Recover())
+
+	members, err := recovered.SMembers("tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db"}, members)
+
+	scored, err := recovered.ZRange("board", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ScoredMember{{Member: "alice", Score: score}}, scored)
+}
@@ -0,0 +1,111 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+var (
+	ErrCorruptedRecord = errors.New("wal: corrupted record")
+)
+
+// record header: payload length + crc32 of payload
+const recordHeaderSize = 8
+
+type Record struct {
+	LSN   uint64
+	Query compute.Query
+}
+
+func (r *Record) encode() []byte {
+	payload := make([]byte, 0, 64)
+	payload = binary.BigEndian.AppendUint64(payload, r.LSN)
+	payload = appendString(payload, string(r.Query.CommandType))
+	payload = binary.AppendUvarint(payload, uint64(len(r.Query.Arguments)))
+	for _, arg := range r.Query.Arguments {
+		payload = appendString(payload, arg)
+	}
+
+	data := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+
+	return append(data, payload...)
+}
+
+// readRecord reads next record from reader and returns its encoded size, io.EOF is returned
+// only on clean end of segment
+func readRecord(reader *bufio.Reader) (*Record, int, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, ErrCorruptedRecord
+	}
+
+	record, err := decodePayload(payload)
+	return record, recordHeaderSize + len(payload), err
+}
+
+func decodePayload(payload []byte) (*Record, error) {
+	if len(payload) < 8 {
+		return nil, ErrCorruptedRecord
+	}
+
+	record := &Record{LSN: binary.BigEndian.Uint64(payload)}
+	rest := payload[8:]
+
+	command, rest, err := readString(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	count, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, ErrCorruptedRecord
+	}
+	rest = rest[n:]
+
+	args := make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var arg string
+		arg, rest, err = readString(rest)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	record.Query = *compute.NewQuery(compute.CommandType(command), args)
+	return record, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < size {
+		return "", nil, ErrCorruptedRecord
+	}
+
+	end := n + int(size)
+	return string(buf[n:end]), buf[end:], nil
+}
@@ -0,0 +1,126 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentPrefix    = "wal_"
+	segmentExtension = ".log"
+)
+
+type segment struct {
+	file *os.File
+	size int
+}
+
+func segmentName(firstLSN uint64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, firstLSN, segmentExtension)
+}
+
+func createSegment(directory string, firstLSN uint64) (*segment, error) {
+	path := filepath.Join(directory, segmentName(firstLSN))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create wal segment: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to stat wal segment: %w", err)
+	}
+
+	return &segment{
+		file: file,
+		size: int(stat.Size()),
+	}, nil
+}
+
+func (s *segment) write(data []byte) error {
+	n, err := s.file.Write(data)
+	s.size += n
+
+	return err
+}
+
+func (s *segment) truncate(size int) error {
+	if err := s.file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+
+	return s.file.Sync()
+}
+
+func (s *segment) sync() error {
+	return s.file.Sync()
+}
+
+func (s *segment) close() error {
+	return s.file.Close()
+}
+
+// truncateSegment cuts segment to size, segment left without records is removed
+func truncateSegment(path string, size int64) error {
+	if size == 0 {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove wal segment: %w", err)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate wal segment: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal segment: %w", err)
+	}
+	return nil
+}
+
+// listSegments returns segment file paths sorted by first lsn
+func listSegments(directory string) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		segments = append(segments, filepath.Join(directory, name))
+	}
+
+	// zero-padded lsn in names keeps lexical order equal to log order
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func segmentFirstLSN(path string) (uint64, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), segmentPrefix), segmentExtension)
+	lsn, err := strconv.ParseUint(name, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid wal segment name %s: %w", path, err)
+	}
+
+	return lsn, nil
+}
@@ -0,0 +1,326 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/helpers"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidLogger = errors.New("invalid logger")
+	ErrInvalidConfig = errors.New("invalid wal config")
+	ErrClosed        = errors.New("wal is closed")
+	ErrFailed        = errors.New("wal has failed")
+)
+
+type pendingRecord struct {
+	record Record
+	done   chan error
+}
+
+// WAL groups written records into batches and flushes them to segment files
+// either when batch is full or when batch timeout expires
+type WAL struct {
+	logger         *zap.Logger
+	directory      string
+	maxSegmentSize int
+	batchSize      int
+	batchTimeout   time.Duration
+
+	mu      sync.Mutex
+	batch   []pendingRecord
+	lastLSN uint64
+	closed  bool
+	// err is set once batch has failed, log on disk may lack records applied by callers then,
+	// so the rest are rejected until restart
+	err error
+
+	flushSignal chan struct{}
+	// segment is touched only by flushing goroutine
+	segment *segment
+}
+
+func NewWAL(logger *zap.Logger, cfg *config.WalConfigOptions) (*WAL, error) {
+	if logger == nil {
+		return nil, ErrInvalidLogger
+	}
+
+	if cfg == nil || cfg.FlushingBatchSize <= 0 || cfg.FlushingBatchTimeout <= 0 || cfg.DataDirectory == "" {
+		return nil, ErrInvalidConfig
+	}
+
+	maxSegmentSize, err := helpers.ParseSize(cfg.MaxSegmentSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse wal max segment size: %w", err)
+	}
+
+	if err := os.MkdirAll(cfg.DataDirectory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+
+	return &WAL{
+		logger:         logger,
+		directory:      cfg.DataDirectory,
+		maxSegmentSize: maxSegmentSize,
+		batchSize:      cfg.FlushingBatchSize,
+		batchTimeout:   cfg.FlushingBatchTimeout,
+		batch:          make([]pendingRecord, 0, cfg.FlushingBatchSize),
+		flushSignal:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Write appends query to current batch. Returned channel receives flush result
+// once the batch containing query is persisted on disk
+func (w *WAL) Write(query compute.Query) <-chan error {
+	done := make(chan error, 1)
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		done <- ErrClosed
+		return done
+	}
+
+	if w.err != nil {
+		w.mu.Unlock()
+		done <- w.err
+		return done
+	}
+
+	w.lastLSN++
+	w.batch = append(w.batch, pendingRecord{
+		record: Record{LSN: w.lastLSN, Query: query},
+		done:   done,
+	})
+	full := len(w.batch) >= w.batchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.flushSignal <- struct{}{}:
+		default:
+		}
+	}
+
+	return done
+}
+
+// Start runs flushing loop until ctx is done, remaining batch is flushed before exit
+func (w *WAL) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.batchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			w.closed = true
+			w.mu.Unlock()
+
+			w.flush()
+			w.closeSegment()
+			w.logger.Info("wal stopped")
+			return
+		case <-ticker.C:
+			w.flush()
+		case <-w.flushSignal:
+			w.flush()
+		}
+	}
+}
+
+// LastLSN returns lsn of the latest written record
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lastLSN
+}
+
+// Truncate removes segments containing only records with lsn not greater than given one
+func (w *WAL) Truncate(lsn uint64) error {
+	segments, err := listSegments(w.directory)
+	if err != nil {
+		return fmt.Errorf("failed to list wal segments: %w", err)
+	}
+
+	// the last segment may be still written, so it is never removed
+	for i := 0; i < len(segments)-1; i++ {
+		nextFirstLSN, err := segmentFirstLSN(segments[i+1])
+		if err != nil {
+			return err
+		}
+
+		if nextFirstLSN > lsn+1 {
+			break
+		}
+
+		if err := os.Remove(segments[i]); err != nil {
+			return fmt.Errorf("failed to remove wal segment: %w", err)
+		}
+		w.logger.Debug("wal segment removed", zap.String("segment", segments[i]))
+	}
+
+	return nil
+}
+
+// Replay reads all segments in log order and passes records with lsn greater than fromLSN to apply
+func (w *WAL) Replay(fromLSN uint64, apply func(query compute.Query) error) error {
+	segments, err := listSegments(w.directory)
+	if err != nil {
+		return fmt.Errorf("failed to list wal segments: %w", err)
+	}
+
+	lastLSN := fromLSN
+	var replayed int
+
+	for _, path := range segments {
+		last, count, valid, err := replaySegment(path, lastLSN, apply)
+		lastLSN, replayed = last, replayed+count
+
+		if errors.Is(err, ErrCorruptedRecord) {
+			// torn write at the tail of segment, records after it were never acknowledged. It is
+			// cut off, so records written after replay never follow garbage
+			w.logger.Warn("wal segment has corrupted tail", zap.String("segment", path), zap.Int64("valid", valid))
+			if err := truncateSegment(path, valid); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	w.lastLSN = lastLSN
+	w.mu.Unlock()
+
+	w.logger.Info("wal replayed", zap.Int("segments", len(segments)), zap.Int("records", replayed), zap.Uint64("lsn", lastLSN))
+	return nil
+}
+
+// replaySegment applies records of segment after lastLSN, it returns lsn of the last record,
+// number of applied records and size of segment part holding valid records
+func replaySegment(path string, lastLSN uint64, apply func(query compute.Query) error) (uint64, int, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return lastLSN, 0, 0, fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	count := 0
+	var valid int64
+
+	for {
+		record, size, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return lastLSN, count, valid, nil
+		} else if err != nil {
+			return lastLSN, count, valid, err
+		}
+		valid += int64(size)
+
+		if record.LSN <= lastLSN {
+			continue
+		}
+
+		if err := apply(record.Query); err != nil {
+			return lastLSN, count, valid, fmt.Errorf("failed to apply wal record %d: %w", record.LSN, err)
+		}
+
+		lastLSN = record.LSN
+		count++
+	}
+}
+
+func (w *WAL) flush() {
+	w.mu.Lock()
+	batch := w.batch
+	w.batch = make([]pendingRecord, 0, w.batchSize)
+	err := w.err
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err == nil {
+		err = w.writeBatch(batch)
+	}
+
+	if err != nil && !errors.Is(err, ErrFailed) {
+		w.logger.Error("failed to flush wal batch", zap.Error(err))
+		err = fmt.Errorf("%w: %w", ErrFailed, err)
+
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+	}
+
+	for _, pending := range batch {
+		pending.done <- err
+	}
+}
+
+func (w *WAL) writeBatch(batch []pendingRecord) error {
+	if w.segment == nil {
+		s, err := createSegment(w.directory, batch[0].record.LSN)
+		if err != nil {
+			return err
+		}
+		w.segment = s
+	}
+
+	data := make([]byte, 0, len(batch)*64)
+	for _, pending := range batch {
+		data = append(data, pending.record.encode()...)
+	}
+
+	size := w.segment.size
+	if err := w.segment.write(data); err != nil {
+		w.discard(size)
+		return fmt.Errorf("failed to write wal segment: %w", err)
+	}
+
+	if err := w.segment.sync(); err != nil {
+		w.discard(size)
+		return fmt.Errorf("failed to sync wal segment: %w", err)
+	}
+
+	if w.segment.size >= w.maxSegmentSize {
+		w.closeSegment()
+	}
+
+	return nil
+}
+
+// discard cuts failed batch off the segment, so it is not replayed after callers were told it
+// has failed. Replay still drops partial record if it can't be cut
+func (w *WAL) discard(size int) {
+	if err := w.segment.truncate(size); err != nil {
+		w.logger.Error("failed to discard wal batch", zap.Error(err))
+	}
+	w.closeSegment()
+}
+
+func (w *WAL) closeSegment() {
+	if w.segment == nil {
+		return
+	}
+
+	if err := w.segment.close(); err != nil {
+		w.logger.Error("failed to close wal segment", zap.Error(err))
+	}
+	w.segment = nil
+}
@@ -0,0 +1,295 @@
+package wal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestConfig(t *testing.T) *config.WalConfigOptions {
+	return &config.WalConfigOptions{
+		FlushingBatchSize:    2,
+		FlushingBatchTimeout: 10 * time.Millisecond,
+		MaxSegmentSize:       "1KB",
+		DataDirectory:        t.TempDir(),
+	}
+}
+
+func startWAL(t *testing.T, cfg *config.WalConfigOptions) (*WAL, context.CancelFunc, chan struct{}) {
+	w, err := NewWAL(zap.NewNop(), cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(stopped)
+	}()
+
+	return w, cancel, stopped
+}
+
+func replayAll(t *testing.T, cfg *config.WalConfigOptions) []compute.Query {
+	w, err := NewWAL(zap.NewNop(), cfg)
+	require.NoError(t, err)
+
+	var queries []compute.Query
+	err = w.Replay(0, func(q compute.Query) error {
+		queries = append(queries, q)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return queries
+}
+
+func TestNewWAL(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		logger      *zap.Logger
+		config      *config.WalConfigOptions
+		expectedErr bool
+	}{
+		"valid config": {
+			logger: zap.NewNop(),
+			config: newTestConfig(t),
+		},
+		"nil logger": {
+			config:      newTestConfig(t),
+			expectedErr: true,
+		},
+		"nil config": {
+			logger:      zap.NewNop(),
+			expectedErr: true,
+		},
+		"invalid segment size": {
+			logger: zap.NewNop(),
+			config: &config.WalConfigOptions{
+				FlushingBatchSize:    1,
+				FlushingBatchTimeout: time.Millisecond,
+				MaxSegmentSize:       "10QB",
+				DataDirectory:        t.TempDir(),
+			},
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w, err := NewWAL(tc.logger, tc.config)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				assert.Nil(t, w)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, w)
+			}
+		})
+	}
+}
+
+func TestWAL_WriteAndReplay(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+
+	written := []*compute.Query{
+		compute.NewQuery(compute.SetCommand, []string{"foo", "bar"}),
+		compute.NewQuery(compute.SetCommand, []string{"baz", "qux"}),
+		compute.NewQuery(compute.DelCommand, []string{"foo"}),
+	}
+
+	for _, q := range written {
+		select {
+		case err := <-w.Write(*q):
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("record was not flushed")
+		}
+	}
+
+	cancel()
+	<-stopped
+
+	replayed := replayAll(t, cfg)
+	require.Len(t, replayed, len(written))
+	for i, q := range written {
+		assert.Equal(t, *q, replayed[i])
+	}
+}
+
+func TestWAL_WriteAfterStop(t *testing.T) {
+	t.Parallel()
+
+	w, cancel, stopped := startWAL(t, newTestConfig(t))
+	cancel()
+	<-stopped
+
+	err := <-w.Write(*compute.NewQuery(compute.DelCommand, []string{"foo"}))
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestWAL_WriteAfterFailure(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+	require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"foo", "bar"})))
+
+	// segment can't be written anymore, flushing goroutine is idle until the next write
+	require.NoError(t, w.segment.file.Close())
+
+	err := <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"foo", "baz"}))
+	assert.ErrorIs(t, err, ErrFailed)
+	err = <-w.Write(*compute.NewQuery(compute.DelCommand, []string{"foo"}))
+	assert.ErrorIs(t, err, ErrFailed)
+
+	cancel()
+	<-stopped
+
+	replayed := replayAll(t, cfg)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, []string{"foo", "bar"}, replayed[0].Arguments)
+}
+
+func TestWAL_SegmentRotation(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+
+	value := string(make([]byte, 300))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"key", value})))
+	}
+
+	cancel()
+	<-stopped
+
+	segments, err := listSegments(cfg.DataDirectory)
+	require.NoError(t, err)
+	assert.Greater(t, len(segments), 1)
+	assert.Len(t, replayAll(t, cfg), 10)
+}
+
+func TestWAL_ReplayCorruptedTail(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+
+	require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"foo", "bar"})))
+	cancel()
+	<-stopped
+
+	segments, err := listSegments(cfg.DataDirectory)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// simulate torn write of the next record
+	record := (&Record{LSN: 2, Query: *compute.NewQuery(compute.DelCommand, []string{"foo"})}).encode()
+	file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write(record[:len(record)-3])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	replayed := replayAll(t, cfg)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, compute.SetCommand, replayed[0].CommandType)
+}
+
+func TestWAL_WriteAfterCorruptedTail(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+	require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"foo", "bar"})))
+	cancel()
+	<-stopped
+
+	// first record of the next segment is torn, the next write gets the same segment name
+	record := (&Record{LSN: 2, Query: *compute.NewQuery(compute.DelCommand, []string{"foo"})}).encode()
+	path := filepath.Join(cfg.DataDirectory, segmentName(2))
+	require.NoError(t, os.WriteFile(path, record[:len(record)-3], 0o644))
+
+	w, cancel, stopped = startWAL(t, cfg)
+	require.NoError(t, w.Replay(0, func(compute.Query) error { return nil }))
+	require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"baz", "qux"})))
+	cancel()
+	<-stopped
+
+	replayed := replayAll(t, cfg)
+	require.Len(t, replayed, 2)
+	assert.Equal(t, []string{"foo", "bar"}, replayed[0].Arguments)
+	assert.Equal(t, []string{"baz", "qux"}, replayed[1].Arguments)
+}
+
+func TestWAL_ReplayContinuesLSN(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+	require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"foo", "bar"})))
+	cancel()
+	<-stopped
+
+	w, cancel, stopped = startWAL(t, cfg)
+	require.NoError(t, w.Replay(0, func(compute.Query) error { return nil }))
+	require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"foo", "baz"})))
+	cancel()
+	<-stopped
+
+	_, err := os.Stat(filepath.Join(cfg.DataDirectory, segmentName(2)))
+	assert.NoError(t, err)
+
+	replayed := replayAll(t, cfg)
+	require.Len(t, replayed, 2)
+	assert.Equal(t, "baz", replayed[1].Arguments[1])
+}
+
+func TestWAL_Truncate(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(t)
+	w, cancel, stopped := startWAL(t, cfg)
+
+	value := string(make([]byte, 300))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, <-w.Write(*compute.NewQuery(compute.SetCommand, []string{"key", value})))
+	}
+
+	cancel()
+	<-stopped
+
+	require.NoError(t, w.Truncate(6))
+
+	segments, err := listSegments(cfg.DataDirectory)
+	require.NoError(t, err)
+	first, err := segmentFirstLSN(segments[0])
+	require.NoError(t, err)
+	assert.LessOrEqual(t, first, uint64(7))
+
+	w, err = NewWAL(zap.NewNop(), cfg)
+	require.NoError(t, err)
+
+	var replayed int
+	require.NoError(t, w.Replay(6, func(compute.Query) error {
+		replayed++
+		return nil
+	}))
+	assert.Equal(t, 4, replayed)
+	assert.Equal(t, uint64(10), w.LastLSN())
+}
@@ -0,0 +1,91 @@
+package helpers
+
+// MatchGlob reports whether s matches redis style glob pattern: '*' matches any sequence,
+// '?' matches any single byte, '[abc]', '[a-z]' and '[^a]' match classes of bytes and
+// backslash escapes special characters. Malformed classes match literally up to the end
+func MatchGlob(pattern string, s string) bool {
+	// position to return to when the last '*' has to consume one more byte
+	starPattern, starS := -1, 0
+	p, i := 0, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starPattern, starS = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, s[i]); ok {
+					p = next
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+
+		if starPattern < 0 {
+			return false
+		}
+
+		starS++
+		p, i = starPattern+1, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchClass matches c against class starting at pattern[start] == '[' and returns
+// position right after the class
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	matched := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			matched = matched || pattern[p] == c
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			p += 2
+		default:
+			matched = matched || pattern[p] == c
+		}
+	}
+
+	// unterminated class consumes the rest of pattern like in redis
+	if p < len(pattern) {
+		p++
+	}
+
+	return p, matched != negate
+}
@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		"everything":            {pattern: "*", s: "foo", expected: true},
+		"empty string":          {pattern: "*", s: "", expected: true},
+		"literal":               {pattern: "foo", s: "foo", expected: true},
+		"literal mismatch":      {pattern: "foo", s: "fo", expected: false},
+		"prefix":                {pattern: "user:*", s: "user:1", expected: true},
+		"star in the middle":    {pattern: "a*b*c", s: "axxbyyc", expected: true},
+		"star backtracking":     {pattern: "a*bc", s: "abcbc", expected: true},
+		"star needs suffix":     {pattern: "a*b", s: "abc", expected: false},
+		"question mark":         {pattern: "h?llo", s: "hallo", expected: true},
+		"question mark no byte": {pattern: "h?llo", s: "hllo", expected: false},
+		"class":                 {pattern: "h[ae]llo", s: "hello", expected: true},
+		"class mismatch":        {pattern: "h[ae]llo", s: "hillo", expected: false},
+		"negated class":         {pattern: "h[^e]llo", s: "hallo", expected: true},
+		"negated class match":   {pattern: "h[^e]llo", s: "hello", expected: false},
+		"range":                 {pattern: "key[0-9]", s: "key7", expected: true},
+		"range mismatch":        {pattern: "key[0-9]", s: "keyx", expected: false},
+		"escaped star":          {pattern: `a\*`, s: "a*", expected: true},
+		"escaped star literal":  {pattern: `a\*`, s: "ab", expected: false},
+		"escape in class":       {pattern: `[\]]`, s: "]", expected: true},
+		"unterminated class":    {pattern: "[ab", s: "a", expected: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, MatchGlob(tc.pattern, tc.s))
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"go.uber.org/zap"
+)
+
+// cdcBatchSize limits number of changes read from log at once
+const cdcBatchSize = 128
+
+// streamChanges pushes committed changes to client until it disconnects, server stops or client
+// falls behind retained changes. Connection serves nothing else afterwards, so it is closed then
+func (s *TCPServer) streamChanges(ctx context.Context, reader io.Reader, cursor *cdc.Cursor, push func(compute.Result) error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// client is not expected to send anything, reading only notices that it has disconnected
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+		cancel()
+	}()
+
+	for {
+		changes, err := cursor.Next(ctx, cdcBatchSize)
+		if errors.Is(err, context.Canceled) {
+			return
+		} else if err != nil {
+			// consumer resumes from the last received sequence number on a new connection
+			s.logger.Warn("cdc stream stopped", zap.Error(err))
+			_ = push(compute.ErrorResult(err))
+			return
+		}
+
+		for _, change := range changes {
+			if err := push(changeResult(change)); err != nil {
+				s.logger.Error("failed to push change", zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+// changeResult replies change as its sequence number followed by queries, each of them is
+// an array of command and arguments
+func changeResult(change cdc.Change) compute.Result {
+	items := make([]compute.Result, 0, len(change.Queries)+1)
+	items = append(items, compute.IntegerResult(int64(change.Seq)))
+
+	for _, query := range change.Queries {
+		tokens := make([]compute.Result, 0, len(query.Arguments)+1)
+		tokens = append(tokens, compute.StringResult(string(query.CommandType)))
+		for _, arg := range query.Arguments {
+			tokens = append(tokens, compute.StringResult(arg))
+		}
+		items = append(items, compute.ArrayResult(tokens))
+	}
+
+	return compute.ArrayResult(items)
+}
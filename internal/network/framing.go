@@ -0,0 +1,274 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+)
+
+var (
+	ErrInvalidFrame    = errors.New("protocol error: invalid frame")
+	ErrFrameTooLarge   = errors.New("protocol error: frame is too large")
+	ErrHandshakeFailed = errors.New("protocol error: framed protocol handshake failed")
+)
+
+const (
+	framedVersion   = 1
+	frameHeaderSize = 4
+)
+
+// framedHandshake is sent by client right after connect to switch connection to length-prefixed
+// framing and is echoed back by server. It starts with zero byte, which never begins text or RESP
+// request, so server in auto mode tells protocols apart by the first byte
+var framedHandshake = []byte{0, 'P', 'D', 'B', framedVersion}
+
+// result types on the wire, they are fixed here so compute.ResultType can change freely
+const (
+	frameOk byte = iota + 1
+	frameStatus
+	frameString
+	frameInteger
+	frameNil
+	frameError
+	frameArray
+)
+
+// Frame is 4 bytes big endian payload size followed by payload. Request payload is
+// uvarint number of arguments, then every argument as uvarint size and raw bytes.
+// Response payload is a result type byte followed by its value encoded the same way,
+// errors carry message, arrays carry number of items and then items
+type frameReader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+func newFrameReader(reader *bufio.Reader, maxSize int) *frameReader {
+	return &frameReader{
+		reader:  reader,
+		maxSize: maxSize,
+	}
+}
+
+// ReadFrame returns payload of the next frame, io.EOF is returned only between frames
+func (r *frameReader) ReadFrame() ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if uint64(size) > uint64(r.maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return payload, nil
+}
+
+// ReadCommand returns next command, arguments are arbitrary bytes
+func (r *frameReader) ReadCommand() ([]string, error) {
+	payload, err := r.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	count, payload, err := readUvarint(payload)
+	if err != nil || count == 0 || count > uint64(len(payload)) {
+		return nil, ErrInvalidFrame
+	}
+
+	tokens := make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var token []byte
+		if token, payload, err = readBytes(payload); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, string(token))
+	}
+
+	if len(payload) != 0 {
+		return nil, ErrInvalidFrame
+	}
+
+	return tokens, nil
+}
+
+func (r *frameReader) ReadResult() (compute.Result, error) {
+	payload, err := r.ReadFrame()
+	if err != nil {
+		return compute.Result{}, err
+	}
+
+	result, rest, err := decodeResult(payload, 0)
+	if err != nil {
+		return compute.Result{}, err
+	}
+
+	if len(rest) != 0 {
+		return compute.Result{}, ErrInvalidFrame
+	}
+
+	return result, nil
+}
+
+type frameWriter struct {
+	writer *bufio.Writer
+	buf    []byte
+}
+
+func newFrameWriter(writer *bufio.Writer) *frameWriter {
+	return &frameWriter{writer: writer}
+}
+
+func (w *frameWriter) WriteCommand(args [][]byte) error {
+	payload := binary.AppendUvarint(w.header(), uint64(len(args)))
+	for _, arg := range args {
+		payload = appendBytes(payload, arg)
+	}
+
+	return w.writeFrame(payload)
+}
+
+func (w *frameWriter) WriteResult(result compute.Result) error {
+	return w.writeFrame(appendResult(w.header(), result))
+}
+
+func (w *frameWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// header resets buffer leaving space for payload size
+func (w *frameWriter) header() []byte {
+	return append(w.buf[:0], make([]byte, frameHeaderSize)...)
+}
+
+func (w *frameWriter) writeFrame(frame []byte) error {
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-frameHeaderSize))
+	w.buf = frame
+
+	_, err := w.writer.Write(frame)
+	return err
+}
+
+func appendResult(buf []byte, result compute.Result) []byte {
+	switch result.Type {
+	case compute.OkResultType:
+		return append(buf, frameOk)
+	case compute.StatusResultType:
+		return appendBytes(append(buf, frameStatus), []byte(result.Value))
+	case compute.StringResultType:
+		return appendBytes(append(buf, frameString), []byte(result.Value))
+	case compute.IntegerResultType:
+		return binary.AppendVarint(append(buf, frameInteger), result.Int)
+	case compute.NilResultType:
+		return appendBytes(append(buf, frameNil), []byte(errorMessage(result.Err)))
+	case compute.ArrayResultType:
+		buf = binary.AppendUvarint(append(buf, frameArray), uint64(len(result.Array)))
+		for _, item := range result.Array {
+			buf = appendResult(buf, item)
+		}
+		return buf
+	default:
+		message := errorMessage(result.Err)
+		if message == "" {
+			message = "unknown error"
+		}
+		return appendBytes(append(buf, frameError), []byte(message))
+	}
+}
+
+// maxResultDepth limits nesting of arrays, so malformed response can't exhaust stack
+const maxResultDepth = 32
+
+func decodeResult(data []byte, depth int) (compute.Result, []byte, error) {
+	if len(data) == 0 || depth > maxResultDepth {
+		return compute.Result{}, nil, ErrInvalidFrame
+	}
+
+	resultType, data := data[0], data[1:]
+
+	switch resultType {
+	case frameOk:
+		return compute.OkResult(), data, nil
+	case frameStatus, frameString, frameNil, frameError:
+		value, rest, err := readBytes(data)
+		if err != nil {
+			return compute.Result{}, nil, err
+		}
+
+		switch resultType {
+		case frameStatus:
+			return compute.StatusResult(string(value)), rest, nil
+		case frameString:
+			return compute.StringResult(string(value)), rest, nil
+		case frameNil:
+			if len(value) == 0 {
+				return compute.NilResult(nil), rest, nil
+			}
+			return compute.NilResult(errors.New(string(value))), rest, nil
+		default:
+			return compute.ErrorResult(errors.New(string(value))), rest, nil
+		}
+	case frameInteger:
+		value, n := binary.Varint(data)
+		if n <= 0 {
+			return compute.Result{}, nil, ErrInvalidFrame
+		}
+		return compute.IntegerResult(value), data[n:], nil
+	case frameArray:
+		count, rest, err := readUvarint(data)
+		if err != nil || count > uint64(len(rest)) {
+			return compute.Result{}, nil, ErrInvalidFrame
+		}
+
+		items := make([]compute.Result, 0, count)
+		for i := uint64(0); i < count; i++ {
+			var item compute.Result
+			if item, rest, err = decodeResult(rest, depth+1); err != nil {
+				return compute.Result{}, nil, err
+			}
+			items = append(items, item)
+		}
+		return compute.ArrayResult(items), rest, nil
+	default:
+		return compute.Result{}, nil, ErrInvalidFrame
+	}
+}
+
+func appendBytes(buf []byte, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	size, rest, err := readUvarint(data)
+	if err != nil || size > uint64(len(rest)) {
+		return nil, nil, ErrInvalidFrame
+	}
+
+	return rest[:size], rest[size:], nil
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	value, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, ErrInvalidFrame
+	}
+
+	return value, data[n:], nil
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
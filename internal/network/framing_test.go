@@ -0,0 +1,137 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameReader_ReadCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		request        []byte
+		expectedTokens []string
+		expectedErr    error
+	}{
+		"binary arguments": {
+			request:        encodeCommand([]byte("SET"), []byte("k\r\n"), []byte{0, 0xff, '\n'}),
+			expectedTokens: []string{"SET", "k\r\n", "\x00\xff\n"},
+		},
+		"empty argument": {
+			request:        encodeCommand([]byte("GET"), []byte{}),
+			expectedTokens: []string{"GET", ""},
+		},
+		"empty command": {
+			request:     frame(0),
+			expectedErr: ErrInvalidFrame,
+		},
+		"argument longer than frame": {
+			request:     frame(1, 5, 'a'),
+			expectedErr: ErrInvalidFrame,
+		},
+		"trailing bytes": {
+			request:     frame(1, 4, 'P', 'I', 'N', 'G', 'x'),
+			expectedErr: ErrInvalidFrame,
+		},
+		"too large frame": {
+			request:     []byte{0, 1, 0, 0},
+			expectedErr: ErrFrameTooLarge,
+		},
+		"truncated frame": {
+			request:     []byte{0, 0, 0, 10, 1},
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := newFrameReader(bufio.NewReader(bytes.NewReader(tc.request)), 1024)
+			tokens, err := reader.ReadCommand()
+
+			assert.Equal(t, tc.expectedTokens, tokens)
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestFrameWriter_WriteResult(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		result compute.Result
+		// expected is nil if result is decoded as is
+		expected *compute.Result
+	}{
+		"ok":      {result: compute.OkResult()},
+		"status":  {result: compute.StatusResult("QUEUED")},
+		"binary":  {result: compute.StringResult("\x00\xff\n")},
+		"integer": {result: compute.IntegerResult(-42)},
+		"nil":     {result: compute.NilResult(nil)},
+		"nil with reason": {
+			result: compute.NilResult(errors.New("key not found")),
+		},
+		"error": {
+			result: compute.ErrorResult(errors.New("boom")),
+		},
+		"error without message": {
+			result:   compute.ErrorResult(nil),
+			expected: ptr(compute.ErrorResult(errors.New("unknown error"))),
+		},
+		"nested array": {
+			result: compute.ArrayResult([]compute.Result{
+				compute.StringResult("a"),
+				compute.NilResult(nil),
+				compute.ArrayResult([]compute.Result{compute.IntegerResult(1)}),
+			}),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			writer := newFrameWriter(bufio.NewWriter(&buf))
+			require.NoError(t, writer.WriteResult(tc.result))
+			require.NoError(t, writer.Flush())
+
+			result, err := newFrameReader(bufio.NewReader(&buf), 1024).ReadResult()
+			require.NoError(t, err)
+
+			expected := tc.result
+			if tc.expected != nil {
+				expected = *tc.expected
+			}
+			assert.Equal(t, expected.String(), result.String())
+			assert.Equal(t, expected.Type, result.Type)
+		})
+	}
+}
+
+func encodeCommand(args ...[]byte) []byte {
+	var buf bytes.Buffer
+	writer := newFrameWriter(bufio.NewWriter(&buf))
+	_ = writer.WriteCommand(args)
+	_ = writer.Flush()
+
+	return buf.Bytes()
+}
+
+// frame prepends payload with its size
+func frame(payload ...byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(payload))), payload...)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
+
 	"github.com/kirban/potato-db/internal/db"
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/kirban/potato-db/internal/replication"
 )
 
 type DatabaseHandler struct {
@@ -18,3 +24,39 @@ func (h *DatabaseHandler) HandleRequest(req string) (string, error) {
 
 	return fmt.Sprintf("%v", resp), nil
 }
+
+func (h *DatabaseHandler) HandleCommand(tokens []string) compute.Result {
+	return h.Db.ExecuteCommand(tokens)
+}
+
+func (h *DatabaseHandler) HandleBlockingCommand(ctx context.Context, tokens []string) compute.Result {
+	return h.Db.ExecuteBlockingCommand(ctx, tokens)
+}
+
+func (h *DatabaseHandler) NewSubscriber() (*pubsub.Subscriber, error) {
+	return h.Db.NewSubscriber()
+}
+
+func (h *DatabaseHandler) OpenChanges(tokens []string) (*cdc.Cursor, error) {
+	return h.Db.OpenChanges(tokens)
+}
+
+func (h *DatabaseHandler) StartSync(remote string) (*replication.Sync, error) {
+	return h.Db.StartSync(remote)
+}
+
+func (h *DatabaseHandler) Role() string {
+	return h.Db.Role()
+}
+
+func (h *DatabaseHandler) ValidateCommand(tokens []string) error {
+	return h.Db.ValidateCommand(tokens)
+}
+
+func (h *DatabaseHandler) Watch(keys []string) (map[string]uint64, error) {
+	return h.Db.Watch(keys)
+}
+
+func (h *DatabaseHandler) ExecuteTransaction(commands [][]string, watched map[string]uint64) compute.Result {
+	return h.Db.ExecuteTransaction(commands, watched)
+}
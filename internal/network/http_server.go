@@ -0,0 +1,275 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/helpers"
+	"go.uber.org/zap"
+)
+
+type HTTPRequestHandler interface {
+	HandleCommand(tokens []string) compute.Result
+}
+
+// badRequestErrors are caused by invalid client input
+var badRequestErrors = []error{
+	compute.ErrUnknownCommand,
+	compute.ErrWrongNOfArgs,
+	compute.ErrInvalidQuery,
+	compute.ErrInvalidArgs,
+	compute.ErrUnterminatedQuote,
+	compute.ErrInvalidEscape,
+	compute.ErrQuoteNotSeparated,
+	db.ErrInvalidExpireTime,
+	storage.ErrNotInteger,
+	storage.ErrNotFloat,
+	storage.ErrIncrOverflow,
+	storage.ErrIncrFloatResult,
+	storage.ErrWrongType,
+	errInvalidBody,
+}
+
+var errInvalidBody = errors.New("invalid request body")
+
+type HTTPServer struct {
+	host        string
+	port        int
+	logger      *zap.Logger
+	handler     HTTPRequestHandler
+	timeout     time.Duration
+	maxBodySize int64
+	server      *http.Server
+	listener    net.Listener
+}
+
+// queryRequest is a body of POST /v1/query, either text query or already split command
+type queryRequest struct {
+	Query   string   `json:"query"`
+	Command []string `json:"command"`
+}
+
+// setRequest is a body of PUT /v1/keys/{key}
+type setRequest struct {
+	Value string `json:"value"`
+	TTLMs int64  `json:"ttl_ms"`
+}
+
+type response struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+func NewHTTPServer(logger *zap.Logger, config *config.HttpServerConfigOptions, handler HTTPRequestHandler) (*HTTPServer, error) {
+	if logger == nil {
+		return nil, errors.New("logger is invalid")
+	}
+
+	if config == nil {
+		return nil, errors.New("config is invalid")
+	}
+
+	if handler == nil {
+		return nil, errors.New("handler is invalid")
+	}
+
+	maxBodySize := 1 << 20
+	if config.MaxBodySize != "" {
+		size, err := helpers.ParseSize(config.MaxBodySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse max body size: %w", err)
+		}
+		maxBodySize = size
+	}
+
+	s := &HTTPServer{
+		host:        config.Host,
+		port:        config.Port,
+		logger:      logger,
+		handler:     handler,
+		timeout:     config.Timeout,
+		maxBodySize: int64(maxBodySize),
+	}
+
+	s.server = &http.Server{
+		Handler:      s.routes(),
+		ReadTimeout:  config.Timeout,
+		WriteTimeout: config.Timeout,
+	}
+
+	return s, nil
+}
+
+func (s *HTTPServer) StartAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", s.host, s.port))
+	if err != nil {
+		return fmt.Errorf("failed to start http server %v", err)
+	}
+
+	s.listener = listener
+	s.logger.Info(fmt.Sprintf("HTTP-server started at %s:%d", s.host, s.port))
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("failed to shutdown http server", zap.Error(err))
+	}
+	s.logger.Info("http server stopped")
+
+	return nil
+}
+
+func (s *HTTPServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/keys/{key}", s.handleGet)
+	mux.HandleFunc("PUT /v1/keys/{key}", s.handleSet)
+	mux.HandleFunc("DELETE /v1/keys/{key}", s.handleDel)
+	mux.HandleFunc("POST /v1/query", s.handleQuery)
+
+	return mux
+}
+
+func (s *HTTPServer) handleGet(w http.ResponseWriter, r *http.Request) {
+	s.execute(w, []string{string(compute.GetCommand), r.PathValue("key")})
+}
+
+func (s *HTTPServer) handleSet(w http.ResponseWriter, r *http.Request) {
+	var req setRequest
+	if err := s.decode(w, r, &req); err != nil {
+		s.writeResult(w, compute.ErrorResult(err))
+		return
+	}
+
+	tokens := []string{string(compute.SetCommand), r.PathValue("key"), req.Value}
+	if req.TTLMs != 0 {
+		tokens = append(tokens, compute.ExpireMillisecondsOption, strconv.FormatInt(req.TTLMs, 10))
+	}
+
+	s.execute(w, tokens)
+}
+
+func (s *HTTPServer) handleDel(w http.ResponseWriter, r *http.Request) {
+	s.execute(w, []string{string(compute.DelCommand), r.PathValue("key")})
+}
+
+func (s *HTTPServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := s.decode(w, r, &req); err != nil {
+		s.writeResult(w, compute.ErrorResult(err))
+		return
+	}
+
+	tokens := req.Command
+	if len(tokens) == 0 {
+		var err error
+		if tokens, err = compute.Tokenize(req.Query); err != nil {
+			s.writeResult(w, compute.ErrorResult(err))
+			return
+		}
+	}
+
+	s.execute(w, tokens)
+}
+
+func (s *HTTPServer) execute(w http.ResponseWriter, tokens []string) {
+	s.logger.Info("received", zap.Strings("msg", tokens))
+	s.writeResult(w, s.handler.HandleCommand(tokens))
+}
+
+func (s *HTTPServer) decode(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidBody, err)
+	}
+
+	return nil
+}
+
+func (s *HTTPServer) writeResult(w http.ResponseWriter, result compute.Result) {
+	status, body := http.StatusOK, response{Result: resultJSON(result)}
+
+	if result.Type == compute.NilResultType || result.Type == compute.ErrorResultType {
+		status = httpStatus(result.Err)
+		if result.Err != nil {
+			body.Error = result.Err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Error("failed to write response", zap.Error(err))
+	}
+}
+
+// resultJSON converts result into value encoded to json, missing values and errors become null
+func resultJSON(result compute.Result) any {
+	switch result.Type {
+	case compute.OkResultType:
+		return "OK"
+	case compute.StatusResultType, compute.StringResultType:
+		return result.Value
+	case compute.IntegerResultType:
+		return result.Int
+	case compute.ArrayResultType:
+		items := make([]any, len(result.Array))
+		for i, item := range result.Array {
+			items[i] = resultJSON(item)
+		}
+		return items
+	default:
+		return nil
+	}
+}
+
+func httpStatus(err error) int {
+	switch {
+	// blocking pop which timed out has nothing to return, like GET of missing key
+	case err == nil, errors.Is(err, storage.ErrKeyNotFound), errors.Is(err, db.ErrBlockTimeout):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrOutOfMemory):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, db.ErrConditionNotMet), errors.Is(err, db.ErrWatchedKeyChanged):
+		return http.StatusConflict
+	// writes must be sent to leader
+	case errors.Is(err, db.ErrReadOnlyReplica), errors.Is(err, cluster.ErrNotLeader):
+		return http.StatusForbidden
+	}
+
+	for _, target := range badRequestErrors {
+		if errors.Is(err, target) {
+			return http.StatusBadRequest
+		}
+	}
+
+	return http.StatusInternalServerError
+}
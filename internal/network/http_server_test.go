@@ -0,0 +1,237 @@
+package network
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/cluster"
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubHandler records executed command and replies with predefined result
+type stubHandler struct {
+	tokens []string
+	result compute.Result
+}
+
+func (h *stubHandler) HandleCommand(tokens []string) compute.Result {
+	h.tokens = tokens
+	return h.result
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	tests := map[string]struct {
+		config  *config.HttpServerConfigOptions
+		handler HTTPRequestHandler
+		wantErr bool
+	}{
+		"valid config": {
+			config:  &config.HttpServerConfigOptions{Host: "127.0.0.1", Port: 8080, MaxBodySize: "1KB"},
+			handler: &stubHandler{},
+			wantErr: false,
+		},
+		"nil config": {
+			config:  nil,
+			handler: &stubHandler{},
+			wantErr: true,
+		},
+		"nil handler": {
+			config:  &config.HttpServerConfigOptions{Host: "127.0.0.1", Port: 8080},
+			handler: nil,
+			wantErr: true,
+		},
+		"invalid max body size": {
+			config:  &config.HttpServerConfigOptions{Host: "127.0.0.1", Port: 8080, MaxBodySize: "big"},
+			handler: &stubHandler{},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, err := NewHTTPServer(createTestLogger(), tt.config, tt.handler)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, server)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, server)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_Routes(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		method         string
+		path           string
+		body           string
+		result         compute.Result
+		expectedTokens []string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"get existing key": {
+			method:         http.MethodGet,
+			path:           "/v1/keys/foo",
+			result:         compute.StringResult("bar"),
+			expectedTokens: []string{"GET", "foo"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"result":"bar"}`,
+		},
+		"get missing key": {
+			method:         http.MethodGet,
+			path:           "/v1/keys/foo",
+			result:         compute.NilResult(storage.ErrKeyNotFound),
+			expectedTokens: []string{"GET", "foo"},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"result":null,"error":"key not found"}`,
+		},
+		"put key with ttl": {
+			method:         http.MethodPut,
+			path:           "/v1/keys/foo",
+			body:           `{"value":"bar baz","ttl_ms":1500}`,
+			result:         compute.OkResult(),
+			expectedTokens: []string{"SET", "foo", "bar baz", "PX", "1500"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"result":"OK"}`,
+		},
+		"put with invalid body": {
+			method:         http.MethodPut,
+			path:           "/v1/keys/foo",
+			body:           `{"val":"bar"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"put over memory limit": {
+			method:         http.MethodPut,
+			path:           "/v1/keys/foo",
+			body:           `{"value":"bar"}`,
+			result:         compute.ErrorResult(storage.ErrOutOfMemory),
+			expectedTokens: []string{"SET", "foo", "bar"},
+			expectedStatus: http.StatusInsufficientStorage,
+		},
+		"delete key": {
+			method:         http.MethodDelete,
+			path:           "/v1/keys/foo",
+			result:         compute.OkResult(),
+			expectedTokens: []string{"DEL", "foo"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"result":"OK"}`,
+		},
+		"text query": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"query":"TTL foo"}`,
+			result:         compute.IntegerResult(-1),
+			expectedTokens: []string{"TTL", "foo"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"result":-1}`,
+		},
+		"array reply": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"query":"MGET foo bar"}`,
+			result:         compute.ArrayResult([]compute.Result{compute.StringResult("1"), compute.NilResult(nil)}),
+			expectedTokens: []string{"MGET", "foo", "bar"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"result":["1",null]}`,
+		},
+		"split command": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"command":["SET","foo","bar baz"]}`,
+			result:         compute.OkResult(),
+			expectedTokens: []string{"SET", "foo", "bar baz"},
+			expectedStatus: http.StatusOK,
+		},
+		"query parse error": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"query":"FOO bar"}`,
+			result:         compute.ErrorResult(compute.ErrUnknownCommand),
+			expectedTokens: []string{"FOO", "bar"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"result":null,"error":"parse error: unknown command"}`,
+		},
+		"storage failure": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"query":"SNAPSHOT"}`,
+			result:         compute.ErrorResult(storage.ErrSnapshotsDisabled),
+			expectedTokens: []string{"SNAPSHOT"},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		"blocking pop timeout": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"query":"BLPOP foo 1"}`,
+			result:         compute.NilResult(db.ErrBlockTimeout),
+			expectedTokens: []string{"BLPOP", "foo", "1"},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"result":null,"error":"timeout, nothing was pushed"}`,
+		},
+		"write on replica": {
+			method:         http.MethodPut,
+			path:           "/v1/keys/foo",
+			body:           `{"value":"bar"}`,
+			result:         compute.ErrorResult(db.ErrReadOnlyReplica),
+			expectedTokens: []string{"SET", "foo", "bar"},
+			expectedStatus: http.StatusForbidden,
+		},
+		"write on cluster follower": {
+			method:         http.MethodPut,
+			path:           "/v1/keys/foo",
+			body:           `{"value":"bar"}`,
+			result:         compute.ErrorResult(cluster.ErrNotLeader),
+			expectedTokens: []string{"SET", "foo", "bar"},
+			expectedStatus: http.StatusForbidden,
+		},
+		"watched key changed": {
+			method:         http.MethodPost,
+			path:           "/v1/query",
+			body:           `{"query":"EXEC"}`,
+			result:         compute.NilResult(db.ErrWatchedKeyChanged),
+			expectedTokens: []string{"EXEC"},
+			expectedStatus: http.StatusConflict,
+		},
+		"unknown route": {
+			method:         http.MethodGet,
+			path:           "/v1/foo",
+			expectedStatus: http.StatusNotFound,
+		},
+		"method not allowed": {
+			method:         http.MethodPost,
+			path:           "/v1/keys/foo",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &stubHandler{result: tc.result}
+			server, err := NewHTTPServer(createTestLogger(), &config.HttpServerConfigOptions{Timeout: time.Second}, handler)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			server.routes().ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			assert.Equal(t, tc.expectedTokens, handler.tokens)
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/replication"
+	"go.uber.org/zap"
+)
+
+// serveFollower sends copy of data to follower and then streams changes after it along with
+// heartbeats until follower disconnects or server stops. Follower only acknowledges its offset
+func (s *TCPServer) serveFollower(ctx context.Context, requests *frameReader, sync *replication.Sync, push func(compute.Result) error) {
+	defer sync.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		defer cancel()
+		for {
+			tokens, err := requests.ReadCommand()
+			if err != nil {
+				return
+			}
+
+			if len(tokens) == 2 && strings.EqualFold(tokens[0], replication.AckCommand) {
+				if offset, err := strconv.ParseUint(tokens[1], 10, 64); err == nil {
+					sync.Ack(offset)
+				}
+			}
+		}
+	}()
+
+	err := push(compute.ArrayResult([]compute.Result{
+		compute.StringResult(replication.FullSyncMessage),
+		compute.IntegerResult(int64(sync.Seq)),
+	}))
+	if err == nil {
+		err = sync.SendData(func(chunk []byte) error {
+			return push(compute.BytesResult(chunk))
+		})
+	}
+	if err == nil {
+		err = push(compute.OkResult())
+	}
+	if err != nil {
+		s.logger.Error("failed to send data to follower", zap.String("remote", sync.Remote), zap.Error(err))
+		return
+	}
+
+	for {
+		waitCtx, stop := context.WithTimeout(ctx, replication.HeartbeatInterval)
+		changes, err := sync.Changes.Next(waitCtx, cdcBatchSize)
+		stop()
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			err = push(compute.ArrayResult([]compute.Result{
+				compute.StringResult(replication.PingMessage),
+				compute.IntegerResult(int64(sync.Offset())),
+			}))
+		case err != nil:
+			if ctx.Err() == nil {
+				// follower which falls behind retained changes starts over with full copy of data
+				s.logger.Warn("replication stream stopped", zap.String("remote", sync.Remote), zap.Error(err))
+				_ = push(compute.ErrorResult(err))
+			}
+			return
+		default:
+			for _, change := range changes {
+				if err = push(changeResult(change)); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			s.logger.Error("failed to push to follower", zap.String("remote", sync.Remote), zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+var (
+	ErrInvalidRESP  = errors.New("protocol error: invalid request")
+	ErrRESPTooLarge = errors.New("protocol error: request is too large")
+)
+
+const (
+	respVersion2 = 2
+	respVersion3 = 3
+
+	respServerName = "potato-db"
+)
+
+// respReader reads commands encoded as RESP arrays of bulk strings or as inline commands
+type respReader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+func newRESPReader(reader *bufio.Reader, maxSize int) *respReader {
+	return &respReader{
+		reader:  reader,
+		maxSize: maxSize,
+	}
+}
+
+// ReadCommand returns next command, empty inline commands are skipped
+func (r *respReader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != '*' {
+			if fields := strings.Fields(string(line)); len(fields) > 0 {
+				return fields, nil
+			}
+			continue
+		}
+
+		count, err := r.parseLength(line[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		tokens := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			token, err := r.readBulkString()
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+		}
+
+		if len(tokens) > 0 {
+			return tokens, nil
+		}
+	}
+}
+
+func (r *respReader) readBulkString() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) == 0 || line[0] != '$' {
+		return "", ErrInvalidRESP
+	}
+
+	size, err := r.parseLength(line[1:])
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return "", err
+	}
+
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		return "", ErrInvalidRESP
+	}
+
+	return string(data[:size]), nil
+}
+
+func (r *respReader) parseLength(raw []byte) (int, error) {
+	length, err := strconv.Atoi(string(raw))
+	if err != nil || length < 0 {
+		return 0, ErrInvalidRESP
+	}
+
+	if length > r.maxSize {
+		return 0, ErrRESPTooLarge
+	}
+
+	return length, nil
+}
+
+// readLine returns line without trailing CRLF, lines longer than reader buffer are rejected
+func (r *respReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, ErrRESPTooLarge
+	} else if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
+
+// respWriter encodes results using protocol version negotiated by HELLO
+type respWriter struct {
+	writer  *bufio.Writer
+	version int
+}
+
+func newRESPWriter(writer *bufio.Writer) *respWriter {
+	return &respWriter{
+		writer:  writer,
+		version: respVersion2,
+	}
+}
+
+func (w *respWriter) WriteResult(result compute.Result) {
+	switch result.Type {
+	case compute.OkResultType:
+		w.writeSimpleString("OK")
+	case compute.StatusResultType:
+		w.writeSimpleString(result.Value)
+	case compute.StringResultType:
+		w.writeBulkString(result.Value)
+	case compute.IntegerResultType:
+		w.writeInteger(result.Int)
+	case compute.NilResultType:
+		w.writeNull()
+	case compute.ArrayResultType:
+		_, _ = w.writer.WriteString("*" + strconv.Itoa(len(result.Array)) + "\r\n")
+		for _, item := range result.Array {
+			w.WriteResult(item)
+		}
+	default:
+		message := "unknown error"
+		if result.Err != nil {
+			message = result.Err.Error()
+		}
+		prefix := errorPrefix(result.Err)
+		// message may already start with error code, like WRONGTYPE one
+		w.WriteError(prefix, strings.TrimPrefix(message, prefix+" "))
+	}
+}
+
+// WritePush writes message sent to client without request, like published one. RESP3 marks
+// such arrays as push type, so clients can tell them from replies
+func (w *respWriter) WritePush(result compute.Result) {
+	if w.version != respVersion3 || result.Type != compute.ArrayResultType {
+		w.WriteResult(result)
+		return
+	}
+
+	_, _ = w.writer.WriteString(">" + strconv.Itoa(len(result.Array)) + "\r\n")
+	for _, item := range result.Array {
+		w.WriteResult(item)
+	}
+}
+
+// errorPrefix returns redis error code clients use to tell errors apart
+func errorPrefix(err error) string {
+	if errors.Is(err, ErrExecAborted) {
+		return "EXECABORT"
+	}
+
+	if errors.Is(err, storage.ErrWrongType) {
+		return "WRONGTYPE"
+	}
+
+	return "ERR"
+}
+
+func (w *respWriter) WriteError(prefix string, message string) {
+	// error is a simple string, it can't contain line breaks
+	message = strings.NewReplacer("\r", " ", "\n", " ").Replace(message)
+	_, _ = w.writer.WriteString("-" + prefix + " " + message + "\r\n")
+}
+
+// WriteHello replies to HELLO with server properties, RESP2 has no maps so flat array is used
+func (w *respWriter) WriteHello(connectionID int64, role string) {
+	if w.version == respVersion3 {
+		_, _ = w.writer.WriteString("%6\r\n")
+	} else {
+		_, _ = w.writer.WriteString("*12\r\n")
+	}
+
+	w.writeBulkString("server")
+	w.writeBulkString(respServerName)
+	w.writeBulkString("proto")
+	w.writeInteger(int64(w.version))
+	w.writeBulkString("id")
+	w.writeInteger(connectionID)
+	w.writeBulkString("mode")
+	w.writeBulkString("standalone")
+	w.writeBulkString("role")
+	w.writeBulkString(role)
+	w.writeBulkString("modules")
+	_, _ = w.writer.WriteString("*0\r\n")
+}
+
+func (w *respWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+func (w *respWriter) writeSimpleString(s string) {
+	_, _ = w.writer.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) writeBulkString(s string) {
+	_, _ = w.writer.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w *respWriter) writeInteger(n int64) {
+	_, _ = w.writer.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) writeNull() {
+	if w.version == respVersion3 {
+		_, _ = w.writer.WriteString("_\r\n")
+		return
+	}
+
+	_, _ = w.writer.WriteString("$-1\r\n")
+}
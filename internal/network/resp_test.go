@@ -0,0 +1,69 @@
+package network
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRESPReader_ReadCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		request        string
+		expectedTokens []string
+		expectedErr    error
+	}{
+		"array of bulk strings": {
+			request:        "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$7\r\nbar baz\r\n",
+			expectedTokens: []string{"SET", "foo", "bar baz"},
+		},
+		"empty bulk string": {
+			request:        "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$0\r\n\r\n",
+			expectedTokens: []string{"SET", "foo", ""},
+		},
+		"inline command": {
+			request:        "GET  foo\r\n",
+			expectedTokens: []string{"GET", "foo"},
+		},
+		"empty lines and arrays are skipped": {
+			request:        "\r\n*0\r\nPING\n",
+			expectedTokens: []string{"PING"},
+		},
+		"bulk string without length": {
+			request:     "*1\r\n$\r\nGET\r\n",
+			expectedErr: ErrInvalidRESP,
+		},
+		"bulk string length mismatch": {
+			request:     "*1\r\n$2\r\nGET\r\n",
+			expectedErr: ErrInvalidRESP,
+		},
+		"too large bulk string": {
+			request:     "*1\r\n$100\r\n",
+			expectedErr: ErrRESPTooLarge,
+		},
+		"too long line": {
+			request:     strings.Repeat("a", 64) + "\r\n",
+			expectedErr: ErrRESPTooLarge,
+		},
+		"truncated request": {
+			request:     "*2\r\n$3\r\nGET\r\n",
+			expectedErr: io.EOF,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := newRESPReader(bufio.NewReaderSize(strings.NewReader(tc.request), 32), 32)
+			tokens, err := reader.ReadCommand()
+
+			assert.Equal(t, tc.expectedTokens, tokens)
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
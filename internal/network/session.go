@@ -0,0 +1,112 @@
+package network
+
+import (
+	"errors"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/pubsub"
+)
+
+var (
+	ErrNestedMulti         = errors.New("MULTI calls can not be nested")
+	ErrExecWithoutMulti    = errors.New("EXEC without MULTI")
+	ErrDiscardWithoutMulti = errors.New("DISCARD without MULTI")
+	ErrWatchInsideMulti    = errors.New("WATCH inside MULTI is not allowed")
+	ErrExecAborted         = errors.New("transaction discarded because of previous errors")
+)
+
+// session is a state of single client connection
+type session struct {
+	// multi is set between MULTI and EXEC or DISCARD, commands are queued meanwhile
+	multi  bool
+	queued [][]string
+	// failed is set if any command was rejected on queueing, EXEC is refused then
+	failed bool
+	// watched holds versions of keys at the moment they were watched
+	watched map[string]uint64
+	// subscriber is set while connection has pub/sub subscriptions
+	subscriber *pubsub.Subscriber
+}
+
+func (s *session) reset() {
+	s.multi = false
+	s.queued = nil
+	s.failed = false
+	s.watched = nil
+}
+
+// handleSession processes transaction commands and queues other commands inside MULTI,
+// false is returned for commands which should be executed right away
+func (s *TCPServer) handleSession(sess *session, tokens []string) (compute.Result, bool) {
+	switch compute.CommandType(tokens[0]) {
+	case compute.MultiCommand:
+		if len(tokens) != 1 {
+			return compute.ErrorResult(compute.ErrWrongNOfArgs), true
+		}
+
+		if sess.multi {
+			return compute.ErrorResult(ErrNestedMulti), true
+		}
+
+		sess.multi = true
+		return compute.OkResult(), true
+	case compute.ExecCommand:
+		if !sess.multi {
+			return compute.ErrorResult(ErrExecWithoutMulti), true
+		}
+		defer sess.reset()
+
+		if sess.failed {
+			return compute.ErrorResult(ErrExecAborted), true
+		}
+
+		return s.handler.ExecuteTransaction(sess.queued, sess.watched), true
+	case compute.DiscardCommand:
+		if !sess.multi {
+			return compute.ErrorResult(ErrDiscardWithoutMulti), true
+		}
+
+		sess.reset()
+		return compute.OkResult(), true
+	case compute.WatchCommand:
+		if sess.multi {
+			return compute.ErrorResult(ErrWatchInsideMulti), true
+		}
+
+		if len(tokens) < 2 {
+			return compute.ErrorResult(compute.ErrWrongNOfArgs), true
+		}
+
+		versions, err := s.handler.Watch(tokens[1:])
+		if err != nil {
+			return compute.ErrorResult(err), true
+		}
+
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64, len(versions))
+		}
+
+		// key watched again keeps its first version
+		for key, version := range versions {
+			if _, exists := sess.watched[key]; !exists {
+				sess.watched[key] = version
+			}
+		}
+		return compute.OkResult(), true
+	case compute.UnwatchCommand:
+		sess.watched = nil
+		return compute.OkResult(), true
+	}
+
+	if !sess.multi {
+		return compute.Result{}, false
+	}
+
+	if err := s.handler.ValidateCommand(tokens); err != nil {
+		sess.failed = true
+		return compute.ErrorResult(err), true
+	}
+
+	sess.queued = append(sess.queued, tokens)
+	return compute.StatusResult("QUEUED"), true
+}
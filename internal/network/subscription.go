@@ -0,0 +1,160 @@
+package network
+
+import (
+	"errors"
+	"net"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrSubscribedContext    = errors.New("only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	ErrSubscribeInsideMulti = errors.New("SUBSCRIBE inside MULTI is not allowed")
+)
+
+// handleSubscription processes pub/sub commands. Connection with subscriptions is in push mode,
+// published messages are sent to it by push and only subscription commands are accepted.
+// False is returned for commands which should be handled as usual
+func (s *TCPServer) handleSubscription(conn net.Conn, sess *session, tokens []string, push func(compute.Result) error) ([]compute.Result, bool) {
+	command := compute.CommandType(tokens[0])
+
+	switch command {
+	case compute.SubscribeCommand, compute.PSubscribeCommand:
+		if sess.multi {
+			return []compute.Result{compute.ErrorResult(ErrSubscribeInsideMulti)}, true
+		}
+
+		if len(tokens) < 2 {
+			return []compute.Result{compute.ErrorResult(compute.ErrWrongNOfArgs)}, true
+		}
+
+		if sess.subscriber == nil {
+			subscriber, err := s.handler.NewSubscriber()
+			if err != nil {
+				return []compute.Result{compute.ErrorResult(err)}, true
+			}
+
+			sess.subscriber = subscriber
+			go s.forwardMessages(conn, subscriber, push)
+		}
+
+		if command == compute.SubscribeCommand {
+			return changeResults("subscribe", sess.subscriber.Subscribe(tokens[1:]...)), true
+		}
+		return changeResults("psubscribe", sess.subscriber.PSubscribe(tokens[1:]...)), true
+	case compute.UnsubscribeCommand, compute.PUnsubscribeCommand:
+		kind := "unsubscribe"
+		if command == compute.PUnsubscribeCommand {
+			kind = "punsubscribe"
+		}
+
+		if sess.subscriber == nil {
+			return []compute.Result{noSubscriptionResult(kind, 0)}, true
+		}
+
+		var changes []pubsub.Change
+		if command == compute.UnsubscribeCommand {
+			changes = sess.subscriber.Unsubscribe(tokens[1:]...)
+		} else {
+			changes = sess.subscriber.PUnsubscribe(tokens[1:]...)
+		}
+
+		results := changeResults(kind, changes)
+		count := sess.subscriber.Count()
+		if len(results) == 0 {
+			results = append(results, noSubscriptionResult(kind, count))
+		}
+
+		// connection leaves push mode once the last subscription is removed
+		if count == 0 {
+			sess.unsubscribe()
+		}
+		return results, true
+	case compute.PingCommand:
+		return nil, false
+	}
+
+	if sess.subscriber != nil {
+		return []compute.Result{compute.ErrorResult(ErrSubscribedContext)}, true
+	}
+
+	return nil, false
+}
+
+// forwardMessages pushes published messages until subscriber is closed. Connection of subscriber
+// dropped for being slow is closed, so client notices lost messages
+func (s *TCPServer) forwardMessages(conn net.Conn, subscriber *pubsub.Subscriber, push func(compute.Result) error) {
+	go func() {
+		<-subscriber.Done()
+		if subscriber.Dropped() {
+			s.logger.Warn("disconnecting slow subscriber", zap.String("remote", conn.RemoteAddr().String()))
+			// closing also unblocks push to client which doesn't read
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-subscriber.Done():
+			return
+		case message := <-subscriber.Messages():
+			// messages left in buffer are not pushed after client has unsubscribed
+			if subscriber.Closed() {
+				return
+			}
+
+			if err := push(messageResult(message)); err != nil {
+				s.logger.Error("failed to push message", zap.Error(err))
+				subscriber.Close()
+				return
+			}
+		}
+	}
+}
+
+// unsubscribe closes subscriber of connection, if there is one
+func (sess *session) unsubscribe() {
+	if sess.subscriber != nil {
+		sess.subscriber.Close()
+		sess.subscriber = nil
+	}
+}
+
+// changeResults replies to subscription command with confirmation of each channel
+func changeResults(kind string, changes []pubsub.Change) []compute.Result {
+	results := make([]compute.Result, 0, len(changes))
+	for _, change := range changes {
+		results = append(results, compute.ArrayResult([]compute.Result{
+			compute.StringResult(kind),
+			compute.StringResult(change.Name),
+			compute.IntegerResult(int64(change.Count)),
+		}))
+	}
+
+	return results
+}
+
+// noSubscriptionResult confirms unsubscribing of client which has no subscriptions of kind,
+// like redis, channel is nil then
+func noSubscriptionResult(kind string, count int) compute.Result {
+	return compute.ArrayResult([]compute.Result{compute.StringResult(kind), compute.NilResult(nil), compute.IntegerResult(int64(count))})
+}
+
+func messageResult(message pubsub.Message) compute.Result {
+	if message.Pattern != "" {
+		return compute.ArrayResult([]compute.Result{
+			compute.StringResult("pmessage"),
+			compute.StringResult(message.Pattern),
+			compute.StringResult(message.Channel),
+			compute.StringResult(message.Payload),
+		})
+	}
+
+	return compute.ArrayResult([]compute.Result{
+		compute.StringResult("message"),
+		compute.StringResult(message.Channel),
+		compute.StringResult(message.Payload),
+	})
+}
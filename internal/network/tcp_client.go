@@ -1,19 +1,28 @@
 package network
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
 )
 
 var defaultBufferSize = 4 << 10
 
+var ErrNotNegotiated = errors.New("framed protocol is not negotiated")
+
 type TCPClient struct {
 	connection  net.Conn
 	idleTimeout time.Duration
 	bufferSize  int
+	// responses and requests are set once framed protocol is negotiated
+	responses *frameReader
+	requests  *frameWriter
 }
 
 func NewTCPClient(address string, idleTimeout time.Duration, bufferSize int) (*TCPClient, error) {
@@ -62,6 +71,71 @@ func (c *TCPClient) Send(request []byte) ([]byte, error) {
 	return response[:count], nil
 }
 
+// Negotiate switches connection to length-prefixed framing, it must be called before
+// any other request. Responses larger than client buffer size are rejected
+func (c *TCPClient) Negotiate() error {
+	if _, err := c.connection.Write(framedHandshake); err != nil {
+		c.Close()
+		return err
+	}
+
+	reader := bufio.NewReaderSize(c.connection, c.bufferSize)
+	ack := make([]byte, len(framedHandshake))
+	if _, err := io.ReadFull(reader, ack); err != nil || !bytes.Equal(ack, framedHandshake) {
+		c.Close()
+		return ErrHandshakeFailed
+	}
+
+	c.responses = newFrameReader(reader, c.bufferSize)
+	c.requests = newFrameWriter(bufio.NewWriter(c.connection))
+
+	return nil
+}
+
+// Execute sends command over negotiated framed protocol, arguments may contain any bytes
+func (c *TCPClient) Execute(args ...[]byte) (compute.Result, error) {
+	if err := c.SendCommand(args...); err != nil {
+		return compute.Result{}, err
+	}
+
+	return c.Receive()
+}
+
+// SendCommand sends command over negotiated framed protocol without waiting for reply,
+// it is used for streams where server sends results on its own, like SYNC
+func (c *TCPClient) SendCommand(args ...[]byte) error {
+	if c.requests == nil {
+		return ErrNotNegotiated
+	}
+
+	if err := c.requests.WriteCommand(args); err != nil {
+		c.Close()
+		return err
+	}
+
+	if err := c.requests.Flush(); err != nil {
+		c.Close()
+		return err
+	}
+
+	return nil
+}
+
+// Receive reads the next result sent by server
+func (c *TCPClient) Receive() (compute.Result, error) {
+	if c.responses == nil {
+		return compute.Result{}, ErrNotNegotiated
+	}
+
+	result, err := c.responses.ReadResult()
+	if err != nil {
+		c.Close()
+		return compute.Result{}, err
+	}
+
+	return result, nil
+}
+
 func (c *TCPClient) Close() {
 	if c.connection != nil {
 		_ = c.connection.Close()
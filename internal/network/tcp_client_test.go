@@ -1,7 +1,11 @@
 package network
 
 import (
+	"context"
 	"errors"
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/network/handlers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"net"
@@ -86,3 +90,43 @@ func TestTCPClient(t *testing.T) {
 		})
 	}
 }
+
+func TestTCPClient_Execute(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1}, &handlers.DatabaseHandler{
+		Db: createMockDatabase(),
+	})
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.semaphore <- struct{}{}
+		server.handleConnection(context.Background(), conn)
+	}()
+
+	client, err := NewTCPClient(listener.Addr().String(), 2*time.Second, 0)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Execute([]byte("PING"))
+	assert.ErrorIs(t, err, ErrNotNegotiated)
+
+	require.NoError(t, client.Negotiate())
+
+	result, err := client.Execute([]byte("SET"), []byte("key"), []byte("line\nbreak\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, compute.StringResult("SET key line\nbreak\x00"), result)
+
+	result, err = client.Execute([]byte("MGET"), []byte("a"), []byte("b"))
+	require.NoError(t, err)
+	assert.Equal(t, compute.ArrayResult([]compute.Result{compute.StringResult("foo"), compute.NilResult(nil)}), result)
+}
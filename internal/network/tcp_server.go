@@ -2,18 +2,50 @@ package network
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"github.com/kirban/potato-db/internal/config"
-	"go.uber.org/zap"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/kirban/potato-db/internal/config"
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/helpers"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/kirban/potato-db/internal/replication"
+	"go.uber.org/zap"
+)
+
+var (
+	defaultProtocol       = config.ProtocolAuto
+	defaultMaxMessageSize = 16 << 20
 )
 
 type TCPRequestHandler interface {
 	HandleRequest(string) (string, error)
+	// HandleCommand executes command already split into tokens by RESP reader
+	HandleCommand(tokens []string) compute.Result
+	// HandleBlockingCommand executes command which may wait for other clients, like BLPOP,
+	// it must stop waiting when ctx is done
+	HandleBlockingCommand(ctx context.Context, tokens []string) compute.Result
+	ValidateCommand(tokens []string) error
+	Watch(keys []string) (map[string]uint64, error)
+	ExecuteTransaction(commands [][]string, watched map[string]uint64) compute.Result
+	// NewSubscriber creates subscriber of pub/sub channels for SUBSCRIBE and PSUBSCRIBE
+	NewSubscriber() (*pubsub.Subscriber, error)
+	// OpenChanges starts reading committed changes for CDC
+	OpenChanges(tokens []string) (*cdc.Cursor, error)
+	// StartSync starts streaming data to follower connected from remote address
+	StartSync(remote string) (*replication.Sync, error)
+	// Role is role of server reported by HELLO, master or replica
+	Role() string
 }
 
 type TCPServer struct {
@@ -26,6 +58,9 @@ type TCPServer struct {
 	idleTimeout    time.Duration
 	maxConnections int
 	semaphore      chan struct{}
+	protocol       string
+	maxMessageSize int
+	connectionID   atomic.Int64
 }
 
 func NewTCPServer(logger *zap.Logger, config *config.ServerConfigOptions, handler TCPRequestHandler) (*TCPServer, error) {
@@ -41,14 +76,35 @@ func NewTCPServer(logger *zap.Logger, config *config.ServerConfigOptions, handle
 		return nil, errors.New("handler is invalid")
 	}
 
+	bufferSize := config.BufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = defaultProtocol
+	}
+
+	maxMessageSize := defaultMaxMessageSize
+	if config.MaxMessageSize != "" {
+		size, err := helpers.ParseSize(config.MaxMessageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse max message size: %w", err)
+		}
+		maxMessageSize = size
+	}
+
 	return &TCPServer{
 		host:           config.Host,
 		port:           config.Port,
 		logger:         logger,
 		handler:        handler,
-		bufferSize:     config.BufferSize,
+		bufferSize:     bufferSize,
 		maxConnections: config.MaxConnections,
 		semaphore:      make(chan struct{}, config.MaxConnections),
+		protocol:       protocol,
+		maxMessageSize: maxMessageSize,
 	}, nil
 }
 
@@ -89,7 +145,7 @@ func (s *TCPServer) StartAndServe(ctx context.Context) error {
 
 			select {
 			case s.semaphore <- struct{}{}:
-				go s.handleConnection(conn)
+				go s.handleConnection(ctx, conn)
 			default:
 				s.logger.Warn("too many connections", zap.String("remote", conn.RemoteAddr().String()))
 				_ = conn.Close()
@@ -112,7 +168,9 @@ func (s *TCPServer) Stop() {
 	s.logger.Info("server stopped")
 }
 
-func (s *TCPServer) handleConnection(conn net.Conn) {
+// handleConnection serves client until it disconnects, commands blocked by client are woken
+// when ctx is done
+func (s *TCPServer) handleConnection(ctx context.Context, conn net.Conn) {
 	s.logger.Info("client connected", zap.String("remote", conn.RemoteAddr().String()))
 
 	defer func() {
@@ -122,32 +180,316 @@ func (s *TCPServer) handleConnection(conn net.Conn) {
 	}()
 
 	defer func(conn net.Conn) {
+		// connection of slow subscriber may be closed already
 		err := conn.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			s.logger.Error("failed to close connection", zap.Error(err))
 		}
 		<-s.semaphore
 	}(conn)
 
+	reader := bufio.NewReaderSize(conn, s.bufferSize)
+
+	protocol := s.protocol
+	if protocol == config.ProtocolAuto {
+		// RESP requests are arrays, framed handshake starts with zero byte,
+		// text queries never start with any of them
+		first, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+
+		switch first[0] {
+		case '*':
+			protocol = config.ProtocolRESP
+		case framedHandshake[0]:
+			protocol = config.ProtocolFramed
+		default:
+			protocol = config.ProtocolText
+		}
+	}
+
+	switch protocol {
+	case config.ProtocolRESP:
+		s.serveRESP(ctx, conn, reader)
+	case config.ProtocolFramed:
+		s.serveFramed(ctx, conn, reader)
+	default:
+		s.serveText(ctx, conn, reader)
+	}
+}
+
+func (s *TCPServer) serveText(ctx context.Context, conn net.Conn, reader *bufio.Reader) {
 	request := make([]byte, 0, s.bufferSize)
-	scanner := bufio.NewScanner(conn)
+	scanner := bufio.NewScanner(reader)
 	scanner.Buffer(request, s.bufferSize)
+	sess := &session{}
+	defer sess.unsubscribe()
+
+	// published messages are pushed by another goroutine, so writes are serialized
+	var writeMu sync.Mutex
+	write := func(response string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		_, err := conn.Write([]byte(response + "\n"))
+		return err
+	}
+	push := func(result compute.Result) error {
+		return write(result.String())
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
 		s.logger.Info("received", zap.String("msg", line), zap.String("remote", conn.RemoteAddr().String()))
 
-		response, err := s.handler.HandleRequest(line)
+		var response string
+		var err error
+
+		// malformed query falls through to handler, which reports syntax error
+		if tokens, lexErr := compute.Tokenize(line); lexErr == nil && len(tokens) > 0 {
+			if results, handled := s.handleSubscription(conn, sess, tokens, push); handled {
+				lines := make([]string, len(results))
+				for i, result := range results {
+					lines[i] = result.String()
+				}
+				response = strings.Join(lines, "\n")
+			} else if result, handled := s.handleSession(sess, tokens); handled {
+				response = result.String()
+			} else if compute.CommandType(tokens[0]).IsBlocking() {
+				response = s.handler.HandleBlockingCommand(ctx, tokens).String()
+			} else if compute.CommandType(tokens[0]) == compute.CDCCommand {
+				if cursor, openErr := s.handler.OpenChanges(tokens); openErr != nil {
+					response = compute.ErrorResult(openErr).String()
+				} else {
+					s.streamChanges(ctx, reader, cursor, push)
+					return
+				}
+			} else {
+				response, err = s.handler.HandleRequest(line)
+			}
+		} else {
+			response, err = s.handler.HandleRequest(line)
+		}
 
 		if err != nil {
 			s.logger.Error("database query failed", zap.Error(err))
 			response = "ERROR database query failed"
 		}
 
-		responseStr := fmt.Sprintf("%v", response)
-		if _, err := conn.Write([]byte(responseStr + "\n")); err != nil {
+		if err := write(fmt.Sprintf("%v", response)); err != nil {
 			s.logger.Error("failed to write response", zap.Error(err))
 			return
 		}
 	}
 }
+
+func (s *TCPServer) serveRESP(ctx context.Context, conn net.Conn, reader *bufio.Reader) {
+	id := s.connectionID.Add(1)
+	requests := newRESPReader(reader, s.maxMessageSize)
+	responses := newRESPWriter(bufio.NewWriterSize(conn, s.bufferSize))
+	sess := &session{}
+	defer sess.unsubscribe()
+
+	// published messages are pushed by another goroutine, so writes are serialized
+	var writeMu sync.Mutex
+	push := func(result compute.Result) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		responses.WritePush(result)
+		return responses.Flush()
+	}
+
+	for {
+		tokens, err := requests.ReadCommand()
+		if errors.Is(err, ErrInvalidRESP) || errors.Is(err, ErrRESPTooLarge) {
+			// like redis, reply with protocol error and close connection as stream is out of sync
+			writeMu.Lock()
+			responses.WriteError("ERR", err.Error())
+			_ = responses.Flush()
+			writeMu.Unlock()
+			return
+		} else if err != nil {
+			return
+		}
+
+		s.logger.Info("received", zap.Strings("msg", tokens), zap.String("remote", conn.RemoteAddr().String()))
+
+		// command names are case insensitive in redis and clients often send them lowercased
+		tokens[0] = strings.ToUpper(tokens[0])
+
+		writeMu.Lock()
+		if tokens[0] == "HELLO" {
+			s.hello(responses, tokens[1:], id)
+		} else if results, handled := s.handleSubscription(conn, sess, tokens, push); handled {
+			for _, result := range results {
+				responses.WritePush(result)
+			}
+		} else if result, handled := s.handleSession(sess, tokens); handled {
+			responses.WriteResult(result)
+		} else if compute.CommandType(tokens[0]).IsBlocking() {
+			// replies of pipelined commands are not held back while client waits
+			if err = responses.Flush(); err == nil {
+				responses.WriteResult(s.handler.HandleBlockingCommand(ctx, tokens))
+			}
+		} else if compute.CommandType(tokens[0]) == compute.CDCCommand {
+			if cursor, openErr := s.handler.OpenChanges(tokens); openErr != nil {
+				responses.WriteResult(compute.ErrorResult(openErr))
+			} else {
+				// replies of pipelined commands are sent before the stream
+				err = responses.Flush()
+				writeMu.Unlock()
+				if err == nil {
+					s.streamChanges(ctx, reader, cursor, push)
+				}
+				return
+			}
+		} else {
+			responses.WriteResult(s.handler.HandleCommand(tokens))
+		}
+
+		// pipelined commands are answered with a single write
+		if err == nil && reader.Buffered() == 0 {
+			err = responses.Flush()
+		}
+		writeMu.Unlock()
+
+		if err != nil {
+			s.logger.Error("failed to write response", zap.Error(err))
+			return
+		}
+	}
+}
+
+// serveFramed answers handshake and then serves length-prefixed binary frames,
+// so keys and values may contain any bytes including line breaks
+func (s *TCPServer) serveFramed(ctx context.Context, conn net.Conn, reader *bufio.Reader) {
+	handshake := make([]byte, len(framedHandshake))
+	if _, err := io.ReadFull(reader, handshake); err != nil || !bytes.Equal(handshake, framedHandshake) {
+		s.logger.Warn("framed protocol handshake failed", zap.String("remote", conn.RemoteAddr().String()))
+		return
+	}
+
+	requests := newFrameReader(reader, s.maxMessageSize)
+	responses := newFrameWriter(bufio.NewWriterSize(conn, s.bufferSize))
+	sess := &session{}
+	defer sess.unsubscribe()
+
+	if _, err := responses.writer.Write(framedHandshake); err != nil {
+		return
+	}
+	if err := responses.Flush(); err != nil {
+		return
+	}
+
+	// published messages are pushed by another goroutine, so writes are serialized
+	var writeMu sync.Mutex
+	push := func(result compute.Result) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		if err := responses.WriteResult(result); err != nil {
+			return err
+		}
+		return responses.Flush()
+	}
+
+	for {
+		tokens, err := requests.ReadCommand()
+		if errors.Is(err, ErrInvalidFrame) || errors.Is(err, ErrFrameTooLarge) {
+			// stream can't be resynchronized after broken frame, so connection is closed
+			writeMu.Lock()
+			_ = responses.WriteResult(compute.ErrorResult(err))
+			_ = responses.Flush()
+			writeMu.Unlock()
+			return
+		} else if err != nil {
+			return
+		}
+
+		s.logger.Info("received", zap.Strings("msg", tokens), zap.String("remote", conn.RemoteAddr().String()))
+
+		tokens[0] = strings.ToUpper(tokens[0])
+
+		writeMu.Lock()
+		if results, handled := s.handleSubscription(conn, sess, tokens, push); handled {
+			for _, result := range results {
+				if err == nil {
+					err = responses.WriteResult(result)
+				}
+			}
+		} else if result, handled := s.handleSession(sess, tokens); handled {
+			err = responses.WriteResult(result)
+		} else if compute.CommandType(tokens[0]).IsBlocking() {
+			if err = responses.Flush(); err == nil {
+				err = responses.WriteResult(s.handler.HandleBlockingCommand(ctx, tokens))
+			}
+		} else if compute.CommandType(tokens[0]) == compute.CDCCommand {
+			if cursor, openErr := s.handler.OpenChanges(tokens); openErr != nil {
+				err = responses.WriteResult(compute.ErrorResult(openErr))
+			} else {
+				// replies of pipelined commands are sent before the stream
+				err = responses.Flush()
+				writeMu.Unlock()
+				if err == nil {
+					s.streamChanges(ctx, reader, cursor, push)
+				}
+				return
+			}
+		} else if compute.CommandType(tokens[0]) == compute.SyncCommand && len(tokens) == 1 {
+			if stream, syncErr := s.handler.StartSync(conn.RemoteAddr().String()); syncErr != nil {
+				err = responses.WriteResult(compute.ErrorResult(syncErr))
+			} else {
+				err = responses.Flush()
+				writeMu.Unlock()
+				if err == nil {
+					s.serveFollower(ctx, requests, stream, push)
+				} else {
+					stream.Close()
+				}
+				return
+			}
+		} else {
+			err = responses.WriteResult(s.handler.HandleCommand(tokens))
+		}
+
+		if err == nil && reader.Buffered() == 0 {
+			err = responses.Flush()
+		}
+		writeMu.Unlock()
+
+		if err != nil {
+			s.logger.Error("failed to write response", zap.Error(err))
+			return
+		}
+	}
+}
+
+// hello switches connection to requested RESP version: HELLO [protover [SETNAME name]]
+func (s *TCPServer) hello(responses *respWriter, args []string, connectionID int64) {
+	version := responses.version
+
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || (v != respVersion2 && v != respVersion3) {
+			responses.WriteError("NOPROTO", "unsupported protocol version")
+			return
+		}
+		version = v
+		args = args[1:]
+	}
+
+	// client name is accepted for compatibility with client libraries, but not stored
+	if len(args) == 2 && strings.EqualFold(args[0], "SETNAME") {
+		args = args[2:]
+	}
+
+	if len(args) != 0 {
+		responses.WriteError("ERR", "syntax error")
+		return
+	}
+
+	responses.version = version
+	responses.WriteHello(connectionID, s.handler.Role())
+}
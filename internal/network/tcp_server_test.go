@@ -4,13 +4,20 @@ import (
 	"bufio"
 	"context"
 	"github.com/kirban/potato-db/internal/network/handlers"
+	"io"
 	"net"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/kirban/potato-db/internal/config"
 	"github.com/kirban/potato-db/internal/db"
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/kirban/potato-db/internal/replication"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -143,7 +150,7 @@ func TestTCPServer_handleConnection(t *testing.T) {
 
 	// Test normal connection handling
 	go func() {
-		server.handleConnection(serverConn)
+		server.handleConnection(context.Background(), serverConn)
 	}()
 
 	// Send test data
@@ -157,6 +164,308 @@ func TestTCPServer_handleConnection(t *testing.T) {
 	assert.True(t, strings.HasSuffix(response, "\n"))
 }
 
+func TestTCPServer_handleConnectionProtocols(t *testing.T) {
+	tests := map[string]struct {
+		protocol string
+		request  string
+		expected string
+	}{
+		"auto detects text protocol": {
+			protocol: config.ProtocolAuto,
+			request:  "GET foo\n",
+			expected: "OK mock response\n",
+		},
+		"auto detects resp protocol": {
+			protocol: config.ProtocolAuto,
+			request:  "*2\r\n$3\r\nget\r\n$3\r\nfoo\r\n",
+			expected: "$-1\r\n",
+		},
+		"resp inline command": {
+			protocol: config.ProtocolRESP,
+			request:  "SET foo bar\r\n",
+			expected: "$11\r\nSET foo bar\r\n",
+		},
+		"resp pipelined commands": {
+			protocol: config.ProtocolRESP,
+			request:  "*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$1\r\nx\r\n",
+			expected: "$4\r\nPING\r\n$-1\r\n",
+		},
+		"resp array reply": {
+			protocol: config.ProtocolRESP,
+			request:  "*3\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n",
+			expected: "*2\r\n$3\r\nfoo\r\n$-1\r\n",
+		},
+		"resp3 negotiated by hello": {
+			protocol: config.ProtocolRESP,
+			request:  "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n*2\r\n$3\r\nGET\r\n$1\r\nx\r\n",
+			expected: "%6\r\n$6\r\nserver\r\n$9\r\npotato-db\r\n$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:1\r\n" +
+				"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n_\r\n",
+		},
+		"hello with unsupported version": {
+			protocol: config.ProtocolRESP,
+			request:  "*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n",
+			expected: "-NOPROTO unsupported protocol version\r\n",
+		},
+		"text transaction": {
+			protocol: config.ProtocolText,
+			request:  "MULTI\nSET a 1\nGET a\nEXEC\n",
+			expected: "[ok]\n[ok] QUEUED\n[ok] QUEUED\n[ok] \"SET a 1\" \"GET a\"\n",
+		},
+		"text exec without multi": {
+			protocol: config.ProtocolText,
+			request:  "EXEC\n",
+			expected: "[err] EXEC without MULTI\n",
+		},
+		"resp transaction": {
+			protocol: config.ProtocolRESP,
+			request:  "MULTI\r\nSET a 1\r\nEXEC\r\n",
+			expected: "+OK\r\n+QUEUED\r\n*1\r\n$7\r\nSET a 1\r\n",
+		},
+		"resp discard": {
+			protocol: config.ProtocolRESP,
+			request:  "MULTI\r\nSET a 1\r\nDISCARD\r\nGET a\r\n",
+			expected: "+OK\r\n+QUEUED\r\n+OK\r\n$-1\r\n",
+		},
+		"resp nested multi": {
+			protocol: config.ProtocolRESP,
+			request:  "MULTI\r\nMULTI\r\n",
+			expected: "+OK\r\n-ERR MULTI calls can not be nested\r\n",
+		},
+		"resp exec aborted after queueing error": {
+			protocol: config.ProtocolRESP,
+			request:  "MULTI\r\nBAD\r\nEXEC\r\n",
+			expected: "+OK\r\n-ERR parse error: unknown command\r\n-EXECABORT transaction discarded because of previous errors\r\n",
+		},
+		"resp watched key changed": {
+			protocol: config.ProtocolRESP,
+			request:  "WATCH changed\r\nMULTI\r\nSET changed 1\r\nEXEC\r\n",
+			expected: "+OK\r\n+OK\r\n+QUEUED\r\n$-1\r\n",
+		},
+		"resp unwatch": {
+			protocol: config.ProtocolRESP,
+			request:  "WATCH changed\r\nUNWATCH\r\nMULTI\r\nEXEC\r\n",
+			expected: "+OK\r\n+OK\r\n+OK\r\n*0\r\n",
+		},
+		"resp watch inside multi": {
+			protocol: config.ProtocolRESP,
+			request:  "MULTI\r\nWATCH a\r\n",
+			expected: "+OK\r\n-ERR WATCH inside MULTI is not allowed\r\n",
+		},
+		"auto detects framed protocol": {
+			protocol: config.ProtocolAuto,
+			request:  string(framedHandshake) + string(encodeCommand([]byte("get"), []byte("x\n"))),
+			expected: string(framedHandshake) + string(frame(frameNil, 0)),
+		},
+		"framed binary arguments": {
+			protocol: config.ProtocolFramed,
+			request:  string(framedHandshake) + string(encodeCommand([]byte("SET"), []byte("k"), []byte("\x00\r\n"))),
+			expected: string(framedHandshake) + string(frame(frameString, 9, 'S', 'E', 'T', ' ', 'k', ' ', 0, '\r', '\n')),
+		},
+		"framed invalid handshake closes connection": {
+			protocol: config.ProtocolFramed,
+			request:  "\x00PDB\x02",
+			expected: "",
+		},
+		"resp wrong type error": {
+			protocol: config.ProtocolRESP,
+			request:  "LPUSH foo bar\r\n",
+			expected: "-WRONGTYPE operation against a key holding the wrong kind of value\r\n",
+		},
+		"resp protocol error": {
+			protocol: config.ProtocolRESP,
+			request:  "*1\r\n+PING\r\n",
+			expected: "-ERR protocol error: invalid request\r\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1, Protocol: tc.protocol}, &handlers.DatabaseHandler{
+				Db: createMockDatabase(),
+			})
+			require.NoError(t, err)
+
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+
+			server.semaphore <- struct{}{}
+			go server.handleConnection(context.Background(), serverConn)
+
+			go func() {
+				_, _ = clientConn.Write([]byte(tc.request))
+			}()
+
+			response := make([]byte, len(tc.expected))
+			_, err = io.ReadFull(clientConn, response)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(response))
+
+			if tc.expected == "" {
+				// nothing is answered and connection is closed
+				_, err = clientConn.Read(make([]byte, 1))
+				assert.ErrorIs(t, err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestTCPServer_BlockedClientWokenOnShutdown(t *testing.T) {
+	server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1}, &handlers.DatabaseHandler{
+		Db: createMockDatabase(),
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	server.semaphore <- struct{}{}
+	go server.handleConnection(ctx, serverConn)
+
+	// reply of pipelined command is flushed before client blocks
+	go func() {
+		_, _ = clientConn.Write([]byte("*1\r\n$4\r\nPING\r\n*3\r\n$5\r\nBLPOP\r\n$4\r\njobs\r\n$1\r\n0\r\n"))
+	}()
+
+	assertResponse(t, clientConn, "$4\r\nPING\r\n")
+
+	cancel()
+	assertResponse(t, clientConn, "-ERR "+db.ErrBlockCancelled.Error()+"\r\n")
+}
+
+func TestTCPServer_PubSub(t *testing.T) {
+	database := &mockDatabase{broker: pubsub.NewBroker(zap.NewNop(), 0)}
+	server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1}, &handlers.DatabaseHandler{
+		Db: database,
+	})
+	require.NoError(t, err)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	server.semaphore <- struct{}{}
+	go server.handleConnection(context.Background(), serverConn)
+
+	send := func(request string) {
+		go func() {
+			_, _ = clientConn.Write([]byte(request))
+		}()
+	}
+
+	send("*3\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n$5\r\nalert\r\n")
+	assertResponse(t, clientConn, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nalert\r\n:2\r\n")
+
+	send("*2\r\n$10\r\nPSUBSCRIBE\r\n$6\r\ncache*\r\n")
+	assertResponse(t, clientConn, "*3\r\n$10\r\npsubscribe\r\n$6\r\ncache*\r\n:3\r\n")
+
+	assert.Equal(t, 1, database.broker.Publish("news", "hello"))
+	assertResponse(t, clientConn, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+
+	assert.Equal(t, 1, database.broker.Publish("cache:user", "1"))
+	assertResponse(t, clientConn, "*4\r\n$8\r\npmessage\r\n$6\r\ncache*\r\n$10\r\ncache:user\r\n$1\r\n1\r\n")
+
+	send("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n")
+	assertResponse(t, clientConn, "-ERR "+ErrSubscribedContext.Error()+"\r\n")
+
+	send("*1\r\n$11\r\nUNSUBSCRIBE\r\n")
+	assertResponse(t, clientConn, "*3\r\n$11\r\nunsubscribe\r\n$5\r\nalert\r\n:2\r\n*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	send("*1\r\n$12\r\nPUNSUBSCRIBE\r\n")
+	assertResponse(t, clientConn, "*3\r\n$12\r\npunsubscribe\r\n$6\r\ncache*\r\n:0\r\n")
+
+	// connection without subscriptions is served as usual
+	assert.Equal(t, 0, database.broker.Publish("news", "missed"))
+	send("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n")
+	assertResponse(t, clientConn, "$-1\r\n")
+}
+
+func TestTCPServer_SlowSubscriberDisconnected(t *testing.T) {
+	database := &mockDatabase{broker: pubsub.NewBroker(zap.NewNop(), 1)}
+	server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1}, &handlers.DatabaseHandler{
+		Db: database,
+	})
+	require.NoError(t, err)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	server.semaphore <- struct{}{}
+	go server.handleConnection(context.Background(), serverConn)
+
+	go func() {
+		_, _ = clientConn.Write([]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n"))
+	}()
+	assertResponse(t, clientConn, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+
+	// client doesn't read, so its buffer overflows and publisher goes on without waiting
+	for i := 0; i < 10; i++ {
+		database.broker.Publish("news", "update")
+	}
+
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadAll(clientConn)
+	assert.NoError(t, err, "connection of slow subscriber must be closed")
+}
+
+func TestTCPServer_CDC(t *testing.T) {
+	database := &mockDatabase{changes: cdc.NewLog(10)}
+	server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1}, &handlers.DatabaseHandler{
+		Db: database,
+	})
+	require.NoError(t, err)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	server.semaphore <- struct{}{}
+	done := make(chan struct{})
+	go func() {
+		server.handleConnection(context.Background(), serverConn)
+		close(done)
+	}()
+
+	send := func(request string) {
+		go func() {
+			_, _ = clientConn.Write([]byte(request))
+		}()
+	}
+
+	// connection is usable after stream can't be opened
+	send("CDC 5\n")
+	assertResponse(t, clientConn, "[err] "+cdc.ErrAhead.Error()+"\n")
+
+	seq := database.changes.Append([]compute.Query{*compute.NewQuery(compute.SetCommand, []string{"a", "1"})})
+	database.changes.Resolve(seq, true)
+
+	send("CDC 1\n")
+	assertResponse(t, clientConn, `[ok] 1 ["SET" "a" "1"]`+"\n")
+
+	// changes are pushed as they are committed, in order of sequence numbers
+	first := database.changes.Append([]compute.Query{*compute.NewQuery(compute.DelCommand, []string{"a"})})
+	second := database.changes.Append([]compute.Query{
+		*compute.NewQuery(compute.IncrByCommand, []string{"n", "1"}),
+		*compute.NewQuery(compute.RPushCommand, []string{"l", "x y"}),
+	})
+	database.changes.Resolve(second, true)
+	database.changes.Resolve(first, true)
+	assertResponse(t, clientConn, `[ok] 2 ["DEL" "a"]`+"\n"+`[ok] 3 ["INCRBY" "n" "1"] ["RPUSH" "l" "x y"]`+"\n")
+
+	require.NoError(t, clientConn.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "stream is not stopped after client has disconnected")
+	}
+}
+
+func assertResponse(t *testing.T, conn net.Conn, expected string) {
+	response := make([]byte, len(expected))
+	_, err := io.ReadFull(conn, response)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(response))
+}
+
 func createTestLogger() *zap.Logger {
 	config := zap.Config{
 		Level:       zap.NewAtomicLevelAt(zap.ErrorLevel),
@@ -179,12 +488,236 @@ func createTestLogger() *zap.Logger {
 }
 
 // mockDatabase implements db.Executable for testing
-type mockDatabase struct{}
+type mockDatabase struct {
+	broker  *pubsub.Broker
+	changes *cdc.Log
+	leader  *replication.Leader
+	data    map[string]storage.Entry
+	role    string
+}
 
 func (m *mockDatabase) ExecuteQuery(q string) (string, error) {
 	return "OK mock response", nil
 }
 
+func (m *mockDatabase) ExecuteCommand(tokens []string) compute.Result {
+	switch tokens[0] {
+	case "GET":
+		return compute.NilResult(nil)
+	case "MGET":
+		return compute.ArrayResult([]compute.Result{compute.StringResult("foo"), compute.NilResult(nil)})
+	case "LPUSH":
+		return compute.ErrorResult(storage.ErrWrongType)
+	}
+
+	return compute.StringResult(strings.Join(tokens, " "))
+}
+
+// ExecuteBlockingCommand waits like blocking pop of empty list until ctx is done
+func (m *mockDatabase) ExecuteBlockingCommand(ctx context.Context, tokens []string) compute.Result {
+	<-ctx.Done()
+	return compute.ErrorResult(db.ErrBlockCancelled)
+}
+
+func (m *mockDatabase) NewSubscriber() (*pubsub.Subscriber, error) {
+	return m.broker.NewSubscriber(), nil
+}
+
+func (m *mockDatabase) OpenChanges(tokens []string) (*cdc.Cursor, error) {
+	if m.changes == nil {
+		return nil, db.ErrCDCDisabled
+	}
+
+	from, err := strconv.ParseUint(tokens[1], 10, 64)
+	if err != nil {
+		return nil, compute.ErrInvalidArgs
+	}
+	return m.changes.Cursor(from)
+}
+
+func (m *mockDatabase) StartSync(remote string) (*replication.Sync, error) {
+	if m.leader == nil {
+		return nil, db.ErrNotLeader
+	}
+
+	seq := m.changes.Last()
+	cursor, err := m.changes.Cursor(seq + 1)
+	if err != nil {
+		return nil, err
+	}
+	return m.leader.Attach(remote, seq, m.data, cursor), nil
+}
+
+func (m *mockDatabase) Role() string {
+	if m.role == "" {
+		return db.RoleMaster
+	}
+	return m.role
+}
+
+func (m *mockDatabase) ValidateCommand(tokens []string) error {
+	if tokens[0] == "BAD" {
+		return compute.ErrUnknownCommand
+	}
+
+	return nil
+}
+
+func (m *mockDatabase) Watch(keys []string) (map[string]uint64, error) {
+	versions := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		versions[key] = 1
+	}
+
+	return versions, nil
+}
+
+// ExecuteTransaction echoes queued commands, watching key "changed" aborts transaction
+func (m *mockDatabase) ExecuteTransaction(commands [][]string, watched map[string]uint64) compute.Result {
+	if _, ok := watched["changed"]; ok {
+		return compute.NilResult(nil)
+	}
+
+	results := make([]compute.Result, 0, len(commands))
+	for _, tokens := range commands {
+		results = append(results, compute.StringResult(strings.Join(tokens, " ")))
+	}
+
+	return compute.ArrayResult(results)
+}
+
+func TestTCPServer_HelloRole(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		role     string
+		expected string
+	}{
+		"master": {
+			role:     db.RoleMaster,
+			expected: "$4\r\nrole\r\n$6\r\nmaster\r\n",
+		},
+		"replica": {
+			role:     db.RoleReplica,
+			expected: "$4\r\nrole\r\n$7\r\nreplica\r\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1, Protocol: config.ProtocolRESP}, &handlers.DatabaseHandler{
+				Db: &mockDatabase{role: tc.role},
+			})
+			require.NoError(t, err)
+
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+
+			server.semaphore <- struct{}{}
+			go server.handleConnection(context.Background(), serverConn)
+
+			go func() {
+				_, _ = clientConn.Write([]byte("*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n"))
+			}()
+
+			prefix := "%6\r\n$6\r\nserver\r\n$9\r\npotato-db\r\n$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:1\r\n" +
+				"$4\r\nmode\r\n$10\r\nstandalone\r\n"
+			response := make([]byte, len(prefix)+len(tc.expected))
+			_, err = io.ReadFull(clientConn, response)
+			require.NoError(t, err)
+			assert.Equal(t, prefix+tc.expected, string(response))
+		})
+	}
+}
+
 func createMockDatabase() db.Executable {
-	return &mockDatabase{}
+	return &mockDatabase{broker: pubsub.NewBroker(zap.NewNop(), 0)}
+}
+
+func TestTCPServer_Replication(t *testing.T) {
+	changes := cdc.NewLog(10)
+	seq := changes.Append([]compute.Query{*compute.NewQuery(compute.SetCommand, []string{"a", "1"})})
+	changes.Resolve(seq, true)
+
+	database := &mockDatabase{
+		changes: changes,
+		leader:  replication.NewLeader(changes),
+		data:    map[string]storage.Entry{"a": {Value: []byte("1")}},
+	}
+	server, err := NewTCPServer(createTestLogger(), &config.ServerConfigOptions{MaxConnections: 1}, &handlers.DatabaseHandler{
+		Db: database,
+	})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		server.semaphore <- struct{}{}
+		server.handleConnection(ctx, conn)
+	}()
+
+	follower := replication.NewFollower(zap.NewNop(), listener.Addr().String(), func(address string) (replication.Conn, error) {
+		client, err := NewTCPClient(address, 0, defaultMaxMessageSize)
+		if err != nil {
+			return nil, err
+		}
+		return client, client.Negotiate()
+	})
+	replica := &recordingReplica{applied: make(chan []compute.Query, 10)}
+	go follower.Start(ctx, replica)
+
+	// data is copied first, then changes after it are streamed in order
+	var queries []compute.Query
+	select {
+	case queries = <-replica.applied:
+	case <-time.After(time.Second):
+		require.Fail(t, "change is not replicated")
+	}
+	assert.Nil(t, queries)
+	assert.Equal(t, map[string]storage.Entry{"a": {Value: []byte("1")}}, replica.data)
+
+	seq = changes.Append([]compute.Query{*compute.NewQuery(compute.RPushCommand, []string{"l", "x y"})})
+	changes.Resolve(seq, true)
+
+	select {
+	case queries = <-replica.applied:
+	case <-time.After(time.Second):
+		require.Fail(t, "change is not replicated")
+	}
+	assert.Equal(t, []compute.Query{*compute.NewQuery(compute.RPushCommand, []string{"l", "x y"})}, queries)
+	assert.Equal(t, uint64(2), follower.Offset())
+
+	// follower acknowledges its offset by heartbeat
+	assert.Eventually(t, func() bool {
+		return strings.Contains(strings.Join(database.leader.Info(), "\n"), ",offset=2,lag=0")
+	}, 3*replication.HeartbeatInterval, 10*time.Millisecond)
+	assert.Contains(t, follower.Info(), "link_status:up")
+}
+
+// recordingReplica reports loaded data as nil queries and then every applied change
+type recordingReplica struct {
+	data    map[string]storage.Entry
+	applied chan []compute.Query
+}
+
+func (r *recordingReplica) LoadReplica(data map[string]storage.Entry) error {
+	r.data = data
+	r.applied <- nil
+	return nil
+}
+
+func (r *recordingReplica) ApplyReplicated(queries []compute.Query) error {
+	r.applied <- queries
+	return nil
 }
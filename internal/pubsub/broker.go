@@ -0,0 +1,241 @@
+package pubsub
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kirban/potato-db/internal/helpers"
+	"go.uber.org/zap"
+)
+
+const defaultBufferSize = 1024
+
+// Message is a payload published to channel, Pattern is set if message is received
+// by pattern subscription
+type Message struct {
+	Pattern string
+	Channel string
+	Payload string
+}
+
+// Change confirms subscription change of a single channel or pattern, Count is number of
+// subscriptions subscriber has after it
+type Change struct {
+	Name  string
+	Count int
+}
+
+// Broker delivers published messages to subscribers of channels and glob patterns
+type Broker struct {
+	logger     *zap.Logger
+	bufferSize int
+	mu         sync.RWMutex
+	channels   map[string]map[*Subscriber]struct{}
+	patterns   map[string]map[*Subscriber]struct{}
+}
+
+func NewBroker(logger *zap.Logger, bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	return &Broker{
+		logger:     logger,
+		bufferSize: bufferSize,
+		channels:   make(map[string]map[*Subscriber]struct{}),
+		patterns:   make(map[string]map[*Subscriber]struct{}),
+	}
+}
+
+// NewSubscriber creates subscriber without subscriptions, it must be closed when not needed
+func (b *Broker) NewSubscriber() *Subscriber {
+	return &Subscriber{
+		broker:   b,
+		messages: make(chan Message, b.bufferSize),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// Publish sends message to subscribers of channel and of patterns matching it, number of
+// deliveries is returned. Publisher never waits, subscriber whose buffer is full is dropped
+func (b *Broker) Publish(channel string, payload string) int {
+	var delivered int
+	var slow []*Subscriber
+
+	b.mu.RLock()
+	for sub := range b.channels[channel] {
+		if sub.deliver(Message{Channel: channel, Payload: payload}) {
+			delivered++
+		} else {
+			slow = append(slow, sub)
+		}
+	}
+
+	for pattern, subs := range b.patterns {
+		if !helpers.MatchGlob(pattern, channel) {
+			continue
+		}
+
+		for sub := range subs {
+			if sub.deliver(Message{Pattern: pattern, Channel: channel, Payload: payload}) {
+				delivered++
+			} else {
+				slow = append(slow, sub)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range slow {
+		b.logger.Warn("subscriber is too slow, dropping it", zap.Int("buffer_size", b.bufferSize))
+		sub.dropped.Store(true)
+		sub.Close()
+	}
+
+	return delivered
+}
+
+// Subscriber receives messages of its channels and patterns. Messages are buffered, subscriber
+// which falls behind by more than buffer size is closed
+type Subscriber struct {
+	broker   *Broker
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+	dropped  atomic.Bool
+	// channels and patterns are guarded by broker lock
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// Messages returns published messages in order they were published
+func (s *Subscriber) Messages() <-chan Message {
+	return s.messages
+}
+
+// Done is closed when subscriber is closed or dropped for being slow
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped reports whether subscriber was closed because its buffer overflowed
+func (s *Subscriber) Dropped() bool {
+	return s.dropped.Load()
+}
+
+func (s *Subscriber) Subscribe(channels ...string) []Change {
+	return s.broker.subscribe(s, s.channels, s.broker.channels, channels)
+}
+
+func (s *Subscriber) PSubscribe(patterns ...string) []Change {
+	return s.broker.subscribe(s, s.patterns, s.broker.patterns, patterns)
+}
+
+// Unsubscribe removes subscriptions of channels, all of them if none is given
+func (s *Subscriber) Unsubscribe(channels ...string) []Change {
+	return s.broker.unsubscribe(s, s.channels, s.broker.channels, channels)
+}
+
+// PUnsubscribe removes subscriptions of patterns, all of them if none is given
+func (s *Subscriber) PUnsubscribe(patterns ...string) []Change {
+	return s.broker.unsubscribe(s, s.patterns, s.broker.patterns, patterns)
+}
+
+// Count returns number of channels and patterns subscriber is subscribed to
+func (s *Subscriber) Count() int {
+	s.broker.mu.RLock()
+	defer s.broker.mu.RUnlock()
+
+	return s.count()
+}
+
+// Close removes all subscriptions, messages left in buffer can still be read
+func (s *Subscriber) Close() {
+	s.once.Do(func() {
+		s.broker.mu.Lock()
+		removeAll(s, s.channels, s.broker.channels)
+		removeAll(s, s.patterns, s.broker.patterns)
+		close(s.done)
+		s.broker.mu.Unlock()
+	})
+}
+
+func (s *Subscriber) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// deliver must be called under broker lock, so closed subscriber is never delivered to
+func (s *Subscriber) deliver(message Message) bool {
+	select {
+	case s.messages <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Broker) subscribe(sub *Subscriber, own map[string]struct{}, index map[string]map[*Subscriber]struct{}, names []string) []Change {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changes := make([]Change, 0, len(names))
+	for _, name := range names {
+		// closed subscriber can't subscribe again, it would never be removed from index
+		if !sub.Closed() {
+			own[name] = struct{}{}
+			if index[name] == nil {
+				index[name] = make(map[*Subscriber]struct{})
+			}
+			index[name][sub] = struct{}{}
+		}
+
+		changes = append(changes, Change{Name: name, Count: sub.count()})
+	}
+
+	return changes
+}
+
+func (b *Broker) unsubscribe(sub *Subscriber, own map[string]struct{}, index map[string]map[*Subscriber]struct{}, names []string) []Change {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(own))
+	}
+
+	changes := make([]Change, 0, len(names))
+	for _, name := range names {
+		delete(own, name)
+		remove(sub, name, index)
+		changes = append(changes, Change{Name: name, Count: sub.count()})
+	}
+
+	return changes
+}
+
+func (s *Subscriber) Closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func removeAll(sub *Subscriber, own map[string]struct{}, index map[string]map[*Subscriber]struct{}) {
+	for name := range own {
+		delete(own, name)
+		remove(sub, name, index)
+	}
+}
+
+func remove(sub *Subscriber, name string, index map[string]map[*Subscriber]struct{}) {
+	delete(index[name], sub)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
@@ -0,0 +1,75 @@
+package pubsub_test
+
+import (
+	"testing"
+
+	"github.com/kirban/potato-db/internal/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	t.Parallel()
+
+	broker := pubsub.NewBroker(zap.NewNop(), 10)
+
+	sub := broker.NewSubscriber()
+	defer sub.Close()
+
+	assert.Equal(t, []pubsub.Change{{Name: "news", Count: 1}, {Name: "alerts", Count: 2}}, sub.Subscribe("news", "alerts"))
+	assert.Equal(t, []pubsub.Change{{Name: "cache:*", Count: 3}}, sub.PSubscribe("cache:*"))
+
+	other := broker.NewSubscriber()
+	defer other.Close()
+	other.Subscribe("news")
+
+	assert.Equal(t, 2, broker.Publish("news", "hello"))
+	assert.Equal(t, 1, broker.Publish("cache:user:1", "invalidate"))
+	assert.Equal(t, 0, broker.Publish("sports", "goal"))
+
+	assert.Equal(t, pubsub.Message{Channel: "news", Payload: "hello"}, <-sub.Messages())
+	assert.Equal(t, pubsub.Message{Pattern: "cache:*", Channel: "cache:user:1", Payload: "invalidate"}, <-sub.Messages())
+	assert.Equal(t, pubsub.Message{Channel: "news", Payload: "hello"}, <-other.Messages())
+
+	// all channels are unsubscribed in order if none is given
+	assert.Equal(t, []pubsub.Change{{Name: "alerts", Count: 2}, {Name: "news", Count: 1}}, sub.Unsubscribe())
+	assert.Equal(t, []pubsub.Change{{Name: "cache:*", Count: 0}}, sub.PUnsubscribe("cache:*"))
+	assert.Empty(t, sub.Unsubscribe())
+
+	assert.Equal(t, 1, broker.Publish("news", "again"))
+}
+
+func TestBroker_SlowSubscriberDropped(t *testing.T) {
+	t.Parallel()
+
+	broker := pubsub.NewBroker(zap.NewNop(), 2)
+
+	slow := broker.NewSubscriber()
+	slow.Subscribe("news")
+	fast := broker.NewSubscriber()
+	defer fast.Close()
+	fast.Subscribe("news")
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 2, broker.Publish("news", "update"))
+		<-fast.Messages()
+	}
+
+	// publisher doesn't wait for subscriber which has no room for message
+	assert.Equal(t, 1, broker.Publish("news", "update"))
+
+	select {
+	case <-slow.Done():
+	default:
+		require.Fail(t, "slow subscriber is not dropped")
+	}
+	assert.True(t, slow.Dropped())
+	assert.False(t, fast.Dropped())
+	assert.Len(t, slow.Messages(), 2)
+
+	// dropped subscriber gets nothing anymore
+	assert.Equal(t, 0, slow.Count())
+	slow.Subscribe("news")
+	assert.Equal(t, 1, broker.Publish("news", "update"))
+}
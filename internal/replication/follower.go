@@ -0,0 +1,296 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/snapshot"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnexpectedMessage = errors.New("replication: unexpected message from leader")
+	ErrLeaderTimeout     = errors.New("replication: leader is not responding")
+)
+
+const (
+	// RetryInterval is how long follower waits before connecting to leader again
+	RetryInterval = time.Second
+	// leaderTimeout is how long follower waits for any message before it reconnects
+	leaderTimeout = 5 * HeartbeatInterval
+)
+
+// Conn is a connection to leader negotiated to framed protocol
+type Conn interface {
+	// SendCommand sends command without waiting for reply
+	SendCommand(args ...[]byte) error
+	// Receive reads the next result sent by leader
+	Receive() (compute.Result, error)
+	Close()
+}
+
+type Dialer func(address string) (Conn, error)
+
+// Replica is a database follower keeps in sync with leader
+type Replica interface {
+	// LoadReplica replaces all data with copy of leader
+	LoadReplica(data map[string]storage.Entry) error
+	// ApplyReplicated applies queries of a single change of leader
+	ApplyReplicated(queries []compute.Query) error
+}
+
+// Follower connects to leader, loads its data and then applies its changes in order. Offset is
+// sequence number of the latest change of leader follower has applied
+type Follower struct {
+	logger  *zap.Logger
+	address string
+	dial    Dialer
+
+	offset       atomic.Uint64
+	leaderOffset atomic.Uint64
+	connected    atomic.Bool
+}
+
+func NewFollower(logger *zap.Logger, address string, dial Dialer) *Follower {
+	return &Follower{
+		logger:  logger,
+		address: address,
+		dial:    dial,
+	}
+}
+
+// Start keeps replica in sync with leader until ctx is done. Every new connection starts with
+// full copy of data, so follower catches up after it has been disconnected for any time
+func (f *Follower) Start(ctx context.Context, replica Replica) {
+	for {
+		err := f.sync(ctx, replica)
+		f.connected.Store(false)
+
+		if ctx.Err() != nil {
+			return
+		}
+		f.logger.Warn("replication link is broken", zap.String("leader", f.address), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RetryInterval):
+		}
+	}
+}
+
+// Offset returns sequence number of the latest change applied by follower
+func (f *Follower) Offset() uint64 {
+	return f.offset.Load()
+}
+
+// Info describes follower and its link to leader for INFO command
+func (f *Follower) Info() []string {
+	status := "down"
+	if f.connected.Load() {
+		status = "up"
+	}
+
+	offset, leaderOffset := f.offset.Load(), f.leaderOffset.Load()
+	return []string{
+		"role:follower",
+		"leader_address:" + f.address,
+		"link_status:" + status,
+		fmt.Sprintf("leader_offset:%d", leaderOffset),
+		fmt.Sprintf("offset:%d", offset),
+		fmt.Sprintf("lag:%d", lag(leaderOffset, offset)),
+	}
+}
+
+func (f *Follower) sync(ctx context.Context, replica Replica) error {
+	conn, err := f.dial(f.address)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// closing connection interrupts receiving when ctx is done
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if err := conn.SendCommand([]byte(compute.SyncCommand)); err != nil {
+		return err
+	}
+
+	if err := f.loadData(conn, replica); err != nil {
+		return err
+	}
+
+	f.connected.Store(true)
+	f.logger.Info("replica is synchronized with leader", zap.String("leader", f.address), zap.Uint64("offset", f.offset.Load()))
+
+	var received atomic.Int64
+	received.Store(time.Now().UnixNano())
+	go f.heartbeat(ctx, conn, &received)
+
+	for {
+		result, err := conn.Receive()
+		if err != nil {
+			if time.Since(time.Unix(0, received.Load())) > leaderTimeout {
+				return ErrLeaderTimeout
+			}
+			return err
+		}
+		received.Store(time.Now().UnixNano())
+
+		if err := f.handle(result, replica); err != nil {
+			return err
+		}
+	}
+}
+
+// loadData receives copy of data, it is sent as sequence number it is taken at followed by
+// chunks of snapshot and ok
+func (f *Follower) loadData(conn Conn, replica Replica) error {
+	result, err := conn.Receive()
+	if err != nil {
+		return err
+	}
+
+	seq, ok := message(result, FullSyncMessage)
+	if !ok {
+		return unexpected(result)
+	}
+
+	var data bytes.Buffer
+	for {
+		result, err := conn.Receive()
+		if err != nil {
+			return err
+		}
+
+		if result.Type == compute.OkResultType {
+			break
+		} else if result.Type != compute.StringResultType {
+			return unexpected(result)
+		}
+		data.WriteString(result.Value)
+	}
+
+	_, entries, err := snapshot.Decode(&data)
+	if err != nil {
+		return fmt.Errorf("failed to decode data of leader: %w", err)
+	}
+
+	if err := replica.LoadReplica(entries); err != nil {
+		return err
+	}
+
+	f.offset.Store(seq)
+	f.leaderOffset.Store(max(f.leaderOffset.Load(), seq))
+	return nil
+}
+
+// handle applies change or remembers offset of leader sent by heartbeat
+func (f *Follower) handle(result compute.Result, replica Replica) error {
+	if offset, ok := message(result, PingMessage); ok {
+		f.leaderOffset.Store(max(f.leaderOffset.Load(), offset))
+		return nil
+	}
+
+	seq, queries, err := parseChange(result)
+	if err != nil {
+		return err
+	}
+
+	// changes taken into copy of data are skipped
+	if seq <= f.offset.Load() {
+		return nil
+	}
+
+	if err := replica.ApplyReplicated(queries); err != nil {
+		return fmt.Errorf("failed to apply change %d: %w", seq, err)
+	}
+
+	f.offset.Store(seq)
+	f.leaderOffset.Store(max(f.leaderOffset.Load(), seq))
+	return nil
+}
+
+// heartbeat reports offset to leader and drops connection if leader has gone silent
+func (f *Follower) heartbeat(ctx context.Context, conn Conn, received *atomic.Int64) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := conn.SendCommand([]byte(AckCommand), []byte(strconv.FormatUint(f.offset.Load(), 10))); err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(time.Unix(0, received.Load())) > leaderTimeout {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// message returns sequence number carried by message of leader, like FULLSYNC and PING
+func message(result compute.Result, name string) (uint64, bool) {
+	if result.Type != compute.ArrayResultType || len(result.Array) != 2 {
+		return 0, false
+	}
+
+	if result.Array[0].Type != compute.StringResultType || result.Array[0].Value != name ||
+		result.Array[1].Type != compute.IntegerResultType || result.Array[1].Int < 0 {
+		return 0, false
+	}
+
+	return uint64(result.Array[1].Int), true
+}
+
+// parseChange reads change sent like CDC does: sequence number followed by queries, each of
+// them is an array of command and arguments
+func parseChange(result compute.Result) (uint64, []compute.Query, error) {
+	if result.Type != compute.ArrayResultType || len(result.Array) < 2 ||
+		result.Array[0].Type != compute.IntegerResultType || result.Array[0].Int <= 0 {
+		return 0, nil, unexpected(result)
+	}
+
+	queries := make([]compute.Query, 0, len(result.Array)-1)
+	for _, item := range result.Array[1:] {
+		if item.Type != compute.ArrayResultType || len(item.Array) == 0 {
+			return 0, nil, unexpected(result)
+		}
+
+		tokens := make([]string, 0, len(item.Array))
+		for _, token := range item.Array {
+			if token.Type != compute.StringResultType {
+				return 0, nil, unexpected(result)
+			}
+			tokens = append(tokens, token.Value)
+		}
+
+		queries = append(queries, *compute.NewQuery(compute.CommandType(tokens[0]), tokens[1:]))
+	}
+
+	return uint64(result.Array[0].Int), queries, nil
+}
+
+func unexpected(result compute.Result) error {
+	if result.Type == compute.ErrorResultType && result.Err != nil {
+		return fmt.Errorf("leader replied error: %w", result.Err)
+	}
+	return ErrUnexpectedMessage
+}
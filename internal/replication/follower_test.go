@@ -0,0 +1,175 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/compute"
+	"github.com/kirban/potato-db/internal/db/snapshot"
+	"github.com/kirban/potato-db/internal/db/storage"
+	"github.com/kirban/potato-db/internal/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFollower(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]storage.Entry{"a": {Value: []byte("1")}}
+	var encoded bytes.Buffer
+	require.NoError(t, snapshot.Encode(&encoded, 5, data))
+
+	change := func(seq int64, tokens ...string) compute.Result {
+		items := make([]compute.Result, 0, len(tokens))
+		for _, token := range tokens {
+			items = append(items, compute.StringResult(token))
+		}
+		return compute.ArrayResult([]compute.Result{compute.IntegerResult(seq), compute.ArrayResult(items)})
+	}
+	message := func(name string, seq int64) compute.Result {
+		return compute.ArrayResult([]compute.Result{compute.StringResult(name), compute.IntegerResult(seq)})
+	}
+
+	conn := &scriptedConn{
+		results: []compute.Result{
+			message(replication.FullSyncMessage, 5),
+			compute.StringResult(encoded.String()),
+			compute.OkResult(),
+			// change already contained in copy of data is skipped
+			change(5, "SET", "a", "1"),
+			change(7, "DEL", "a"),
+			message(replication.PingMessage, 9),
+		},
+		closed: make(chan struct{}),
+	}
+
+	follower := replication.NewFollower(zap.NewNop(), "leader:8282", func(address string) (replication.Conn, error) {
+		assert.Equal(t, "leader:8282", address)
+		return conn, nil
+	})
+	replica := &recordingReplica{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		follower.Start(ctx, replica)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return follower.Offset() == 7 && conn.drained()
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, data, replica.loaded())
+	assert.Equal(t, [][]compute.Query{{*compute.NewQuery(compute.DelCommand, []string{"a"})}}, replica.changes())
+	assert.Eventually(t, func() bool {
+		info := follower.Info()
+		return assert.ObjectsAreEqual([]string{
+			"role:follower",
+			"leader_address:leader:8282",
+			"link_status:up",
+			"leader_offset:9",
+			"offset:7",
+			"lag:2",
+		}, info)
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, [][]byte{[]byte(compute.SyncCommand)}, conn.sent()[0])
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "follower is not stopped")
+	}
+	assert.Contains(t, follower.Info(), "link_status:down")
+}
+
+// scriptedConn replies scripted results and then waits until it is closed
+type scriptedConn struct {
+	mu       sync.Mutex
+	results  []compute.Result
+	commands [][][]byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (c *scriptedConn) SendCommand(args ...[]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commands = append(c.commands, args)
+	return nil
+}
+
+func (c *scriptedConn) Receive() (compute.Result, error) {
+	c.mu.Lock()
+	if len(c.results) > 0 {
+		result := c.results[0]
+		c.results = c.results[1:]
+		c.mu.Unlock()
+		return result, nil
+	}
+	c.mu.Unlock()
+
+	<-c.closed
+	return compute.Result{}, io.EOF
+}
+
+func (c *scriptedConn) Close() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+func (c *scriptedConn) drained() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.results) == 0
+}
+
+func (c *scriptedConn) sent() [][][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.commands
+}
+
+type recordingReplica struct {
+	mu      sync.Mutex
+	data    map[string]storage.Entry
+	applied [][]compute.Query
+}
+
+func (r *recordingReplica) LoadReplica(data map[string]storage.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.data = data
+	return nil
+}
+
+func (r *recordingReplica) ApplyReplicated(queries []compute.Query) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.applied = append(r.applied, queries)
+	return nil
+}
+
+func (r *recordingReplica) loaded() map[string]storage.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.data
+}
+
+func (r *recordingReplica) changes() [][]compute.Query {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.applied
+}
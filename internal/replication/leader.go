@@ -0,0 +1,169 @@
+package replication
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirban/potato-db/internal/db/cdc"
+	"github.com/kirban/potato-db/internal/db/snapshot"
+	"github.com/kirban/potato-db/internal/db/storage"
+)
+
+const (
+	// FullSyncMessage starts full copy of data, it carries sequence number the copy is taken at
+	FullSyncMessage = "FULLSYNC"
+	// PingMessage is sent by leader when there are no changes, it carries leader offset
+	PingMessage = "PING"
+	// AckCommand is sent by follower to report offset it has applied
+	AckCommand = "ACK"
+
+	// HeartbeatInterval is how often leader reports its offset and follower acknowledges its own
+	HeartbeatInterval = time.Second
+	// ChunkSize limits size of a single message of full copy
+	ChunkSize = 64 << 10
+)
+
+// Leader keeps track of followers streaming from it. Offset is sequence number of the latest
+// committed change in change log
+type Leader struct {
+	changes *cdc.Log
+	mu      sync.Mutex
+	syncs   map[*Sync]struct{}
+}
+
+func NewLeader(changes *cdc.Log) *Leader {
+	return &Leader{
+		changes: changes,
+		syncs:   make(map[*Sync]struct{}),
+	}
+}
+
+// Attach registers follower which gets copy of data taken at seq and then changes read by cursor
+func (l *Leader) Attach(remote string, seq uint64, data map[string]storage.Entry, changes *cdc.Cursor) *Sync {
+	sync := &Sync{
+		Remote:  remote,
+		Seq:     seq,
+		Data:    data,
+		Changes: changes,
+		leader:  l,
+	}
+	sync.acked.Store(seq)
+
+	l.mu.Lock()
+	l.syncs[sync] = struct{}{}
+	l.mu.Unlock()
+
+	return sync
+}
+
+// Offset returns sequence number of the latest change followers may get
+func (l *Leader) Offset() uint64 {
+	return l.changes.Committed()
+}
+
+// Info describes leader and its followers for INFO command
+func (l *Leader) Info() []string {
+	l.mu.Lock()
+	syncs := make([]*Sync, 0, len(l.syncs))
+	for sync := range l.syncs {
+		syncs = append(syncs, sync)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(syncs, func(i, j int) bool {
+		return syncs[i].Remote < syncs[j].Remote
+	})
+
+	offset := l.Offset()
+	lines := []string{
+		"role:leader",
+		fmt.Sprintf("offset:%d", offset),
+		fmt.Sprintf("connected_followers:%d", len(syncs)),
+	}
+	for i, sync := range syncs {
+		acked := sync.acked.Load()
+		lines = append(lines, fmt.Sprintf("follower%d:address=%s,offset=%d,lag=%d", i, sync.Remote, acked, lag(offset, acked)))
+	}
+
+	return lines
+}
+
+// Sync is a stream of data of leader to a single follower
+type Sync struct {
+	Remote string
+	// Seq is sequence number of the latest change Data contains
+	Seq     uint64
+	Data    map[string]storage.Entry
+	Changes *cdc.Cursor
+	leader  *Leader
+	acked   atomic.Uint64
+}
+
+// SendData encodes copy of data in snapshot format and passes it to send in chunks of ChunkSize
+func (s *Sync) SendData(send func(chunk []byte) error) error {
+	writer := &chunkWriter{send: send, buf: make([]byte, 0, ChunkSize)}
+	if err := snapshot.Encode(writer, s.Seq, s.Data); err != nil {
+		return err
+	}
+
+	return writer.flush()
+}
+
+// Ack records offset follower has applied
+func (s *Sync) Ack(offset uint64) {
+	s.acked.Store(offset)
+}
+
+// Offset returns offset of leader
+func (s *Sync) Offset() uint64 {
+	return s.leader.Offset()
+}
+
+// Close removes follower from leader once it has disconnected
+func (s *Sync) Close() {
+	s.leader.mu.Lock()
+	delete(s.leader.syncs, s)
+	s.leader.mu.Unlock()
+}
+
+func lag(leader uint64, follower uint64) uint64 {
+	if follower >= leader {
+		return 0
+	}
+	return leader - follower
+}
+
+type chunkWriter struct {
+	send func(chunk []byte) error
+	buf  []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(len(p), ChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.send(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}